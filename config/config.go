@@ -2,6 +2,7 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 )
 
@@ -14,6 +15,32 @@ type CSMConfig struct {
 	CSMAccuracy  float64 `json:"csm_accuracy"`
 }
 
+// BloomConfig sets the capacity and false-positive rate of the bloom filters that are
+// created by adding to a missing one. Filters created by users get their own.
+type BloomConfig struct {
+	BloomCapacity      int     `json:"bloom_capacity"`
+	BloomFalsePositive float64 `json:"bloom_false_positive"`
+}
+
+// CuckooConfig sets the capacity of the cuckoo filters that users create.
+type CuckooConfig struct {
+	CuckooCapacity int `json:"cuckoo_capacity"`
+}
+
+// SimHashConfig sets the size in bits, 64 or 128, of the SimHash fingerprints of texts and
+// the largest Hamming distance that the index of stored fingerprints answers queries for.
+// The other fields set how texts are turned into features: the tokenizer, "words" or
+// "whitespace", a file of stop words instead of the builtin ones, the tokens in a shingle
+// and the weights of features.
+type SimHashConfig struct {
+	SimHashBits          int                `json:"simhash_bits"`
+	SimHashIndexDistance int                `json:"simhash_index_distance"`
+	SimHashTokenizer     string             `json:"simhash_tokenizer"`
+	SimHashStopWords     string             `json:"simhash_stop_words"`
+	SimHashShingle       int                `json:"simhash_shingle"`
+	SimHashWeights       map[string]float64 `json:"simhash_weights"`
+}
+
 type HLLConfig struct {
 	HLLPrecision int `json:"hll_precision"`
 }
@@ -25,6 +52,87 @@ type CacheConfig struct {
 type LSMConfig struct {
 	LSMMaxLevel  int `json:"lsm_max_level"`
 	LSMLevelSize int `json:"lsm_level_size"`
+	// False-positive rate of the bloom filters of each level, starting with level 1.
+	// Deeper levels use the last rate. Without rates every level uses 0.01, except the
+	// last one, which holds the most keys and is read the least, and uses 0.1.
+	LSMBloomFalsePositive []float64 `json:"lsm_bloom_false_positive"`
+	// Prefixes of keys that the bloom filters hold too, so prefix scans skip tables without
+	// them: the first LSMPrefixLength bytes of keys, or every prefix of a key that ends with
+	// LSMPrefixDelimiter. 0 and "" leave prefixes out.
+	LSMPrefixLength    int    `json:"lsm_prefix_length"`
+	LSMPrefixDelimiter string `json:"lsm_prefix_delimiter"`
+	// Filter of new SSTables: "bloom" or "cuckoo", which also supports deletion. The
+	// false-positive rates above only size bloom filters.
+	LSMFilter string `json:"lsm_filter"`
+}
+
+// BloomFalsePositive returns the false-positive rate of the bloom filters of a level.
+func (lsm LSMConfig) BloomFalsePositive(level int) float64 {
+	return LevelFalsePositive(lsm.LSMBloomFalsePositive, level, lsm.LSMMaxLevel)
+}
+
+// LevelFalsePositive picks the false-positive rate of a level from per-level rates, starting
+// with level 1. Levels past the last rate use the last one. Without rates the last level,
+// maxLevel, uses 0.1 and the others 0.01; a maxLevel of 0 leaves the last level unknown.
+func LevelFalsePositive(rates []float64, level, maxLevel int) float64 {
+	if len(rates) == 0 {
+		if maxLevel > 0 && level >= maxLevel {
+			return 0.1
+		}
+		return 0.01
+	}
+	if level > len(rates) {
+		level = len(rates)
+	}
+	return rates[level-1]
+}
+
+// BloomFalsePositives returns the false-positive rates of all levels, starting with level 1.
+func (lsm LSMConfig) BloomFalsePositives() []float64 {
+	rates := make([]float64, lsm.LSMMaxLevel)
+	for i := range rates {
+		rates[i] = lsm.BloomFalsePositive(i + 1)
+	}
+	return rates
+}
+
+// Validate returns an error if a false-positive rate is not between 0 and 1 or if both a
+// prefix length and a prefix delimiter are set.
+func (lsm LSMConfig) Validate() error {
+	for i, rate := range lsm.LSMBloomFalsePositive {
+		if !(rate > 0 && rate < 1) {
+			return fmt.Errorf("bloom filter false-positive rate %v of level %d is not between 0 and 1", rate, i+1)
+		}
+	}
+	if lsm.LSMPrefixLength < 0 {
+		return fmt.Errorf("prefix length %d is negative", lsm.LSMPrefixLength)
+	}
+	if lsm.LSMPrefixLength > 0 && lsm.LSMPrefixDelimiter != "" {
+		return fmt.Errorf("both a prefix length and a prefix delimiter are set")
+	}
+	return nil
+}
+
+// MerkleConfig selects the hash of the Merkle trees of new SSTables: "sha1" or "sha256".
+type MerkleConfig struct {
+	HashFunction string `json:"merkle_hash_function"`
+}
+
+// ScrubberConfig controls the background check of SSTables. An interval of 0 turns it off.
+type ScrubberConfig struct {
+	ScrubberInterval        int `json:"scrubber_interval"` // seconds between passes
+	ScrubberTablesPerSecond int `json:"scrubber_tables_per_second"`
+}
+
+// Validate returns an error if the interval is negative or the rate is not positive.
+func (scrubber ScrubberConfig) Validate() error {
+	if scrubber.ScrubberInterval < 0 {
+		return fmt.Errorf("scrubber interval %d is negative", scrubber.ScrubberInterval)
+	}
+	if scrubber.ScrubberTablesPerSecond <= 0 {
+		return fmt.Errorf("scrubber rate of %d tables per second is not positive", scrubber.ScrubberTablesPerSecond)
+	}
+	return nil
 }
 
 type TokenBucketConfig struct {
@@ -42,25 +150,48 @@ type Config struct {
 	WalParameters         WalConfig         `json:"wal_config"`
 	HLLParameters         HLLConfig         `json:"hll_config"`
 	CSMParameters         CSMConfig         `json:"csm_config"`
+	CuckooParameters      CuckooConfig      `json:"cuckoo_config"`
+	BloomParameters       BloomConfig       `json:"bloom_config"`
+	SimHashParameters     SimHashConfig     `json:"simhash_config"`
 	CacheParameters       CacheConfig       `json:"cache_config"`
 	LSMParameters         LSMConfig         `json:"lsm_config"`
 	TokenBucketParameters TokenBucketConfig `json:"token_bucket_config"`
 	MemTableParameters    MemTableConfig    `json:"mem_table_config"`
+	MerkleParameters      MerkleConfig      `json:"merkle_config"`
+	ScrubberParameters    ScrubberConfig    `json:"scrubber_config"`
+}
+
+// GetSystemConfig reads config/config.json from the working directory.
+func GetSystemConfig() (*Config, error) {
+	return ReadConfig("config/config.json")
 }
 
-func GetSystemConfig() (config *Config) {
+// ReadConfig reads a configuration file. Values set to -1 get their defaults.
+func ReadConfig(path string) (config *Config, err error) {
 	config = new(Config)
 
-	jsonBytes, err := ioutil.ReadFile("config/config.json")
+	jsonBytes, err := ioutil.ReadFile(path)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	err = json.Unmarshal(jsonBytes, config)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("%s: %w", path, err)
 	}
 
+	config.applyDefaults()
+	return config, nil
+}
+
+// DefaultConfig returns the configuration of a file that leaves every value at -1.
+func DefaultConfig() *Config {
+	config := unsetConfig()
+	config.applyDefaults()
+	return config
+}
+
+func (config *Config) applyDefaults() {
 	if config.WalParameters.SegmentCapacity == -1 {
 		config.WalParameters.SegmentCapacity = 50
 	}
@@ -73,6 +204,24 @@ func GetSystemConfig() (config *Config) {
 	if config.CSMParameters.CSMAccuracy == -1 {
 		config.CSMParameters.CSMAccuracy = 0.01
 	}
+	if config.BloomParameters.BloomCapacity == -1 {
+		config.BloomParameters.BloomCapacity = 1000
+	}
+	if config.BloomParameters.BloomFalsePositive == -1 {
+		config.BloomParameters.BloomFalsePositive = 0.01
+	}
+	if config.CuckooParameters.CuckooCapacity == -1 {
+		config.CuckooParameters.CuckooCapacity = 1000
+	}
+	if config.SimHashParameters.SimHashBits == -1 {
+		config.SimHashParameters.SimHashBits = 64
+	}
+	if config.SimHashParameters.SimHashIndexDistance == -1 {
+		config.SimHashParameters.SimHashIndexDistance = 3
+	}
+	if config.SimHashParameters.SimHashShingle == -1 {
+		config.SimHashParameters.SimHashShingle = 1
+	}
 	if config.CacheParameters.CacheMaxData == -1 {
 		config.CacheParameters.CacheMaxData = 5
 	}
@@ -97,11 +246,18 @@ func GetSystemConfig() (config *Config) {
 	if config.MemTableParameters.MemTableThreshold == -1 {
 		config.MemTableParameters.MemTableThreshold = 60
 	}
-
-	return
+	if config.ScrubberParameters.ScrubberInterval == -1 {
+		config.ScrubberParameters.ScrubberInterval = 3600
+	}
+	if config.ScrubberParameters.ScrubberTablesPerSecond == -1 {
+		config.ScrubberParameters.ScrubberTablesPerSecond = 5
+	}
+	if config.MerkleParameters.HashFunction == "" {
+		config.MerkleParameters.HashFunction = "sha1"
+	}
 }
 
-func CreateConfigFile() {
+func unsetConfig() *Config {
 	config := new(Config)
 	config.LSMParameters.LSMMaxLevel = -1
 	config.LSMParameters.LSMLevelSize = -1
@@ -109,12 +265,25 @@ func CreateConfigFile() {
 	config.HLLParameters.HLLPrecision = -1
 	config.CSMParameters.CSMPrecision = -1
 	config.CSMParameters.CSMAccuracy = -1
+	config.CuckooParameters.CuckooCapacity = -1
+	config.BloomParameters.BloomCapacity = -1
+	config.BloomParameters.BloomFalsePositive = -1
+	config.SimHashParameters.SimHashBits = -1
+	config.SimHashParameters.SimHashIndexDistance = -1
+	config.SimHashParameters.SimHashShingle = -1
 	config.CacheParameters.CacheMaxData = -1
 	config.TokenBucketParameters.TokenBucketMaxTokens = -1
 	config.TokenBucketParameters.TokenBucketInterval = -1
 	config.MemTableParameters.SkipListMaxHeight = -1
 	config.MemTableParameters.MemTableThreshold = -1
 	config.MemTableParameters.MaxMemTableSize = -1
+	config.ScrubberParameters.ScrubberInterval = -1
+	config.ScrubberParameters.ScrubberTablesPerSecond = -1
+	return config
+}
+
+func CreateConfigFile() {
+	config := unsetConfig()
 
 	file, _ := json.MarshalIndent(config, "", "  ")
 
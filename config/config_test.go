@@ -0,0 +1,41 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestLevelFalsePositive(t *testing.T) {
+	for _, test := range []struct {
+		rates           []float64
+		level, maxLevel int
+		want            float64
+	}{
+		{nil, 1, 4, 0.01},
+		{nil, 3, 4, 0.01},
+		{nil, 4, 4, 0.1},
+		{nil, 4, 0, 0.01},
+		{[]float64{0.001, 0.05}, 1, 4, 0.001},
+		{[]float64{0.001, 0.05}, 2, 4, 0.05},
+		{[]float64{0.001, 0.05}, 4, 4, 0.05},
+	} {
+		if rate := LevelFalsePositive(test.rates, test.level, test.maxLevel); rate != test.want {
+			t.Errorf("LevelFalsePositive(%v, %d, %d) = %v, want %v", test.rates, test.level, test.maxLevel, rate, test.want)
+		}
+	}
+
+	lsm := LSMConfig{LSMMaxLevel: 3}
+	if rates := lsm.BloomFalsePositives(); len(rates) != 3 || rates[0] != 0.01 || rates[2] != 0.1 {
+		t.Errorf("default rates are %v", rates)
+	}
+}
+
+func TestValidateFalsePositiveRates(t *testing.T) {
+	for _, rates := range [][]float64{{0}, {0.01, 1}, {-0.5}} {
+		if err := (LSMConfig{LSMBloomFalsePositive: rates}).Validate(); err == nil {
+			t.Errorf("rates %v are valid", rates)
+		}
+	}
+	if err := (LSMConfig{LSMBloomFalsePositive: []float64{0.001, 0.5}}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -4,8 +4,12 @@ import (
 	engine "KVSystem/system"
 	"KVSystem/system/structures"
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -23,17 +27,72 @@ func menu() {
 	fmt.Println(" 8. CREATE CMS")
 	fmt.Println(" 9. ADD TO CMS")
 	fmt.Println("10. QUERY IN CMS")
+	fmt.Println("----- Counters -----")
+	fmt.Println("11. INCREMENT")
+	fmt.Println("12. DECREMENT")
+	fmt.Println("---- Keyspaces -----")
+	fmt.Println("13. CREATE KEYSPACE")
+	fmt.Println("14. DROP KEYSPACE")
+	fmt.Println("15. LIST KEYSPACES")
+	fmt.Println("----- Storage ------")
+	fmt.Println("16. VERIFY")
+	fmt.Println("17. PROVE")
+	fmt.Println("18. SCRUB")
+	fmt.Println("19. BLOOM STATS")
+	fmt.Println("-- CuckooFilter ---")
+	fmt.Println("20. CREATE CUCKOO FILTER")
+	fmt.Println("21. ADD TO CUCKOO FILTER")
+	fmt.Println("22. CHECK IN CUCKOO FILTER")
+	fmt.Println("23. DELETE FROM CUCKOO FILTER")
+	fmt.Println("-- BloomFilter ----")
+	fmt.Println("24. CREATE BLOOM FILTER")
+	fmt.Println("25. ADD TO BLOOM FILTER")
+	fmt.Println("26. CHECK IN BLOOM FILTER")
+	fmt.Println("----- SimHash ------")
+	fmt.Println("27. FINGERPRINT TEXT")
+	fmt.Println("28. HAMMING DISTANCE")
+	fmt.Println("29. FIND SIMILAR TEXTS")
+	fmt.Println("---- HLL Sets ------")
+	fmt.Println("30. HLL UNION")
+	fmt.Println("31. HLL INTERSECTION")
 	fmt.Println("--------------------")
 	fmt.Println("0. EXIT")
 	fmt.Print("\nChose option from menu: ")
 }
 
+// notFound reports whether err means that the key does not exist.
+func notFound(err error) bool {
+	return errors.Is(err, engine.ErrNotFound)
+}
+
 func scan() string {
 	input := bufio.NewScanner(os.Stdin)
 	input.Scan()
 	return input.Text()
 }
 
+// scanKeyspace asks for the keyspace of a key. An empty answer is the default keyspace.
+func scanKeyspace() string {
+	fmt.Print("Keyspace (empty for default): ")
+	if name := scan(); name != "" {
+		return name
+	}
+	return engine.DefaultKeyspace
+}
+
+// deleteKey deletes key from the named keyspace. Fingerprints are deleted together with
+// their entries in the SimHash index.
+func deleteKey(e *engine.Engine, keyspace, key string) error {
+	if keyspace == engine.SimHashKeyspace {
+		return e.DeleteSimHash(key)
+	}
+	ks := e.Keyspace(keyspace)
+	if ks == nil {
+		return engine.ErrKeyspaceNotFound
+	}
+	return ks.Delete(key)
+}
+
 func request(engine *engine.Engine) bool {
 	request := engine.TokenBucket.AllowRequest()
 	if !request {
@@ -46,7 +105,9 @@ func request(engine *engine.Engine) bool {
 func parseChoice(choice string, engine *engine.Engine) bool {
 	switch choice {
 	case "0":
-		engine.Wal.PersistCurrentSegment()
+		if err := engine.Close(); err != nil {
+			fmt.Println("Could not close the engine:", err)
+		}
 		fmt.Println("\nGoodbye !")
 		return false
 	case "1":
@@ -58,10 +119,10 @@ func parseChoice(choice string, engine *engine.Engine) bool {
 		key := scan()
 		fmt.Print("Value: ")
 		value := scan()
-		if engine.Put(key, []byte(value), false) {
-			fmt.Println("Data inserted !")
+		if err := engine.Put(key, []byte(value), false); err != nil {
+			fmt.Println("Could not put data:", err)
 		} else {
-			fmt.Println("Could not put data !")
+			fmt.Println("Data inserted !")
 		}
 		break
 	case "2":
@@ -69,22 +130,32 @@ func parseChoice(choice string, engine *engine.Engine) bool {
 			break
 		}
 		fmt.Println("\n- GET")
+		keyspace := scanKeyspace()
 		fmt.Print("Key: ")
 		key := scan()
-		value := engine.GetAsString(key)
-		fmt.Println("Value: ", value)
+		value, err := engine.GetAsString(keyspace, key)
+		if notFound(err) {
+			fmt.Println("Data with given key does not exist !")
+		} else if err != nil {
+			fmt.Println("Could not get data:", err)
+		} else {
+			fmt.Println("Value: ", value)
+		}
 		break
 	case "3":
 		if !request(engine) {
 			break
 		}
 		fmt.Println("\n- DELETE")
+		keyspace := scanKeyspace()
 		fmt.Print("Key: ")
 		key := scan()
-		if engine.Delete(key) {
-			fmt.Println("Data deleted !")
-		} else {
+		if err := deleteKey(engine, keyspace, key); notFound(err) {
 			fmt.Println("Could not find data to delete!")
+		} else if err != nil {
+			fmt.Println("Could not delete data:", err)
+		} else {
+			fmt.Println("Data deleted !")
 		}
 		break
 	case "4":
@@ -96,10 +167,12 @@ func parseChoice(choice string, engine *engine.Engine) bool {
 		key := scan()
 		fmt.Print("Value: ")
 		value := scan()
-		if engine.Edit(key, []byte(value)) {
-			fmt.Println("Value edited !")
-		} else {
+		if err := engine.Edit(key, []byte(value)); notFound(err) {
 			fmt.Println("Could not find data to edit !")
+		} else if err != nil {
+			fmt.Println("Could not edit data:", err)
+		} else {
+			fmt.Println("Value edited !")
 		}
 		break
 	case "5":
@@ -108,9 +181,11 @@ func parseChoice(choice string, engine *engine.Engine) bool {
 		}
 		fmt.Println("\n- CREATE HLL")
 		fmt.Print("HLL's Key: ")
-		key := "hll-" + scan()
-		value := structures.CreateHyperLogLog(uint8(engine.Config.HLLParameters.HLLPrecision)).SerializeHLL()
-		if engine.Put(key, value, false) {
+		key := scan()
+		hll := structures.CreateHyperLogLog(uint8(engine.Config.HLLParameters.HLLPrecision))
+		if err := engine.PutHLL(key, hll); err != nil {
+			fmt.Println("Could not create HLL:", err)
+		} else {
 			fmt.Println("HLL created !")
 		}
 		break
@@ -120,20 +195,13 @@ func parseChoice(choice string, engine *engine.Engine) bool {
 		}
 		fmt.Println("\n- ADD TO HLL")
 		fmt.Print("HLL's Key: ")
-		key := "hll-" + scan()
-		ok, hllData := engine.Get(key)
-		if !ok {
-			fmt.Println("HLL with given key not found !")
-			break
-		}
-		hll := structures.DeserializeHLL(hllData)
+		key := scan()
 		fmt.Print("Value to add: ")
 		value := scan()
-		hll.Add(value)
-		if engine.Edit(key, hll.SerializeHLL()) {
-			fmt.Println("Value added !")
+		if err := engine.AddToHLL(key, value); err != nil {
+			fmt.Println("Could not add data:", err)
 		} else {
-			fmt.Println("Could not add data !")
+			fmt.Println("Value added !")
 		}
 		break
 	case "7":
@@ -142,13 +210,12 @@ func parseChoice(choice string, engine *engine.Engine) bool {
 		}
 		fmt.Println("\n- ESTIMATE HLL")
 		fmt.Print("HLL's Key: ")
-		key := "hll-" + scan()
-		ok, hllData := engine.Get(key)
-		if !ok {
-			fmt.Println("HLL with given key not found !")
+		key := scan()
+		hll, err := engine.GetHLL(key)
+		if err != nil {
+			fmt.Println("Could not get HLL:", err)
 			break
 		}
-		hll := structures.DeserializeHLL(hllData)
 		fmt.Println("Estimation: ", hll.Evaluate())
 		break
 	case "8":
@@ -157,9 +224,12 @@ func parseChoice(choice string, engine *engine.Engine) bool {
 		}
 		fmt.Println("\n- CREATE CMS")
 		fmt.Print("CMS's Key: ")
-		key := "cms-" + scan()
-		value := structures.CreateCountMinSketch(0.1, 0.01).SerializeCMS()
-		if engine.Put(key, value, false) {
+		key := scan()
+		cms := structures.CreateCountMinSketch(engine.Config.CSMParameters.CSMPrecision,
+			engine.Config.CSMParameters.CSMAccuracy)
+		if err := engine.PutCMS(key, cms); err != nil {
+			fmt.Println("Could not create CMS:", err)
+		} else {
 			fmt.Println("CMS created !")
 		}
 		break
@@ -169,20 +239,13 @@ func parseChoice(choice string, engine *engine.Engine) bool {
 		}
 		fmt.Println("\n- ADD TO CMS")
 		fmt.Print("CMS's Key: ")
-		key := "cms-" + scan()
-		ok, cmsData := engine.Get(key)
-		if !ok {
-			fmt.Println("CMS with given key not found !")
-			break
-		}
-		cms := structures.DeserializeCMS(cmsData)
+		key := scan()
 		fmt.Print("Value to add: ")
 		value := scan()
-		cms.Add(strings.ToUpper(value))
-		if engine.Edit(key, cms.SerializeCMS()) {
-			fmt.Println("Value added !")
+		if err := engine.AddToCMS(key, strings.ToUpper(value)); err != nil {
+			fmt.Println("Could not add data:", err)
 		} else {
-			fmt.Println("Could not add data !")
+			fmt.Println("Value added !")
 		}
 		break
 	case "10":
@@ -191,16 +254,356 @@ func parseChoice(choice string, engine *engine.Engine) bool {
 		}
 		fmt.Println("\n- QUERY IN CMS")
 		fmt.Print("CMS's Key: ")
-		key := "cms-" + scan()
-		ok, cmsData := engine.Get(key)
-		if !ok {
-			fmt.Println("CMS with given key not found !")
+		key := scan()
+		cms, err := engine.GetCMS(key)
+		if err != nil {
+			fmt.Println("Could not get CMS:", err)
 			break
 		}
 		fmt.Print("Value to query: ")
 		value := scan()
-		cms := structures.DeserializeCMS(cmsData)
-		fmt.Println(value, " ? : ", cms.Search(strings.ToUpper(value)))
+		count, err := cms.Search(strings.ToUpper(value))
+		if err != nil {
+			fmt.Println("Could not query CMS:", err)
+			break
+		}
+		fmt.Println(value, " ? : ", count)
+		break
+	case "11", "12":
+		if !request(engine) {
+			break
+		}
+		if choice == "11" {
+			fmt.Println("\n- INCREMENT")
+		} else {
+			fmt.Println("\n- DECREMENT")
+		}
+		fmt.Print("Counter's Key: ")
+		key := scan()
+		fmt.Print("Amount: ")
+		delta, err := strconv.ParseInt(scan(), 10, 64)
+		if err != nil {
+			fmt.Println("Amount must be a whole number !")
+			break
+		}
+		var value int64
+		if choice == "11" {
+			value, err = engine.Incr(key, delta)
+		} else {
+			value, err = engine.Decr(key, delta)
+		}
+		if err != nil {
+			fmt.Println("Could not update counter:", err)
+			break
+		}
+		fmt.Println("Counter value: ", value)
+		break
+	case "13":
+		if !request(engine) {
+			break
+		}
+		fmt.Println("\n- CREATE KEYSPACE")
+		fmt.Print("Keyspace's Name: ")
+		name := scan()
+		settings := engine.KeyspaceSettings()
+		if _, err := engine.CreateKeyspace(name, settings); err != nil {
+			fmt.Println("Could not create keyspace:", err)
+			break
+		}
+		fmt.Println("Keyspace created !")
+		break
+	case "14":
+		if !request(engine) {
+			break
+		}
+		fmt.Println("\n- DROP KEYSPACE")
+		fmt.Print("Keyspace's Name: ")
+		if err := engine.DropKeyspace(scan()); err != nil {
+			fmt.Println("Could not drop keyspace:", err)
+			break
+		}
+		fmt.Println("Keyspace dropped !")
+		break
+	case "15":
+		fmt.Println("\n- LIST KEYSPACES")
+		for _, name := range engine.ListKeyspaces() {
+			fmt.Println(name)
+		}
+		break
+	case "16":
+		fmt.Println("\n- VERIFY")
+		reports, err := engine.Verify()
+		if err != nil {
+			fmt.Println("Could not verify:", err)
+			break
+		}
+		printVerifyReports(engine.ListKeyspaces(), reports)
+		break
+	case "17":
+		if !request(engine) {
+			break
+		}
+		fmt.Println("\n- PROVE")
+		fmt.Print("Key: ")
+		key := scan()
+		value, root, proof, err := engine.Proof(key)
+		if notFound(err) {
+			fmt.Println("There is no value for this key !")
+			break
+		} else if err != nil {
+			fmt.Println("Could not prove the value:", err)
+			break
+		}
+		_, data := structures.DecodeValue(value)
+		fmt.Println("Value: ", string(data))
+		fmt.Printf("Root (%s): %x\n", proof.Hash, root)
+		for level, sibling := range proof.Siblings {
+			fmt.Printf("  sibling %d: %x\n", level, sibling)
+		}
+		fmt.Println("Proof is valid: ", structures.VerifyProof(root, key, value, proof))
+		break
+	case "18":
+		fmt.Println("\n- SCRUB")
+		if err := engine.Scrub(); err != nil {
+			fmt.Println("Could not scrub:", err)
+		}
+		stats := engine.ScrubberStats()
+		fmt.Printf("Passes: %d, tables checked: %d\n", stats.Passes, stats.TablesChecked)
+		for _, table := range stats.Quarantined {
+			fmt.Printf("QUARANTINED [%s] %s (%s) -> %s\n", table.Keyspace, table.Table, table.Problem, table.Directory)
+		}
+		if stats.LastError != nil {
+			fmt.Println("Last error: ", stats.LastError)
+		}
+		break
+	case "19":
+		fmt.Println("\n- BLOOM STATS")
+		for _, name := range engine.ListKeyspaces() {
+			ks := engine.Keyspace(name)
+			if ks == nil {
+				continue
+			}
+			lsm := ks.Settings().LSMParameters
+			for i, level := range ks.BloomStats().Levels {
+				fmt.Printf("[%s] level %d: useful %d, useless %d, positive %d, false positives %.4f (configured %.4f)\n",
+					name, i+1, level.Useful, level.Useless, level.Positive, level.FalsePositiveRate(),
+					lsm.BloomFalsePositive(i+1))
+			}
+		}
+		break
+	case "20":
+		if !request(engine) {
+			break
+		}
+		fmt.Println("\n- CREATE CUCKOO FILTER")
+		fmt.Print("Filter's Key: ")
+		key := scan()
+		cf := structures.CreateCuckooFilter(uint(engine.Config.CuckooParameters.CuckooCapacity))
+		if err := engine.PutCuckoo(key, cf); err != nil {
+			fmt.Println("Could not create cuckoo filter:", err)
+		} else {
+			fmt.Println("Cuckoo filter created !")
+		}
+		break
+	case "21", "23":
+		if !request(engine) {
+			break
+		}
+		if choice == "21" {
+			fmt.Println("\n- ADD TO CUCKOO FILTER")
+		} else {
+			fmt.Println("\n- DELETE FROM CUCKOO FILTER")
+		}
+		fmt.Print("Filter's Key: ")
+		key := scan()
+		fmt.Print("Value: ")
+		value := scan()
+		var err error
+		if choice == "21" {
+			err = engine.AddToCuckoo(key, value)
+		} else {
+			err = engine.DeleteFromCuckoo(key, value)
+		}
+		if err != nil {
+			fmt.Println("Could not change cuckoo filter:", err)
+		} else if choice == "21" {
+			fmt.Println("Value added !")
+		} else {
+			fmt.Println("Value deleted !")
+		}
+		break
+	case "22":
+		if !request(engine) {
+			break
+		}
+		fmt.Println("\n- CHECK IN CUCKOO FILTER")
+		fmt.Print("Filter's Key: ")
+		key := scan()
+		cf, err := engine.GetCuckoo(key)
+		if err != nil {
+			fmt.Println("Could not get cuckoo filter:", err)
+			break
+		}
+		fmt.Print("Value to check: ")
+		value := scan()
+		if cf.Contains(value) {
+			fmt.Println("Value is probably in the filter.")
+		} else {
+			fmt.Println("Value is not in the filter.")
+		}
+		break
+	case "24":
+		if !request(engine) {
+			break
+		}
+		fmt.Println("\n- CREATE BLOOM FILTER")
+		fmt.Print("Filter's Key: ")
+		key := scan()
+		fmt.Print("Capacity: ")
+		capacity, err := strconv.ParseUint(scan(), 10, 32)
+		if err != nil || capacity == 0 {
+			fmt.Println("Capacity must be a positive whole number !")
+			break
+		}
+		fmt.Print("False-positive rate: ")
+		falsePositive, err := strconv.ParseFloat(scan(), 64)
+		if err != nil || !(falsePositive > 0 && falsePositive < 1) {
+			fmt.Println("False-positive rate must be between 0 and 1 !")
+			break
+		}
+		bf := structures.CreateBF(uint(capacity), falsePositive)
+		if err = engine.PutBloom(key, bf); err != nil {
+			fmt.Println("Could not create bloom filter:", err)
+		} else {
+			fmt.Printf("Bloom filter of %d bits with %d hash functions created !\n", bf.M, bf.K)
+		}
+		break
+	case "25":
+		if !request(engine) {
+			break
+		}
+		fmt.Println("\n- ADD TO BLOOM FILTER")
+		fmt.Print("Filter's Key: ")
+		key := scan()
+		fmt.Print("Value to add: ")
+		value := scan()
+		if err := engine.AddToBloom(key, value); err != nil {
+			fmt.Println("Could not add data:", err)
+		} else {
+			fmt.Println("Value added !")
+		}
+		break
+	case "26":
+		if !request(engine) {
+			break
+		}
+		fmt.Println("\n- CHECK IN BLOOM FILTER")
+		fmt.Print("Filter's Key: ")
+		key := scan()
+		bf, err := engine.GetBloom(key)
+		if err != nil {
+			fmt.Println("Could not get bloom filter:", err)
+			break
+		}
+		fmt.Print("Value to check: ")
+		value := scan()
+		if bf.Search(value) {
+			fmt.Println("Value is probably in the filter.")
+		} else {
+			fmt.Println("Value is not in the filter.")
+		}
+		break
+	case "27":
+		if !request(engine) {
+			break
+		}
+		fmt.Println("\n- FINGERPRINT TEXT")
+		fmt.Print("Fingerprint's Key: ")
+		key := scan()
+		fmt.Print("Text: ")
+		text := scan()
+		fingerprint, err := engine.PutSimHash(key, text)
+		if err != nil {
+			fmt.Println("Could not store fingerprint:", err)
+		} else {
+			fmt.Println("Fingerprint", fingerprint, "stored !")
+		}
+		break
+	case "28":
+		if !request(engine) {
+			break
+		}
+		fmt.Println("\n- HAMMING DISTANCE")
+		fmt.Print("First fingerprint's Key: ")
+		key1 := scan()
+		fmt.Print("Second fingerprint's Key: ")
+		key2 := scan()
+		distance, err := engine.SimHashDistance(key1, key2)
+		if notFound(err) {
+			fmt.Println("Fingerprint not found !")
+		} else if err != nil {
+			fmt.Println("Could not compare fingerprints:", err)
+		} else {
+			fmt.Println("Hamming distance:", distance)
+		}
+		break
+	case "29":
+		if !request(engine) {
+			break
+		}
+		fmt.Println("\n- FIND SIMILAR TEXTS")
+		fmt.Print("Text: ")
+		text := scan()
+		fmt.Print("Largest distance: ")
+		k, err := strconv.Atoi(scan())
+		if err != nil || k < 0 {
+			fmt.Println("Distance must be a whole number that is not negative !")
+			break
+		}
+		similar, err := engine.SimilarTo(text, k)
+		if err != nil {
+			fmt.Println("Could not search fingerprints:", err)
+			break
+		}
+		if len(similar) == 0 {
+			fmt.Println("No similar texts found.")
+		}
+		for _, found := range similar {
+			fmt.Printf("%s (distance %d)\n", found.Key, found.Distance)
+		}
+		break
+	case "30", "31":
+		if !request(engine) {
+			break
+		}
+		if choice == "30" {
+			fmt.Println("\n- HLL UNION")
+		} else {
+			fmt.Println("\n- HLL INTERSECTION")
+		}
+		fmt.Print("HLL Keys (separated by spaces): ")
+		keys := strings.Fields(scan())
+		if len(keys) == 0 {
+			fmt.Println("At least one key is needed !")
+			break
+		}
+		var estimate structures.SetEstimate
+		var err error
+		if choice == "31" {
+			estimate, err = engine.HLLIntersection(keys)
+		} else {
+			fmt.Print("Store union under key (empty to skip): ")
+			if dest := scan(); dest != "" {
+				estimate, err = engine.StoreHLLUnion(dest, keys)
+			} else {
+				estimate, err = engine.HLLUnion(keys)
+			}
+		}
+		if err != nil {
+			fmt.Println("Could not estimate:", err)
+		} else {
+			fmt.Printf("Estimation: %.0f (+/- %.0f)\n", estimate.Estimate, estimate.Error)
+		}
 		break
 	default:
 		fmt.Println("\nWrong input ! Please try again. ")
@@ -210,9 +613,122 @@ func parseChoice(choice string, engine *engine.Engine) bool {
 	return true
 }
 
+// diff prints the key ranges that differ between the store of system and the replica in dir.
+func diff(system *engine.Engine, dir, configPath string) error {
+	replica, err := engine.Open(dir, engine.Options{ConfigPath: configPath})
+	if err != nil {
+		return err
+	}
+	defer replica.Close()
+
+	diffs, err := system.Diff(replica)
+	if err != nil {
+		return err
+	}
+	keyspaces := make([]string, 0, len(diffs))
+	for name := range diffs {
+		keyspaces = append(keyspaces, name)
+	}
+	sort.Strings(keyspaces)
+	for _, name := range keyspaces {
+		for _, keyRange := range diffs[name] {
+			if keyRange.Start == keyRange.End {
+				fmt.Printf("[%s] %s\n", name, keyRange.Start)
+			} else {
+				fmt.Printf("[%s] %s .. %s\n", name, keyRange.Start, keyRange.End)
+			}
+		}
+	}
+	if len(diffs) == 0 {
+		fmt.Println("The stores hold the same data.")
+	}
+	return nil
+}
+
+// printVerifyReports lists the corrupted SSTables and how many tables were checked.
+func printVerifyReports(keyspaces []string, reports map[string][]structures.TableReport) {
+	tables, corrupted := 0, 0
+	for _, name := range keyspaces {
+		for _, report := range reports[name] {
+			tables++
+			if report.MissingMetadata {
+				fmt.Println("No Merkle tree for", report.Table, "- only checksums were checked")
+			}
+			if !report.Corrupted() {
+				continue
+			}
+			corrupted++
+			fmt.Printf("CORRUPTED [%s] %s\n", name, report.Table)
+			if report.Err != nil {
+				fmt.Println("   unreadable:", report.Err)
+			}
+			if report.FirstBadChecksum >= 0 {
+				fmt.Println("   first record with a bad checksum:", report.FirstBadChecksum)
+			}
+			if report.FirstBadLeaf >= 0 {
+				fmt.Println("   first bad Merkle leaf:", report.FirstBadLeaf)
+			} else if report.RootMismatch {
+				fmt.Println("   Merkle root differs, the metadata file is damaged")
+			}
+		}
+	}
+	fmt.Printf("%d tables verified, %d corrupted.\n", tables, corrupted)
+}
+
+// repair rebuilds the tables of the store in dir and prints what was lost. With force, keys
+// lost with quarantined tables can be read again.
+func repair(dir, configPath string, force bool) error {
+	report, err := engine.Repair(dir, engine.Options{ConfigPath: configPath, Force: force})
+	if err != nil {
+		return err
+	}
+	keyspaces := make([]string, 0, len(report.Keyspaces))
+	for name := range report.Keyspaces {
+		keyspaces = append(keyspaces, name)
+	}
+	sort.Strings(keyspaces)
+	for _, name := range keyspaces {
+		keyspaceReport := report.Keyspaces[name]
+		fmt.Printf("%s: %d tables rebuilt, %d records dropped\n", name, len(keyspaceReport.Rebuilt),
+			len(keyspaceReport.Dropped))
+		for _, problem := range keyspaceReport.Problems {
+			fmt.Println("  ", problem)
+		}
+	}
+	fmt.Println("Report written to", report.Filename)
+	return nil
+}
+
 func main() {
-	system := new(engine.Engine)
-	system.Init()
+	dir := flag.String("dir", "system/data", "data directory")
+	configPath := flag.String("config", "config/config.json", "configuration file")
+	replicaDir := flag.String("diff", "", "data directory of a replica to compare with, instead of starting the menu")
+	repairStore := flag.Bool("repair", false, "rebuild the table files from the data files, instead of starting the menu")
+	force := flag.Bool("force", false, "with -repair, allow reads of keys lost with quarantined tables again")
+	flag.Parse()
+
+	if *repairStore {
+		if err := repair(*dir, *configPath, *force); err != nil {
+			fmt.Println("Could not repair the store:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	system, err := engine.Open(*dir, engine.Options{ConfigPath: *configPath})
+	if err != nil {
+		fmt.Println("Could not start the engine:", err)
+		os.Exit(1)
+	}
+	if *replicaDir != "" {
+		err = diff(system, *replicaDir, *configPath)
+		_ = system.Close()
+		if err != nil {
+			fmt.Println("Could not compare the stores:", err)
+			os.Exit(1)
+		}
+		return
+	}
 	fmt.Println("Welcome !")
 	run := true
 	for run {
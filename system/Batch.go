@@ -0,0 +1,85 @@
+package system
+
+import (
+	"KVSystem/system/structures"
+	"encoding/binary"
+	"errors"
+)
+
+// Batch collects writes to one or more keyspaces that Engine.Write applies atomically.
+type Batch struct {
+	records []structures.Element
+}
+
+func NewBatch() *Batch {
+	return &Batch{records: make([]structures.Element, 0)}
+}
+
+// Put adds a write of a raw value.
+func (b *Batch) Put(keyspace, key string, value []byte) {
+	b.PutTyped(keyspace, key, structures.TypeRaw, value)
+}
+
+// PutTyped adds a write of data tagged with its type.
+func (b *Batch) PutTyped(keyspace, key string, valueType structures.ValueType, data []byte) {
+	b.records = append(b.records, structures.Element{Keyspace: keyspace, Key: key,
+		Value: structures.EncodeValue(valueType, data)})
+}
+
+func (b *Batch) Delete(keyspace, key string) {
+	b.records = append(b.records, structures.Element{Keyspace: keyspace, Key: key, Tombstone: true})
+}
+
+func (b *Batch) Len() int {
+	return len(b.records)
+}
+
+// encode serializes the batch for the WAL: [count] and then for every record
+// [kind][keyspace size][key size][value size][keyspace][key][value].
+func (b *Batch) encode() []byte {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, uint64(len(b.records)))
+	for _, record := range b.records {
+		data = append(data, record.Kind())
+		for _, field := range [][]byte{[]byte(record.Keyspace), []byte(record.Key), record.Value} {
+			size := make([]byte, 8)
+			binary.LittleEndian.PutUint64(size, uint64(len(field)))
+			data = append(data, size...)
+		}
+		data = append(data, record.Keyspace...)
+		data = append(data, record.Key...)
+		data = append(data, record.Value...)
+	}
+	return data
+}
+
+// decodeBatch reads the records of a batch back from the encoding of encode.
+func decodeBatch(data []byte) ([]structures.Element, error) {
+	if len(data) < 8 {
+		return nil, errors.New("batch is cut off")
+	}
+	count := binary.LittleEndian.Uint64(data)
+	data = data[8:]
+	records := make([]structures.Element, 0)
+	for i := uint64(0); i < count; i++ {
+		if len(data) < 25 {
+			return nil, errors.New("batch is cut off")
+		}
+		kind := data[0]
+		sizes := make([]uint64, 3)
+		for j := range sizes {
+			sizes[j] = binary.LittleEndian.Uint64(data[1+8*j:])
+		}
+		data = data[25:]
+		fields := make([][]byte, 3)
+		for j, size := range sizes {
+			if uint64(len(data)) < size {
+				return nil, errors.New("batch is cut off")
+			}
+			fields[j], data = data[:size], data[size:]
+		}
+		records = append(records, structures.Element{Keyspace: string(fields[0]), Key: string(fields[1]),
+			Value: fields[2], Tombstone: kind == structures.RecordTombstone})
+	}
+	return records, nil
+}
@@ -3,155 +3,903 @@ package system
 import (
 	"KVSystem/config"
 	"KVSystem/system/structures"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+const (
+	DefaultKeyspace = "default"
+	HLLKeyspace     = "hll"
+	CMSKeyspace     = "cms"
+	CuckooKeyspace  = "cuckoo"
+	BloomKeyspace   = "bloom"
+	SimHashKeyspace = "simhash"
+
+	SimHashIndexKeyspace = "simhash-index"
+)
+
+// builtinKeyspaces always exist and cannot be dropped.
+var builtinKeyspaces = []string{DefaultKeyspace, HLLKeyspace, CMSKeyspace, CuckooKeyspace, BloomKeyspace,
+	SimHashKeyspace}
+
+// indexKeyspaces hold indexes that the engine keeps up to date. Like the builtin keyspaces
+// they always exist and cannot be dropped.
+var indexKeyspaces = []string{SimHashIndexKeyspace}
+
+var (
+	ErrNotFound         = errors.New("key not found")
+	ErrClosed           = errors.New("engine is closed")
+	ErrLocked           = errors.New("data directory is used by another engine")
+	ErrCorruption       = structures.ErrCorruption
+	ErrNotProvable      = structures.ErrNotProvable
+	ErrInvalidRange     = errors.New("range start must be before its end")
+	ErrKeyspaceExists   = errors.New("keyspace already exists")
+	ErrKeyspaceNotFound = errors.New("keyspace does not exist")
+	ErrKeyspaceDropped  = errors.New("keyspace was dropped")
+	ErrInvalidKeyspace  = errors.New("invalid keyspace name")
+	ErrDistanceTooLarge = errors.New("distance is larger than the SimHash index answers")
+)
+
+var keyspaceName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Options configure an engine opened with Open.
+type Options struct {
+	// Config is used as is when it is set. Otherwise the file at ConfigPath is read,
+	// and without a ConfigPath the values of config.DefaultConfig are used.
+	Config     *config.Config
+	ConfigPath string
+	// Force lets Repair allow reads of keys that were lost with quarantined tables. What is
+	// left of them in the other tables is what they hold from then on. Open ignores it.
+	Force bool
+}
+
+func (opts Options) config() (*config.Config, error) {
+	if opts.Config != nil {
+		return opts.Config, nil
+	}
+	if opts.ConfigPath != "" {
+		return config.ReadConfig(opts.ConfigPath)
+	}
+	return config.DefaultConfig(), nil
+}
+
 type Engine struct {
-	Wal         *structures.WriteAheadLog
-	memTable    *structures.MemoryTable
-	cache       *structures.LRUCache
-	lsm         *structures.LSMTree
-	TokenBucket *structures.RateLimiter
-	Config      *config.Config
-}
-
-func (e *Engine) Init() {
-	e.Config = config.GetSystemConfig()
-	e.Wal = structures.NewWriteAheadLog(structures.WalPath)
-	e.memTable = structures.NewMemoryTable(e.Config.MemTableParameters.SkipListMaxHeight,
-		uint(e.Config.MemTableParameters.MaxMemTableSize),
-		uint(e.Config.MemTableParameters.MemTableThreshold))
-	e.cache = structures.NewLRUCache(e.Config.CacheParameters.CacheMaxData)
-	e.lsm = structures.NewLSMTree(e.Config.LSMParameters.LSMMaxLevel, e.Config.LSMParameters.LSMLevelSize)
+	Wal          *structures.WriteAheadLog
+	keyspaces    map[string]*Keyspace
+	TokenBucket  *structures.RateLimiter
+	Config       *config.Config
+	directory    string
+	tables       structures.TableOptions
+	simHash      structures.SimHash
+	simHashIndex structures.SimHashIndex
+	simHashLock  sync.Mutex // keeps the SimHash index in step with the fingerprints
+	scrubber     *scrubber
+	fileLock     *lockFile
+	lock         sync.Mutex
+	closed       bool
+}
+
+// Open opens the store in dir, creating it if needed. Every file of the store lives under
+// dir, and a lock file keeps other engines, in this or another process, from opening it too.
+// Each engine has its own merge operators, so the sketches and filters it creates have
+// the HLL, CMS and filter parameters of its own configuration.
+func Open(dir string, opts Options) (*Engine, error) {
+	cfg, err := opts.config()
+	if err != nil {
+		return nil, err
+	}
+	if err = cfg.ScrubberParameters.Validate(); err != nil {
+		return nil, err
+	}
+	directory := strings.TrimSuffix(dir, "/") + "/"
+	if err = os.MkdirAll(directory+"wal/", 0755); err != nil {
+		return nil, err
+	}
+	fileLock, err := acquireLock(directory + "LOCK")
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Engine{Config: cfg, directory: directory, fileLock: fileLock}
+	if err = e.init(); err != nil {
+		_ = fileLock.release()
+		return nil, err
+	}
+	e.scrubber.start()
+	return e, nil
+}
+
+func (e *Engine) init() error {
+	merkleHash, err := structures.ParseHashFunction(e.Config.MerkleParameters.HashFunction)
+	if err != nil {
+		return err
+	}
+	e.tables = structures.TableOptions{MerkleHash: merkleHash, Operators: newMergeOperators(e.Config)}
+	if e.simHash, err = newSimHash(e.Config.SimHashParameters); err != nil {
+		return err
+	}
+	e.simHashIndex, err = structures.NewSimHashIndex(e.Config.SimHashParameters.SimHashBits,
+		e.Config.SimHashParameters.SimHashIndexDistance)
+	if err != nil {
+		return err
+	}
+
+	positions, err := e.readWalPositions()
+	if err != nil {
+		return err
+	}
+	if positions == nil {
+		if err = e.removeLegacySegments(); err != nil {
+			return err
+		}
+	}
+	// Replay starts at the oldest record that some keyspace has not flushed yet.
+	start, first := uint64(0), true
+	for _, position := range positions {
+		if first || position < start {
+			start, first = position, false
+		}
+	}
+	var records []structures.WalRecord
+	if e.Wal, records, err = structures.OpenWriteAheadLog(e.directory+"wal/", start); err != nil {
+		return err
+	}
 	rate := int64(e.Config.TokenBucketParameters.TokenBucketInterval)
 	e.TokenBucket = structures.NewRateLimiter(rate, e.Config.TokenBucketParameters.TokenBucketMaxTokens)
+	e.scrubber = newScrubber(e)
+
+	e.keyspaces = make(map[string]*Keyspace)
+	_, err = os.Stat(e.directory + "keyspaces.json")
+	hadRegistry := err == nil
+	registry, err := e.readKeyspaceRegistry()
+	if err != nil {
+		return err
+	}
+	for name, settings := range registry {
+		if _, err = e.openKeyspace(name, settings); err != nil {
+			return err
+		}
+	}
+	for _, name := range reservedKeyspaces() {
+		if _, ok := e.keyspaces[name]; !ok {
+			if _, err = e.openKeyspace(name, e.KeyspaceSettings()); err != nil {
+				return err
+			}
+		}
+	}
+	if err = e.writeKeyspaceRegistry(); err != nil {
+		return err
+	}
+	if err = e.replay(records, positions); err != nil {
+		return err
+	}
+	if err = e.migrate(hadRegistry); err != nil {
+		return err
+	}
+	return e.checkSimHashIndex()
 }
 
-func (e *Engine) Put(key string, value []byte, tombstone bool) bool {
+// Close stops the scrubber, flushes the memory tables of all keyspaces to SSTables, syncs
+// the write-ahead log and releases the data directory. Every later call on the engine returns ErrClosed.
+func (e *Engine) Close() error {
+	e.scrubber.shutdown()
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.closed {
+		return ErrClosed
+	}
 
-	elem := structures.Element{
-		Key:       key,
-		Value:     value,
-		NextNodes: nil,
-		Timestamp: time.Now().String(),
-		Tombstone: tombstone,
-		Checksum:  structures.CRC32(value),
-	}
-	e.Wal.PutElement(&elem)
-	e.memTable.Insert(key, value, tombstone)
-	e.cache.Put(key, value)
-
-	if e.memTable.ShouldFlush() {
-		e.memTable.PerformFlush()
-		e.Wal.RemoveOldSegments()
-		e.lsm.PerformCompaction("system/data/sstable/", 1)
-		e.memTable = structures.NewMemoryTable(e.Config.MemTableParameters.SkipListMaxHeight,
-			uint(e.Config.MemTableParameters.MaxMemTableSize),
-			uint(e.Config.MemTableParameters.MemTableThreshold))
-	}
-
-	return true
-}
-
-func (e *Engine) Get(key string) (bool, []byte) {
-	ok, deleted, value := e.memTable.Lookup(key)
-	if ok && deleted {
-		return false, nil
-	} else if ok {
-		e.cache.Put(key, value)
-		//fmt.Println("Found in memtable.")
-		return true, value
-	}
-	ok, value = e.cache.Get(key)
-	if ok {
-		//fmt.Println("Found in cache.")
-		e.cache.Put(key, value)
-		return true, value
-	}
-	ok, value = structures.SearchThroughSSTables(key, e.Config.LSMParameters.LSMMaxLevel)
-	if ok {
-		//fmt.Println("Found in sstable.")
-		e.cache.Put(key, value)
-		//if strings.Trim(string(value), " ") != "" {
-		//	return true, value
-		//}
-		return true, value
-	}
-	return false, nil
-}
-
-func (e *Engine) Delete(key string) bool {
-	if e.memTable.Erase(key) {
-		e.cache.Delete(key)
-		return true
-	}
-	if e.memTable.Erase("hll-" + key) {
-		e.cache.Delete("hll-" + key)
-		return true
-	}
-	if e.memTable.Erase("cms-" + key) {
-		e.cache.Delete("cms-" + key)
-		return true
-	}
-	ok, value := e.Get(key)
+	var err error
+	for _, ks := range e.keyspaces {
+		if flushErr := ks.flush(); flushErr != nil && err == nil {
+			err = flushErr
+		}
+	}
+	if walErr := e.Wal.PersistCurrentSegment(); walErr != nil && err == nil {
+		err = walErr
+	}
+	if walErr := e.removeFlushedSegments(); walErr != nil && err == nil {
+		err = walErr
+	}
+	e.closed = true
+	if lockErr := e.fileLock.release(); lockErr != nil && err == nil {
+		err = lockErr
+	}
+	return err
+}
+
+// Directory returns the data directory of the engine.
+func (e *Engine) Directory() string {
+	return e.directory
+}
+
+// KeyspaceSettings returns the settings from the configuration file, used for new keyspaces.
+func (e *Engine) KeyspaceSettings() KeyspaceSettings {
+	return KeyspaceSettings{
+		LSMParameters:      e.Config.LSMParameters,
+		MemTableParameters: e.Config.MemTableParameters,
+	}
+}
+
+// newSimHash returns the SimHash that fingerprints texts with the configured pipeline.
+func newSimHash(cfg config.SimHashConfig) (structures.SimHash, error) {
+	tokenizer, err := structures.ParseTokenizer(cfg.SimHashTokenizer)
+	if err != nil {
+		return structures.SimHash{}, err
+	}
+	var stopWords map[string]bool
+	if cfg.SimHashStopWords != "" {
+		if stopWords, err = structures.LoadStopWords(cfg.SimHashStopWords); err != nil {
+			return structures.SimHash{}, fmt.Errorf("SimHash stop words: %w", err)
+		}
+	}
+	return structures.NewSimHash(structures.SimHashOptions{Size: cfg.SimHashBits, Tokenizer: tokenizer,
+		StopWords: stopWords, Shingle: cfg.SimHashShingle, Weights: cfg.SimHashWeights})
+}
+
+// newMergeOperators returns the merge operators of an engine, with the sketch and filter
+// parameters of its configuration.
+func newMergeOperators(cfg *config.Config) structures.MergeOperators {
+	operators := structures.NewMergeOperators()
+	operators.Register(structures.NewHLLAddOperator(uint8(cfg.HLLParameters.HLLPrecision)))
+	operators.Register(structures.NewCMSAddOperator(cfg.CSMParameters.CSMPrecision, cfg.CSMParameters.CSMAccuracy))
+	operators.Register(structures.NewBloomAddOperator(uint(cfg.BloomParameters.BloomCapacity),
+		cfg.BloomParameters.BloomFalsePositive))
+	capacity := uint(cfg.CuckooParameters.CuckooCapacity)
+	operators.Register(structures.NewCuckooAddOperator(capacity))
+	operators.Register(structures.NewCuckooDeleteOperator(capacity))
+	return operators
+}
+
+// reservedKeyspaces returns the builtin and index keyspaces.
+func reservedKeyspaces() []string {
+	return append(append([]string(nil), builtinKeyspaces...), indexKeyspaces...)
+}
+
+// isBuiltinKeyspace tells whether a keyspace is one of the builtin or index keyspaces.
+func isBuiltinKeyspace(name string) bool {
+	for _, builtin := range reservedKeyspaces() {
+		if name == builtin {
+			return true
+		}
+	}
+	return false
+}
+
+// keyspaceDirectory returns where the files of a keyspace live. The default keyspace
+// keeps the layout from before keyspaces existed.
+func (e *Engine) keyspaceDirectory(name string) string {
+	if name == DefaultKeyspace {
+		return e.directory
+	}
+	return e.directory + "keyspaces/" + name + "/"
+}
+
+func (e *Engine) openKeyspace(name string, settings KeyspaceSettings) (*Keyspace, error) {
+	tables, err := e.tableOptions(settings)
+	if err != nil {
+		return nil, fmt.Errorf("keyspace %s: %w", name, err)
+	}
+	directory := e.keyspaceDirectory(name)
+	if err := os.MkdirAll(structures.SSTableDirectory(directory), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(structures.MetadataDirectory(directory), 0755); err != nil {
+		return nil, err
+	}
+	ks := newKeyspace(e, name, directory, settings, tables)
+	if ks.quarantined, err = readQuarantinedRanges(directory); err != nil {
+		return nil, err
+	}
+	e.keyspaces[name] = ks
+	return ks, nil
+}
+
+func (e *Engine) readKeyspaceRegistry() (map[string]KeyspaceSettings, error) {
+	registry := make(map[string]KeyspaceSettings)
+	jsonBytes, err := ioutil.ReadFile(e.directory + "keyspaces.json")
+	if errors.Is(err, os.ErrNotExist) {
+		return registry, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(jsonBytes, &registry); err != nil {
+		return nil, fmt.Errorf("%w: %skeyspaces.json: %v", ErrCorruption, e.directory, err)
+	}
+	return registry, nil
+}
+
+func (e *Engine) writeKeyspaceRegistry() error {
+	registry := make(map[string]KeyspaceSettings)
+	for name, ks := range e.keyspaces {
+		registry[name] = ks.settings
+	}
+	file, _ := json.MarshalIndent(registry, "", "  ")
+	return ioutil.WriteFile(e.directory+"keyspaces.json", file, 0644)
+}
+
+// CreateKeyspace adds a keyspace with its own memory table, SSTables and compaction settings.
+func (e *Engine) CreateKeyspace(name string, settings KeyspaceSettings) (*Keyspace, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.closed {
+		return nil, ErrClosed
+	}
+	if !keyspaceName.MatchString(name) {
+		return nil, ErrInvalidKeyspace
+	}
+	if _, ok := e.keyspaces[name]; ok {
+		return nil, ErrKeyspaceExists
+	}
+	ks, err := e.openKeyspace(name, settings)
+	if err != nil {
+		return nil, err
+	}
+	if err = e.writeKeyspaceRegistry(); err != nil {
+		delete(e.keyspaces, name)
+		return nil, err
+	}
+	// Records of a dropped keyspace with the same name must not be replayed into this one.
+	if err = e.removeFlushedSegments(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// DropKeyspace deletes a keyspace together with all of its data. The default, HLL, CMS,
+// filter, SimHash and index keyspaces cannot be dropped.
+func (e *Engine) DropKeyspace(name string) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.closed {
+		return ErrClosed
+	}
+	if isBuiltinKeyspace(name) {
+		return ErrInvalidKeyspace
+	}
+	ks, ok := e.keyspaces[name]
 	if !ok {
-		keyHLL := "hll-" + key
-		ok, value = e.Get(keyHLL)
-		if !ok {
-			keyCMS := "cms-" + key
-			ok, value = e.Get(keyCMS)
-			if !ok {
-				return false
-			} else {
-				key = keyCMS
+		return ErrKeyspaceNotFound
+	}
+	delete(e.keyspaces, name)
+	if err := e.writeKeyspaceRegistry(); err != nil {
+		e.keyspaces[name] = ks
+		return err
+	}
+	ks.dropped = true
+	if err := e.removeFlushedSegments(); err != nil {
+		return err
+	}
+	return os.RemoveAll(ks.directory)
+}
+
+// ListKeyspaces returns the names of all keyspaces, sorted.
+func (e *Engine) ListKeyspaces() []string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	names := make([]string, 0, len(e.keyspaces))
+	for name := range e.keyspaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Keyspace returns the named keyspace, or nil if it does not exist.
+func (e *Engine) Keyspace(name string) *Keyspace {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.keyspaces[name]
+}
+
+// Verify checks the SSTables of every keyspace and returns their reports by keyspace name.
+func (e *Engine) Verify() (map[string][]structures.TableReport, error) {
+	reports := make(map[string][]structures.TableReport)
+	for _, name := range e.ListKeyspaces() {
+		ks := e.Keyspace(name)
+		if ks == nil {
+			continue // dropped in the meantime
+		}
+		keyspaceReports, err := ks.Verify()
+		if errors.Is(err, ErrKeyspaceDropped) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("verify keyspace %s: %w", name, err)
+		}
+		reports[name] = keyspaceReports
+	}
+	return reports, nil
+}
+
+// Diff compares the SSTables of the engine with those of other, a replica, and returns the
+// key ranges that differ by keyspace name. Memory tables of both engines are flushed first,
+// so every record is in an SSTable.
+func (e *Engine) Diff(other *Engine) (map[string][]structures.KeyRange, error) {
+	diffs := make(map[string][]structures.KeyRange)
+	if e == other {
+		return diffs, nil
+	}
+	// Both engines are locked in the order of their directories, so two calls in opposite
+	// directions cannot wait for each other.
+	first, second := e, other
+	if first.directory > second.directory {
+		first, second = second, first
+	}
+	first.lock.Lock()
+	defer first.lock.Unlock()
+	second.lock.Lock()
+	defer second.lock.Unlock()
+	if e.closed || other.closed {
+		return nil, ErrClosed
+	}
+
+	for _, engine := range []*Engine{e, other} {
+		for _, ks := range engine.keyspaces {
+			if err := ks.flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	names := make(map[string]bool)
+	for name := range e.keyspaces {
+		names[name] = true
+	}
+	for name := range other.keyspaces {
+		names[name] = true
+	}
+	for name := range names {
+		// A keyspace that exists on one side only is compared with an empty directory.
+		maxLevels := 0
+		for _, engine := range []*Engine{e, other} {
+			if ks, ok := engine.keyspaces[name]; ok && ks.settings.LSMParameters.LSMMaxLevel > maxLevels {
+				maxLevels = ks.settings.LSMParameters.LSMMaxLevel
 			}
-		} else {
-			key = keyHLL
 		}
+		ranges, err := structures.DiffSSTables(e.keyspaceDirectory(name), other.keyspaceDirectory(name), maxLevels)
+		if err != nil {
+			return nil, fmt.Errorf("diff of keyspace %s: %w", name, err)
+		}
+		if len(ranges) > 0 {
+			diffs[name] = ranges
+		}
+	}
+	return diffs, nil
+}
+
+// removeFlushedSegments saves for every keyspace where the replay of the WAL has to start
+// and deletes the segments before the oldest of these positions.
+func (e *Engine) removeFlushedSegments() error {
+	current := e.Wal.Position()
+	oldest := current
+	positions := make(map[string]uint64)
+	for name, ks := range e.keyspaces {
+		positions[name] = current
+		if ks.memTable.CurrentSize() == 0 && len(ks.memTable.RangeTombstones()) == 0 {
+			continue
+		}
+		positions[name] = ks.walStart
+		if ks.walStart < oldest {
+			oldest = ks.walStart
+		}
+	}
+	if err := e.writeWalPositions(positions); err != nil {
+		return err
 	}
-	e.Put(key, value, true)
-	e.cache.Delete(key)
-	return true
+	return e.Wal.RemoveSegmentsBefore(oldest / structures.SegmentCapacity)
 }
 
-func (e *Engine) Edit(key string, value []byte) bool {
-	e.memTable.Modify(key, value, false)
+// Write applies all writes of the batch, possibly to several keyspaces, as one WAL record.
+// Either every keyspace in the batch exists and all writes are applied, or none is.
+func (e *Engine) Write(batch *Batch) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.write(batch)
+}
+
+// write is Write for callers that already hold the engine lock.
+func (e *Engine) write(batch *Batch) error {
+	if e.closed {
+		return ErrClosed
+	}
+
+	for _, record := range batch.records {
+		if _, ok := e.keyspaces[record.Keyspace]; !ok {
+			return ErrKeyspaceNotFound
+		}
+	}
+	if len(batch.records) == 0 {
+		return nil
+	}
+
+	for _, record := range batch.records {
+		if err := e.keyspaces[record.Keyspace].flushIfNeeded(); err != nil {
+			return err
+		}
+	}
+	for _, record := range batch.records {
+		e.keyspaces[record.Keyspace].beginWrite()
+	}
 	elem := structures.Element{
-		Key:       key,
-		Value:     value,
+		Value:     batch.encode(),
 		NextNodes: nil,
 		Timestamp: time.Now().String(),
-		Tombstone: false,
-		Checksum:  structures.CRC32(value),
+		Batch:     true,
+	}
+	elem.Checksum = structures.CRC32(elem.Value)
+	if err := e.Wal.PutElement(&elem); err != nil {
+		return err
+	}
+
+	for _, record := range batch.records {
+		ks := e.keyspaces[record.Keyspace]
+		ks.memTable.Insert(record.Key, record.Value, record.Tombstone)
+		if record.Tombstone {
+			ks.cache.Delete(record.Key)
+		} else {
+			ks.cache.Put(record.Key, record.Value)
+		}
 	}
+	return nil
+}
 
-	e.Wal.PutElement(&elem)
+func (e *Engine) defaultKeyspace() *Keyspace {
+	return e.Keyspace(DefaultKeyspace)
+}
+
+// The methods below work on the default keyspace.
 
-	e.cache.Put(key, value)
+func (e *Engine) Put(key string, value []byte, tombstone bool) error {
+	return e.defaultKeyspace().Put(key, value, tombstone)
+}
+
+func (e *Engine) Merge(key string, operand []byte) error {
+	return e.defaultKeyspace().Merge(key, operand)
+}
+
+func (e *Engine) Get(key string) ([]byte, error) {
+	return e.defaultKeyspace().Get(key)
+}
+
+func (e *Engine) MultiGet(keys []string) (found []bool, values [][]byte, err error) {
+	return e.defaultKeyspace().MultiGet(keys)
+}
+
+// Delete deletes key from the default keyspace. Keys of other keyspaces are deleted with
+// Keyspace(name).Delete, and SimHash fingerprints with DeleteSimHash.
+func (e *Engine) Delete(key string) error {
+	return e.defaultKeyspace().Delete(key)
+}
+
+func (e *Engine) Proof(key string) (value, root []byte, proof *structures.MerkleProof, err error) {
+	return e.defaultKeyspace().Proof(key)
+}
+
+func (e *Engine) BloomStats() structures.BloomStats {
+	return e.defaultKeyspace().BloomStats()
+}
+
+func (e *Engine) DeleteRange(start, end string) error {
+	return e.defaultKeyspace().DeleteRange(start, end)
+}
 
-	return true
+func (e *Engine) Scan(start, end string) ([]string, [][]byte, error) {
+	return e.defaultKeyspace().Scan(start, end)
 }
 
-func (e *Engine) GetAsString(key string) string {
-	ok, val := e.Get(key)
-	var value string
+func (e *Engine) ScanPrefix(prefix string) ([]string, [][]byte, error) {
+	return e.defaultKeyspace().ScanPrefix(prefix)
+}
+
+func (e *Engine) Edit(key string, value []byte) error {
+	return e.defaultKeyspace().Edit(key, value)
+}
+
+func (e *Engine) CompareAndSwap(key string, expected, value []byte) (bool, error) {
+	return e.defaultKeyspace().CompareAndSwap(key, expected, value)
+}
+
+func (e *Engine) PutIfAbsent(key string, value []byte) (bool, error) {
+	return e.defaultKeyspace().PutIfAbsent(key, value)
+}
+
+func (e *Engine) DeleteIfEquals(key string, expected []byte) (bool, error) {
+	return e.defaultKeyspace().DeleteIfEquals(key, expected)
+}
+
+func (e *Engine) Incr(key string, delta int64) (int64, error) {
+	return e.defaultKeyspace().Incr(key, delta)
+}
+
+func (e *Engine) Decr(key string, delta int64) (int64, error) {
+	return e.defaultKeyspace().Decr(key, delta)
+}
+
+func (e *Engine) GetCounter(key string) (int64, error) {
+	data, err := e.defaultKeyspace().GetAs(key, structures.TypeCounter)
+	if err != nil {
+		return 0, err
+	}
+	value, _ := structures.DecodeCounter(structures.TypeCounter, data)
+	return value, nil
+}
+
+// The methods below work on the HyperLogLogs, CountMinSketches, CuckooFilters, BloomFilters and
+// SimHash fingerprints, which live in their own keyspaces.
+
+func (e *Engine) PutHLL(key string, hll *structures.HyperLogLog) error {
+	return e.Keyspace(HLLKeyspace).PutTyped(key, structures.TypeHLL, hll.SerializeHLL(), false)
+}
+
+// AddToHLL adds item to the HyperLogLog under key, creating it if needed.
+func (e *Engine) AddToHLL(key, item string) error {
+	return e.Keyspace(HLLKeyspace).Merge(key, structures.HLLAddOperand(item))
+}
+
+func (e *Engine) GetHLL(key string) (*structures.HyperLogLog, error) {
+	data, err := e.Keyspace(HLLKeyspace).GetAs(key, structures.TypeHLL)
+	if err != nil {
+		return nil, err
+	}
+	return decodeHLL(key, data)
+}
+
+// getHLL is GetHLL for callers that already hold the engine lock.
+func (ks *Keyspace) getHLL(key string) (*structures.HyperLogLog, error) {
+	ok, value, err := ks.get(key)
+	if err != nil {
+		return nil, err
+	}
 	if !ok {
-		ok, val = e.Get("hll-" + key)
-		if ok {
-			hll := structures.DeserializeHLL(val)
-			value = "It's a HLL with Estimation: " + fmt.Sprintf("%f", hll.Evaluate())
-		} else {
-			ok, val = e.Get("cms-" + key)
-			if ok {
-				value = "It's a CMS"
-			}
-			if !ok {
-				value = "Data with given key does not exist !"
-			}
+		return nil, ErrNotFound
+	}
+	valueType, data := structures.DecodeValue(value)
+	if valueType != structures.TypeHLL {
+		return nil, &structures.TypeMismatchError{Key: key, Expected: structures.TypeHLL, Actual: valueType}
+	}
+	return decodeHLL(key, data)
+}
+
+func decodeHLL(key string, data []byte) (*structures.HyperLogLog, error) {
+	hll, err := structures.DeserializeHLL(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: HyperLogLog %q: %v", ErrCorruption, key, err)
+	}
+	return hll, nil
+}
+
+// getHLLs returns the HyperLogLogs under keys, in the same order.
+func (e *Engine) getHLLs(keys []string) ([]*structures.HyperLogLog, error) {
+	hlls := make([]*structures.HyperLogLog, len(keys))
+	for i, key := range keys {
+		hll, err := e.GetHLL(key)
+		if err != nil {
+			return nil, fmt.Errorf("HyperLogLog %q: %w", key, err)
+		}
+		hlls[i] = hll
+	}
+	return hlls, nil
+}
+
+// HLLUnion estimates how many distinct items the HyperLogLogs under keys hold together,
+// e.g. the unique users of several campaigns. The HyperLogLogs must have the same precision.
+func (e *Engine) HLLUnion(keys []string) (structures.SetEstimate, error) {
+	hlls, err := e.getHLLs(keys)
+	if err != nil {
+		return structures.SetEstimate{}, err
+	}
+	return structures.EstimateUnion(hlls)
+}
+
+// StoreHLLUnion is HLLUnion that also stores the union under dest, which may be one of keys.
+// The sources are read and dest is written under one hold of the engine lock, so the stored
+// union reflects a single point in time and no write can slip in between.
+func (e *Engine) StoreHLLUnion(dest string, keys []string) (structures.SetEstimate, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	ks, ok := e.keyspaces[HLLKeyspace]
+	if !ok {
+		return structures.SetEstimate{}, ErrKeyspaceNotFound
+	}
+	if err := ks.usable(); err != nil {
+		return structures.SetEstimate{}, err
+	}
+	hlls := make([]*structures.HyperLogLog, len(keys))
+	for i, key := range keys {
+		hll, err := ks.getHLL(key)
+		if err != nil {
+			return structures.SetEstimate{}, fmt.Errorf("HyperLogLog %q: %w", key, err)
+		}
+		hlls[i] = hll
+	}
+	union, err := structures.UnionHLL(hlls)
+	if err != nil {
+		return structures.SetEstimate{}, err
+	}
+	batch := NewBatch()
+	batch.PutTyped(HLLKeyspace, dest, structures.TypeHLL, union.SerializeHLL())
+	if err = e.write(batch); err != nil {
+		return structures.SetEstimate{}, err
+	}
+	estimate := union.Evaluate()
+	return structures.SetEstimate{Estimate: estimate, Error: estimate * union.StandardError()}, nil
+}
+
+// HLLIntersection estimates how many items are in every one of the HyperLogLogs under keys,
+// at most structures.MaxIntersectionHLLs of them, by inclusion and exclusion of their unions.
+func (e *Engine) HLLIntersection(keys []string) (structures.SetEstimate, error) {
+	hlls, err := e.getHLLs(keys)
+	if err != nil {
+		return structures.SetEstimate{}, err
+	}
+	return structures.EstimateIntersection(hlls)
+}
+
+func (e *Engine) PutCMS(key string, cms *structures.CountMinSketch) error {
+	return e.Keyspace(CMSKeyspace).PutTyped(key, structures.TypeCMS, cms.SerializeCMS(), false)
+}
+
+// AddToCMS adds item to the CountMinSketch under key, creating it if needed.
+func (e *Engine) AddToCMS(key, item string) error {
+	return e.Keyspace(CMSKeyspace).Merge(key, structures.CMSAddOperand(item))
+}
+
+func (e *Engine) GetCMS(key string) (*structures.CountMinSketch, error) {
+	data, err := e.Keyspace(CMSKeyspace).GetAs(key, structures.TypeCMS)
+	if err != nil {
+		return nil, err
+	}
+	cms, err := structures.DeserializeCMS(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: CountMinSketch %q: %v", ErrCorruption, key, err)
+	}
+	return cms, nil
+}
+
+func (e *Engine) PutCuckoo(key string, cf *structures.CuckooFilter) error {
+	return e.Keyspace(CuckooKeyspace).PutTyped(key, structures.TypeCuckooFilter, cf.SerializeCF(), false)
+}
+
+// AddToCuckoo adds item to the CuckooFilter under key, creating it if needed.
+func (e *Engine) AddToCuckoo(key, item string) error {
+	return e.Keyspace(CuckooKeyspace).Merge(key, structures.CuckooAddOperand(item))
+}
+
+// DeleteFromCuckoo removes item from the CuckooFilter under key.
+func (e *Engine) DeleteFromCuckoo(key, item string) error {
+	return e.Keyspace(CuckooKeyspace).Merge(key, structures.CuckooDeleteOperand(item))
+}
+
+func (e *Engine) GetCuckoo(key string) (*structures.CuckooFilter, error) {
+	data, err := e.Keyspace(CuckooKeyspace).GetAs(key, structures.TypeCuckooFilter)
+	if err != nil {
+		return nil, err
+	}
+	cf, err := structures.DeserializeCF(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cuckoo filter %q: %v", ErrCorruption, key, err)
+	}
+	return cf, nil
+}
+
+func (e *Engine) PutBloom(key string, bf *structures.BloomFilter) error {
+	return e.Keyspace(BloomKeyspace).PutTyped(key, structures.TypeBloomFilter, bf.SerializeBF(), false)
+}
+
+// AddToBloom adds item to the BloomFilter under key, creating it if needed.
+func (e *Engine) AddToBloom(key, item string) error {
+	return e.Keyspace(BloomKeyspace).Merge(key, structures.BloomAddOperand(item))
+}
+
+func (e *Engine) GetBloom(key string) (*structures.BloomFilter, error) {
+	data, err := e.Keyspace(BloomKeyspace).GetAs(key, structures.TypeBloomFilter)
+	if err != nil {
+		return nil, err
+	}
+	bf, err := structures.DeserializeBF(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bloom filter %q: %v", ErrCorruption, key, err)
+	}
+	return bf, nil
+}
+
+// PutSimHash stores the SimHash fingerprint of text under key, adds it to the SimHash index
+// and returns it. Fingerprints have the size in bits set by the configuration.
+func (e *Engine) PutSimHash(key, text string) (structures.Fingerprint, error) {
+	fingerprint := structures.GenerateTextFromString(text, e.simHash).Fingerprint()
+	e.simHashLock.Lock()
+	defer e.simHashLock.Unlock()
+	old, err := e.GetSimHash(key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return structures.Fingerprint{}, err
+	}
+
+	batch := NewBatch()
+	batch.PutTyped(SimHashKeyspace, key, structures.TypeSimHash, structures.EncodeFingerprint(fingerprint))
+	if err == nil {
+		e.unindexSimHash(batch, key, old)
+	}
+	e.indexSimHash(batch, key, fingerprint)
+	return fingerprint, e.Write(batch)
+}
+
+func (e *Engine) GetSimHash(key string) (structures.Fingerprint, error) {
+	data, err := e.Keyspace(SimHashKeyspace).GetAs(key, structures.TypeSimHash)
+	if err != nil {
+		return structures.Fingerprint{}, err
+	}
+	fingerprint, err := structures.DecodeFingerprint(data)
+	if err != nil {
+		return structures.Fingerprint{}, fmt.Errorf("%w: fingerprint %q: %v", ErrCorruption, key, err)
+	}
+	return fingerprint, nil
+}
+
+// SimHashDistance returns the Hamming distance between the fingerprints under key1 and key2:
+// the number of bits in which they differ, where texts that are alike are close. It returns
+// structures.ErrFingerprintSize if the fingerprints were stored with different sizes.
+func (e *Engine) SimHashDistance(key1, key2 string) (int, error) {
+	fingerprint1, err := e.GetSimHash(key1)
+	if err != nil {
+		return 0, err
+	}
+	fingerprint2, err := e.GetSimHash(key2)
+	if err != nil {
+		return 0, err
+	}
+	return fingerprint1.Distance(fingerprint2)
+}
+
+// GetAsString describes the value of key in the named keyspace according to its type tag.
+func (e *Engine) GetAsString(keyspace, key string) (string, error) {
+	ks := e.Keyspace(keyspace)
+	if ks == nil {
+		return "", ErrKeyspaceNotFound
+	}
+	valueType, data, err := ks.GetTyped(key)
+	if err != nil {
+		return "", err
+	}
+	switch valueType {
+	case structures.TypeRaw:
+		return string(data), nil
+	case structures.TypeCounter:
+		value, _ := structures.DecodeCounter(structures.TypeCounter, data)
+		return strconv.FormatInt(value, 10), nil
+	case structures.TypeHLL:
+		hll, err := structures.DeserializeHLL(data)
+		if err != nil {
+			return "", fmt.Errorf("%w: HyperLogLog %q: %v", ErrCorruption, key, err)
+		}
+		return "It's a HLL with Estimation: " + fmt.Sprintf("%f", hll.Evaluate()), nil
+	case structures.TypeCuckooFilter:
+		cf, err := structures.DeserializeCF(data)
+		if err != nil {
+			return "", fmt.Errorf("%w: cuckoo filter %q: %v", ErrCorruption, key, err)
+		}
+		return fmt.Sprintf("It's a CuckooFilter with %d items", cf.Count), nil
+	case structures.TypeBloomFilter:
+		bf, err := structures.DeserializeBF(data)
+		if err != nil {
+			return "", fmt.Errorf("%w: bloom filter %q: %v", ErrCorruption, key, err)
+		}
+		return fmt.Sprintf("It's a BloomFilter of %d bits with %d hash functions", bf.M, bf.K), nil
+	case structures.TypeSimHash:
+		fingerprint, err := structures.DecodeFingerprint(data)
+		if err != nil {
+			return "", fmt.Errorf("%w: fingerprint %q: %v", ErrCorruption, key, err)
 		}
-	} else {
-		value = string(val)
+		return fmt.Sprintf("It's a %d-bit SimHash fingerprint %s", fingerprint.Size, fingerprint), nil
+	default:
+		return "It's a " + valueType.String(), nil
 	}
-	return value
 }
@@ -0,0 +1,605 @@
+package system
+
+import (
+	"KVSystem/config"
+	"KVSystem/system/structures"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// openTestEngine opens an engine in dir whose levels hold 4 tables, so flushed tables are
+// not compacted away.
+func openTestEngine(t *testing.T, dir string) *Engine {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.LSMParameters.LSMLevelSize = 4
+	e, err := Open(dir, Options{Config: cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func reopen(t *testing.T, e *Engine) *Engine {
+	t.Helper()
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return openTestEngine(t, e.Directory())
+}
+
+func TestMergeOverRangeDeletedValue(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	if err := e.Put("key", []byte("base"), false); err != nil {
+		t.Fatal(err)
+	}
+	e = reopen(t, e)
+	if err := e.DeleteRange("k", "l"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Merge("key", structures.AppendOperand([]byte("X"))); err != nil {
+		t.Fatal(err)
+	}
+	e = reopen(t, e)
+	defer e.Close()
+
+	if value, err := e.Get("key"); err != nil || string(value) != "X" {
+		t.Errorf("Get gives %q, %v, want X", value, err)
+	}
+	found, values, err := e.MultiGet([]string{"key"})
+	if err != nil || !found[0] || string(values[0]) != "X" {
+		t.Errorf("MultiGet gives %q, %v, want X", values, err)
+	}
+	keys, values, err := e.Scan("a", "z")
+	if err != nil || len(keys) != 1 || string(values[0]) != "X" {
+		t.Errorf("Scan gives %q %q, %v, want X", keys, values, err)
+	}
+}
+
+func TestIncrOfDecimalRawValue(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	defer e.Close()
+	// Eight digits are as long as a binary counter.
+	if err := e.Put("n", []byte("12345678"), false); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := e.Incr("n", 1); err != nil || value != 12345679 {
+		t.Errorf("Incr gives %d, %v, want 12345679", value, err)
+	}
+
+	if err := e.Put("m", []byte("12345678"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Merge("m", structures.CounterOperand(2)); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := e.GetCounter("m"); err != nil || value != 12345680 {
+		t.Errorf("merged counter is %d, %v, want 12345680", value, err)
+	}
+}
+
+func TestEnginesHaveTheirOwnMergeOperators(t *testing.T) {
+	engines := make([]*Engine, 2)
+	for i, precision := range []int{6, 10} {
+		cfg := config.DefaultConfig()
+		cfg.HLLParameters.HLLPrecision = precision
+		e, err := Open(t.TempDir(), Options{Config: cfg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer e.Close()
+		engines[i] = e
+	}
+	for i, precision := range []uint8{6, 10} {
+		if err := engines[i].AddToHLL("visits", "user"); err != nil {
+			t.Fatal(err)
+		}
+		hll, err := engines[i].GetHLL("visits")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hll.P != precision {
+			t.Errorf("engine %d created a HyperLogLog of precision %d, want %d", i, hll.P, precision)
+		}
+	}
+}
+
+func TestOpenStoreWithUntaggedValues(t *testing.T) {
+	// A store written before values had type tags and keyspaces: one table of the default
+	// keyspace, which holds HyperLogLogs under a prefix.
+	dir := t.TempDir() + "/"
+	for _, directory := range []string{structures.SSTableDirectory(dir), structures.MetadataDirectory(dir)} {
+		if err := os.MkdirAll(directory, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mt := structures.NewMemoryTable(5, 100, 80)
+	mt.Insert("text", []byte("hello"), false)
+	mt.Insert("tagged", []byte{byte(structures.TypeHLL), 'x'}, false)
+	mt.Insert("gone", []byte("old"), true)
+	hll := structures.CreateHyperLogLog(4)
+	hll.Add("user")
+	mt.Insert("hll-visits", hll.SerializeHLL(), false)
+	if err := mt.PerformFlush(dir, structures.TableOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		e := openTestEngine(t, dir)
+		if value, err := e.Get("text"); err != nil || string(value) != "hello" {
+			t.Errorf("text is %q, %v, want hello", value, err)
+		}
+		if value, err := e.Get("tagged"); err != nil || string(value) != "\x01x" {
+			t.Errorf("tagged is %q, %v, want \\x01x", value, err)
+		}
+		if _, err := e.Get("gone"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("deleted key gives %v", err)
+		}
+		if hll, err := e.GetHLL("visits"); err != nil || hll.Evaluate() < 0.5 {
+			t.Errorf("HyperLogLog was not moved to its keyspace: %v", err)
+		}
+		if _, err := e.Get("hll-visits"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("prefixed HyperLogLog is still in the default keyspace: %v", err)
+		}
+		reports, err := e.Verify()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, report := range reports[DefaultKeyspace] {
+			if report.Corrupted() {
+				t.Errorf("migrated table is not valid: %+v", report)
+			}
+		}
+		if err = e.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDeleteAndGetAsStringUseOneKeyspace(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	defer e.Close()
+	if err := e.Put("x", []byte("raw"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddToHLL("x", "user"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := e.GetAsString(HLLKeyspace, "x"); err != nil || !strings.HasPrefix(value, "It's a HLL") {
+		t.Errorf("HLL x is described as %q, %v", value, err)
+	}
+	if err := e.Delete("x"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.GetAsString(DefaultKeyspace, "x"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted x gives %v", err)
+	}
+	if _, err := e.GetHLL("x"); err != nil {
+		t.Errorf("Delete removed the HLL x too: %v", err)
+	}
+	if err := e.Delete("x"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete of x that is only an HLL gives %v, want ErrNotFound", err)
+	}
+	if _, err := e.GetAsString("missing", "x"); !errors.Is(err, ErrKeyspaceNotFound) {
+		t.Errorf("missing keyspace gives %v", err)
+	}
+}
+
+func TestDeleteOfDeletedKey(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	if err := e.Put("key", []byte("old"), false); err != nil {
+		t.Fatal(err)
+	}
+	e = reopen(t, e)
+	defer e.Close()
+	if err := e.Put("key", []byte("new"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Delete("key"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := e.Get("key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted key gives %q, %v", value, err)
+	}
+	if err := e.Delete("key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second delete gives %v, want ErrNotFound", err)
+	}
+}
+
+func TestReadsOfQuarantinedKeysFail(t *testing.T) {
+	dir := t.TempDir() + "/"
+	e := openTestEngine(t, dir)
+	for key, value := range map[string]string{"key": "old", "zz": "stale"} {
+		if err := e.Put(key, []byte(value), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e = reopen(t, e)
+	for key, value := range map[string]string{"key": "new", "zz": "lost"} {
+		if err := e.Put(key, []byte(value), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e = reopen(t, e)
+
+	// The value of the last record of the newer table no longer matches its checksum.
+	data, err := os.ReadFile(dir + "sstable/usertable-data-ic-2-lev1-Data.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err = os.WriteFile(dir+"sstable/usertable-data-ic-2-lev1-Data.db", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Scrub(); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.ScrubberStats().Quarantined) != 1 {
+		t.Fatalf("quarantined %v", e.ScrubberStats().Quarantined)
+	}
+
+	for i := 0; i < 2; i++ {
+		if value, err := e.Get("key"); !errors.Is(err, ErrCorruption) {
+			t.Errorf("quarantined key gives %q, %v", value, err)
+		}
+		if _, _, err := e.MultiGet([]string{"key"}); !errors.Is(err, ErrCorruption) {
+			t.Errorf("MultiGet of a quarantined key gives %v", err)
+		}
+		if _, _, err := e.Scan("a", "z"); !errors.Is(err, ErrCorruption) {
+			t.Errorf("Scan over a quarantined key gives %v", err)
+		}
+		if _, err := e.Get("other"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("key outside the quarantined table gives %v", err)
+		}
+		e = reopen(t, e)
+	}
+	if err = e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = Repair(dir, Options{Config: e.Config}); err != nil {
+		t.Fatal(err)
+	}
+	e = openTestEngine(t, dir)
+	defer func() { e.Close() }()
+	if value, err := e.Get("key"); err != nil || string(value) != "new" {
+		t.Errorf("after repair key is %q, %v, want the salvaged new", value, err)
+	}
+	// The record of zz could not be salvaged, and its older value is not the one it held.
+	if value, err := e.Get("zz"); !errors.Is(err, ErrCorruption) {
+		t.Errorf("after repair the unsalvaged key gives %q, %v", value, err)
+	}
+	if err = e.Put("zz", []byte("rewritten"), false); err != nil {
+		t.Fatal(err)
+	}
+	e = reopen(t, e)
+	if keys, values, err := e.Scan("a", "z~"); err != nil || len(keys) != 2 || string(values[1]) != "rewritten" {
+		t.Errorf("scan after zz was written again gives %v %q, %v", keys, values, err)
+	}
+}
+
+func TestRepairReleasesLostKeysOnlyWhenForced(t *testing.T) {
+	dir := t.TempDir() + "/"
+	e := openTestEngine(t, dir)
+	if err := e.Put("key", []byte("old"), false); err != nil {
+		t.Fatal(err)
+	}
+	e = reopen(t, e)
+	if err := e.Put("key", []byte("new"), false); err != nil {
+		t.Fatal(err)
+	}
+	e = reopen(t, e)
+
+	// Neither the index nor the data file of the newer table tells which keys it held.
+	for _, file := range []string{"data-ic-2-lev1-Data.db", "index-ic-2-lev1-Index.db"} {
+		if err := os.WriteFile(dir+"sstable/usertable-"+file, []byte{1, 2, 3}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Scrub(); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Repair(dir, Options{Config: e.Config}); err != nil {
+		t.Fatal(err)
+	}
+	e = openTestEngine(t, dir)
+	if value, err := e.Get("key"); !errors.Is(err, ErrCorruption) {
+		t.Errorf("lost key gives %q, %v after a repair", value, err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Repair(dir, Options{Config: e.Config, Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	e = openTestEngine(t, dir)
+	defer e.Close()
+	if value, err := e.Get("key"); err != nil || string(value) != "old" {
+		t.Errorf("lost key is %q, %v after a forced repair, want old", value, err)
+	}
+}
+
+func TestScrubberRateMustBePositive(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.ScrubberParameters.ScrubberTablesPerSecond = 0
+	if e, err := Open(t.TempDir(), Options{Config: cfg}); err == nil {
+		e.Close()
+		t.Error("a scrubber rate of 0 was accepted")
+	}
+}
+
+func TestStoreHLLUnionIntoSource(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	defer e.Close()
+	for _, item := range []string{"a", "b", "c"} {
+		if err := e.AddToHLL("first", item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, item := range []string{"c", "d"} {
+		if err := e.AddToHLL("second", item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	union, err := e.StoreHLLUnion("first", []string{"first", "second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := e.GetHLL("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if estimate := stored.Evaluate(); estimate != union.Estimate {
+		t.Errorf("stored union estimates %v, StoreHLLUnion returned %v", estimate, union.Estimate)
+	}
+	if _, err = e.StoreHLLUnion("third", []string{"first", "missing"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("union with a missing source gives %v", err)
+	}
+	if _, err = e.GetHLL("third"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("failed union stored third: %v", err)
+	}
+}
+
+func TestHLLUnionAndIntersection(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	defer e.Close()
+	for i := 0; i < 300; i++ {
+		if err := e.AddToHLL("first", "user"+strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.AddToHLL("second", "user"+strconv.Itoa(i+200)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	union, err := e.HLLUnion([]string{"first", "second"})
+	if err != nil || math.Abs(union.Estimate-500) > 3*union.Error+1 {
+		t.Errorf("union is %+v, %v, want 500", union, err)
+	}
+	intersection, err := e.HLLIntersection([]string{"first", "second"})
+	if err != nil || math.Abs(intersection.Estimate-100) > 3*intersection.Error+1 {
+		t.Errorf("intersection is %+v, %v, want 100", intersection, err)
+	}
+	if _, err = e.HLLUnion([]string{"first", "missing"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("union with a missing HyperLogLog gives %v", err)
+	}
+	if _, err = e.HLLIntersection([]string{"missing"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("intersection with a missing HyperLogLog gives %v", err)
+	}
+}
+
+func TestCorruptHLLIsCorruption(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	defer e.Close()
+	hll := structures.CreateHyperLogLog(10)
+	hll.Add("a")
+	data := hll.SerializeHLL()
+	data[len(data)-1] ^= 1
+	if err := e.Keyspace(HLLKeyspace).PutTyped("broken", structures.TypeHLL, data, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.GetHLL("broken"); !errors.Is(err, ErrCorruption) {
+		t.Errorf("corrupt HyperLogLog gives %v", err)
+	}
+	if _, err := e.GetAsString(HLLKeyspace, "broken"); !errors.Is(err, ErrCorruption) {
+		t.Errorf("corrupt HyperLogLog as a string gives %v", err)
+	}
+}
+
+func TestCreateDropAndListKeyspaces(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	defer func() { e.Close() }()
+
+	settings := e.Keyspace(DefaultKeyspace).settings
+	settings.LSMParameters.LSMLevelSize = 2
+	users, err := e.CreateKeyspace("users", settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = e.CreateKeyspace("users", settings); !errors.Is(err, ErrKeyspaceExists) {
+		t.Errorf("second users keyspace gives %v", err)
+	}
+	if _, err = e.CreateKeyspace("a/b", settings); !errors.Is(err, ErrInvalidKeyspace) {
+		t.Errorf("keyspace named a/b gives %v", err)
+	}
+	if err = e.DropKeyspace(DefaultKeyspace); !errors.Is(err, ErrInvalidKeyspace) {
+		t.Errorf("dropping the default keyspace gives %v", err)
+	}
+	if err = e.DropKeyspace("missing"); !errors.Is(err, ErrKeyspaceNotFound) {
+		t.Errorf("dropping a missing keyspace gives %v", err)
+	}
+	if err = e.Put("key", []byte("default"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err = users.Put("key", []byte("users"), false); err != nil {
+		t.Fatal(err)
+	}
+
+	e = reopen(t, e)
+	if names := e.ListKeyspaces(); !contains(names, "users") || !contains(names, DefaultKeyspace) {
+		t.Errorf("keyspaces are %q after a reopen", names)
+	}
+	users = e.Keyspace("users")
+	if users.settings.LSMParameters.LSMLevelSize != 2 {
+		t.Errorf("users has level size %d after a reopen", users.settings.LSMParameters.LSMLevelSize)
+	}
+	if value, err := users.Get("key"); err != nil || string(value) != "users" {
+		t.Errorf("key of users is %q, %v", value, err)
+	}
+	if value, err := e.Get("key"); err != nil || string(value) != "default" {
+		t.Errorf("key of the default keyspace is %q, %v", value, err)
+	}
+
+	// Records of the dropped keyspace, in the WAL or in SSTables, do not come back.
+	if err = users.Put("unflushed", []byte("1"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.DropKeyspace("users"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = users.Get("key"); !errors.Is(err, ErrKeyspaceDropped) {
+		t.Errorf("read of a dropped keyspace gives %v", err)
+	}
+	if _, err = os.Stat(users.directory); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("directory of the dropped keyspace: %v", err)
+	}
+	if users, err = e.CreateKeyspace("users", settings); err != nil {
+		t.Fatal(err)
+	}
+	e = reopen(t, e)
+	users = e.Keyspace("users")
+	for _, key := range []string{"key", "unflushed"} {
+		if _, err := users.Get(key); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s of the dropped keyspace gives %v", key, err)
+		}
+	}
+}
+
+func TestCorruptFilesAreReportedAsErrors(t *testing.T) {
+	dir := t.TempDir() + "/"
+	e := openTestEngine(t, dir)
+	if err := e.Put("key", []byte("value"), false); err != nil {
+		t.Fatal(err)
+	}
+	e = reopen(t, e)
+	ks := e.Keyspace(DefaultKeyspace)
+	data, err := os.ReadFile(ks.directory + "sstable/usertable-data-ic-1-lev1-Data.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(ks.directory+"sstable/usertable-data-ic-1-lev1-Data.db", data[:len(data)-3], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = e.Get("key"); !errors.Is(err, ErrCorruption) {
+		t.Errorf("read of a truncated table gives %v", err)
+	}
+	if err = e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(dir+"keyspaces.json", []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Open(dir, Options{Config: config.DefaultConfig()}); !errors.Is(err, ErrCorruption) {
+		t.Errorf("open with a broken keyspace registry gives %v", err)
+	}
+}
+
+func TestOpenLocksTheDataDirectory(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.LSMParameters.LSMLevelSize = 3
+	configPath := dir + "/config.json"
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(configPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := Open(dir+"/data", Options{ConfigPath: configPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Config.LSMParameters.LSMLevelSize != 3 {
+		t.Errorf("engine has level size %d, not the one of its configuration file", e.Config.LSMParameters.LSMLevelSize)
+	}
+	if e.Directory() != dir+"/data/" {
+		t.Errorf("engine directory is %s", e.Directory())
+	}
+	if _, err = Open(dir+"/data/", Options{}); !errors.Is(err, ErrLocked) {
+		t.Errorf("second engine on the directory gives %v", err)
+	}
+	other, err := Open(dir+"/other", Options{})
+	if err != nil {
+		t.Fatalf("engine on another directory gives %v", err)
+	}
+	if err = other.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = e.Put("key", []byte("value"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Close(); !errors.Is(err, ErrClosed) {
+		t.Errorf("second Close gives %v", err)
+	}
+	if _, err = e.Get("key"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Get after Close gives %v", err)
+	}
+	if err = e.Put("key", []byte("other"), false); !errors.Is(err, ErrClosed) {
+		t.Errorf("Put after Close gives %v", err)
+	}
+
+	e, err = Open(dir+"/data", Options{})
+	if err != nil {
+		t.Fatalf("open after Close gives %v", err)
+	}
+	defer e.Close()
+	if value, err := e.Get("key"); err != nil || string(value) != "value" {
+		t.Errorf("key is %q, %v after a reopen", value, err)
+	}
+}
+
+func TestDiffOfReplicas(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	defer e.Close()
+	replica := openTestEngine(t, t.TempDir())
+	defer replica.Close()
+
+	settings := e.Keyspace(DefaultKeyspace).settings
+	users, err := e.CreateKeyspace("users", settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if err = users.Put(key, []byte(key), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Unflushed writes are compared too.
+	diffs, err := e.Diff(replica)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := structures.KeyRange{Start: "a", End: "b"}
+	if len(diffs) != 1 || len(diffs["users"]) != 1 || diffs["users"][0] != want {
+		t.Errorf("diffs are %v, want users: %v", diffs, want)
+	}
+	if diffs, err = e.Diff(e); err != nil || len(diffs) != 0 {
+		t.Errorf("engine differs from itself in %v, %v", diffs, err)
+	}
+}
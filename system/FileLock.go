@@ -0,0 +1,35 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package system
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile holds an exclusive lock on a file for as long as it is open. The operating
+// system releases the lock if the process dies, so a crash never leaves a stale lock behind.
+type lockFile struct {
+	file *os.File
+}
+
+func acquireLock(filename string) (*lockFile, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		_ = file.Close()
+		return nil, ErrLocked
+	} else if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &lockFile{file: file}, nil
+}
+
+func (lock *lockFile) release() error {
+	return lock.file.Close()
+}
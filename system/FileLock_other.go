@@ -0,0 +1,31 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package system
+
+import (
+	"errors"
+	"os"
+)
+
+// lockFile is a file that exists while a process has the data directory open.
+// If the process dies, the file stays behind and has to be removed by hand.
+type lockFile struct {
+	filename string
+}
+
+func acquireLock(filename string) (*lockFile, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return nil, ErrLocked
+	} else if err != nil {
+		return nil, err
+	}
+	if err = file.Close(); err != nil {
+		return nil, err
+	}
+	return &lockFile{filename: filename}, nil
+}
+
+func (lock *lockFile) release() error {
+	return os.Remove(lock.filename)
+}
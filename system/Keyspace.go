@@ -0,0 +1,658 @@
+package system
+
+import (
+	"KVSystem/config"
+	"KVSystem/system/structures"
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// KeyspaceSettings are the memory table and compaction settings of one keyspace.
+type KeyspaceSettings struct {
+	LSMParameters      config.LSMConfig      `json:"lsm_config"`
+	MemTableParameters config.MemTableConfig `json:"mem_table_config"`
+}
+
+// Keyspace is a named set of keys with its own memory table, cache and SSTables.
+// All keyspaces of an engine share its write-ahead log and lock.
+type Keyspace struct {
+	name      string
+	directory string
+	settings  KeyspaceSettings
+	engine    *Engine
+	memTable  *structures.MemoryTable
+	cache     *structures.LRUCache
+	lsm       *structures.LSMTree
+	tables    structures.TableOptions // for the SSTables of this keyspace
+	bloom     structures.BloomStats
+	walStart  uint64 // WAL position of the first record of the current memory table
+	dropped   bool
+	// quarantined are the quarantined tables whose lost keys fail reads until a repair.
+	quarantined []structures.Quarantine
+}
+
+func newKeyspace(engine *Engine, name, directory string, settings KeyspaceSettings,
+	tables structures.TableOptions) *Keyspace {
+	ks := &Keyspace{
+		name:      name,
+		directory: directory,
+		settings:  settings,
+		engine:    engine,
+		cache:     structures.NewLRUCache(engine.Config.CacheParameters.CacheMaxData),
+		lsm:       structures.NewLSMTree(settings.LSMParameters.LSMMaxLevel, settings.LSMParameters.LSMLevelSize, tables),
+		tables:    tables,
+	}
+	ks.memTable = ks.newMemoryTable()
+	return ks
+}
+
+// tableOptions returns the options of the SSTables of a keyspace with the given settings.
+func (e *Engine) tableOptions(settings KeyspaceSettings) (structures.TableOptions, error) {
+	if err := settings.LSMParameters.Validate(); err != nil {
+		return structures.TableOptions{}, err
+	}
+	filter, err := structures.ParseFilterType(settings.LSMParameters.LSMFilter)
+	if err != nil {
+		return structures.TableOptions{}, err
+	}
+	options := e.tables
+	options.BloomFalsePositive = settings.LSMParameters.BloomFalsePositives()
+	options.Prefix = structures.PrefixExtractor{
+		Length:    settings.LSMParameters.LSMPrefixLength,
+		Delimiter: settings.LSMParameters.LSMPrefixDelimiter,
+	}
+	options.Filter = filter
+	return options, nil
+}
+
+func (ks *Keyspace) Name() string {
+	return ks.name
+}
+
+func (ks *Keyspace) Settings() KeyspaceSettings {
+	return ks.settings
+}
+
+func (ks *Keyspace) newMemoryTable() *structures.MemoryTable {
+	return structures.NewMemoryTable(ks.settings.MemTableParameters.SkipListMaxHeight,
+		uint(ks.settings.MemTableParameters.MaxMemTableSize),
+		uint(ks.settings.MemTableParameters.MemTableThreshold))
+}
+
+// usable returns ErrClosed or ErrKeyspaceDropped if the keyspace cannot be used anymore.
+func (ks *Keyspace) usable() error {
+	if ks.engine.closed {
+		return ErrClosed
+	}
+	if ks.dropped {
+		return ErrKeyspaceDropped
+	}
+	return nil
+}
+
+// logElement appends a record of the keyspace to the shared write-ahead log.
+func (ks *Keyspace) logElement(elem *structures.Element) error {
+	ks.beginWrite()
+	elem.Keyspace = ks.name
+	return ks.engine.Wal.PutElement(elem)
+}
+
+// beginWrite remembers the WAL position of the first write into an empty memory table.
+func (ks *Keyspace) beginWrite() {
+	if ks.memTable.CurrentSize() == 0 && len(ks.memTable.RangeTombstones()) == 0 {
+		ks.walStart = ks.engine.Wal.Position()
+	}
+}
+
+// replay applies a record read back from the WAL, which starts at position, to the memory
+// table without logging it again.
+func (ks *Keyspace) replay(position uint64, elem structures.Element) {
+	if ks.memTable.CurrentSize() == 0 && len(ks.memTable.RangeTombstones()) == 0 {
+		ks.walStart = position
+	}
+	if elem.RangeDelete {
+		ks.memTable.DeleteRange(elem.Key, string(elem.Value))
+	} else if elem.Merge {
+		for _, operand := range structures.DecodeOperands(elem.Value) {
+			ks.memTable.Merge(elem.Key, operand, ks.tables.Operators)
+		}
+	} else {
+		ks.memTable.Insert(elem.Key, elem.Value, elem.Tombstone)
+	}
+}
+
+// flushIfNeeded writes a full memory table to an SSTable. It runs before a write, so a
+// failed flush fails the write without applying it; the memory table and its WAL segments
+// are kept and the next write tries again.
+func (ks *Keyspace) flushIfNeeded() error {
+	if !ks.memTable.ShouldFlush() {
+		return nil
+	}
+	return ks.flush()
+}
+
+// flush writes the memory table to an SSTable unless it is empty.
+func (ks *Keyspace) flush() error {
+	if ks.memTable.CurrentSize() == 0 && len(ks.memTable.RangeTombstones()) == 0 {
+		return nil
+	}
+	if err := ks.memTable.PerformFlush(ks.directory, ks.tables); err != nil {
+		return fmt.Errorf("flush of keyspace %s: %w", ks.name, err)
+	}
+	ks.memTable = ks.newMemoryTable()
+	if err := ks.engine.removeFlushedSegments(); err != nil {
+		return err
+	}
+	return ks.lsm.PerformCompaction(ks.directory, 1)
+}
+
+// Put stores value as raw bytes.
+func (ks *Keyspace) Put(key string, value []byte, tombstone bool) error {
+	return ks.PutTyped(key, structures.TypeRaw, value, tombstone)
+}
+
+// PutTyped stores data tagged with its type.
+func (ks *Keyspace) PutTyped(key string, valueType structures.ValueType, data []byte, tombstone bool) error {
+	ks.engine.lock.Lock()
+	defer ks.engine.lock.Unlock()
+	if err := ks.usable(); err != nil {
+		return err
+	}
+	return ks.put(key, structures.EncodeValue(valueType, data), tombstone)
+}
+
+// put stores a value that already carries its type tag. An error means that the write
+// was not applied.
+func (ks *Keyspace) put(key string, value []byte, tombstone bool) error {
+	if err := ks.flushIfNeeded(); err != nil {
+		return err
+	}
+	elem := structures.Element{
+		Key:       key,
+		Value:     value,
+		NextNodes: nil,
+		Timestamp: time.Now().String(),
+		Tombstone: tombstone,
+		Checksum:  structures.CRC32(value),
+	}
+	if err := ks.logElement(&elem); err != nil {
+		return err
+	}
+	ks.memTable.Insert(key, value, tombstone)
+	ks.cache.Put(key, value)
+	return nil
+}
+
+// Merge logs a merge operand for key without reading the current value. Operands are
+// built with the structures.*Operand helpers and are folded lazily by Get and compaction.
+func (ks *Keyspace) Merge(key string, operand []byte) error {
+	ks.engine.lock.Lock()
+	defer ks.engine.lock.Unlock()
+	if err := ks.usable(); err != nil {
+		return err
+	}
+	if err := ks.flushIfNeeded(); err != nil {
+		return err
+	}
+
+	elem := structures.Element{
+		Key:       key,
+		Value:     structures.EncodeOperands([][]byte{operand}),
+		NextNodes: nil,
+		Timestamp: time.Now().String(),
+		Merge:     true,
+	}
+	elem.Checksum = structures.CRC32(elem.Value)
+	if err := ks.logElement(&elem); err != nil {
+		return err
+	}
+	ks.memTable.Merge(key, operand, ks.tables.Operators)
+	ks.cache.Delete(key)
+	return nil
+}
+
+// Get returns the raw value of key. Keys holding sketches or counters give a
+// *structures.TypeMismatchError; use GetTyped or the typed accessors of Engine for them.
+func (ks *Keyspace) Get(key string) ([]byte, error) {
+	return ks.GetAs(key, structures.TypeRaw)
+}
+
+// GetAs returns the data of key if it holds a value of the expected type.
+func (ks *Keyspace) GetAs(key string, expected structures.ValueType) ([]byte, error) {
+	valueType, data, err := ks.GetTyped(key)
+	if err != nil {
+		return nil, err
+	}
+	if valueType != expected {
+		return nil, &structures.TypeMismatchError{Key: key, Expected: expected, Actual: valueType}
+	}
+	return data, nil
+}
+
+// GetTyped returns the type and data of the value of key, or ErrNotFound.
+func (ks *Keyspace) GetTyped(key string) (structures.ValueType, []byte, error) {
+	ks.engine.lock.Lock()
+	defer ks.engine.lock.Unlock()
+	if err := ks.usable(); err != nil {
+		return structures.TypeRaw, nil, err
+	}
+	ok, value, err := ks.get(key)
+	if err != nil {
+		return structures.TypeRaw, nil, err
+	}
+	if !ok {
+		return structures.TypeRaw, nil, ErrNotFound
+	}
+	valueType, data := structures.DecodeValue(value)
+	return valueType, data, nil
+}
+
+// get returns the stored, type-tagged value of key.
+func (ks *Keyspace) get(key string) (bool, []byte, error) {
+	ok, deleted, value, operands := ks.memTable.Lookup(key)
+	if ok && deleted {
+		return false, nil, nil
+	} else if ok && operands == nil {
+		ks.cache.Put(key, value)
+		//fmt.Println("Found in memtable.")
+		return true, value, nil
+	}
+	// Merge drops the key from the cache, so a cached value already includes the operands.
+	ok, value = ks.cache.Get(key)
+	if ok {
+		//fmt.Println("Found in cache.")
+		ks.cache.Put(key, value)
+		return true, value, nil
+	}
+	if ks.memTable.Covered(key) {
+		ok, value = false, nil
+	} else {
+		if err := ks.checkQuarantine(key, key+"\x00"); err != nil {
+			return false, nil, err
+		}
+		var err error
+		ok, value, err = structures.SearchThroughSSTables(ks.directory, key, ks.settings.LSMParameters.LSMMaxLevel,
+			ks.tables.Operators, &ks.bloom)
+		if err != nil {
+			return false, nil, err
+		}
+	}
+	if operands != nil {
+		if !ok {
+			value = nil
+		}
+		ok, value = true, ks.tables.Operators.Fold(value, operands)
+	}
+	if ok {
+		//fmt.Println("Found in sstable.")
+		ks.cache.Put(key, value)
+		return true, value, nil
+	}
+	return false, nil, nil
+}
+
+// MultiGet looks up many keys at once and returns, in the order of keys, whether each
+// one was found and its value. SSTables are consulted once per table instead of once per key.
+// Like Get it only returns raw values; keys of other types are reported as not found.
+func (ks *Keyspace) MultiGet(keys []string) (found []bool, values [][]byte, err error) {
+	ks.engine.lock.Lock()
+	defer ks.engine.lock.Unlock()
+	if err = ks.usable(); err != nil {
+		return nil, nil, err
+	}
+
+	sorted := make([]string, len(keys))
+	copy(sorted, keys)
+	sort.Strings(sorted)
+
+	results := make(map[string][]byte)
+	pending := make(map[string][][]byte)
+	remaining := make([]string, 0, len(sorted))
+	for i, key := range sorted {
+		if i > 0 && sorted[i-1] == key {
+			continue
+		}
+		ok, deleted, value, operands := ks.memTable.Lookup(key)
+		if ok && deleted {
+			continue
+		} else if ok && operands == nil {
+			results[key] = value
+			continue
+		}
+		if ok, value = ks.cache.Get(key); ok {
+			results[key] = value
+			continue
+		}
+		if ks.memTable.Covered(key) {
+			if operands != nil {
+				results[key] = ks.tables.Operators.Fold(nil, operands)
+			}
+			continue
+		}
+		if err = ks.checkQuarantine(key, key+"\x00"); err != nil {
+			return nil, nil, err
+		}
+		if operands != nil {
+			pending[key] = operands
+		}
+		remaining = append(remaining, key)
+	}
+
+	tableFound, tableValues, err := structures.MultiSearchThroughSSTables(ks.directory, remaining,
+		ks.settings.LSMParameters.LSMMaxLevel, ks.tables.Operators, &ks.bloom)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, key := range remaining {
+		value := tableValues[i]
+		if !tableFound[i] {
+			value = nil
+		}
+		if operands, ok := pending[key]; ok {
+			results[key] = ks.tables.Operators.Fold(value, operands)
+		} else if tableFound[i] {
+			results[key] = value
+		}
+	}
+
+	found = make([]bool, len(keys))
+	values = make([][]byte, len(keys))
+	for i, key := range keys {
+		if value, ok := results[key]; ok {
+			var valueType structures.ValueType
+			valueType, values[i] = structures.DecodeValue(value)
+			found[i] = valueType == structures.TypeRaw
+			if !found[i] {
+				values[i] = nil
+			}
+		}
+	}
+	return found, values, nil
+}
+
+// Delete writes a tombstone for key. It returns ErrNotFound if the key is not visible.
+func (ks *Keyspace) Delete(key string) error {
+	ks.engine.lock.Lock()
+	defer ks.engine.lock.Unlock()
+	if err := ks.usable(); err != nil {
+		return err
+	}
+	return ks.delete(key)
+}
+
+func (ks *Keyspace) delete(key string) error {
+	// A tombstone is written even for keys in the memory table, so older versions in
+	// SSTables stay hidden.
+	ok, value, err := ks.get(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotFound
+	}
+	if err = ks.put(key, value, true); err != nil {
+		return err
+	}
+	ks.cache.Delete(key)
+	return nil
+}
+
+// DeleteRange deletes every key in [start, end) with a single range tombstone.
+func (ks *Keyspace) DeleteRange(start, end string) error {
+	ks.engine.lock.Lock()
+	defer ks.engine.lock.Unlock()
+	if err := ks.usable(); err != nil {
+		return err
+	}
+	if start >= end {
+		return ErrInvalidRange
+	}
+	if err := ks.flushIfNeeded(); err != nil {
+		return err
+	}
+
+	elem := structures.Element{
+		Key:         start,
+		Value:       []byte(end),
+		NextNodes:   nil,
+		Timestamp:   time.Now().String(),
+		RangeDelete: true,
+		Checksum:    structures.CRC32([]byte(end)),
+	}
+	if err := ks.logElement(&elem); err != nil {
+		return err
+	}
+	ks.memTable.DeleteRange(start, end)
+	ks.cache.DeleteRange(start, end)
+
+	return nil
+}
+
+// Scan returns the visible keys in [start, end) that hold raw values, and their values, sorted
+// by key. An empty end has no bound.
+func (ks *Keyspace) Scan(start, end string) ([]string, [][]byte, error) {
+	return ks.scan(start, end, "", structures.TypeRaw)
+}
+
+// ScanAs is Scan for the keys whose values have the expected type.
+func (ks *Keyspace) ScanAs(start, end string, expected structures.ValueType) ([]string, [][]byte, error) {
+	return ks.scan(start, end, "", expected)
+}
+
+// ScanPrefix returns the visible keys that start with prefix and hold raw values, and their
+// values, sorted by key. With a prefix extractor in the keyspace settings, SSTables whose
+// filter rules the prefix out are not read.
+func (ks *Keyspace) ScanPrefix(prefix string) ([]string, [][]byte, error) {
+	return ks.scan(prefix, structures.PrefixEnd(prefix), prefix, structures.TypeRaw)
+}
+
+func (ks *Keyspace) scan(start, end, prefix string, expected structures.ValueType) ([]string, [][]byte, error) {
+	ks.engine.lock.Lock()
+	defer ks.engine.lock.Unlock()
+	if err := ks.usable(); err != nil {
+		return nil, nil, err
+	}
+
+	if err := ks.checkQuarantine(start, end); err != nil {
+		return nil, nil, err
+	}
+	values, quarantined, err := structures.ScanSSTables(ks.directory, start, end, prefix,
+		ks.settings.LSMParameters.LSMMaxLevel, ks.tables.Operators, ks.memTable.RangeTombstones())
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, node := range ks.memTable.Range(start, end) {
+		if node.Tombstone {
+			delete(values, node.Key)
+			delete(quarantined, node.Key)
+		} else if node.Merge {
+			values[node.Key] = ks.tables.Operators.Fold(values[node.Key], structures.DecodeOperands(node.Value))
+		} else {
+			values[node.Key] = node.Value
+			delete(quarantined, node.Key)
+		}
+	}
+	// Keys written since their table was quarantined can be read, the others cannot.
+	if len(quarantined) > 0 {
+		lost := make([]string, 0, len(quarantined))
+		for key := range quarantined {
+			lost = append(lost, key)
+		}
+		sort.Strings(lost)
+		return nil, nil, quarantined[lost[0]]
+	}
+
+	keys := make([]string, 0, len(values))
+	for key, value := range values {
+		if valueType, data := structures.DecodeValue(value); valueType == expected {
+			keys = append(keys, key)
+			values[key] = data
+		}
+	}
+	sort.Strings(keys)
+	result := make([][]byte, len(keys))
+	for i, key := range keys {
+		result[i] = values[key]
+	}
+	return keys, result, nil
+}
+
+// Verify checks the record checksums and Merkle trees of all SSTables of the keyspace.
+// Records still in the memory table are not checked.
+func (ks *Keyspace) Verify() ([]structures.TableReport, error) {
+	ks.engine.lock.Lock()
+	defer ks.engine.lock.Unlock()
+	if err := ks.usable(); err != nil {
+		return nil, err
+	}
+	return structures.VerifySSTables(ks.directory, ks.settings.LSMParameters.LSMMaxLevel)
+}
+
+// BloomStats returns how the bloom filters of each level answered lookups since the engine
+// was opened. They show whether the false-positive rate of a level is worth its memory.
+func (ks *Keyspace) BloomStats() structures.BloomStats {
+	ks.engine.lock.Lock()
+	defer ks.engine.lock.Unlock()
+	return ks.bloom.Copy()
+}
+
+// Proof returns the stored value of key, with its type tag, the root hash of the SSTable
+// holding it and the proof that the value is a leaf under that root. Values written since
+// the last flush are not in an SSTable yet and return ErrNotProvable.
+func (ks *Keyspace) Proof(key string) (value, root []byte, proof *structures.MerkleProof, err error) {
+	ks.engine.lock.Lock()
+	defer ks.engine.lock.Unlock()
+	if err = ks.usable(); err != nil {
+		return nil, nil, nil, err
+	}
+	if ok, _, _, _ := ks.memTable.Lookup(key); ok || ks.memTable.Covered(key) {
+		return nil, nil, nil, ErrNotProvable
+	}
+	if err = ks.checkQuarantine(key, key+"\x00"); err != nil {
+		return nil, nil, nil, err
+	}
+	found, value, root, proof, err := structures.ProveRecord(ks.directory, key, ks.settings.LSMParameters.LSMMaxLevel)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !found {
+		return nil, nil, nil, ErrNotFound
+	}
+	return value, root, proof, nil
+}
+
+// Edit overwrites the value of an existing key. It returns ErrNotFound if the key is not visible.
+func (ks *Keyspace) Edit(key string, value []byte) error {
+	ks.engine.lock.Lock()
+	defer ks.engine.lock.Unlock()
+	if err := ks.usable(); err != nil {
+		return err
+	}
+	ok, _, err := ks.get(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotFound
+	}
+	return ks.put(key, structures.EncodeValue(structures.TypeRaw, value), false)
+}
+
+// CompareAndSwap replaces the value of key with value only if the current visible
+// value is raw and equals expected, and reports whether it did. A missing key is not swapped.
+func (ks *Keyspace) CompareAndSwap(key string, expected, value []byte) (bool, error) {
+	ks.engine.lock.Lock()
+	defer ks.engine.lock.Unlock()
+	if err := ks.usable(); err != nil {
+		return false, err
+	}
+	ok, current, err := ks.get(key)
+	if err != nil || !ok || !bytes.Equal(current, structures.EncodeValue(structures.TypeRaw, expected)) {
+		return false, err
+	}
+	if err = ks.put(key, structures.EncodeValue(structures.TypeRaw, value), false); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PutIfAbsent inserts the value only if the key has no visible value, and reports whether it did.
+func (ks *Keyspace) PutIfAbsent(key string, value []byte) (bool, error) {
+	ks.engine.lock.Lock()
+	defer ks.engine.lock.Unlock()
+	if err := ks.usable(); err != nil {
+		return false, err
+	}
+	ok, _, err := ks.get(key)
+	if err != nil || ok {
+		return false, err
+	}
+	if err = ks.put(key, structures.EncodeValue(structures.TypeRaw, value), false); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DeleteIfEquals deletes the key only if its current visible value is raw and equals expected,
+// and reports whether it did.
+func (ks *Keyspace) DeleteIfEquals(key string, expected []byte) (bool, error) {
+	ks.engine.lock.Lock()
+	defer ks.engine.lock.Unlock()
+	if err := ks.usable(); err != nil {
+		return false, err
+	}
+	ok, current, err := ks.get(key)
+	if err != nil || !ok || !bytes.Equal(current, structures.EncodeValue(structures.TypeRaw, expected)) {
+		return false, err
+	}
+	if err = ks.put(key, current, true); err != nil {
+		return false, err
+	}
+	ks.cache.Delete(key)
+	return true, nil
+}
+
+// Incr adds delta to the counter stored under key and returns the new value.
+// A missing key counts as zero. Counters are stored in the binary form of structures.EncodeCounter
+// with the counter type; raw values holding decimal numbers are accepted as well.
+func (ks *Keyspace) Incr(key string, delta int64) (int64, error) {
+	ks.engine.lock.Lock()
+	defer ks.engine.lock.Unlock()
+	if err := ks.usable(); err != nil {
+		return 0, err
+	}
+
+	var current int64
+	ok, value, err := ks.get(key)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		valueType, data := structures.DecodeValue(value)
+		if valueType != structures.TypeCounter && valueType != structures.TypeRaw {
+			return 0, &structures.TypeMismatchError{Key: key, Expected: structures.TypeCounter, Actual: valueType}
+		}
+		if current, ok = structures.DecodeCounter(valueType, data); !ok {
+			return 0, structures.ErrNotCounter
+		}
+	}
+	next, err := structures.AddCounter(current, delta)
+	if err != nil {
+		return current, err
+	}
+	if err = ks.put(key, structures.EncodeValue(structures.TypeCounter, structures.EncodeCounter(next)), false); err != nil {
+		return current, err
+	}
+	return next, nil
+}
+
+// Decr subtracts delta from the counter stored under key and returns the new value.
+func (ks *Keyspace) Decr(key string, delta int64) (int64, error) {
+	if delta == math.MinInt64 {
+		return 0, structures.ErrCounterOverflow
+	}
+	return ks.Incr(key, -delta)
+}
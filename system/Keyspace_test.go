@@ -0,0 +1,525 @@
+package system
+
+import (
+	"KVSystem/config"
+	"KVSystem/system/structures"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConditionalWrites(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	defer func() { e.Close() }()
+
+	if err := e.Edit("key", []byte("v")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Edit of a missing key gives %v", err)
+	}
+	if ok, err := e.CompareAndSwap("key", nil, []byte("v")); err != nil || ok {
+		t.Errorf("CompareAndSwap of a missing key gives %v, %v", ok, err)
+	}
+	if ok, err := e.PutIfAbsent("key", []byte("first")); err != nil || !ok {
+		t.Fatalf("PutIfAbsent of a missing key gives %v, %v", ok, err)
+	}
+	// The checks read the value from SSTables as well as from the memory table.
+	e = reopen(t, e)
+	if ok, err := e.PutIfAbsent("key", []byte("second")); err != nil || ok {
+		t.Errorf("PutIfAbsent of a present key gives %v, %v", ok, err)
+	}
+	if ok, err := e.CompareAndSwap("key", []byte("other"), []byte("second")); err != nil || ok {
+		t.Errorf("CompareAndSwap with a wrong expected value gives %v, %v", ok, err)
+	}
+	if ok, err := e.CompareAndSwap("key", []byte("first"), []byte("second")); err != nil || !ok {
+		t.Errorf("CompareAndSwap with the current value gives %v, %v", ok, err)
+	}
+	if value, err := e.Get("key"); err != nil || string(value) != "second" {
+		t.Errorf("key is %q, %v after the swap, want second", value, err)
+	}
+
+	if ok, err := e.DeleteIfEquals("key", []byte("first")); err != nil || ok {
+		t.Errorf("DeleteIfEquals with a wrong expected value gives %v, %v", ok, err)
+	}
+	if ok, err := e.DeleteIfEquals("key", []byte("second")); err != nil || !ok {
+		t.Errorf("DeleteIfEquals with the current value gives %v, %v", ok, err)
+	}
+	e = reopen(t, e)
+	if _, err := e.Get("key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted key gives %v", err)
+	}
+	if ok, err := e.PutIfAbsent("key", []byte("again")); err != nil || !ok {
+		t.Errorf("PutIfAbsent of a deleted key gives %v, %v", ok, err)
+	}
+}
+
+// flushTables writes the memory table of every write to an SSTable of its own. With the
+// level size of openTestEngine, four tables on level 1 are compacted into level 2.
+func flushTables(t *testing.T, ks *Keyspace, writes ...func() error) {
+	t.Helper()
+	for _, write := range writes {
+		if err := write(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ks.flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMergeOperandsAcrossFlushAndCompaction(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	defer func() { e.Close() }()
+	ks := e.Keyspace(DefaultKeyspace)
+
+	appendTo := func(suffix string) func() error {
+		return func() error { return e.Merge("log", structures.AppendOperand([]byte(suffix))) }
+	}
+	flushTables(t, ks, func() error { return e.Put("log", []byte("a"), false) }, appendTo("b"), appendTo("c"))
+	if value, err := e.Get("log"); err != nil || string(value) != "abc" {
+		t.Errorf("log is %q, %v from three tables, want abc", value, err)
+	}
+	flushTables(t, ks, appendTo("d"))
+	if count, err := structures.TableCount(ks.directory, 2); err != nil || count != 2 {
+		t.Fatalf("level 2 has %d tables, %v after compaction", count, err)
+	}
+	if err := e.Merge("log", structures.AppendOperand([]byte("e"))); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := e.Get("log"); err != nil || string(value) != "abcde" {
+		t.Errorf("log is %q, %v after compaction, want abcde", value, err)
+	}
+	if err := e.Merge("new", structures.AppendOperand([]byte("x"))); err != nil {
+		t.Fatal(err)
+	}
+	e = reopen(t, e)
+	if value, err := e.Get("new"); err != nil || string(value) != "x" {
+		t.Errorf("merge into a missing key gives %q, %v, want x", value, err)
+	}
+}
+
+func TestCounters(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	defer func() { e.Close() }()
+
+	if value, err := e.Incr("hits", 5); err != nil || value != 5 {
+		t.Errorf("Incr of a missing counter gives %d, %v", value, err)
+	}
+	e = reopen(t, e)
+	if value, err := e.Decr("hits", 7); err != nil || value != -2 {
+		t.Errorf("Decr gives %d, %v, want -2", value, err)
+	}
+	if value, err := e.GetCounter("hits"); err != nil || value != -2 {
+		t.Errorf("GetCounter gives %d, %v, want -2", value, err)
+	}
+
+	// Decimal strings written before counters existed are counted on.
+	if err := e.Put("legacy", []byte("41"), false); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := e.Incr("legacy", 1); err != nil || value != 42 {
+		t.Errorf("Incr of a decimal string gives %d, %v, want 42", value, err)
+	}
+	if err := e.Put("name", []byte("forty"), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Incr("name", 1); !errors.Is(err, structures.ErrNotCounter) {
+		t.Errorf("Incr of a non-numeric value gives %v", err)
+	}
+
+	if _, err := e.Incr("max", math.MaxInt64); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := e.Incr("max", 1); !errors.Is(err, structures.ErrCounterOverflow) || value != math.MaxInt64 {
+		t.Errorf("overflowing Incr gives %d, %v", value, err)
+	}
+	if _, err := e.Decr("max", math.MinInt64); !errors.Is(err, structures.ErrCounterOverflow) {
+		t.Errorf("Decr by MinInt64 gives %v", err)
+	}
+	if value, err := e.GetCounter("max"); err != nil || value != math.MaxInt64 {
+		t.Errorf("counter is %d, %v after the failed updates", value, err)
+	}
+}
+
+func TestDeleteRangeAcrossFlushAndCompaction(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	defer func() { e.Close() }()
+	ks := e.Keyspace(DefaultKeyspace)
+
+	visible := func(when string, want ...string) {
+		t.Helper()
+		keys, _, err := e.Scan("a", "z")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != len(want) {
+			t.Fatalf("%s: scan gives %q, want %q", when, keys, want)
+		}
+		for i := range want {
+			if keys[i] != want[i] {
+				t.Fatalf("%s: scan gives %q, want %q", when, keys, want)
+			}
+			if _, err := e.Get(want[i]); err != nil {
+				t.Errorf("%s: %s gives %v", when, want[i], err)
+			}
+		}
+		for _, key := range []string{"b", "c"} {
+			if !contains(want, key) {
+				if _, err := e.Get(key); !errors.Is(err, ErrNotFound) {
+					t.Errorf("%s: deleted %s gives %v", when, key, err)
+				}
+			}
+		}
+	}
+
+	if err := e.DeleteRange("c", "b"); !errors.Is(err, ErrInvalidRange) {
+		t.Errorf("reversed range gives %v", err)
+	}
+	put := func(key string) func() error {
+		return func() error { return e.Put(key, []byte(key), false) }
+	}
+	flushTables(t, ks, put("a"), put("b"), put("c"))
+	if err := e.Put("d", []byte("d"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.DeleteRange("b", "d"); err != nil {
+		t.Fatal(err)
+	}
+	visible("in the memory table", "a", "d")
+	flushTables(t, ks, func() error { return nil })
+	if count, err := structures.TableCount(ks.directory, 2); err != nil || count != 2 {
+		t.Fatalf("level 2 has %d tables, %v after compaction", count, err)
+	}
+	visible("after compaction", "a", "d")
+
+	// Keys written after the range tombstone are not deleted by it.
+	flushTables(t, ks, put("c"))
+	e = reopen(t, e)
+	visible("after a rewrite", "a", "c", "d")
+}
+
+func contains(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMultiGetMatchesGet(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	defer func() { e.Close() }()
+	ks := e.Keyspace(DefaultKeyspace)
+
+	put := func(key, value string) func() error {
+		return func() error { return e.Put(key, []byte(value), false) }
+	}
+	flushTables(t, ks, put("a", "old"), put("b", "b"), put("a", "new"))
+	for _, write := range []func() error{
+		put("c", "c"),
+		func() error { return e.Delete("b") },
+		func() error { return e.Merge("a", structures.AppendOperand([]byte("er"))) },
+		func() error { _, err := e.Incr("counter", 1); return err },
+	} {
+		if err := write(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys := []string{"c", "missing", "a", "b", "a", "counter"}
+	check := func(when string) {
+		t.Helper()
+		found, values, err := e.MultiGet(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, key := range keys {
+			value, err := e.Get(key)
+			if err != nil && !errors.Is(err, ErrNotFound) && !errors.As(err, new(*structures.TypeMismatchError)) {
+				t.Fatal(err)
+			}
+			if found[i] != (err == nil) || string(values[i]) != string(value) {
+				t.Errorf("%s: MultiGet of %s gives %v %q, Get gives %q, %v", when, key, found[i], values[i], value, err)
+			}
+		}
+		if string(values[2]) != "newer" {
+			t.Errorf("%s: a is %q, want newer", when, values[2])
+		}
+	}
+	check("before a flush")
+	e = reopen(t, e)
+	check("after a flush")
+}
+
+func TestValuesKeepTheirType(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	defer func() { e.Close() }()
+	ks := e.Keyspace(DefaultKeyspace)
+
+	// Keys no longer tell the type, so user keys may look like the old prefixes.
+	if err := e.Put("hll-visits", []byte("raw"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.PutTyped("sketch", structures.TypeCMS, []byte("data"), false); err != nil {
+		t.Fatal(err)
+	}
+	e = reopen(t, e)
+	ks = e.Keyspace(DefaultKeyspace)
+	if value, err := e.Get("hll-visits"); err != nil || string(value) != "raw" {
+		t.Errorf("hll-visits is %q, %v", value, err)
+	}
+	var mismatch *structures.TypeMismatchError
+	if _, err := e.Get("sketch"); !errors.As(err, &mismatch) || mismatch.Actual != structures.TypeCMS {
+		t.Errorf("Get of a CountMinSketch gives %v", err)
+	}
+	if _, err := ks.GetAs("hll-visits", structures.TypeHLL); !errors.As(err, &mismatch) || mismatch.Actual != structures.TypeRaw {
+		t.Errorf("GetAs of a raw value as a HyperLogLog gives %v", err)
+	}
+	if valueType, data, err := ks.GetTyped("sketch"); err != nil || valueType != structures.TypeCMS || string(data) != "data" {
+		t.Errorf("GetTyped gives %s %q, %v", valueType, data, err)
+	}
+}
+
+func TestProofOfFlushedValue(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	defer func() { e.Close() }()
+
+	if err := e.Put("key", []byte("value"), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := e.Proof("key"); !errors.Is(err, structures.ErrNotProvable) {
+		t.Errorf("proof of an unflushed value gives %v", err)
+	}
+	e = reopen(t, e)
+	value, root, proof, err := e.Proof("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !structures.VerifyProof(root, "key", value, proof) {
+		t.Error("proof of a flushed value does not verify")
+	}
+	if valueType, data := structures.DecodeValue(value); valueType != structures.TypeRaw || string(data) != "value" {
+		t.Errorf("proven value is %s %q", valueType, data)
+	}
+	if structures.VerifyProof(root, "key", structures.EncodeValue(structures.TypeRaw, []byte("other")), proof) {
+		t.Error("proof verifies another value")
+	}
+	if _, _, _, err = e.Proof("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("proof of a missing key gives %v", err)
+	}
+}
+
+func TestBloomStatsOfLevels(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	defer func() { e.Close() }()
+	ks := e.Keyspace(DefaultKeyspace)
+	put := func(key string) func() error {
+		return func() error { return e.Put(key, []byte(key), false) }
+	}
+	flushTables(t, ks, put("a"), put("b"), put("c"), put("d"), put("e"))
+
+	// a is in the first table of level 2, below a table on level 1 and another one on level 2.
+	// The engine is opened again, so a is not read from the cache.
+	e = reopen(t, e)
+	if _, err := e.Get("a"); err != nil {
+		t.Fatal(err)
+	}
+	stats := e.BloomStats()
+	if len(stats.Levels) != 2 || stats.Levels[1].Positive != 1 || stats.Levels[0].Useful+stats.Levels[0].Useless != 1 {
+		t.Errorf("stats after a read of a are %+v", stats)
+	}
+}
+
+func TestScanPrefix(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	defer func() { e.Close() }()
+	settings := e.Keyspace(DefaultKeyspace).settings
+	settings.LSMParameters.LSMPrefixDelimiter = ":"
+	ks, err := e.CreateKeyspace("tenants", settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(key string) func() error {
+		return func() error { return ks.Put(key, []byte(key), false) }
+	}
+	flushTables(t, ks, put("tenant:1:a"), put("tenant:2:a"), put("tenant:10:a"))
+	if err = ks.Put("tenant:1:b", []byte("tenant:1:b"), false); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, values, err := ks.ScanPrefix("tenant:1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []string{"tenant:1:a", "tenant:1:b"}) || string(values[1]) != "tenant:1:b" {
+		t.Errorf("scan of tenant:1: gives %q %q", keys, values)
+	}
+	if keys, _, err = ks.ScanPrefix("tenant:1"); err != nil || len(keys) != 3 {
+		t.Errorf("scan of tenant:1 gives %q, %v", keys, err)
+	}
+	if keys, _, err = ks.ScanPrefix("tenant:3:"); err != nil || len(keys) != 0 {
+		t.Errorf("scan of tenant:3: gives %q, %v", keys, err)
+	}
+}
+
+func TestKeyspaceWithCuckooFilters(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	defer func() { e.Close() }()
+	settings := e.Keyspace(DefaultKeyspace).settings
+	settings.LSMParameters.LSMFilter = "cuckoo"
+	ks, err := e.CreateKeyspace("cuckoo-tables", settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(key string) func() error {
+		return func() error { return ks.Put(key, []byte(key), false) }
+	}
+	flushTables(t, ks, put("a"), put("b"), put("c"), put("d"), put("e"))
+	e = reopen(t, e)
+	ks = e.Keyspace("cuckoo-tables")
+	for _, key := range []string{"a", "c", "e"} {
+		if value, err := ks.Get(key); err != nil || string(value) != key {
+			t.Errorf("%s is %q, %v", key, value, err)
+		}
+	}
+	if _, err = ks.Get("f"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing key gives %v", err)
+	}
+	if stats := ks.BloomStats(); len(stats.Levels) != 2 || stats.Levels[1].Positive != 2 {
+		t.Errorf("filter stats are %+v", stats)
+	}
+
+	settings.LSMParameters.LSMFilter = "quotient"
+	if _, err = e.CreateKeyspace("unknown-filter", settings); err == nil {
+		t.Error("keyspace with an unknown filter was created")
+	}
+}
+
+func TestUserCuckooFilters(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	defer func() { e.Close() }()
+
+	for _, item := range []string{"x", "y"} {
+		if err := e.AddToCuckoo("seen", item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e = reopen(t, e)
+	if err := e.DeleteFromCuckoo("seen", "x"); err != nil {
+		t.Fatal(err)
+	}
+	cf, err := e.GetCuckoo("seen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cf.Contains("x") || !cf.Contains("y") || cf.Count != 1 {
+		t.Errorf("filter after deleting x holds x %v, y %v, %d items", cf.Contains("x"), cf.Contains("y"), cf.Count)
+	}
+	if _, err = e.GetCuckoo("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing filter gives %v", err)
+	}
+}
+
+func TestUserBloomFilters(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.BloomParameters.BloomCapacity = 50
+	cfg.BloomParameters.BloomFalsePositive = 0.05
+	e, err := Open(t.TempDir(), Options{Config: cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { e.Close() }()
+
+	// Filters created by adding to a missing one have the parameters of the configuration.
+	if err = e.AddToBloom("visitors", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if e, err = Open(e.Directory(), Options{Config: cfg}); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.AddToBloom("visitors", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	bf, err := e.GetBloom("visitors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := structures.CreateBF(50, 0.05); bf.M != want.M || bf.K != want.K {
+		t.Errorf("filter has %d bits and %d hash functions, want %d and %d", bf.M, bf.K, want.M, want.K)
+	}
+	if !bf.Search("alice") || !bf.Search("bob") {
+		t.Error("an added item is missing")
+	}
+
+	// Filters that users create keep their own parameters when items are added.
+	own := structures.CreateBF(1000, 0.001)
+	if err = e.PutBloom("own", own); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.AddToBloom("own", "carol"); err != nil {
+		t.Fatal(err)
+	}
+	if bf, err = e.GetBloom("own"); err != nil || bf.M != own.M || !bf.Search("carol") {
+		t.Errorf("own filter is %d bits, %v", bf.M, err)
+	}
+	if description, err := e.GetAsString(BloomKeyspace, "own"); err != nil || !strings.HasPrefix(description, "It's a BloomFilter") {
+		t.Errorf("own filter is described as %q, %v", description, err)
+	}
+	if _, err = e.GetBloom("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing filter gives %v", err)
+	}
+}
+
+func TestStoredSimHashes(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.SimHashParameters.SimHashBits = 128
+	e, err := Open(t.TempDir(), Options{Config: cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { e.Close() }()
+
+	texts := map[string]string{
+		"original": "the engine flushes the memory table to sorted tables on disk and compacts the levels",
+		"edited":   "the engine flushes its memory table to sorted tables on disk and compacts every level",
+		"recipe":   "bake the bread for forty minutes until the crust turns golden and let it cool",
+	}
+	fingerprints := make(map[string]structures.Fingerprint)
+	for key, text := range texts {
+		if fingerprints[key], err = e.PutSimHash(key, text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if e, err = Open(e.Directory(), Options{Config: cfg}); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range fingerprints {
+		if fingerprint, err := e.GetSimHash(key); err != nil || fingerprint != want || fingerprint.Size != 128 {
+			t.Errorf("fingerprint of %s is %v, %v, want %v", key, fingerprint, err, want)
+		}
+	}
+	similar, err := e.SimHashDistance("original", "edited")
+	if err != nil {
+		t.Fatal(err)
+	}
+	different, err := e.SimHashDistance("original", "recipe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if similar >= different {
+		t.Errorf("similar texts are %d bits apart, unrelated ones %d", similar, different)
+	}
+	if _, err = e.SimHashDistance("original", "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("distance to a missing fingerprint gives %v", err)
+	}
+	if _, err = e.Get("original"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("fingerprint is visible in the default keyspace: %v", err)
+	}
+}
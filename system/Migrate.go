@@ -0,0 +1,157 @@
+package system
+
+import (
+	"KVSystem/system/structures"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// formatFile holds the format version of the data directory. Stores without one were
+// written before values had type tags, and Open migrates them.
+const formatFile = "FORMAT"
+
+// migrationFile lists the tables a migration has tagged, so an interrupted migration
+// goes on where it stopped instead of tagging tables twice.
+const migrationFile = "MIGRATION"
+
+// migrationBatch is how many writes a migration puts in one batch.
+const migrationBatch = 100
+
+// Format versions of a data directory.
+const (
+	formatTaggedValues = 1 // every value starts with its type tag
+	formatKeyspaces    = 2 // HyperLogLogs and CountMinSketches are in their own keyspaces
+)
+
+// legacyPrefixes are the prefixes under which the default keyspace held HyperLogLogs and
+// CountMinSketches before keyspaces existed, with the keyspace and type they move to.
+var legacyPrefixes = []struct {
+	prefix    string
+	keyspace  string
+	valueType structures.ValueType
+}{
+	{"hll-", HLLKeyspace, structures.TypeHLL},
+	{"cms-", CMSKeyspace, structures.TypeCMS},
+}
+
+// migrate brings a store written by an older version to the current format. Stores from
+// before type tags get their SSTable values tagged: values of the hll and cms keyspaces
+// as sketches and every other one as a raw value. Binary counters of that time cannot be
+// told from text, so they become raw values too. Stores from before keyspaces, opened
+// without a keyspace registry, then get their sketches moved out of the default keyspace.
+// Memory tables are empty when it runs, since Close flushes them.
+func (e *Engine) migrate(hadRegistry bool) error {
+	version := 0
+	format, err := ioutil.ReadFile(e.directory + formatFile)
+	if err == nil {
+		if version, err = strconv.Atoi(strings.TrimSpace(string(format))); err != nil || version > formatKeyspaces {
+			return fmt.Errorf("%w: %s%s: unknown format %q", ErrCorruption, e.directory, formatFile, format)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if version < formatTaggedValues {
+		if err = e.tagLegacyValues(); err != nil {
+			return err
+		}
+		version = formatTaggedValues
+		if hadRegistry {
+			version = formatKeyspaces
+		}
+		if err = e.writeFormat(version); err != nil {
+			return err
+		}
+		if err = os.Remove(e.directory + migrationFile); err != nil {
+			return err
+		}
+	}
+	if version < formatKeyspaces {
+		if err = e.moveLegacySketches(); err != nil {
+			return err
+		}
+		return e.writeFormat(formatKeyspaces)
+	}
+	return nil
+}
+
+func (e *Engine) writeFormat(version int) error {
+	return ioutil.WriteFile(e.directory+formatFile, []byte(strconv.Itoa(version)+"\n"), 0644)
+}
+
+// tagLegacyValues tags the values in the SSTables of every keyspace.
+func (e *Engine) tagLegacyValues() error {
+	migrated := make(map[string]bool)
+	progress, err := ioutil.ReadFile(e.directory + migrationFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, name := range strings.Split(string(progress), "\n") {
+		if name != "" {
+			migrated[e.directory+name] = true
+		}
+	}
+
+	file, err := os.OpenFile(e.directory+migrationFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	for name, ks := range e.keyspaces {
+		valueType := structures.TypeRaw
+		switch name {
+		case HLLKeyspace:
+			valueType = structures.TypeHLL
+		case CMSKeyspace:
+			valueType = structures.TypeCMS
+		}
+		err = structures.TagLegacyTables(ks.directory, ks.settings.LSMParameters.LSMMaxLevel, valueType, ks.tables,
+			migrated, func(dataFilename string) error {
+				if _, err := file.WriteString(strings.TrimPrefix(dataFilename, e.directory) + "\n"); err != nil {
+					return err
+				}
+				return file.Sync()
+			})
+		if err != nil {
+			return fmt.Errorf("migration of keyspace %s: %w", name, err)
+		}
+	}
+	return file.Close()
+}
+
+// moveLegacySketches moves the HyperLogLogs and CountMinSketches that the default keyspace
+// holds under their old prefixes to their keyspaces, and flushes every keyspace, so the
+// format can be written. A move that was interrupted is done again.
+func (e *Engine) moveLegacySketches() error {
+	defaultKeyspace := e.defaultKeyspace()
+	for _, legacy := range legacyPrefixes {
+		keys, values, err := defaultKeyspace.ScanPrefix(legacy.prefix)
+		if err != nil {
+			return err
+		}
+		batch := NewBatch()
+		for i, key := range keys {
+			batch.PutTyped(legacy.keyspace, strings.TrimPrefix(key, legacy.prefix), legacy.valueType, values[i])
+			batch.Delete(DefaultKeyspace, key)
+			if batch.Len() >= migrationBatch {
+				if err = e.Write(batch); err != nil {
+					return err
+				}
+				batch = NewBatch()
+			}
+		}
+		if err = e.Write(batch); err != nil {
+			return err
+		}
+	}
+	for _, ks := range e.keyspaces {
+		if err := ks.flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package system
+
+import (
+	"KVSystem/system/structures"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// walPositionsFile holds, for every keyspace, the WAL position from which its records are
+// not in SSTables yet. Open replays the log from there.
+const walPositionsFile = "wal.json"
+
+// readWalPositions returns the replay positions of the keyspaces, or nil for stores written
+// before the log was replayed.
+func (e *Engine) readWalPositions() (map[string]uint64, error) {
+	jsonBytes, err := ioutil.ReadFile(e.directory + walPositionsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	positions := make(map[string]uint64)
+	if err = json.Unmarshal(jsonBytes, &positions); err != nil {
+		return nil, fmt.Errorf("%w: %s%s: %v", ErrCorruption, e.directory, walPositionsFile, err)
+	}
+	return positions, nil
+}
+
+// writeWalPositions replaces the positions file in one rename, so a crash leaves either
+// the old or the new positions behind.
+func (e *Engine) writeWalPositions(positions map[string]uint64) error {
+	file, _ := json.MarshalIndent(positions, "", "  ")
+	temporary := e.directory + walPositionsFile + ".tmp"
+	if err := ioutil.WriteFile(temporary, file, 0644); err != nil {
+		return err
+	}
+	return os.Rename(temporary, e.directory+walPositionsFile)
+}
+
+// removeLegacySegments deletes the WAL segments of a store from before the log was replayed.
+// Those engines flushed every write on Close and never read the segments back.
+func (e *Engine) removeLegacySegments() error {
+	files, err := ioutil.ReadDir(e.directory + "wal/")
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err = os.Remove(e.directory + "wal/" + file.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replay applies the WAL records that are not in SSTables yet to the memory tables, so
+// writes acknowledged before a crash are not lost. A batch is applied whole, as Write did.
+// Records of keyspaces that were dropped are skipped. A crash after a flush wrote its
+// SSTable but before the new positions were saved replays the flushed records once more.
+func (e *Engine) replay(records []structures.WalRecord, positions map[string]uint64) error {
+	for _, record := range records {
+		elements := []structures.Element{record.Element}
+		if record.Batch {
+			var err error
+			if elements, err = decodeBatch(record.Value); err != nil {
+				return fmt.Errorf("%w: WAL record at %d: %v", ErrCorruption, record.Position, err)
+			}
+		}
+		for _, elem := range elements {
+			ks, ok := e.keyspaces[elem.Keyspace]
+			if !ok || record.Position < positions[elem.Keyspace] {
+				continue
+			}
+			ks.replay(record.Position, elem)
+		}
+	}
+	return e.removeFlushedSegments()
+}
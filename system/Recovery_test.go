@@ -0,0 +1,106 @@
+package system
+
+import (
+	"KVSystem/system/structures"
+	"errors"
+	"testing"
+)
+
+// crash leaves e the way a crashed process would: nothing is flushed, but the data
+// directory can be opened again.
+func crash(t *testing.T, e *Engine) *Engine {
+	t.Helper()
+	e.scrubber.shutdown()
+	e.lock.Lock()
+	e.closed = true
+	e.lock.Unlock()
+	if err := e.fileLock.release(); err != nil {
+		t.Fatal(err)
+	}
+	return openTestEngine(t, e.Directory())
+}
+
+func TestBatchSurvivesCrash(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	if _, err := e.CreateKeyspace("users", e.KeyspaceSettings()); err != nil {
+		t.Fatal(err)
+	}
+	batch := NewBatch()
+	batch.Put(DefaultKeyspace, "order", []byte("42"))
+	batch.Put("users", "alice", []byte("order 42"))
+	if err := e.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	e = crash(t, e)
+	defer func() { e.Close() }()
+
+	if value, err := e.Get("order"); err != nil || string(value) != "42" {
+		t.Errorf("order is %q, %v after the crash, want 42", value, err)
+	}
+	if value, err := e.Keyspace("users").Get("alice"); err != nil || string(value) != "order 42" {
+		t.Errorf("alice is %q, %v after the crash, want order 42", value, err)
+	}
+	if err := e.Put("after", []byte("crash"), false); err != nil {
+		t.Fatal(err)
+	}
+	e = reopen(t, e)
+	for key, want := range map[string]string{"order": "42", "after": "crash"} {
+		if value, err := e.Get(key); err != nil || string(value) != want {
+			t.Errorf("%s is %q, %v after a reopen, want %s", key, value, err, want)
+		}
+	}
+}
+
+func TestReplayAppliesEveryRecordOnce(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	if _, err := e.Incr("flushed", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Keyspace(DefaultKeyspace).flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Merge("counter", structures.CounterOperand(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Put("gone", []byte("x"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.DeleteRange("g", "h"); err != nil {
+		t.Fatal(err)
+	}
+	e = crash(t, e)
+	e = crash(t, e)
+	defer func() { e.Close() }()
+
+	if value, err := e.GetCounter("flushed"); err != nil || value != 1 {
+		t.Errorf("flushed counter is %d, %v, want 1", value, err)
+	}
+	if value, err := e.GetCounter("counter"); err != nil || value != 2 {
+		t.Errorf("counter is %d, %v, want 2", value, err)
+	}
+	if _, err := e.Get("gone"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("range deleted key gives %v", err)
+	}
+}
+
+func TestDroppedKeyspaceIsNotReplayed(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	if _, err := e.CreateKeyspace("tmp", e.KeyspaceSettings()); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Keyspace("tmp").Put("key", []byte("old"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.DropKeyspace("tmp"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.CreateKeyspace("tmp", e.KeyspaceSettings()); err != nil {
+		t.Fatal(err)
+	}
+	e = crash(t, e)
+	defer func() { e.Close() }()
+
+	if _, err := e.Keyspace("tmp").Get("key"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("key of the dropped keyspace gives %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package system
+
+import (
+	"KVSystem/system/structures"
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RepairReport tells what Repair did in every keyspace.
+type RepairReport struct {
+	Keyspaces map[string]*structures.RepairReport
+	Filename  string // file the report was written to
+}
+
+// Repair rebuilds the auxiliary files of every SSTable in dir from the data files, keeping
+// the records with valid checksums, and writes a report of what was dropped to dir. The
+// records of tables the scrubber quarantined are salvaged and put back in the place of
+// their markers. Keys that could not be salvaged keep failing reads until they are written
+// again, and keys lost with a quarantined table until a repair with opts.Force. The store
+// must not be open: Repair takes the same lock as Open.
+func Repair(dir string, opts Options) (*RepairReport, error) {
+	cfg, err := opts.config()
+	if err != nil {
+		return nil, err
+	}
+	merkleHash, err := structures.ParseHashFunction(cfg.MerkleParameters.HashFunction)
+	if err != nil {
+		return nil, err
+	}
+	directory := strings.TrimSuffix(dir, "/") + "/"
+	if _, err = os.Stat(directory); err != nil {
+		return nil, err
+	}
+	fileLock, err := acquireLock(directory + "LOCK")
+	if err != nil {
+		return nil, err
+	}
+	defer fileLock.release()
+
+	e := &Engine{Config: cfg, directory: directory, tables: structures.TableOptions{MerkleHash: merkleHash,
+		Operators: newMergeOperators(cfg)}}
+	registry, err := e.readKeyspaceRegistry()
+	if err != nil {
+		return nil, err
+	}
+	names := reservedKeyspaces()
+	for name := range registry {
+		if !isBuiltinKeyspace(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	report := &RepairReport{Keyspaces: make(map[string]*structures.RepairReport)}
+	for _, name := range names {
+		settings, ok := registry[name]
+		if !ok {
+			settings = e.KeyspaceSettings()
+		}
+		options, err := e.tableOptions(settings)
+		if err != nil {
+			return nil, fmt.Errorf("keyspace %s: %w", name, err)
+		}
+		keyspaceReport, err := structures.RepairSSTables(e.keyspaceDirectory(name), e.quarantineDirectory(name),
+			options)
+		if err != nil {
+			return nil, fmt.Errorf("repair of keyspace %s: %w", name, err)
+		}
+		if err = releaseQuarantinedRanges(e.keyspaceDirectory(name), keyspaceReport, opts.Force); err != nil {
+			return nil, fmt.Errorf("repair of keyspace %s: %w", name, err)
+		}
+		report.Keyspaces[name] = keyspaceReport
+	}
+
+	report.Filename = directory + "repair-" + time.Now().Format("20060102-150405") + ".txt"
+	if err = writeRepairReport(report, names); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// releaseQuarantinedRanges forgets the quarantined tables whose records were put back by
+// the repair. Tables with lost keys are kept, so reads of those keys go on failing, unless
+// force is set: then what is left of them in the other tables is what they hold from now on.
+func releaseQuarantinedRanges(directory string, report *structures.RepairReport, force bool) error {
+	quarantined, err := readQuarantinedRanges(directory)
+	if err != nil {
+		return err
+	}
+	kept := make([]structures.Quarantine, 0)
+	for _, quarantine := range quarantined {
+		for _, keys := range quarantine.Lost {
+			if force {
+				report.Problems = append(report.Problems, fmt.Sprintf("keys in [%q, %q) can be read again, "+
+					"without the versions in quarantined table %s", keys.Start, keys.End, quarantine.Table))
+			} else {
+				report.Problems = append(report.Problems, fmt.Sprintf("keys in [%q, %q) may have been lost with "+
+					"quarantined table %s, reads of them fail until a forced repair", keys.Start, keys.End,
+					quarantine.Table))
+			}
+		}
+		if len(quarantine.Lost) > 0 && !force {
+			kept = append(kept, quarantine)
+		}
+	}
+	if len(kept) > 0 {
+		return writeQuarantinedRanges(directory, kept)
+	}
+	if err = os.Remove(directory + quarantinedRangesFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func writeRepairReport(report *RepairReport, names []string) error {
+	file, err := os.Create(report.Filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	for _, name := range names {
+		keyspaceReport := report.Keyspaces[name]
+		_, _ = fmt.Fprintf(writer, "keyspace %s: %d tables rebuilt\n", name, len(keyspaceReport.Rebuilt))
+		for _, dropped := range keyspaceReport.Dropped {
+			_, _ = fmt.Fprintf(writer, "  dropped record %d of %s, key %q: %s\n", dropped.Index, dropped.Table,
+				dropped.Key, dropped.Reason)
+		}
+		for _, problem := range keyspaceReport.Problems {
+			_, _ = fmt.Fprintf(writer, "  %s\n", problem)
+		}
+	}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,242 @@
+package system
+
+import (
+	"KVSystem/system/structures"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ScrubberStats describe what the scrubber has found since the engine was opened.
+type ScrubberStats struct {
+	Passes        int // passes over all SSTables that were finished
+	TablesChecked int
+	Quarantined   []QuarantinedTable
+	LastError     error // the last error, other than corruption, that ended a pass
+}
+
+// QuarantinedTable is a corrupt SSTable that the scrubber moved out of its keyspace.
+type QuarantinedTable struct {
+	Keyspace  string
+	Table     string // data file of the table before it was moved
+	Directory string // where its files are now
+	Problem   string
+	Time      time.Time
+}
+
+var errScrubberStopped = errors.New("scrubber stopped")
+
+// scrubber checks the checksums and Merkle roots of all SSTables in the background and
+// moves corrupt tables to the quarantine directory of the engine.
+type scrubber struct {
+	engine   *Engine
+	interval time.Duration
+	limiter  *structures.RateLimiter
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	lock     sync.Mutex // guards stats
+	stats    ScrubberStats
+}
+
+func newScrubber(engine *Engine) *scrubber {
+	params := engine.Config.ScrubberParameters
+	return &scrubber{
+		engine:   engine,
+		interval: time.Duration(params.ScrubberInterval) * time.Second,
+		limiter:  structures.NewRateLimiter(0, params.ScrubberTablesPerSecond),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// start runs a pass every interval until shutdown, unless the scrubber is turned off.
+func (s *scrubber) start() {
+	if s.interval <= 0 {
+		close(s.done)
+		return
+	}
+	go func() {
+		defer close(s.done)
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(s.interval):
+			}
+			if err := s.pass(s.wait); err != nil && !errors.Is(err, errScrubberStopped) {
+				s.lock.Lock()
+				s.stats.LastError = err
+				s.lock.Unlock()
+			}
+		}
+	}()
+}
+
+// shutdown stops the background passes and waits for the current one to end.
+func (s *scrubber) shutdown() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+}
+
+// wait blocks until the rate limit allows the next table. It returns false on shutdown.
+func (s *scrubber) wait() bool {
+	for !s.limiter.AllowRequest() {
+		select {
+		case <-s.stop:
+			return false
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	select {
+	case <-s.stop:
+		return false
+	default:
+		return true
+	}
+}
+
+// pass checks every SSTable of every keyspace once. The engine is locked for one table at
+// a time, so reads and writes go on between tables.
+func (s *scrubber) pass(wait func() bool) error {
+	for _, name := range s.engine.ListKeyspaces() {
+		ks := s.engine.Keyspace(name)
+		if ks == nil {
+			continue
+		}
+		for level := 1; level <= ks.settings.LSMParameters.LSMMaxLevel; level++ {
+			for num := 1; ; {
+				if !wait() {
+					return errScrubberStopped
+				}
+				exists, quarantined, err := ks.scrubTable(level, num)
+				if err != nil {
+					return err
+				}
+				if !exists {
+					break
+				}
+
+				s.lock.Lock()
+				s.stats.TablesChecked++
+				if quarantined != nil {
+					s.stats.Quarantined = append(s.stats.Quarantined, *quarantined)
+				}
+				s.lock.Unlock()
+				num++
+			}
+		}
+	}
+	s.lock.Lock()
+	s.stats.Passes++
+	s.lock.Unlock()
+	return nil
+}
+
+func (s *scrubber) Stats() ScrubberStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	stats := s.stats
+	stats.Quarantined = append([]QuarantinedTable(nil), s.stats.Quarantined...)
+	return stats
+}
+
+// scrubTable checks the table with the given number on a level and quarantines it if it is
+// corrupt. It returns false if the level has no such table.
+func (ks *Keyspace) scrubTable(level, num int) (exists bool, quarantined *QuarantinedTable, err error) {
+	ks.engine.lock.Lock()
+	defer ks.engine.lock.Unlock()
+	if ks.dropped {
+		return false, nil, nil
+	}
+	if err = ks.usable(); err != nil {
+		return false, nil, err
+	}
+
+	count, err := structures.TableCount(ks.directory, level)
+	if err != nil || num > count {
+		return false, nil, err
+	}
+	report, err := structures.VerifyTable(ks.directory, level, num)
+	if err != nil || !report.Corrupted() {
+		return true, nil, err
+	}
+
+	now := time.Now()
+	directory := ks.engine.quarantineDirectory(ks.name) + now.Format("20060102-150405.000000000") + "/"
+	quarantine, err := structures.QuarantineSSTable(ks.directory, level, num, directory, ks.tables)
+	if err != nil {
+		return true, nil, err
+	}
+	// Older versions of the keys the markers do not stand for are in other tables, so reads
+	// of them fail instead of returning those, even after the engine is opened again.
+	ks.quarantined = append(ks.quarantined, quarantine)
+	if err = writeQuarantinedRanges(ks.directory, ks.quarantined); err != nil {
+		return true, nil, err
+	}
+	// The cache may hold values read from the quarantined table.
+	ks.cache = structures.NewLRUCache(ks.engine.Config.CacheParameters.CacheMaxData)
+	return true, &QuarantinedTable{
+		Keyspace:  ks.name,
+		Table:     filepath.Base(report.Table),
+		Directory: directory,
+		Problem:   report.Problem(),
+		Time:      now,
+	}, nil
+}
+
+// quarantineDirectory holds the quarantined tables of a keyspace.
+func (e *Engine) quarantineDirectory(name string) string {
+	return e.directory + "quarantine/" + name + "/"
+}
+
+// quarantinedRangesFile lists the quarantined tables of a keyspace and their lost keys.
+const quarantinedRangesFile = "quarantined.json"
+
+func readQuarantinedRanges(directory string) ([]structures.Quarantine, error) {
+	var ranges []structures.Quarantine
+	jsonBytes, err := ioutil.ReadFile(directory + quarantinedRangesFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(jsonBytes, &ranges); err != nil {
+		return nil, fmt.Errorf("%w: %s%s: %v", ErrCorruption, directory, quarantinedRangesFile, err)
+	}
+	return ranges, nil
+}
+
+func writeQuarantinedRanges(directory string, ranges []structures.Quarantine) error {
+	file, _ := json.MarshalIndent(ranges, "", "  ")
+	return ioutil.WriteFile(directory+quarantinedRangesFile, file, 0644)
+}
+
+// checkQuarantine returns ErrCorruption if keys in [start, end) may have been lost with a
+// quarantined table. A forced repair allows reads of them again.
+func (ks *Keyspace) checkQuarantine(start, end string) error {
+	for _, quarantine := range ks.quarantined {
+		if quarantine.Overlaps(start, end) {
+			return fmt.Errorf("%w: keys in [%q, %q) may have been lost with quarantined table %s", ErrCorruption,
+				start, end, quarantine.Table)
+		}
+	}
+	return nil
+}
+
+// Scrub checks every SSTable now, without the rate limit, and quarantines the corrupt ones.
+func (e *Engine) Scrub() error {
+	return e.scrubber.pass(func() bool { return true })
+}
+
+// ScrubberStats returns what the scrubber has checked and quarantined so far.
+func (e *Engine) ScrubberStats() ScrubberStats {
+	return e.scrubber.Stats()
+}
@@ -0,0 +1,109 @@
+package system
+
+import (
+	"KVSystem/config"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// corruptLastValue flips the last byte of the data file of table num on level 1, so the
+// value of its last record no longer matches its checksum.
+func corruptLastValue(t *testing.T, ks *Keyspace, num int) {
+	t.Helper()
+	filename := ks.directory + "sstable/usertable-data-ic-" + strconv.Itoa(num) + "-lev1-Data.db"
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err = os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScrubQuarantinesOnlyCorruptTables(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	defer func() { e.Close() }()
+	ks := e.Keyspace(DefaultKeyspace)
+	put := func(key string) func() error {
+		return func() error { return e.Put(key, []byte(key), false) }
+	}
+	flushTables(t, ks, put("a"), put("b"), put("c"))
+
+	if err := e.Scrub(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := e.ScrubberStats(); stats.Passes != 1 || stats.TablesChecked != 3 || len(stats.Quarantined) != 0 {
+		t.Fatalf("scrub of intact tables gives %+v", stats)
+	}
+
+	corruptLastValue(t, ks, 2)
+	if err := e.Scrub(); err != nil {
+		t.Fatal(err)
+	}
+	stats := e.ScrubberStats()
+	if stats.Passes != 2 || len(stats.Quarantined) != 1 {
+		t.Fatalf("scrub of a corrupt table gives %+v", stats)
+	}
+	quarantined := stats.Quarantined[0]
+	if quarantined.Keyspace != DefaultKeyspace || quarantined.Table != "usertable-data-ic-2-lev1-Data.db" ||
+		!strings.Contains(quarantined.Problem, "bad checksum of record 0") {
+		t.Errorf("quarantined table is %+v", quarantined)
+	}
+	if !strings.HasPrefix(quarantined.Directory, e.quarantineDirectory(DefaultKeyspace)) {
+		t.Errorf("table was moved to %s", quarantined.Directory)
+	}
+	if _, err := os.Stat(filepath.Join(quarantined.Directory, quarantined.Table)); err != nil {
+		t.Errorf("quarantined data file: %v", err)
+	}
+
+	// The placeholder in the slot of the table is intact, and so are the other tables.
+	if err := e.Scrub(); err != nil {
+		t.Fatal(err)
+	}
+	if stats = e.ScrubberStats(); len(stats.Quarantined) != 1 {
+		t.Errorf("second scrub quarantined %+v", stats.Quarantined)
+	}
+	if _, err := e.Get("b"); !errors.Is(err, ErrCorruption) {
+		t.Errorf("key of the quarantined table gives %v", err)
+	}
+	for _, key := range []string{"a", "c"} {
+		if value, err := e.Get(key); err != nil || string(value) != key {
+			t.Errorf("%s is %q, %v", key, value, err)
+		}
+	}
+}
+
+func TestScrubberRunsInTheBackground(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	if err := e.Put("key", []byte("value"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	corruptLastValue(t, e.Keyspace(DefaultKeyspace), 1)
+
+	cfg := config.DefaultConfig()
+	cfg.ScrubberParameters.ScrubberInterval = 1
+	e, err := Open(e.Directory(), Options{Config: cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for len(e.ScrubberStats().Quarantined) == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if stats := e.ScrubberStats(); len(stats.Quarantined) != 1 || stats.LastError != nil {
+		t.Errorf("background scrubber gives %+v", stats)
+	}
+	if err = e.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
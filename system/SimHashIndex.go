@@ -0,0 +1,140 @@
+package system
+
+import (
+	"KVSystem/system/structures"
+	"errors"
+	"fmt"
+)
+
+// simHashIndexMeta is the key of the index keyspace that tells which fingerprint size and
+// distance the index was built for. Entries start with a table number, so they sort before it.
+const simHashIndexMeta = "meta"
+
+// simHashIndexBatch is how many writes a rebuild of the index puts in one batch.
+const simHashIndexBatch = 100
+
+// indexSimHash adds to batch the entries of the fingerprint stored under key. Fingerprints
+// of another size than the index are not indexed.
+func (e *Engine) indexSimHash(batch *Batch, key string, fingerprint structures.Fingerprint) {
+	if fingerprint.Size != e.simHashIndex.Size {
+		return
+	}
+	for _, entry := range e.simHashIndex.Entries(key, fingerprint) {
+		batch.Put(SimHashIndexKeyspace, entry, structures.EncodeFingerprint(fingerprint))
+	}
+}
+
+// unindexSimHash adds to batch the removal of the entries of the fingerprint stored under key.
+func (e *Engine) unindexSimHash(batch *Batch, key string, fingerprint structures.Fingerprint) {
+	if fingerprint.Size != e.simHashIndex.Size {
+		return
+	}
+	for _, entry := range e.simHashIndex.Entries(key, fingerprint) {
+		batch.Delete(SimHashIndexKeyspace, entry)
+	}
+}
+
+// DeleteSimHash deletes the fingerprint under key together with its index entries.
+func (e *Engine) DeleteSimHash(key string) error {
+	e.simHashLock.Lock()
+	defer e.simHashLock.Unlock()
+	fingerprint, err := e.GetSimHash(key)
+	if errors.Is(err, ErrCorruption) {
+		// Its entries cannot be found, and SimilarTo skips them.
+		return e.Keyspace(SimHashKeyspace).Delete(key)
+	} else if err != nil {
+		return err
+	}
+	batch := NewBatch()
+	batch.Delete(SimHashKeyspace, key)
+	e.unindexSimHash(batch, key, fingerprint)
+	return e.Write(batch)
+}
+
+// checkSimHashIndex rebuilds the SimHash index from the stored fingerprints when it was
+// built for another fingerprint size or distance than the configuration sets, or not at all.
+func (e *Engine) checkSimHashIndex() error {
+	index := e.Keyspace(SimHashIndexKeyspace)
+	layout := fmt.Sprintf("%d/%d", e.simHashIndex.Size, e.simHashIndex.MaxDistance)
+	meta, err := index.Get(simHashIndexMeta)
+	if err == nil && string(meta) == layout {
+		return nil
+	} else if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	if err = index.DeleteRange("", simHashIndexMeta); err != nil {
+		return err
+	}
+	keys, values, err := e.Keyspace(SimHashKeyspace).ScanAs("", "", structures.TypeSimHash)
+	if err != nil {
+		return err
+	}
+	batch := NewBatch()
+	for i, key := range keys {
+		fingerprint, err := structures.DecodeFingerprint(values[i])
+		if err != nil {
+			continue
+		}
+		e.indexSimHash(batch, key, fingerprint)
+		if batch.Len() >= simHashIndexBatch {
+			if err = e.Write(batch); err != nil {
+				return err
+			}
+			batch = NewBatch()
+		}
+	}
+	// The layout is written last, so an interrupted rebuild starts over.
+	batch.Put(SimHashIndexKeyspace, simHashIndexMeta, []byte(layout))
+	return e.Write(batch)
+}
+
+// SimilarTo returns the stored fingerprints within Hamming distance k of the fingerprint of
+// text, closest first. It returns ErrDistanceTooLarge if k is larger than the distance the
+// index was configured for.
+func (e *Engine) SimilarTo(text string, k int) ([]structures.SimilarFingerprint, error) {
+	if k > e.simHashIndex.MaxDistance {
+		return nil, fmt.Errorf("%w: %d > %d", ErrDistanceTooLarge, k, e.simHashIndex.MaxDistance)
+	}
+	fingerprint := structures.GenerateTextFromString(text, e.simHash).Fingerprint()
+	index := e.Keyspace(SimHashIndexKeyspace)
+
+	similar := make([]structures.SimilarFingerprint, 0)
+	seen := make(map[string]bool)
+	for _, prefix := range e.simHashIndex.Prefixes(fingerprint) {
+		entries, values, err := index.ScanPrefix(prefix)
+		if err != nil {
+			return nil, err
+		}
+		for i, entry := range entries {
+			key := e.simHashIndex.EntryKey(entry)
+			if seen[key] {
+				continue
+			}
+			candidate, err := structures.DecodeFingerprint(values[i])
+			if err != nil {
+				continue
+			}
+			distance, err := fingerprint.Distance(candidate)
+			if err != nil || distance > k {
+				continue
+			}
+			// Entries of fingerprints that were changed or deleted behind the engine's back
+			// are left out by reading the fingerprint under the key.
+			seen[key] = true
+			stored, err := e.GetSimHash(key)
+			var mismatch *structures.TypeMismatchError
+			if errors.Is(err, ErrNotFound) || errors.Is(err, ErrCorruption) || errors.As(err, &mismatch) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			if distance, err = fingerprint.Distance(stored); err != nil || distance > k {
+				continue
+			}
+			similar = append(similar, structures.SimilarFingerprint{Key: key, Fingerprint: stored, Distance: distance})
+		}
+	}
+	structures.SortSimilar(similar)
+	return similar, nil
+}
@@ -0,0 +1,121 @@
+package system
+
+import (
+	"KVSystem/config"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// variants returns texts that differ from one another in more and more words. The texts are
+// long, so a changed word moves their fingerprints by a few bits.
+func variants() map[string]string {
+	words := strings.Fields(strings.Repeat(`the storage engine keeps recent writes in a memory table and flushes
+it to sorted string tables on disk where compaction merges the tables of each level into the next one `, 4) +
+		`every table has an index a summary and a filter so a lookup reads at most one block of a table`)
+	replacements := strings.Fields("bread oven crust flour yeast salt water dough knead proof bake slice")
+	texts := make(map[string]string)
+	for changed := 0; changed <= len(replacements); changed++ {
+		text := append([]string(nil), words...)
+		for i := 0; i < changed; i++ {
+			text[2*i+1] = replacements[i]
+		}
+		texts["doc"+string(rune('a'+changed))] = strings.Join(text, " ")
+	}
+	return texts
+}
+
+// similarByDistance returns the keys among texts whose fingerprints are within k bits of
+// the one under query, found by comparing it with every stored fingerprint.
+func similarByDistance(t *testing.T, e *Engine, texts map[string]string, query string, k int) []string {
+	t.Helper()
+	keys := make([]string, 0)
+	for key := range texts {
+		distance, err := e.SimHashDistance(query, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if distance <= k {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func checkSimilarTo(t *testing.T, e *Engine, texts map[string]string, query string, k int) int {
+	t.Helper()
+	similar, err := e.SimilarTo(texts[query], k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]string, len(similar))
+	for i, s := range similar {
+		keys[i] = s.Key
+		if i > 0 && s.Distance < similar[i-1].Distance {
+			t.Errorf("%s within %d: %s is closer than %s before it", query, k, s.Key, similar[i-1].Key)
+		}
+	}
+	sort.Strings(keys)
+	if want := similarByDistance(t, e, texts, query, k); strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Errorf("%s within %d: index finds %v, comparing every fingerprint finds %v", query, k, keys, want)
+	}
+	return len(keys)
+}
+
+func TestSimilarToMatchesAFullComparison(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.SimHashParameters.SimHashIndexDistance = 6
+	e, err := Open(t.TempDir(), Options{Config: cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { e.Close() }()
+
+	texts := variants()
+	for key, text := range texts {
+		if _, err = e.PutSimHash(key, text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	found := 0
+	for k := 0; k <= 6; k++ {
+		found += checkSimilarTo(t, e, texts, "doca", k)
+	}
+	if found <= 7 {
+		t.Errorf("queries found only the text itself")
+	}
+	if _, err = e.SimilarTo(texts["doca"], 7); !errors.Is(err, ErrDistanceTooLarge) {
+		t.Errorf("query past the distance of the index gives %v", err)
+	}
+
+	// Deleted and changed fingerprints leave the index with their entries.
+	if err = e.DeleteSimHash("docb"); err != nil {
+		t.Fatal(err)
+	}
+	delete(texts, "docb")
+	if _, err = e.PutSimHash("docc", texts["docl"]); err != nil {
+		t.Fatal(err)
+	}
+	texts["docc"] = texts["docl"]
+	e = reopenWithConfig(t, e, cfg)
+	checkSimilarTo(t, e, texts, "doca", 6)
+
+	// An index built for another distance is built again.
+	cfg.SimHashParameters.SimHashIndexDistance = 2
+	e = reopenWithConfig(t, e, cfg)
+	checkSimilarTo(t, e, texts, "doca", 2)
+}
+
+func reopenWithConfig(t *testing.T, e *Engine, cfg *config.Config) *Engine {
+	t.Helper()
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	e, err := Open(e.Directory(), Options{Config: cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
@@ -0,0 +1,196 @@
+package structures
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// KeyRange holds the keys from Start to End, both included.
+type KeyRange struct {
+	Start string
+	End   string
+}
+
+// merkleTable is what the comparison of two tables needs: the keys from the index and the
+// node hashes from the metadata file.
+type merkleTable struct {
+	keys   []string
+	hashes [][]byte
+	shape  *merkleShape
+}
+
+// merkleShape maps the nodes of a tree with a given number of leaves to their positions in
+// the metadata file. The shape depends only on the number of leaves, not on the hash function.
+type merkleShape struct {
+	root      *MerkleNode
+	positions map[*MerkleNode]int
+	leaves    map[*MerkleNode]int
+	leafNodes []*MerkleNode
+}
+
+func newMerkleShape(leafCount int) *merkleShape {
+	shape := &merkleShape{
+		leafNodes: CreateLeafNodes(make([][]byte, leafCount), SHA1),
+		positions: make(map[*MerkleNode]int),
+		leaves:    make(map[*MerkleNode]int),
+	}
+	shape.root = CreateAllNodes(shape.leafNodes, SHA1)
+	for i, node := range breadthFirst(shape.root) {
+		shape.positions[node] = i
+	}
+	for i, leaf := range shape.leafNodes {
+		shape.leaves[leaf] = i
+	}
+	return shape
+}
+
+// DiffSSTables compares the SSTables of two keyspaces, stored in directories a and b, and
+// returns the sorted ranges of keys whose records differ in value, kind or timestamp. Tables
+// are paired by level and number, so two replicas that hold the same records with the same
+// settings have the same tables. Trees of paired tables with the same number of records are compared from the
+// root down, only into subtrees that differ; otherwise their leaves are compared in key order.
+func DiffSSTables(a, b string, maxLevels int) ([]KeyRange, error) {
+	ranges := make([]KeyRange, 0)
+	for levelNum := 1; levelNum <= maxLevels; levelNum++ {
+		countA, err := TableCount(a, levelNum)
+		if err != nil {
+			return nil, err
+		}
+		countB, err := TableCount(b, levelNum)
+		if err != nil {
+			return nil, err
+		}
+		for tableNum := 1; tableNum <= countA || tableNum <= countB; tableNum++ {
+			x, err := loadMerkleTable(a, levelNum, tableNum, countA)
+			if err != nil {
+				return nil, err
+			}
+			y, err := loadMerkleTable(b, levelNum, tableNum, countB)
+			if err != nil {
+				return nil, err
+			}
+			if len(x.keys) == len(y.keys) {
+				ranges = append(ranges, diffTrees(x, y)...)
+			} else {
+				ranges = append(ranges, diffLeaves(x, y)...)
+			}
+		}
+	}
+	return mergeKeyRanges(ranges), nil
+}
+
+// loadMerkleTable reads a table for the comparison. A table past the last one of the level
+// is empty.
+func loadMerkleTable(directory string, levelNum, tableNum, count int) (*merkleTable, error) {
+	if tableNum > count {
+		return &merkleTable{shape: newMerkleShape(0), hashes: [][]byte{nil}}, nil
+	}
+	table, err := readSSTable(directory, strconv.Itoa(tableNum), strconv.Itoa(levelNum))
+	if err != nil {
+		return nil, err
+	}
+	keys, err := readIndexKeys(table.indexFilename)
+	if err != nil {
+		return nil, err
+	}
+	filename := metadataFilename(directory, table.dataFilename)
+	hashes, err := ReadTreeFromFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	shape := newMerkleShape(len(keys))
+	if len(hashes) != len(shape.positions) {
+		return nil, corrupted(filename, fmt.Errorf("%d nodes for %d records", len(hashes), len(keys)))
+	}
+	return &merkleTable{keys: keys, hashes: hashes, shape: shape}, nil
+}
+
+// leafHash returns the stored hash of the i-th leaf.
+func (mt *merkleTable) leafHash(i int) []byte {
+	return mt.hashes[mt.shape.positions[mt.shape.leafNodes[i]]]
+}
+
+// diffTrees compares two trees of the same shape from the root down. The nodes of the
+// shape of x give the positions of the hashes in both trees.
+func diffTrees(x, y *merkleTable) []KeyRange {
+	ranges := make([]KeyRange, 0)
+	var descend func(node *MerkleNode)
+	descend = func(node *MerkleNode) {
+		position := x.shape.positions[node]
+		if bytes.Equal(x.hashes[position], y.hashes[position]) {
+			return
+		}
+		if i, ok := x.shape.leaves[node]; ok {
+			start, end := x.keys[i], y.keys[i]
+			if start > end {
+				start, end = end, start
+			}
+			ranges = append(ranges, KeyRange{start, end})
+			return
+		}
+		if node.Left != nil {
+			descend(node.Left)
+		}
+		if node.Right != nil {
+			descend(node.Right)
+		}
+	}
+	descend(x.shape.root)
+	return ranges
+}
+
+// diffLeaves compares the leaves of two tables in key order. Keys that differ one after
+// another form a single range.
+func diffLeaves(x, y *merkleTable) []KeyRange {
+	ranges := make([]KeyRange, 0)
+	extend := false
+	add := func(key string, differs bool) {
+		if !differs {
+			extend = false
+			return
+		}
+		if extend {
+			ranges[len(ranges)-1].End = key
+		} else {
+			ranges = append(ranges, KeyRange{key, key})
+		}
+		extend = true
+	}
+
+	i, j := 0, 0
+	for i < len(x.keys) || j < len(y.keys) {
+		switch {
+		case j == len(y.keys) || i < len(x.keys) && x.keys[i] < y.keys[j]:
+			add(x.keys[i], true)
+			i++
+		case i == len(x.keys) || y.keys[j] < x.keys[i]:
+			add(y.keys[j], true)
+			j++
+		default:
+			add(x.keys[i], !bytes.Equal(x.leafHash(i), y.leafHash(j)))
+			i++
+			j++
+		}
+	}
+	return ranges
+}
+
+// mergeKeyRanges sorts the ranges and joins the ones that overlap.
+func mergeKeyRanges(ranges []KeyRange) []KeyRange {
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Start < ranges[j].Start
+	})
+	merged := make([]KeyRange, 0, len(ranges))
+	for _, r := range ranges {
+		if last := len(merged) - 1; last >= 0 && r.Start <= merged[last].End {
+			if r.End > merged[last].End {
+				merged[last].End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
@@ -0,0 +1,126 @@
+package structures
+
+import (
+	"os"
+	"testing"
+)
+
+// writeTestTable flushes the records of mt to a new table of the keyspace stored in a
+// temporary directory, with every timestamp set to the same time, and returns the directory.
+func writeTestTable(t *testing.T, mt *MemoryTable) string {
+	t.Helper()
+	directory := t.TempDir() + "/"
+	for _, dir := range []string{SSTableDirectory(directory), MetadataDirectory(directory)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for node := mt.skipList.head.NextNodes[0]; node != nil; node = node.NextNodes[0] {
+		node.Timestamp = "2024-01-01 00:00:00"
+	}
+	if err := mt.PerformFlush(directory, TableOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	return directory
+}
+
+func TestDiffFindsDeletes(t *testing.T) {
+	live := NewMemoryTable(5, 100, 80)
+	deleted := NewMemoryTable(5, 100, 80)
+	for _, mt := range []*MemoryTable{live, deleted} {
+		mt.Insert("a", []byte("1"), false)
+		mt.Insert("b", []byte("2"), false)
+		mt.Insert("c", []byte("3"), false)
+	}
+	// A delete keeps the old value in the tombstone.
+	deleted.Insert("b", []byte("2"), true)
+
+	a, b := writeTestTable(t, live), writeTestTable(t, deleted)
+	ranges, err := DiffSSTables(a, b, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 1 || ranges[0] != (KeyRange{"b", "b"}) {
+		t.Errorf("ranges %v, want b", ranges)
+	}
+
+	same := writeTestTable(t, live)
+	if ranges, err = DiffSSTables(a, same, 1); err != nil || len(ranges) != 0 {
+		t.Errorf("equal tables differ in %v, %v", ranges, err)
+	}
+}
+
+func TestDiffFindsChangedAndMissingKeys(t *testing.T) {
+	keys := []string{"a", "b", "c", "d", "e", "f", "g"}
+	table := func(change map[string]string, skip ...string) *MemoryTable {
+		mt := NewMemoryTable(5, 100, 80)
+		for _, key := range keys {
+			if contains(skip, key) {
+				continue
+			}
+			value := key
+			if changed, ok := change[key]; ok {
+				value = changed
+			}
+			mt.Insert(key, []byte(value), false)
+		}
+		return mt
+	}
+	a := writeTestTable(t, table(nil))
+
+	// Trees of the same shape are compared from the root down.
+	ranges, err := DiffSSTables(a, writeTestTable(t, table(map[string]string{"b": "x", "f": "x"})), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []KeyRange{{"b", "b"}, {"f", "f"}}; !equalRanges(ranges, want) {
+		t.Errorf("changed values differ in %v, want %v", ranges, want)
+	}
+
+	// Keys that differ one after another form one range.
+	ranges, err = DiffSSTables(a, writeTestTable(t, table(map[string]string{"e": "x"}, "c", "d")), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []KeyRange{{"c", "e"}}; !equalRanges(ranges, want) {
+		t.Errorf("missing keys differ in %v, want %v", ranges, want)
+	}
+
+	// A table missing on one side differs in all of its keys.
+	empty := t.TempDir() + "/"
+	ranges, err = DiffSSTables(a, empty, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []KeyRange{{"a", "g"}}; !equalRanges(ranges, want) {
+		t.Errorf("table and no table differ in %v, want %v", ranges, want)
+	}
+}
+
+func TestMergeKeyRanges(t *testing.T) {
+	ranges := mergeKeyRanges([]KeyRange{{"m", "p"}, {"a", "c"}, {"c", "d"}, {"n", "o"}, {"x", "x"}})
+	if want := []KeyRange{{"a", "d"}, {"m", "p"}, {"x", "x"}}; !equalRanges(ranges, want) {
+		t.Errorf("merged ranges are %v, want %v", ranges, want)
+	}
+}
+
+func equalRanges(x, y []KeyRange) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
@@ -1,38 +1,56 @@
 package structures
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
+	"fmt"
 	"github.com/spaolacci/murmur3"
-	"hash"
 	"math"
-	"os"
-	"time"
 )
 
+// bloomFilterMagic starts a serialized filter. A gob stream cannot start with it, so filter
+// files written with gob by older versions are still told apart and read.
+var bloomFilterMagic = []byte("KVBF")
+
+// Version 2 added the prefix extractor.
+const bloomFilterVersion = 2
+
+// bloomFilterSeed seeds the hashes of new filters, so a filter of the same keys is the same
+// every time it is built.
+const bloomFilterSeed = 0x1b873593
+
 type BloomFilter struct {
-	Set           []byte        // set sa bitovima
-	hashFunctions []hash.Hash32 // slice hash funkcija
-	K             uint          // broj hash funkcija
-	M             uint          // duzina (velicina) bloom filtera
-	P             float64       // verovatnoca false-positive
-	TimeSeconds   uint          // vreme u sekundama od 1.1.1970
+	Set    []uint64        // bitovi filtera, 64 u svakoj reci
+	K      uint            // broj hash funkcija
+	M      uint            // duzina (velicina) bloom filtera u bitovima
+	P      float64         // verovatnoca false-positive
+	Seed   uint32          // seme prve od dve hash funkcije, druga koristi Seed+1
+	Prefix PrefixExtractor // prefiksi kljuceva koji su dodati u filter, sa semenima Seed+2 i Seed+3
+	legacy bool            // read from a gob file, whose K hash functions were all the same
+}
+
+// legacyBloomFilter is the filter as older versions wrote it with gob: a byte per bit and
+// K murmur3 functions that were all seeded with TimeSeconds+1.
+type legacyBloomFilter struct {
+	Set         []byte
+	K           uint
+	M           uint
+	P           float64
+	TimeSeconds uint
 }
 
 func CreateBF(numOfElements uint, falsePositive float64) *BloomFilter {
 	sizeOfFilter := EvaluateMForBloomF(int(numOfElements), falsePositive)
 	numOfHashFunctions := EvaluateKForBloomF(int(numOfElements), sizeOfFilter)
-	hashFs, seconds := GenerateHashFunctions(numOfHashFunctions)
-	filter := BloomFilter{Set: make([]byte, sizeOfFilter), hashFunctions: hashFs, K: numOfHashFunctions, M: sizeOfFilter, P: falsePositive, TimeSeconds: seconds}
-	return &filter
-}
-
-func GenerateHashFunctions(numOfHashFunctions uint) ([]hash.Hash32, uint) {
-	var hashFuncs []hash.Hash32
-	seconds := uint(time.Now().Unix())
-	for i := uint(0); i < numOfHashFunctions; i++ {
-		hashFuncs = append(hashFuncs, murmur3.New32WithSeed(uint32(seconds+1)))
+	return &BloomFilter{
+		Set:  make([]uint64, (sizeOfFilter+63)/64),
+		K:    numOfHashFunctions,
+		M:    sizeOfFilter,
+		P:    falsePositive,
+		Seed: bloomFilterSeed,
 	}
-	return hashFuncs, seconds
 }
 
 // Generise broj hash funkcija
@@ -45,79 +63,206 @@ func EvaluateMForBloomF(numOfElements int, falsePositive float64) uint {
 	return uint(math.Ceil(float64(numOfElements) * math.Abs(math.Log(falsePositive)) / math.Pow(math.Log(2), float64(2))))
 }
 
+// positions calls visit with the K bits of a key, until visit returns false. The i-th bit
+// is h1 + i*h2 (Kirsch–Mitzenmacher), where h1 and h2 are murmur3 hashes with the seeds
+// seed and seed+1.
+func (bloomFilter *BloomFilter) positions(key string, seed uint32, visit func(bit uint64) bool) {
+	m := uint64(bloomFilter.M)
+	if bloomFilter.legacy {
+		visit(uint64(murmur3.Sum32WithSeed([]byte(key), bloomFilter.Seed) % uint32(m)))
+		return
+	}
+	h1 := murmur3.Sum64WithSeed([]byte(key), seed)
+	h2 := murmur3.Sum64WithSeed([]byte(key), seed+1)
+	for i := uint64(0); i < uint64(bloomFilter.K); i++ {
+		if !visit((h1 + i*h2) % m) {
+			return
+		}
+	}
+}
+
+func (bloomFilter *BloomFilter) add(key string, seed uint32) {
+	if bloomFilter.M == 0 {
+		return
+	}
+	bloomFilter.positions(key, seed, func(bit uint64) bool {
+		bloomFilter.Set[bit/64] |= 1 << (bit % 64)
+		return true
+	})
+}
+
+func (bloomFilter *BloomFilter) search(key string, seed uint32) bool {
+	if bloomFilter.M == 0 {
+		return false // filter of an empty table
+	}
+	found := true
+	bloomFilter.positions(key, seed, func(bit uint64) bool {
+		found = bloomFilter.Set[bit/64]&(1<<(bit%64)) != 0
+		return found
+	})
+	return found
+}
+
 // Dodavanje elementa u bloom filter
 func (bloomFilter *BloomFilter) Add(element Element) {
-	for _, hashFunction := range bloomFilter.hashFunctions {
-		i := HashTheKey(hashFunction, element.Key, bloomFilter.M)
-		bloomFilter.Set[i] = 1
-	}
+	bloomFilter.add(element.Key, bloomFilter.Seed)
 }
 
 // Pretraga elementa u bloom filteru
 func (bloomFilter *BloomFilter) Search(key string) bool {
-	for _, hashFunction := range bloomFilter.hashFunctions {
-		i := HashTheKey(hashFunction, key, bloomFilter.M)
-		if bloomFilter.Set[i] != 1 {
-			return false
-		}
-	}
-	return true
+	return bloomFilter.search(key, bloomFilter.Seed)
 }
 
-func HashTheKey(hashFunction hash.Hash32, key string, sizeOfFilter uint) uint32 {
-	_, err := hashFunction.Write([]byte(key))
-	if err != nil {
-		panic(err)
+// AddPrefix adds a prefix extracted from a key. Prefixes are hashed with other seeds than
+// keys, so a prefix is never taken for a key with the same bytes.
+func (bloomFilter *BloomFilter) AddPrefix(prefix string) {
+	bloomFilter.add(prefix, bloomFilter.Seed+2)
+}
+
+// SearchPrefix tells whether the table of the filter may hold keys that start with prefix.
+// It returns true when the prefix extractor of the filter cannot tell.
+func (bloomFilter *BloomFilter) SearchPrefix(prefix string) bool {
+	filterPrefix, ok := bloomFilter.Prefix.covering(prefix)
+	if !ok || bloomFilter.legacy {
+		return true
 	}
-	index := hashFunction.Sum32() % uint32(sizeOfFilter)
-	hashFunction.Reset()
-	return index
+	return bloomFilter.search(filterPrefix, bloomFilter.Seed+2)
 }
 
-func CopyHashFunctions(numOfHashFunctions uint, seconds uint) []hash.Hash32 {
-	var hashFuncs []hash.Hash32
-	for i := uint(0); i < numOfHashFunctions; i++ {
-		hashFuncs = append(hashFuncs, murmur3.New32WithSeed(uint32(seconds+1)))
+// SerializeBF encodes the filter as the magic, the format version, K, M, P, the seed, the
+// prefix length, the length and bytes of the prefix delimiter and the bits, all little
+// endian, followed by a CRC32 of everything before it.
+func (bloomFilter *BloomFilter) SerializeBF() []byte {
+	delimiter := bloomFilter.Prefix.Delimiter
+	data := make([]byte, 0, len(bloomFilterMagic)+1+4+8+8+4+4+4+len(delimiter)+8*len(bloomFilter.Set)+4)
+	data = append(data, bloomFilterMagic...)
+	data = append(data, bloomFilterVersion)
+	data = binary.LittleEndian.AppendUint32(data, uint32(bloomFilter.K))
+	data = binary.LittleEndian.AppendUint64(data, uint64(bloomFilter.M))
+	data = binary.LittleEndian.AppendUint64(data, math.Float64bits(bloomFilter.P))
+	data = binary.LittleEndian.AppendUint32(data, bloomFilter.Seed)
+	data = binary.LittleEndian.AppendUint32(data, uint32(bloomFilter.Prefix.Length))
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(delimiter)))
+	data = append(data, delimiter...)
+	for _, word := range bloomFilter.Set {
+		data = binary.LittleEndian.AppendUint64(data, word)
 	}
-	return hashFuncs
+	return binary.LittleEndian.AppendUint32(data, CRC32(data))
 }
 
-func readBF(filepath string) (filter *BloomFilter) {
-	f, err := os.Open(filepath)
-	if err != nil {
-		panic(err)
+// DeserializeBF decodes a filter encoded by SerializeBF. Filters of version 1 have no
+// prefix extractor.
+func DeserializeBF(data []byte) (*BloomFilter, error) {
+	headerLength := 4 + 1 + 4 + 8 + 8 + 4
+	if len(data) < headerLength+4 || !bytes.Equal(data[:4], bloomFilterMagic) {
+		return nil, errors.New("not a bloom filter")
+	}
+	version := data[4]
+	if version < 1 || version > bloomFilterVersion {
+		return nil, fmt.Errorf("unknown bloom filter version %d", version)
+	}
+	body, crc := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if CRC32(body) != crc {
+		return nil, errors.New("bloom filter checksum mismatch")
 	}
-	defer f.Close()
 
-	decoder := gob.NewDecoder(f)
-	filter = new(BloomFilter)
-	_, err = f.Seek(0, 0)
-	if err != nil {
-		return nil
+	bloomFilter := &BloomFilter{
+		K:    uint(binary.LittleEndian.Uint32(data[5:])),
+		M:    uint(binary.LittleEndian.Uint64(data[9:])),
+		P:    math.Float64frombits(binary.LittleEndian.Uint64(data[17:])),
+		Seed: binary.LittleEndian.Uint32(data[25:]),
+	}
+	if version >= 2 {
+		if len(body) < headerLength+8 {
+			return nil, errors.New("bloom filter header is cut short")
+		}
+		bloomFilter.Prefix.Length = int(binary.LittleEndian.Uint32(body[headerLength:]))
+		delimiterLength := uint64(binary.LittleEndian.Uint32(body[headerLength+4:]))
+		headerLength += 8
+		if uint64(len(body)-headerLength) < delimiterLength {
+			return nil, errors.New("bloom filter header is cut short")
+		}
+		bloomFilter.Prefix.Delimiter = string(body[headerLength : headerLength+int(delimiterLength)])
+		headerLength += int(delimiterLength)
 	}
+	words := body[headerLength:]
+	if uint64(len(words)) != (uint64(bloomFilter.M)+63)/64*8 {
+		return nil, errors.New("filter size does not match its bits")
+	}
+	bloomFilter.Set = make([]uint64, len(words)/8)
+	for i := range bloomFilter.Set {
+		bloomFilter.Set[i] = binary.LittleEndian.Uint64(words[8*i:])
+	}
+	return bloomFilter, nil
+}
 
-	for {
-		err = decoder.Decode(filter)
-		if err != nil {
-			//fmt.Println(err)
-			break
+// deserializeLegacyBF decodes a filter written with gob and packs its bits.
+func deserializeLegacyBF(data []byte) (*BloomFilter, error) {
+	legacy := new(legacyBloomFilter)
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(legacy); err != nil {
+		return nil, err
+	}
+	if uint(len(legacy.Set)) != legacy.M {
+		return nil, errors.New("filter size does not match its bits")
+	}
+	bloomFilter := &BloomFilter{
+		Set:    make([]uint64, (legacy.M+63)/64),
+		K:      legacy.K,
+		M:      legacy.M,
+		P:      legacy.P,
+		Seed:   uint32(legacy.TimeSeconds + 1),
+		legacy: true,
+	}
+	for i, bit := range legacy.Set {
+		if bit == 1 {
+			bloomFilter.Set[i/64] |= 1 << (i % 64)
 		}
-		//fmt.Println(*filter)
 	}
-	filter.hashFunctions = CopyHashFunctions(filter.K, filter.TimeSeconds)
-	return
+	return bloomFilter, nil
+}
+
+// BloomLevelStats count how the filters of the tables on one level answered lookups.
+type BloomLevelStats struct {
+	Useful   uint64 // the filter ruled the key out, so the table was not read
+	Useless  uint64 // the filter let the key through but the table did not hold it
+	Positive uint64 // the filter let the key through and the table held it
 }
 
-func writeBF(filepath string, filter *BloomFilter) {
-	f, err := os.Create(filepath)
-	if err != nil {
-		panic(err)
+// FalsePositiveRate returns the share of lookups of keys missing from a table that its filter
+// let through. It can be compared with the configured rate of the level.
+func (stats BloomLevelStats) FalsePositiveRate() float64 {
+	if stats.Useful+stats.Useless == 0 {
+		return 0
 	}
-	defer f.Close()
+	return float64(stats.Useless) / float64(stats.Useful+stats.Useless)
+}
+
+// BloomStats count the bloom filter probes of the tables of a keyspace by level.
+type BloomStats struct {
+	Levels []BloomLevelStats // level 1 first
+}
 
-	encoder := gob.NewEncoder(f)
-	err = encoder.Encode(filter)
-	if err != nil {
-		panic(err)
+// record counts a probe of a filter on a level: whether the filter let the key through
+// and whether the table held it. Nothing is counted in nil stats.
+func (stats *BloomStats) record(level int, passed, found bool) {
+	if stats == nil {
+		return
+	}
+	for len(stats.Levels) < level {
+		stats.Levels = append(stats.Levels, BloomLevelStats{})
+	}
+	levelStats := &stats.Levels[level-1]
+	switch {
+	case !passed:
+		levelStats.Useful++
+	case found:
+		levelStats.Positive++
+	default:
+		levelStats.Useless++
 	}
 }
+
+// Copy returns stats that do not change with the original ones.
+func (stats *BloomStats) Copy() BloomStats {
+	return BloomStats{Levels: append([]BloomLevelStats(nil), stats.Levels...)}
+}
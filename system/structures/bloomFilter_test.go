@@ -0,0 +1,91 @@
+package structures
+
+import (
+	"bytes"
+	"encoding/gob"
+	"github.com/spaolacci/murmur3"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestBloomFilterIsBuiltTheSameWayEveryTime(t *testing.T) {
+	build := func() *BloomFilter {
+		bf := CreateBF(10, 0.01)
+		for _, key := range []string{"a", "b", "c"} {
+			bf.Add(Element{Key: key})
+		}
+		return bf
+	}
+	first, second := build(), build()
+	if first.Seed != bloomFilterSeed {
+		t.Errorf("new filter has seed %d", first.Seed)
+	}
+	if !bytes.Equal(first.SerializeBF(), second.SerializeBF()) {
+		t.Error("filters of the same keys differ")
+	}
+}
+
+func TestBloomFilterHasNoFalseNegatives(t *testing.T) {
+	bf := CreateBF(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		bf.Add(Element{Key: "key" + strconv.Itoa(i)})
+	}
+	for i := 0; i < 10000; i++ {
+		if !bf.Search("key" + strconv.Itoa(i)) {
+			t.Fatalf("key%d is missing", i)
+		}
+	}
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if bf.Search("other" + strconv.Itoa(i)) {
+			falsePositives++
+		}
+	}
+	// The hash functions are independent, so the rate is close to the configured one.
+	if falsePositives > 200 {
+		t.Errorf("%d false positives in 10000 lookups, want about 100", falsePositives)
+	}
+}
+
+func TestBloomFilterRoundTrip(t *testing.T) {
+	bf := CreateBF(100, 0.05)
+	bf.Prefix = PrefixExtractor{Delimiter: ":"}
+	bf.Add(Element{Key: "user:1"})
+	data := bf.SerializeBF()
+
+	decoded, err := DeserializeBF(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.SerializeBF(), data) || decoded.Prefix != bf.Prefix || !decoded.Search("user:1") {
+		t.Errorf("decoded filter is %+v", decoded)
+	}
+	data[len(data)/2] ^= 1
+	if _, err = DeserializeBF(data); err == nil {
+		t.Error("filter with a flipped bit was decoded")
+	}
+	if _, err = DeserializeBF(data[:10]); err == nil {
+		t.Error("filter cut short was decoded")
+	}
+}
+
+func TestLegacyBloomFilterIsRead(t *testing.T) {
+	legacy := legacyBloomFilter{Set: make([]byte, 100), K: 3, M: 100, P: 0.01, TimeSeconds: 41}
+	legacy.Set[murmur3.Sum32WithSeed([]byte("key"), 42)%100] = 1
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(legacy); err != nil {
+		t.Fatal(err)
+	}
+	filename := t.TempDir() + "/Filter.gob"
+	if err := os.WriteFile(filename, buffer.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	filter, err := readFilter(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filter.Search("key") {
+		t.Error("key of a legacy filter is missing")
+	}
+}
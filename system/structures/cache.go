@@ -74,6 +74,9 @@ func (cache *LRUCache) moveNodeToHead(key string) {
 					// Update the tail pointer if the node is the tail
 					list.tail = current.Previous
 				}
+				current.Previous = nil
+				current.Next = nil
+				list.size--
 
 				// Add the node to the head of the list
 				cache.addNodeToHead(current)
@@ -192,6 +195,15 @@ func (cache *LRUCache) Delete(key string) bool {
 	return false
 }
 
+// DeleteRange removes every cached key in [start, end).
+func (cache *LRUCache) DeleteRange(start, end string) {
+	for key, value := range cache.values {
+		if key >= start && key < end {
+			cache.removeNode(key, value)
+		}
+	}
+}
+
 func (cache *LRUCache) Print() {
 	list := cache.list
 	fmt.Println("\nLinked List:")
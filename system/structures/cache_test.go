@@ -0,0 +1,52 @@
+package structures
+
+import (
+	"testing"
+)
+
+// checkList checks that the list of the cache holds keys from the head to the tail, and
+// that it is linked the same way in both directions.
+func checkList(t *testing.T, cache *LRUCache, keys ...string) {
+	t.Helper()
+	list := cache.list
+	if list.size != len(keys) || len(cache.values) != len(keys) {
+		t.Fatalf("cache has size %d and %d values, want %d", list.size, len(cache.values), len(keys))
+	}
+	node := list.head
+	var previous *CacheNode
+	for _, key := range keys {
+		if node == nil || node.Key != key || node.Previous != previous {
+			t.Fatalf("list is not %v", keys)
+		}
+		previous, node = node, node.Next
+	}
+	if node != nil || list.tail != previous {
+		t.Fatalf("list is longer than %v", keys)
+	}
+}
+
+func TestCacheMovesReadKeysToTheHead(t *testing.T) {
+	cache := NewLRUCache(3)
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Put(key, []byte(key))
+	}
+	checkList(t, cache, "c", "b", "a")
+	cache.Get("a")
+	cache.Get("b")
+	cache.Get("a")
+	checkList(t, cache, "a", "b", "c")
+
+	// The least recently read key is evicted.
+	cache.Put("d", []byte("d"))
+	checkList(t, cache, "d", "a", "b")
+	if ok, _ := cache.Get("c"); ok {
+		t.Error("c was not evicted")
+	}
+	cache.Put("b", []byte("new"))
+	checkList(t, cache, "b", "d", "a")
+	if ok, value := cache.Get("b"); !ok || string(value) != "new" {
+		t.Errorf("b is %q, %v", value, ok)
+	}
+	cache.Delete("d")
+	checkList(t, cache, "b", "a")
+}
@@ -3,6 +3,7 @@ package structures
 import (
 	"bytes"
 	"encoding/gob"
+	"fmt"
 	"github.com/spaolacci/murmur3"
 	"hash"
 	"math"
@@ -49,18 +50,25 @@ func GenerateHashFunctionsForCMS(numOfHashFunctions uint) ([]hash.Hash32, uint)
 	return hashFuncs, seconds
 }
 
-func (countMinSketch *CountMinSketch) Add(key string) {
+func (countMinSketch *CountMinSketch) Add(key string) error {
 	for i, hashFunction := range countMinSketch.hashFunctions {
-		j := HashTheKeyForCMS(hashFunction, key, countMinSketch.M)
+		j, err := HashTheKeyForCMS(hashFunction, key, countMinSketch.M)
+		if err != nil {
+			return err
+		}
 		countMinSketch.Set[i][j] += 1
 	}
 	//fmt.Printf("Element %s added !\n", key)
+	return nil
 }
 
-func (countMinSketch *CountMinSketch) Search(key string) int {
+func (countMinSketch *CountMinSketch) Search(key string) (int, error) {
 	values := make([]int, countMinSketch.K, countMinSketch.K)
 	for i, hashFunction := range countMinSketch.hashFunctions {
-		j := HashTheKeyForCMS(hashFunction, key, countMinSketch.M)
+		j, err := HashTheKeyForCMS(hashFunction, key, countMinSketch.M)
+		if err != nil {
+			return 0, err
+		}
 		values[i] = countMinSketch.Set[i][j]
 	}
 
@@ -70,17 +78,17 @@ func (countMinSketch *CountMinSketch) Search(key string) int {
 			minValue = value
 		}
 	}
-	return minValue
+	return minValue, nil
 }
 
-func HashTheKeyForCMS(hashFunction hash.Hash32, key string, sizeOfFilter uint) uint32 {
+func HashTheKeyForCMS(hashFunction hash.Hash32, key string, sizeOfFilter uint) (uint32, error) {
 	_, err := hashFunction.Write([]byte(key))
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
 	index := hashFunction.Sum32() % uint32(sizeOfFilter)
 	hashFunction.Reset()
-	return index
+	return index, nil
 }
 
 func CopyHashFunctionsForCMS(numOfHashFunctions uint, seconds uint) []hash.Hash32 {
@@ -102,18 +110,22 @@ func (countMinSketch *CountMinSketch) SerializeCMS() []byte {
 	return buffer.Bytes()
 }
 
-func DeserializeCMS(data []byte) *CountMinSketch {
-
-	buffer := bytes.NewBuffer(data)
-	decoder := gob.NewDecoder(buffer)
+// DeserializeCMS reads a CountMinSketch written by SerializeCMS. It returns an error if
+// data is not one or its table does not have K rows of M counters.
+func DeserializeCMS(data []byte) (*CountMinSketch, error) {
 	countMinSketch := new(CountMinSketch)
-
-	for {
-		err := decoder.Decode(countMinSketch)
-		if err != nil {
-			break
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(countMinSketch); err != nil {
+		return nil, err
+	}
+	if countMinSketch.K == 0 || countMinSketch.M == 0 || uint(len(countMinSketch.Set)) != countMinSketch.K {
+		return nil, fmt.Errorf("CountMinSketch with %d rows of %d counters has %d rows",
+			countMinSketch.K, countMinSketch.M, len(countMinSketch.Set))
+	}
+	for _, row := range countMinSketch.Set {
+		if uint(len(row)) != countMinSketch.M {
+			return nil, fmt.Errorf("CountMinSketch row has %d counters instead of %d", len(row), countMinSketch.M)
 		}
 	}
 	countMinSketch.hashFunctions = CopyHashFunctionsForCMS(countMinSketch.K, countMinSketch.TimeSeconds)
-	return countMinSketch
+	return countMinSketch, nil
 }
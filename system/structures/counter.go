@@ -0,0 +1,51 @@
+package structures
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+)
+
+var (
+	ErrCounterOverflow = errors.New("counter overflow")
+	ErrNotCounter      = errors.New("value is not a counter")
+)
+
+// EncodeCounter returns the compact binary form of a counter: 8 little-endian bytes.
+func EncodeCounter(value int64) []byte {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, uint64(value))
+	return data
+}
+
+// DecodeCounter reads a counter value of the given type. Missing values count as zero.
+// Counters are in the binary form of EncodeCounter, and raw values hold decimal strings,
+// written by clients before counters existed, whatever their length.
+func DecodeCounter(valueType ValueType, data []byte) (int64, bool) {
+	if len(data) == 0 {
+		return 0, true
+	}
+	switch valueType {
+	case TypeCounter:
+		if len(data) != 8 {
+			return 0, false
+		}
+		return int64(binary.LittleEndian.Uint64(data)), true
+	case TypeRaw:
+		value, err := strconv.ParseInt(string(data), 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return value, true
+	}
+	return 0, false
+}
+
+// AddCounter returns current+delta, or ErrCounterOverflow if the sum does not fit in an int64.
+func AddCounter(current, delta int64) (int64, error) {
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return current, ErrCounterOverflow
+	}
+	return current + delta, nil
+}
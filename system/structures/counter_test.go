@@ -0,0 +1,39 @@
+package structures
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestDecodeCounter(t *testing.T) {
+	for _, test := range []struct {
+		valueType ValueType
+		data      []byte
+		value     int64
+		ok        bool
+	}{
+		{TypeCounter, EncodeCounter(-7), -7, true},
+		{TypeCounter, []byte{1, 2, 3}, 0, false},
+		{TypeRaw, []byte("12345678901"), 12345678901, true},
+		{TypeRaw, []byte("twelve"), 0, false},
+		{TypeRaw, nil, 0, true},
+		{TypeHLL, []byte("1"), 0, false},
+	} {
+		if value, ok := DecodeCounter(test.valueType, test.data); value != test.value || ok != test.ok {
+			t.Errorf("DecodeCounter(%v, %q) = %d, %v, want %d, %v", test.valueType, test.data, value, ok, test.value, test.ok)
+		}
+	}
+}
+
+func TestAddCounterOverflow(t *testing.T) {
+	if _, err := AddCounter(math.MaxInt64, 1); !errors.Is(err, ErrCounterOverflow) {
+		t.Errorf("MaxInt64+1 gives %v", err)
+	}
+	if _, err := AddCounter(math.MinInt64, -1); !errors.Is(err, ErrCounterOverflow) {
+		t.Errorf("MinInt64-1 gives %v", err)
+	}
+	if value, err := AddCounter(math.MaxInt64, math.MinInt64); err != nil || value != -1 {
+		t.Errorf("MaxInt64+MinInt64 gives %d, %v", value, err)
+	}
+}
@@ -0,0 +1,272 @@
+package structures
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/spaolacci/murmur3"
+	"math/rand"
+)
+
+// cuckooFilterMagic starts a serialized cuckoo filter, like bloomFilterMagic starts a bloom filter.
+var cuckooFilterMagic = []byte("KVCF")
+
+const (
+	cuckooFilterVersion = 1
+	cuckooBucketSize    = 4   // fingerprints in a bucket
+	cuckooMaxKicks      = 500 // fingerprints moved before an item goes to the stash
+	cuckooLoadFactor    = 0.95
+	// cuckooFilterSeed seeds the hashes of new filters, so a filter of the same items is
+	// built the same way every time.
+	cuckooFilterSeed = 0x9747b28c
+)
+
+// CuckooFilter is a set of 16-bit fingerprints that, unlike a bloom filter, supports
+// deletion. An item may be in one of two buckets: i1 from the hash of the item and
+// i2 = i1 XOR hash(fingerprint), so the other bucket of a fingerprint is known without the item.
+// Items that find no free slot are kept in a stash, so an Add never loses an item; a filter
+// with a stash holds more than its capacity and gives more false positives.
+type CuckooFilter struct {
+	Buckets []uint16 // cuckooBucketSize fingerprints per bucket, 0 is an empty slot
+	Stash   []cuckooStashEntry
+	Count   uint64 // items in the filter
+	Seed    uint32
+	Prefix  PrefixExtractor // prefixes of keys added to the filter, hashed with Seed+2
+	random  *rand.Rand      // picks the fingerprints to kick out, seeded with Seed
+}
+
+type cuckooStashEntry struct {
+	Index       uint32
+	Fingerprint uint16
+}
+
+// CreateCuckooFilter returns an empty filter for capacity items. The number of buckets is
+// a power of two.
+func CreateCuckooFilter(capacity uint) *CuckooFilter {
+	numBuckets := uint(1)
+	for float64(numBuckets*cuckooBucketSize)*cuckooLoadFactor < float64(capacity) {
+		numBuckets *= 2
+	}
+	return &CuckooFilter{
+		Buckets: make([]uint16, numBuckets*cuckooBucketSize),
+		Seed:    cuckooFilterSeed,
+	}
+}
+
+func (cf *CuckooFilter) numBuckets() uint32 {
+	return uint32(len(cf.Buckets) / cuckooBucketSize)
+}
+
+// locate returns the fingerprint and the first bucket of an item.
+func (cf *CuckooFilter) locate(item string, seed uint32) (uint16, uint32) {
+	hash := murmur3.Sum64WithSeed([]byte(item), seed)
+	fingerprint := uint16(hash >> 48)
+	if fingerprint == 0 {
+		fingerprint = 1
+	}
+	return fingerprint, uint32(hash) & (cf.numBuckets() - 1)
+}
+
+// altIndex returns the other bucket of a fingerprint in bucket i.
+func (cf *CuckooFilter) altIndex(i uint32, fingerprint uint16) uint32 {
+	return (i ^ (uint32(fingerprint) * 0x5bd1e995)) & (cf.numBuckets() - 1)
+}
+
+func (cf *CuckooFilter) bucket(i uint32) []uint16 {
+	return cf.Buckets[i*cuckooBucketSize : (i+1)*cuckooBucketSize]
+}
+
+func (cf *CuckooFilter) insertInto(i uint32, fingerprint uint16) bool {
+	bucket := cf.bucket(i)
+	for slot := range bucket {
+		if bucket[slot] == 0 {
+			bucket[slot] = fingerprint
+			return true
+		}
+	}
+	return false
+}
+
+func (cf *CuckooFilter) add(item string, seed uint32) {
+	fingerprint, i1 := cf.locate(item, seed)
+	i2 := cf.altIndex(i1, fingerprint)
+	cf.Count++
+	if cf.insertInto(i1, fingerprint) || cf.insertInto(i2, fingerprint) {
+		return
+	}
+	if cf.random == nil {
+		cf.random = rand.New(rand.NewSource(int64(cf.Seed)))
+	}
+	i := i1
+	if cf.random.Intn(2) == 1 {
+		i = i2
+	}
+	for kick := 0; kick < cuckooMaxKicks; kick++ {
+		slot := cf.random.Intn(cuckooBucketSize)
+		bucket := cf.bucket(i)
+		fingerprint, bucket[slot] = bucket[slot], fingerprint
+		i = cf.altIndex(i, fingerprint)
+		if cf.insertInto(i, fingerprint) {
+			return
+		}
+	}
+	cf.Stash = append(cf.Stash, cuckooStashEntry{Index: i, Fingerprint: fingerprint})
+}
+
+func (cf *CuckooFilter) contains(item string, seed uint32) bool {
+	fingerprint, i1 := cf.locate(item, seed)
+	i2 := cf.altIndex(i1, fingerprint)
+	for _, i := range []uint32{i1, i2} {
+		for _, stored := range cf.bucket(i) {
+			if stored == fingerprint {
+				return true
+			}
+		}
+	}
+	for _, entry := range cf.Stash {
+		if entry.Fingerprint == fingerprint && (entry.Index == i1 || entry.Index == i2) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add adds an item to the filter.
+func (cf *CuckooFilter) Add(item string) {
+	cf.add(item, cf.Seed)
+}
+
+// Contains tells whether the item may be in the filter. It has no false negatives.
+func (cf *CuckooFilter) Contains(item string) bool {
+	return cf.contains(item, cf.Seed)
+}
+
+// Delete removes one copy of an item and reports whether the filter held it. Deleting an
+// item that was never added may remove another item with the same fingerprint and bucket.
+func (cf *CuckooFilter) Delete(item string) bool {
+	fingerprint, i1 := cf.locate(item, cf.Seed)
+	i2 := cf.altIndex(i1, fingerprint)
+	for _, i := range []uint32{i1, i2} {
+		bucket := cf.bucket(i)
+		for slot := range bucket {
+			if bucket[slot] == fingerprint {
+				bucket[slot] = 0
+				cf.Count--
+				return true
+			}
+		}
+	}
+	for j, entry := range cf.Stash {
+		if entry.Fingerprint == fingerprint && (entry.Index == i1 || entry.Index == i2) {
+			cf.Stash = append(cf.Stash[:j], cf.Stash[j+1:]...)
+			cf.Count--
+			return true
+		}
+	}
+	return false
+}
+
+// Search is Contains, for the filters of SSTables.
+func (cf *CuckooFilter) Search(key string) bool {
+	return cf.Contains(key)
+}
+
+// AddPrefix adds a prefix extracted from a key. Prefixes are hashed with another seed than
+// keys, so a prefix is never taken for a key with the same bytes.
+func (cf *CuckooFilter) AddPrefix(prefix string) {
+	cf.add(prefix, cf.Seed+2)
+}
+
+// SearchPrefix tells whether the table of the filter may hold keys that start with prefix.
+// It returns true when the prefix extractor of the filter cannot tell.
+func (cf *CuckooFilter) SearchPrefix(prefix string) bool {
+	filterPrefix, ok := cf.Prefix.covering(prefix)
+	if !ok {
+		return true
+	}
+	return cf.contains(filterPrefix, cf.Seed+2)
+}
+
+// SerializeCF encodes the filter as the magic, the format version, the number of buckets,
+// the item count, the seed, the prefix length, the length and bytes of the prefix delimiter,
+// the fingerprints, the stash length and the stash entries, all little endian, followed by a
+// CRC32 of everything before it.
+func (cf *CuckooFilter) SerializeCF() []byte {
+	delimiter := cf.Prefix.Delimiter
+	data := make([]byte, 0, len(cuckooFilterMagic)+1+4+8+4+4+4+len(delimiter)+2*len(cf.Buckets)+4+6*len(cf.Stash)+4)
+	data = append(data, cuckooFilterMagic...)
+	data = append(data, cuckooFilterVersion)
+	data = binary.LittleEndian.AppendUint32(data, cf.numBuckets())
+	data = binary.LittleEndian.AppendUint64(data, cf.Count)
+	data = binary.LittleEndian.AppendUint32(data, cf.Seed)
+	data = binary.LittleEndian.AppendUint32(data, uint32(cf.Prefix.Length))
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(delimiter)))
+	data = append(data, delimiter...)
+	for _, fingerprint := range cf.Buckets {
+		data = binary.LittleEndian.AppendUint16(data, fingerprint)
+	}
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(cf.Stash)))
+	for _, entry := range cf.Stash {
+		data = binary.LittleEndian.AppendUint32(data, entry.Index)
+		data = binary.LittleEndian.AppendUint16(data, entry.Fingerprint)
+	}
+	return binary.LittleEndian.AppendUint32(data, CRC32(data))
+}
+
+// DeserializeCF decodes a filter encoded by SerializeCF.
+func DeserializeCF(data []byte) (*CuckooFilter, error) {
+	if len(data) < len(cuckooFilterMagic)+1+4 || !bytes.Equal(data[:4], cuckooFilterMagic) {
+		return nil, errors.New("not a cuckoo filter")
+	}
+	if version := data[4]; version != cuckooFilterVersion {
+		return nil, fmt.Errorf("unknown cuckoo filter version %d", version)
+	}
+	body, crc := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if CRC32(body) != crc {
+		return nil, errors.New("cuckoo filter checksum mismatch")
+	}
+
+	errShort := errors.New("cuckoo filter is cut short")
+	reader := bytes.NewReader(body[5:])
+	var header struct {
+		NumBuckets      uint32
+		Count           uint64
+		Seed            uint32
+		PrefixLength    uint32
+		DelimiterLength uint32
+	}
+	if binary.Read(reader, binary.LittleEndian, &header) != nil {
+		return nil, errShort
+	}
+	if header.NumBuckets == 0 || header.NumBuckets&(header.NumBuckets-1) != 0 {
+		return nil, fmt.Errorf("%d buckets is not a power of two", header.NumBuckets)
+	}
+	if uint64(reader.Len()) < uint64(header.DelimiterLength)+2*uint64(header.NumBuckets)*cuckooBucketSize+4 {
+		return nil, errShort
+	}
+	delimiter := make([]byte, header.DelimiterLength)
+	_, _ = reader.Read(delimiter)
+
+	cf := &CuckooFilter{
+		Buckets: make([]uint16, header.NumBuckets*cuckooBucketSize),
+		Count:   header.Count,
+		Seed:    header.Seed,
+		Prefix:  PrefixExtractor{Length: int(header.PrefixLength), Delimiter: string(delimiter)},
+	}
+	_ = binary.Read(reader, binary.LittleEndian, cf.Buckets)
+	var stashLength uint32
+	_ = binary.Read(reader, binary.LittleEndian, &stashLength)
+	if uint64(reader.Len()) != 6*uint64(stashLength) {
+		return nil, errors.New("cuckoo filter stash does not match its length")
+	}
+	cf.Stash = make([]cuckooStashEntry, stashLength)
+	for i := range cf.Stash {
+		_ = binary.Read(reader, binary.LittleEndian, &cf.Stash[i].Index)
+		_ = binary.Read(reader, binary.LittleEndian, &cf.Stash[i].Fingerprint)
+		if cf.Stash[i].Index >= header.NumBuckets {
+			return nil, errors.New("cuckoo filter stash entry is out of range")
+		}
+	}
+	return cf, nil
+}
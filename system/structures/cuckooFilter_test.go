@@ -0,0 +1,108 @@
+package structures
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestCuckooFilterIsBuiltTheSameWayEveryTime(t *testing.T) {
+	build := func() *CuckooFilter {
+		// More items than the capacity, so some are kicked out of their buckets.
+		cf := CreateCuckooFilter(100)
+		for i := 0; i < 150; i++ {
+			cf.Add("item" + strconv.Itoa(i))
+		}
+		return cf
+	}
+	first, second := build(), build()
+	if !bytes.Equal(first.SerializeCF(), second.SerializeCF()) {
+		t.Error("filters of the same items differ")
+	}
+	for i := 0; i < 150; i++ {
+		if !first.Contains("item" + strconv.Itoa(i)) {
+			t.Errorf("item%d is missing", i)
+		}
+	}
+}
+
+func TestCuckooFilterDelete(t *testing.T) {
+	// Twice the capacity, so some items are in the stash.
+	cf := CreateCuckooFilter(20)
+	for i := 0; i < 40; i++ {
+		cf.Add("item" + strconv.Itoa(i))
+	}
+	if len(cf.Stash) == 0 {
+		t.Fatal("no item went to the stash")
+	}
+	for i := 0; i < 40; i += 2 {
+		if !cf.Delete("item" + strconv.Itoa(i)) {
+			t.Errorf("item%d could not be deleted", i)
+		}
+	}
+	if cf.Count != 20 {
+		t.Errorf("filter holds %d items after deleting half of 40", cf.Count)
+	}
+	for i := 1; i < 40; i += 2 {
+		if !cf.Contains("item" + strconv.Itoa(i)) {
+			t.Errorf("item%d is missing after other items were deleted", i)
+		}
+	}
+	if cf.Delete("never added") {
+		t.Error("an item that was never added was deleted")
+	}
+}
+
+func TestCuckooFilterRoundTrip(t *testing.T) {
+	cf := CreateCuckooFilter(8)
+	cf.Prefix = PrefixExtractor{Length: 2}
+	for i := 0; i < 20; i++ {
+		cf.Add("item" + strconv.Itoa(i))
+	}
+	data := cf.SerializeCF()
+	decoded, err := DeserializeCF(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.SerializeCF(), data) || decoded.Prefix != cf.Prefix || len(decoded.Stash) != len(cf.Stash) {
+		t.Errorf("decoded filter is %+v", decoded)
+	}
+	data[len(data)/2] ^= 1
+	if _, err = DeserializeCF(data); err == nil {
+		t.Error("filter with a flipped bit was decoded")
+	}
+}
+
+func TestCuckooFilterOfSSTable(t *testing.T) {
+	mt := NewMemoryTable(5, 100, 80)
+	for _, key := range []string{"a", "b", "c"} {
+		mt.Insert(key, EncodeValue(TypeRaw, []byte(key)), false)
+	}
+	directory := t.TempDir() + "/"
+	for _, dir := range []string{SSTableDirectory(directory), MetadataDirectory(directory)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mt.PerformFlush(directory, TableOptions{Filter: FilterCuckoo}); err != nil {
+		t.Fatal(err)
+	}
+	filter, err := readFilter(tableFiles(directory, 1, 1).filterFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := filter.(*CuckooFilter); !ok {
+		t.Fatalf("table has filter %T", filter)
+	}
+	var stats BloomStats
+	for _, key := range []string{"a", "b", "c", "d"} {
+		found, _, err := SearchThroughSSTables(directory, key, 1, nil, &stats)
+		if err != nil || found != (key != "d") {
+			t.Errorf("search of %s gives %v, %v", key, found, err)
+		}
+	}
+	if stats.Levels[0].Positive != 3 {
+		t.Errorf("stats are %+v", stats)
+	}
+}
@@ -1,10 +1,45 @@
 package structures
 
+// Record kinds stored in the tombstone byte of WAL and SSTable records.
+const (
+	RecordValue byte = iota
+	RecordTombstone
+	RecordMerge
+	RecordRangeTombstone
+	RecordBatch
+	RecordQuarantined // stands for a record of a quarantined table (SSTable only)
+)
+
 type Element struct {
-	Checksum  uint32
-	Timestamp string
-	Tombstone bool
-	Key       string
-	Value     []byte
-	NextNodes []*Element
+	Checksum    uint32
+	Timestamp   string
+	Tombstone   bool
+	Merge       bool // Value holds encoded merge operands instead of a full value
+	RangeDelete bool // Key and Value hold the start and end of a range tombstone (WAL only)
+	Batch       bool // Value holds an encoded write batch (WAL only)
+	Quarantined bool // Value holds a quarantine marker (SSTable only)
+	Keyspace    string
+	Key         string
+	Value       []byte
+	NextNodes   []*Element
+}
+
+// Kind returns the record kind that is written to disk for the element.
+func (element *Element) Kind() byte {
+	if element.Batch {
+		return RecordBatch
+	}
+	if element.RangeDelete {
+		return RecordRangeTombstone
+	}
+	if element.Quarantined {
+		return RecordQuarantined
+	}
+	if element.Tombstone {
+		return RecordTombstone
+	}
+	if element.Merge {
+		return RecordMerge
+	}
+	return RecordValue
 }
@@ -0,0 +1,493 @@
+// Code generated by hllBiasGen.go; DO NOT EDIT.
+
+package structures
+
+const hllBiasMinPrecision = 4
+
+// hllRawEstimates are mean raw estimates of HyperLogLogs of every precision, and
+// hllBiases how much they overestimate the cardinality.
+var hllRawEstimates = [][]float64{
+	// P = 4
+	{
+		11.2327, 11.7072, 11.7072, 12.2037, 12.7371, 13.2905, 13.8378, 13.8378,
+		14.3819, 14.973, 15.636, 16.2454, 16.2454, 16.8958, 17.5135, 18.2316,
+		18.8741, 18.8741, 19.5359, 20.1988, 20.9622, 21.6831, 21.6831, 22.4494,
+		23.2068, 23.9511, 24.6904, 24.6904, 25.4414, 26.2624, 27.084, 27.7656,
+		27.7656, 28.68, 29.4437, 30.0692, 30.868, 30.868, 31.7148, 32.7682,
+		33.7454, 34.5523, 34.5523, 35.4342, 36.1951, 37.2504, 38.1967, 38.1967,
+		38.9602, 40.2335, 41.3492, 42.2869, 42.2869, 43.0775, 43.9701, 45.1389,
+		46.0514, 46.0514, 47.1816, 48.1164, 49.1923, 49.932, 49.932, 50.9691,
+		51.7405, 52.4562, 53.3954, 53.3954, 54.3651, 55.4104, 56.3421, 57.3282,
+		57.3282, 58.447, 59.5556, 60.3856, 61.5272, 61.5272, 62.4275, 63.6624,
+		64.7253, 65.5381, 65.5381, 66.2608, 67.416, 68.3889, 69.2703, 69.2703,
+		70.3232, 71.3259, 72.5617, 73.5102, 73.5102, 74.1068, 74.8531, 75.541,
+		76.5316, 76.5316, 77.4319, 78.6011,
+	},
+	// P = 5
+	{
+		23.2514, 23.7235, 24.7168, 25.2093, 26.2452, 27.295, 27.8425, 28.9885,
+		29.5416, 30.6431, 31.8555, 32.4709, 33.7099, 34.2925, 35.5089, 36.6618,
+		37.3499, 38.6924, 39.4102, 40.9265, 42.2956, 43.0223, 44.4976, 45.2381,
+		46.7495, 48.4771, 49.1884, 50.8734, 51.6953, 53.0885, 54.467, 55.2083,
+		56.9436, 57.7165, 59.3363, 61.2107, 62.0501, 63.4354, 64.3686, 66.2947,
+		67.9087, 68.9071, 70.5305, 71.3388, 73.0243, 74.8724, 75.9275, 77.8404,
+		78.9213, 80.8172, 82.3777, 83.2024, 85.2998, 86.0683, 88.1816, 89.7987,
+		90.826, 92.3273, 93.5985, 95.6762, 98.0401, 99.1605, 101.058, 102.291,
+		104.348, 106.139, 107.411, 109.108, 109.868, 111.69, 113.64, 114.419,
+		116.542, 117.601, 119.62, 121.01, 122.119, 124.246, 125.263, 126.73,
+		128.884, 129.76, 132.214, 133.223, 135.45, 137.517, 138.535, 140.574,
+		141.785, 143.334, 144.896, 145.897, 147.824, 148.768, 150.896, 152.588,
+		153.661, 155.855, 156.647, 158.328,
+	},
+	// P = 6
+	{
+		46.7974, 48.2605, 50.2892, 51.8382, 53.3966, 55.0245, 56.6829, 59.0251,
+		60.8203, 62.5585, 64.2831, 66.0805, 68.4976, 70.3781, 72.3268, 74.2384,
+		76.3091, 78.9801, 81.036, 83.0908, 85.2165, 87.5189, 90.5728, 92.7192,
+		95.1028, 97.3307, 99.6557, 102.719, 105.052, 107.295, 109.735, 112.074,
+		115.306, 117.599, 120.17, 122.603, 125.002, 128.427, 130.938, 133.602,
+		136.149, 138.843, 142.699, 145.203, 147.659, 150.403, 153.545, 157.236,
+		159.914, 162.757, 165.686, 168.388, 172.351, 175.027, 177.472, 179.798,
+		182.369, 186.509, 188.91, 191.677, 194.975, 197.878, 202.161, 205.576,
+		207.967, 210.65, 213.297, 217.467, 220.257, 223.078, 226.067, 228.944,
+		233.36, 236.767, 239.427, 242.064, 245.655, 249.68, 252.803, 256.201,
+		259.304, 262.112, 267.036, 269.545, 272.363, 275.978, 278.937, 281.621,
+		284.484, 287.349, 290.08, 293.029, 296.824, 299.088, 301.734, 305.008,
+		308.124, 312.889, 316.436, 319.767,
+	},
+	// P = 7
+	{
+		94.4463, 97.8927, 101.014, 104.685, 107.789, 111.061, 114.942, 118.509,
+		122.484, 126.041, 129.798, 133.959, 137.653, 142.177, 145.941, 149.832,
+		154.469, 158.555, 163.522, 167.539, 171.707, 176.87, 181.083, 186.243,
+		190.801, 195.155, 200.524, 205.317, 210.98, 215.807, 220.43, 226.168,
+		231.23, 237.346, 242.794, 247.941, 254.195, 259.168, 264.977, 269.784,
+		274.866, 280.233, 285.646, 291.632, 296.861, 302.479, 309.301, 314.834,
+		322.098, 327.235, 332.9, 338.856, 344.493, 351.114, 356.319, 362.332,
+		368.665, 374.387, 380.868, 385.795, 391.432, 399.132, 405.137, 411.929,
+		417.27, 423.439, 429.86, 436.252, 442.576, 448.186, 454.028, 461.101,
+		466.38, 474.255, 480.68, 486.755, 494.295, 500.85, 507.443, 513.748,
+		519.998, 527.659, 532.689, 539.957, 546.106, 551.62, 558.875, 564.852,
+		570.906, 577.857, 583.222, 590.122, 596.63, 603.358, 609.953, 615.615,
+		623.108, 629.006, 635.08, 640.818,
+	},
+	// P = 8
+	{
+		190.162, 196.571, 202.752, 209.501, 216.506, 223.474, 230.774, 237.61,
+		245.125, 252.835, 260.822, 268.788, 276.318, 284.25, 292.837, 301.23,
+		310.369, 318.459, 327.355, 336.095, 345.442, 354.546, 363.551, 372.931,
+		382.452, 392.313, 402.431, 411.837, 421.374, 431.801, 442.372, 453.443,
+		463.393, 474.972, 485.892, 496.184, 506.993, 517.584, 528.2, 539.531,
+		550.928, 562.553, 573.128, 584.195, 595.803, 608.179, 619.095, 630.123,
+		641.818, 652.787, 665.026, 676.775, 688.262, 699.871, 712.299, 724.481,
+		737.227, 749.197, 762.179, 774.712, 786.84, 799.328, 810.977, 822.56,
+		834.206, 847.939, 859.661, 872.549, 885.392, 897.877, 910.991, 924.373,
+		936.45, 949.092, 962.247, 973.981, 987.701, 998.516, 1010.95, 1025.14,
+		1038.4, 1051.79, 1062.92, 1076.66, 1090.01, 1102.42, 1113.82, 1126.09,
+		1139.21, 1152.2, 1163.53, 1176.74, 1188.6, 1201.47, 1214.95, 1227.45,
+		1239.48, 1251.19, 1264.6, 1277.72,
+	},
+	// P = 9
+	{
+		381.245, 393.797, 406.971, 419.963, 433.759, 447.973, 461.848, 476.639,
+		491.247, 506.689, 522.557, 537.914, 554.258, 570.095, 586.719, 603.785,
+		620.568, 638.384, 655.235, 673.585, 692.047, 709.975, 729.174, 747.184,
+		767.161, 786.627, 806.106, 826.453, 846.859, 868.185, 889.743, 910.091,
+		931.53, 951.915, 973.708, 996.178, 1018.28, 1041.76, 1063.19, 1085.13,
+		1106.55, 1127.7, 1151.15, 1173.17, 1196.1, 1219.91, 1243.22, 1266.98,
+		1290.45, 1313.45, 1337.93, 1362.87, 1385.54, 1409.8, 1434.37, 1458.37,
+		1481.68, 1506.7, 1531.07, 1555.91, 1579.93, 1604.25, 1627.98, 1652.35,
+		1678.76, 1702.56, 1727.37, 1752.68, 1775.91, 1801.5, 1826.68, 1852.36,
+		1878.27, 1902.51, 1928.04, 1953.16, 1978.28, 2002.75, 2026.91, 2050.55,
+		2075.37, 2100.64, 2125.77, 2150.8, 2175.66, 2201.44, 2226.73, 2253.33,
+		2278.75, 2305.33, 2331.36, 2358.39, 2382.4, 2406.27, 2431.04, 2456.65,
+		2481.26, 2504.6, 2529.76, 2555.87,
+	},
+	// P = 10
+	{
+		762.649, 788.01, 814.522, 841.143, 868.367, 896.205, 924.349, 953.727,
+		983.276, 1013.49, 1043.98, 1075.51, 1107.73, 1140.25, 1173, 1206.37,
+		1240.4, 1275.15, 1310.91, 1346.3, 1382.56, 1419.3, 1457.06, 1494.05,
+		1532.32, 1570.92, 1610.1, 1651.12, 1691.09, 1731.05, 1772.12, 1812.78,
+		1855.53, 1897.02, 1939.15, 1981.98, 2025.87, 2069.53, 2113.71, 2157.97,
+		2202.79, 2249.34, 2296.99, 2342.54, 2388.84, 2435.9, 2481.33, 2528.37,
+		2574.98, 2623.67, 2671.17, 2718.48, 2766.76, 2815.66, 2862.58, 2910.47,
+		2958.01, 3007.02, 3057.97, 3108.02, 3156.72, 3205.91, 3257.49, 3306.8,
+		3356.3, 3404.22, 3454.96, 3504.68, 3555, 3603.99, 3654.4, 3706.28,
+		3756.29, 3806.59, 3856.81, 3905.45, 3956.66, 4009.8, 4061.47, 4108.13,
+		4155.64, 4206.02, 4258.01, 4308.16, 4360.79, 4412.31, 4465.34, 4518.89,
+		4569.83, 4622.14, 4670.25, 4721.98, 4773.39, 4824.05, 4874.09, 4922.91,
+		4973.42, 5024.47, 5074.9, 5125.92,
+	},
+	// P = 11
+	{
+		1526.28, 1577.59, 1629.77, 1683.53, 1737.81, 1793, 1850.52, 1909.22,
+		1968.57, 2028.93, 2090.13, 2152.97, 2216.86, 2282.82, 2349.32, 2416.29,
+		2484.48, 2553.8, 2624.87, 2696.97, 2768.66, 2842.43, 2915.99, 2992.3,
+		3068.49, 3145.24, 3225.01, 3306.03, 3386.91, 3468.84, 3550.07, 3635.05,
+		3720.89, 3804.82, 3893.4, 3978.9, 4066.18, 4153.31, 4241.15, 4328.21,
+		4417.74, 4509.97, 4602.72, 4693.9, 4784.62, 4876.67, 4971.51, 5066.22,
+		5160.39, 5256.45, 5351.14, 5448.74, 5543.93, 5639.78, 5736.75, 5832.84,
+		5928.62, 6023.36, 6122.57, 6221.11, 6318, 6416.73, 6514.32, 6613.49,
+		6714.01, 6812.19, 6914.91, 7015.93, 7115.67, 7216.78, 7317.31, 7418.87,
+		7517.27, 7615.66, 7716.39, 7811.66, 7914.69, 8016.42, 8115.23, 8218.8,
+		8319.94, 8421.67, 8524.47, 8628.24, 8730.05, 8833.6, 8937.44, 9042.02,
+		9147.89, 9251.43, 9355.46, 9458.42, 9563.53, 9665.97, 9763.65, 9865.95,
+		9967.5, 10066, 10169.6, 10271.6,
+	},
+	// P = 12
+	{
+		3053.17, 3155.57, 3259.07, 3365.89, 3475, 3586.34, 3699.97, 3815.72,
+		3934.74, 4055.17, 4178.32, 4303.38, 4430.98, 4561.04, 4693.83, 4827.97,
+		4965.34, 5103.23, 5244.71, 5389.59, 5535.56, 5684.05, 5831.57, 5981.27,
+		6133.64, 6290.54, 6448.87, 6607.05, 6769.27, 6931.37, 7093.75, 7260.02,
+		7427.64, 7595.76, 7767.77, 7941.56, 8116.74, 8288.86, 8465.64, 8644.13,
+		8826.01, 9004.29, 9183.36, 9366.24, 9549.79, 9732.77, 9918.58, 10102.6,
+		10290.4, 10480.6, 10672.9, 10865, 11056, 11247.6, 11442.6, 11635.7,
+		11831.5, 12025.4, 12221.5, 12419.5, 12613.9, 12811.9, 13005.5, 13207.8,
+		13406.4, 13606.5, 13803.7, 14002.7, 14206.1, 14404.4, 14605, 14805.1,
+		14998.8, 15199, 15397.1, 15599.5, 15802, 16005.6, 16204.4, 16409.3,
+		16614, 16816.6, 17016.8, 17220.4, 17421.1, 17623.4, 17827.3, 18033.3,
+		18237.8, 18444.4, 18651.1, 18856.3, 19057.7, 19261.6, 19466.6, 19671.1,
+		19876.2, 20082.2, 20290.7, 20493.3,
+	},
+	// P = 13
+	{
+		6107.47, 6311.23, 6519.85, 6732.39, 6950.66, 7174.4, 7400.72, 7632.4,
+		7868.06, 8107.84, 8353.98, 8605.21, 8860.54, 9119.21, 9381.95, 9651.43,
+		9924.16, 10198, 10477.7, 10764.8, 11055.6, 11350.1, 11650, 11949.9,
+		12254.6, 12565.8, 12878.6, 13194.9, 13514.5, 13839.5, 14168.7, 14498.3,
+		14836.1, 15174.1, 15512.3, 15859.7, 16206.6, 16554.6, 16904.6, 17260.9,
+		17616.4, 17973.9, 18330.7, 18697.4, 19061.6, 19431.4, 19803.1, 20180.3,
+		20555.4, 20932.6, 21313.1, 21686.6, 22067.3, 22453.6, 22830.4, 23217.1,
+		23601.9, 23993.5, 24388.7, 24784.8, 25179.6, 25569.2, 25968.1, 26360.4,
+		26754.8, 27155.9, 27557.4, 27956.4, 28350.7, 28754.5, 29155.6, 29564,
+		29966.1, 30358.1, 30759.9, 31167.2, 31571.1, 31978.6, 32385.1, 32793.2,
+		33184.6, 33589.9, 34001.7, 34411.1, 34824.8, 35228.2, 35637.3, 36053.7,
+		36458.6, 36865.1, 37259.3, 37674.7, 38085, 38488.9, 38890.1, 39295.7,
+		39695.6, 40112.7, 40511.8, 40916.2,
+	},
+	// P = 14
+	{
+		12216.3, 12623.7, 13041.8, 13467.9, 13903.7, 14348.6, 14804.2, 15270.1,
+		15741.7, 16226.9, 16721.5, 17222.7, 17735.4, 18256, 18786.7, 19328,
+		19874.4, 20436.1, 20995.4, 21567.1, 22148, 22739.5, 23334.2, 23934.6,
+		24551.7, 25160.1, 25788.6, 26423.2, 27063.8, 27712.4, 28371.1, 29038.2,
+		29708, 30375.2, 31059.1, 31753, 32437.5, 33133.9, 33841.3, 34550.3,
+		35266.6, 35998.8, 36716.8, 37441.2, 38163.2, 38905.8, 39637.3, 40377.8,
+		41134.5, 41892.3, 42655.4, 43424.8, 44194.7, 44961.2, 45731.3, 46509.6,
+		47293.2, 48070.3, 48839.4, 49619.2, 50392.7, 51184, 51979, 52761,
+		53548.9, 54330, 55129.3, 55922.6, 56710.8, 57504.5, 58302.4, 59101,
+		59915.3, 60726, 61525.8, 62341.2, 63133.1, 63941.4, 64755.3, 65555.1,
+		66362.5, 67166.2, 67976.8, 68786.1, 69595.5, 70420.4, 71213.2, 72017,
+		72841.1, 73637.6, 74434.9, 75249, 76066.1, 76893.8, 77721.4, 78553.7,
+		79368.5, 80190.4, 81004.2, 81815.8,
+	},
+	// P = 15
+	{
+		24433, 25249.8, 26085.7, 26937.9, 27811.7, 28700.9, 29607.8, 30535.6,
+		31482.7, 32447.1, 33429.2, 34431.4, 35458.8, 36498.3, 37555.1, 38643.7,
+		39732.5, 40837.5, 41970, 43121.1, 44276.7, 45473.3, 46673.4, 47890.9,
+		49122.4, 50370.5, 51639.8, 52916.6, 54188.2, 55483.3, 56804.8, 58140.2,
+		59472.9, 60823.4, 62207.7, 63599.2, 64988.3, 66385.8, 67784.7, 69210.6,
+		70649.6, 72094.7, 73550.9, 74996.7, 76464.2, 77949.9, 79437.8, 80930.6,
+		82471.5, 83982.3, 85504.1, 87032.3, 88561.5, 90106, 91629.6, 93162.1,
+		94727.3, 96290, 97862.4, 99427.4, 101020, 102598, 104200, 105779,
+		107372, 108962, 110585, 112205, 113813, 115418, 117036, 118606,
+		120221, 121845, 123433, 125060, 126655, 128250, 129873, 131500,
+		133124, 134764, 136385, 138046, 139662, 141308, 142890, 144504,
+		146138, 147787, 149454, 151086, 152725, 154355, 156013, 157633,
+		159267, 160883, 162535, 164172,
+	},
+	// P = 16
+	{
+		48869.3, 50502.6, 52173, 53886.5, 55638, 57421.4, 59245.2, 61098.2,
+		62990.4, 64927.2, 66896, 68911.2, 70970.1, 73059.1, 75177.5, 77346.8,
+		79554.1, 81773.3, 84056.7, 86333, 88662.8, 91028.4, 93417.8, 95856.8,
+		98310.7, 100786, 103330, 105884, 108460, 111071, 113675, 116288,
+		119017, 121731, 124439, 127190, 129972, 132774, 135624, 138441,
+		141303, 144232, 147065, 149967, 152966, 155944, 158879, 161877,
+		164885, 167988, 171001, 174098, 177119, 180158, 183245, 186332,
+		189396, 192510, 195651, 198799, 201907, 205013, 208117, 211249,
+		214347, 217519, 220707, 223949, 227172, 230432, 233671, 236894,
+		240107, 243253, 246553, 249798, 253053, 256304, 259560, 262850,
+		266149, 269433, 272694, 275994, 279215, 282428, 285594, 288799,
+		292039, 295375, 298693, 301972, 305212, 308530, 311728, 314998,
+		318307, 321545, 324849, 328137,
+	},
+	// P = 17
+	{
+		97732.5, 101002, 104336, 107749, 111236, 114822, 118476, 122181,
+		125957, 129843, 133810, 137824, 141900, 146072, 150304, 154633,
+		159044, 163489, 167973, 172609, 177285, 181998, 186816, 191668,
+		196562, 201543, 206596, 211722, 216823, 222019, 227333, 232585,
+		237946, 243382, 248884, 254422, 259980, 265646, 271302, 277039,
+		282740, 288522, 294234, 299983, 305925, 311830, 317804, 323738,
+		329789, 335828, 341752, 347882, 354052, 360277, 366408, 372567,
+		378836, 385072, 391294, 397635, 403922, 410246, 416604, 422945,
+		429334, 435648, 442019, 448403, 454670, 461125, 467660, 474258,
+		480651, 487129, 493583, 500125, 506588, 513162, 519624, 526093,
+		532540, 538838, 545422, 551818, 558354, 564788, 571283, 577637,
+		584063, 590493, 596899, 603357, 609805, 616518, 622936, 629471,
+		636175, 642715, 649144, 655724,
+	},
+	// P = 18
+	{
+		195457, 201996, 208660, 215493, 222468, 229600, 236946, 244402,
+		251978, 259748, 267599, 275716, 283835, 292154, 300632, 309226,
+		318073, 327022, 336014, 345145, 354424, 363903, 373383, 383135,
+		392935, 402828, 412855, 423027, 433372, 443735, 454198, 464819,
+		475458, 486314, 497286, 508251, 519181, 530349, 541738, 553120,
+		564597, 575901, 587734, 599268, 610858, 622677, 634275, 646213,
+		658074, 670357, 682424, 694563, 706615, 718936, 731307, 743605,
+		755884, 768297, 780858, 793416, 806114, 818548, 831161, 844074,
+		856543, 869382, 882393, 894795, 907270, 920279, 933030, 946045,
+		959047, 971764, 984644, 997660, 1.01064e+06, 1.0235e+06, 1.03629e+06, 1.04906e+06,
+		1.06216e+06, 1.0754e+06, 1.08829e+06, 1.1013e+06, 1.11431e+06, 1.12737e+06, 1.14016e+06, 1.15303e+06,
+		1.16616e+06, 1.17924e+06, 1.19246e+06, 1.20549e+06, 1.21843e+06, 1.23133e+06, 1.24407e+06, 1.25688e+06,
+		1.2698e+06, 1.28303e+06, 1.29606e+06, 1.30914e+06,
+	},
+}
+
+var hllBiases = [][]float64{
+	// P = 4
+	{
+		10.2327, 9.70719, 9.70719, 9.20374, 8.73714, 8.29051, 7.83778, 7.83778,
+		7.38191, 6.97296, 6.636, 6.24539, 6.24539, 5.89581, 5.51355, 5.23156,
+		4.8741, 4.8741, 4.53586, 4.19883, 3.96221, 3.68313, 3.68313, 3.44937,
+		3.20681, 2.95115, 2.69035, 2.69035, 2.44144, 2.26236, 2.08398, 1.7656,
+		1.7656, 1.68002, 1.44374, 1.06917, 0.867997, 0.867997, 0.714819, 0.76824,
+		0.745369, 0.552288, 0.552288, 0.434179, 0.195052, 0.250399, 0.196696, 0.196696,
+		-0.0398422, 0.233547, 0.349159, 0.286861, 0.286861, 0.0774637, -0.029897, 0.138867,
+		0.0513709, 0.0513709, 0.1816, 0.116412, 0.192334, -0.0679884, -0.0679884, -0.0308534,
+		-0.259466, -0.543821, -0.604593, -0.604593, -0.634949, -0.589594, -0.657915, -0.6718,
+		-0.6718, -0.553024, -0.444371, -0.614353, -0.472827, -0.472827, -0.572526, -0.337579,
+		-0.2747, -0.46188, -0.46188, -0.739243, -0.584044, -0.611062, -0.729698, -0.729698,
+		-0.67685, -0.674083, -0.438277, -0.489813, -0.489813, -0.89317, -1.14693, -1.45901,
+		-1.46843, -1.46843, -1.56806, -1.39887,
+	},
+	// P = 5
+	{
+		21.2514, 20.7235, 19.7168, 19.2093, 18.2452, 17.295, 16.8425, 15.9885,
+		15.5416, 14.6431, 13.8555, 13.4709, 12.7099, 12.2925, 11.5089, 10.6618,
+		10.3499, 9.69236, 9.41022, 8.92652, 8.2956, 8.02232, 7.49757, 7.23814,
+		6.7495, 6.47713, 6.18841, 5.87336, 5.69528, 5.08855, 4.467, 4.20828,
+		3.94359, 3.71648, 3.33627, 3.21066, 3.05015, 2.43543, 2.3686, 2.29474,
+		1.90872, 1.90709, 1.53046, 1.33884, 1.02429, 0.872355, 0.927496, 0.840362,
+		0.921251, 0.817249, 0.377729, 0.202406, 0.299842, 0.0682803, 0.181551, -0.201279,
+		-0.174011, -0.672697, -0.40148, -0.323809, 0.0400968, 0.160542, 0.0578397, 0.290785,
+		0.34828, 0.139336, 0.410756, 0.108082, -0.131607, -0.309513, -0.359665, -0.581482,
+		-0.457684, -0.399072, -0.380276, -0.989802, -0.881429, -0.75449, -0.736983, -1.26955,
+		-1.11578, -1.24006, -0.786, -0.776647, -0.550415, -0.483045, -0.464804, -0.426496,
+		-0.215113, -0.666278, -1.10438, -1.1027, -1.17621, -1.23183, -1.10419, -1.41178,
+		-1.33944, -1.14494, -1.3529, -1.6717,
+	},
+	// P = 6
+	{
+		43.7974, 42.2605, 40.2892, 38.8382, 37.3966, 36.0245, 34.6829, 33.0251,
+		31.8203, 30.5585, 29.2831, 28.0805, 26.4976, 25.3781, 24.3268, 23.2384,
+		22.3091, 20.9801, 20.036, 19.0908, 18.2165, 17.5189, 16.5728, 15.7192,
+		15.1028, 14.3307, 13.6557, 12.7187, 12.0524, 11.2949, 10.7353, 10.0737,
+		9.30618, 8.59926, 8.17015, 7.60322, 7.00179, 6.42735, 5.93756, 5.60226,
+		5.14865, 4.84292, 4.6988, 4.20275, 3.65938, 3.40274, 3.54502, 3.23619,
+		2.91377, 2.75704, 2.68611, 2.38832, 2.35072, 2.02748, 1.47162, 0.797803,
+		0.368999, 0.508927, -0.0899655, -0.323269, -0.0249516, -0.122056, 0.160683, 0.575932,
+		-0.0325159, -0.350293, -0.703443, -0.532686, -0.74335, -0.922026, -0.932935, -1.0558,
+		-0.640084, -0.233374, -0.573049, -0.936214, -0.344529, -0.320477, -0.196978, 0.200966,
+		0.303998, 0.112138, 1.03564, 0.544944, 0.363357, 0.977562, 0.936543, -0.379158,
+		-0.516008, -0.651242, -0.91981, -0.971108, -1.17579, -1.91213, -2.2662, -1.99162,
+		-1.87607, -1.11062, -0.564413, -0.233185,
+	},
+	// P = 7
+	{
+		88.4463, 84.8927, 82.0136, 78.6852, 75.789, 73.0613, 69.942, 67.5093,
+		64.4838, 62.0414, 59.7981, 56.9592, 54.6531, 52.1775, 49.9412, 47.8317,
+		45.4692, 43.5546, 41.5215, 39.5388, 37.7075, 35.8702, 34.0832, 32.2428,
+		30.8007, 29.1552, 27.5244, 26.317, 24.9797, 23.8065, 22.4303, 21.1676,
+		20.2298, 19.3458, 18.7939, 17.941, 17.1947, 16.1677, 14.9773, 13.7838,
+		12.8663, 11.2334, 10.6461, 9.63246, 8.86141, 8.47947, 8.30147, 7.83369,
+		8.09782, 7.23505, 6.89999, 5.85615, 5.49286, 5.11377, 4.31926, 4.33229,
+		3.66489, 3.38722, 2.86791, 1.79527, 1.43189, 2.13158, 2.13699, 1.92932,
+		1.27045, 1.43933, 0.859994, 1.25217, 0.57571, 0.186114, 0.0281847, 0.101309,
+		-0.619651, 0.255219, 0.680297, 0.755052, 1.29514, 1.84957, 1.44281, 1.74844,
+		1.99775, 2.65889, 1.68866, 1.95674, 2.10615, 1.62046, 1.87504, 1.85237,
+		0.906481, 1.8568, 1.22196, 1.12183, 1.63016, 1.35772, 1.95327, 1.61546,
+		2.10797, 2.0065, 1.08038, 0.817606,
+	},
+	// P = 8
+	{
+		177.162, 170.571, 164.752, 158.501, 152.506, 146.474, 140.774, 135.61,
+		130.125, 124.835, 119.822, 114.788, 110.318, 105.25, 100.837, 96.2301,
+		92.3694, 88.4588, 84.3546, 80.0953, 76.4424, 72.5458, 69.5509, 65.9312,
+		62.4519, 59.313, 56.4305, 53.8371, 50.3739, 47.801, 45.3717, 43.4434,
+		41.3933, 39.9723, 37.892, 35.1842, 32.9934, 31.5842, 29.1996, 27.531,
+		25.9277, 24.5526, 23.1282, 21.1954, 19.8031, 19.1795, 17.0951, 16.1233,
+		14.8181, 12.787, 12.0262, 10.7751, 10.2623, 8.87053, 8.29929, 7.48127,
+		7.22746, 7.19688, 7.17852, 6.71176, 5.84047, 5.32796, 4.97709, 3.55974,
+		2.20603, 2.93867, 1.66096, 2.54913, 2.39195, 1.87674, 1.99067, 2.37333,
+		2.45027, 2.09234, 2.24676, 0.980936, 1.7014, 0.516041, -0.0482575, 1.14367,
+		1.39941, 1.78656, 0.918762, 1.66194, 2.01068, 1.41654, -0.182159, 0.0899931,
+		0.213258, 0.201524, -1.46844, -1.26008, -1.40283, -1.52527, -1.04538, -1.55486,
+		-2.51802, -2.80742, -2.40312, -2.2806,
+	},
+	// P = 9
+	{
+		355.245, 342.797, 329.971, 317.963, 305.759, 293.973, 282.848, 271.639,
+		261.247, 250.689, 240.557, 230.914, 221.258, 212.095, 202.719, 193.785,
+		185.568, 177.384, 169.235, 161.585, 154.047, 146.975, 140.174, 133.184,
+		127.161, 120.627, 115.106, 109.453, 104.859, 100.185, 95.7429, 91.0906,
+		86.5299, 81.9155, 77.7077, 74.1775, 71.2801, 68.7557, 65.1888, 61.1322,
+		56.5537, 52.7031, 50.1456, 47.1729, 44.1033, 41.9074, 40.2226, 37.9752,
+		36.4475, 33.4484, 31.9276, 31.8732, 28.5364, 27.8042, 26.3689, 24.3723,
+		22.6849, 21.7027, 21.0699, 19.9119, 17.9272, 17.2504, 14.9807, 14.3478,
+		14.765, 12.5604, 12.3683, 11.6808, 9.90698, 9.4996, 8.68154, 9.35867,
+		9.2696, 8.51198, 8.03998, 7.16283, 7.28475, 5.74874, 4.90679, 2.5517,
+		1.36852, 1.64171, 0.774024, 0.804813, -0.340878, -0.558805, -0.272939, 0.328677,
+		0.745256, 1.32609, 1.36414, 3.38971, 1.40022, 0.267567, -0.959553, -1.35015,
+		-1.7416, -4.40401, -4.24256, -4.12871,
+	},
+	// P = 10
+	{
+		711.649, 686.01, 660.522, 636.143, 612.367, 589.205, 566.349, 543.727,
+		522.276, 501.494, 480.983, 461.512, 441.728, 423.253, 405.001, 387.367,
+		370.4, 353.147, 337.914, 322.299, 307.561, 293.299, 279.06, 265.047,
+		252.322, 239.925, 228.096, 217.118, 206.089, 195.046, 185.122, 174.782,
+		165.531, 156.022, 147.153, 138.975, 131.869, 123.525, 116.711, 109.967,
+		103.791, 99.343, 94.9879, 89.5376, 84.8416, 80.8952, 75.3324, 70.3713,
+		65.9831, 63.6736, 60.1713, 56.479, 52.7599, 50.6551, 46.5754, 43.4654,
+		40.0059, 37.025, 36.9734, 36.0166, 33.7154, 31.9098, 31.4918, 29.8013,
+		28.2963, 25.2175, 24.9569, 22.6788, 21.9969, 19.9887, 19.3983, 20.2783,
+		18.2908, 17.586, 16.8113, 14.4471, 14.6625, 15.8019, 16.4658, 12.1291,
+		8.64256, 8.01996, 8.00892, 7.15787, 8.79165, 9.30671, 11.339, 12.891,
+		12.8295, 14.1419, 11.2452, 11.9817, 11.3948, 11.0535, 10.0888, 7.91414,
+		7.41818, 6.47461, 5.89827, 5.91851,
+	},
+	// P = 11
+	{
+		1424.28, 1372.59, 1322.77, 1273.53, 1225.81, 1179, 1133.52, 1090.22,
+		1046.57, 1004.93, 964.126, 923.974, 885.864, 848.823, 813.319, 778.286,
+		743.483, 710.795, 678.874, 648.971, 618.656, 589.426, 560.994, 534.297,
+		508.486, 483.245, 460.006, 439.026, 416.908, 396.839, 376.07, 358.047,
+		341.893, 322.824, 309.398, 292.899, 277.18, 262.31, 247.154, 232.212,
+		219.737, 208.973, 199.723, 187.896, 176.62, 166.674, 158.515, 151.225,
+		142.387, 136.451, 129.136, 123.736, 116.932, 109.784, 104.75, 98.8408,
+		91.6221, 84.3557, 80.5735, 77.114, 71.9957, 67.7277, 63.32, 59.4861,
+		58.009, 54.186, 53.9061, 52.9316, 49.6704, 48.7828, 47.3119, 45.8695,
+		42.2695, 37.6608, 36.3944, 29.6601, 29.6871, 29.4234, 25.2337, 26.8009,
+		25.9442, 24.6732, 25.4658, 26.2425, 26.049, 27.5994, 28.4353, 31.0248,
+		33.8915, 35.4277, 37.4607, 37.4168, 40.5334, 39.9727, 35.6515, 35.9519,
+		34.4996, 30.9899, 31.5661, 31.5865,
+	},
+	// P = 12
+	{
+		2848.17, 2745.57, 2645.07, 2546.89, 2451, 2357.34, 2265.97, 2177.72,
+		2091.74, 2007.17, 1925.32, 1845.38, 1768.98, 1694.04, 1621.83, 1550.97,
+		1483.34, 1417.23, 1353.71, 1293.59, 1234.56, 1178.05, 1121.57, 1066.27,
+		1013.64, 965.544, 918.866, 873.05, 830.269, 787.374, 744.75, 706.022,
+		669.641, 632.762, 599.769, 568.562, 538.737, 506.862, 478.636, 452.131,
+		429.006, 402.288, 377.362, 355.245, 333.79, 311.77, 292.581, 272.604,
+		255.413, 240.599, 227.883, 215.036, 201.961, 188.577, 178.596, 166.688,
+		157.494, 147.43, 138.477, 131.475, 120.86, 113.899, 103.508, 100.764,
+		94.3518, 89.5403, 81.7311, 76.6594, 75.1232, 68.3813, 63.9993, 59.0946,
+		48.81, 44.0276, 37.0846, 34.4777, 31.9922, 31.6427, 25.3795, 25.2963,
+		25.0454, 22.6327, 18.8324, 17.3827, 13.0591, 10.4321, 9.33024, 11.2711,
+		10.7981, 12.4098, 14.1393, 14.2512, 11.7229, 10.6202, 10.5632, 10.1044,
+		10.2053, 12.1571, 15.6948, 13.255,
+	},
+	// P = 13
+	{
+		5697.47, 5492.23, 5290.85, 5094.39, 4902.66, 4716.4, 4533.72, 4355.4,
+		4182.06, 4011.84, 3847.98, 3690.21, 3535.54, 3385.21, 3237.95, 3097.43,
+		2961.16, 2824.97, 2695.66, 2572.85, 2453.57, 2339.06, 2228.97, 2119.93,
+		2014.6, 1915.77, 1819.63, 1725.92, 1636.55, 1551.51, 1470.67, 1391.34,
+		1319.07, 1248.06, 1176.35, 1113.73, 1051.59, 989.637, 930.574, 876.931,
+		822.396, 770.865, 717.749, 675.363, 629.619, 589.447, 552.063, 519.26,
+		485.442, 452.584, 423.102, 387.582, 358.343, 335.623, 302.388, 279.113,
+		254.886, 236.477, 222.749, 208.818, 193.565, 174.21, 163.115, 146.403,
+		130.834, 121.901, 114.416, 103.434, 88.6725, 82.4908, 73.5532, 73.0121,
+		65.0548, 48.1081, 39.909, 37.156, 32.0669, 29.5846, 27.1436, 25.1765,
+		6.62332, 2.86708, 4.72484, 5.14934, 8.80192, 2.15356, 2.26649, 8.72235,
+		4.58026, 1.06878, -14.6667, -8.30077, -7.97096, -13.1268, -21.9049, -26.2664,
+		-35.4282, -28.3009, -38.1876, -43.8448,
+	},
+	// P = 14
+	{
+		11397.3, 10985.7, 10583.8, 10190.9, 9807.7, 9433.61, 9070.15, 8716.06,
+		8368.72, 8034.92, 7710.47, 7392.72, 7085.38, 6787.01, 6498.69, 6221.04,
+		5948.45, 5690.06, 5430.37, 5183.1, 4945, 4717.45, 4492.23, 4273.64,
+		4071.71, 3861.12, 3670.56, 3485.18, 3306.84, 3136.36, 2976.12, 2824.25,
+		2673.98, 2522.24, 2387.12, 2262.01, 2127.54, 2003.85, 1892.29, 1782.3,
+		1679.65, 1592.84, 1490.78, 1396.15, 1299.22, 1222.85, 1135.29, 1055.84,
+		993.529, 932.273, 876.4, 826.822, 776.724, 724.152, 675.295, 634.556,
+		599.169, 556.263, 506.439, 467.155, 421.656, 394.028, 368.956, 332.01,
+		300.862, 263.009, 243.347, 216.585, 185.825, 160.477, 139.418, 119.011,
+		113.264, 104.986, 85.815, 82.2493, 55.1485, 43.4052, 38.2994, 19.0763,
+		7.53793, -7.76507, -17.2436, -26.8657, -36.5297, -30.645, -56.8305, -73.0061,
+		-67.8768, -90.4454, -112.093, -117.03, -119.856, -111.161, -102.559, -89.2615,
+		-93.5279, -91.5514, -96.8392, -104.205,
+	},
+	// P = 15
+	{
+		22795, 21972.8, 21170.7, 20383.9, 19619.7, 18870.9, 18138.8, 17428.6,
+		16736.7, 16063.1, 15407.2, 14770.4, 14159.8, 13560.3, 12979.1, 12429.7,
+		11879.5, 11346.5, 10840, 10353.1, 9870.66, 9428.29, 8990.35, 8568.89,
+		8162.44, 7772.53, 7402.78, 7041.56, 6674.25, 6331.26, 6014.77, 5711.19,
+		5405.87, 5117.42, 4863.67, 4617.16, 4367.32, 4126.85, 3886.68, 3674.61,
+		3475.58, 3281.68, 3099.94, 2906.69, 2736.17, 2583.86, 2432.82, 2287.61,
+		2189.5, 2062.33, 1946.14, 1835.34, 1726.54, 1631.99, 1517.58, 1412.1,
+		1338.28, 1262.96, 1196.41, 1123.39, 1078.48, 1016.64, 981.263, 921.403,
+		875.501, 828.165, 811.609, 793.662, 762.996, 729.652, 709.678, 641.393,
+		617.842, 602.72, 553.008, 541.893, 497.96, 455.128, 439.481, 428.229,
+		414.225, 414.68, 398.292, 419.77, 398.482, 406.13, 348.945, 324.967,
+		320.296, 331.464, 359.685, 352.961, 353.606, 344.655, 364.513, 347.016,
+		341.659, 320.338, 332.52, 332.282,
+	},
+	// P = 16
+	{
+		45592.3, 43948.6, 42343, 40779.5, 39254, 37760.4, 36307.2, 34884.2,
+		33499.4, 32159.2, 30851, 29589.2, 28372.1, 27184.1, 26025.5, 24917.8,
+		23848.1, 22791.3, 21797.7, 20797, 19849.8, 18938.4, 18051.8, 17213.8,
+		16390.7, 15588.9, 14855.9, 14133.6, 13432.7, 12766.6, 12094.2, 11429.8,
+		10883.2, 10319.8, 9750.68, 9225.4, 8730.23, 8256.44, 7828.83, 7368.6,
+		6954.21, 6605.58, 6162.72, 5788.15, 5509.58, 5210.88, 4869.15, 4590.95,
+		4321.92, 4147.54, 3883.57, 3704.28, 3448.92, 3210.96, 3021.08, 2830.95,
+		2617.77, 2456.26, 2319.87, 2190.63, 2021.68, 1850.57, 1679.27, 1533.8,
+		1354.62, 1250.48, 1161.15, 1126.92, 1072.68, 1055.53, 1018.39, 964.077,
+		900.96, 770.488, 792.617, 760.834, 738.693, 714.14, 692.559, 706.489,
+		727.688, 734.662, 720.349, 743.405, 687.203, 623.235, 511.775, 441.211,
+		404.449, 462.632, 504.459, 506.378, 470.066, 510.987, 432.148, 425.402,
+		456.918, 418.945, 445.573, 457.265,
+	},
+	// P = 17
+	{
+		91178.5, 87895, 84674.5, 81535.4, 78467.6, 75500.3, 72601.4, 69751.6,
+		66975, 64306.8, 61720, 59180.8, 56703, 54321.5, 52000.4, 49775.2,
+		47632.6, 45524.2, 43454.6, 41536.6, 39659.3, 37818.8, 36082.7, 34382.5,
+		32721.7, 31149.4, 29648.8, 28220.6, 26769.3, 25411.5, 24170.8, 22870.3,
+		21677.2, 20560.2, 19508.2, 18491.9, 17497.4, 16608.7, 15711.8, 14895,
+		14042.4, 13271.5, 12429, 11624.8, 11012.6, 10364.4, 9784.91, 9165.2,
+		8662.77, 8147.72, 7517.89, 7094.89, 6711.05, 6382.84, 5960.17, 5564.75,
+		5280.57, 4963.1, 4632.49, 4419.44, 4151.81, 3922.66, 3726.94, 3514.8,
+		3350.37, 3109.52, 2927.99, 2757.54, 2471.85, 2372.72, 2354.16, 2398.88,
+		2238.22, 2163.47, 2062.54, 2051.48, 1961.36, 1980.79, 1889.66, 1805.31,
+		1697.84, 1442.73, 1472.69, 1316.01, 1298.03, 1177.73, 1120.07, 920.283,
+		793.017, 668.734, 521.452, 426.057, 319.604, 479.751, 343.567, 325.348,
+		476.073, 461.705, 338.257, 364.351,
+	},
+	// P = 18
+	{
+		182350, 175782, 169338, 163064, 156932, 150957, 145196, 139544,
+		134013, 128676, 123420, 118430, 113441, 108653, 104024, 99511.2,
+		95250.8, 91092, 86976.8, 83000.7, 79173, 75545.2, 71916.6, 68562,
+		65255.1, 62041.3, 58961.1, 56024.9, 53263.4, 50518.6, 47875.3, 45388.8,
+		42919.8, 40669.3, 38533.6, 36391.7, 34214.7, 32275.5, 30556.7, 28832.1,
+		27201.8, 25398.5, 24124.3, 22551.2, 21034, 19745.7, 18237.3, 17067.1,
+		15821.1, 14996.8, 13956.9, 12989.4, 11933.4, 11147.1, 10410.6, 9602.44,
+		8774.19, 8078.87, 7532.99, 6983.82, 6575.44, 5901.72, 5407.04, 5212.96,
+		4574.56, 4306.83, 4211.2, 3505.03, 2873.11, 2775.16, 2419.08, 2327.39,
+		2221.29, 1830.67, 1604.05, 1513.21, 1384.73, 1138.1, 818.961, 483.474,
+		472.665, 606.676, 396.738, 299.14, 199.106, 147.447, -170.496, -407.709,
+		-385.864, -406.788, -292.006, -373.479, -540.026, -744.647, -1117.44, -1409.6,
+		-1598.19, -1479.17, -1554.18, -1582.12,
+	},
+}
@@ -1,63 +1,329 @@
 package structures
 
+//go:generate go run hllBiasGen.go
+
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/spaolacci/murmur3"
 	"math"
+	"math/bits"
+	"sort"
+)
+
+// hyperLogLogMagic starts a serialized HyperLogLog. A gob stream cannot start with it, so
+// values written with gob by older versions are still told apart and read.
+var hyperLogLogMagic = []byte("KVHL")
+
+const (
+	hyperLogLogVersion = 1
+	hllDense           = 0
+	hllSparse          = 1
+
+	// hllSparsePrecision is the precision of the sparse representation. Its registers are
+	// never stored, only those that are not empty, so it is much higher than that of the
+	// dense registers and small sets are counted almost exactly.
+	hllSparsePrecision = 25
 )
 
+// hllThresholds are the cardinalities up to which linear counting of the empty registers
+// estimates better than the bias-corrected estimate, for precisions from 4 on (Heule et al.).
+var hllThresholds = []float64{10, 20, 40, 80, 220, 400, 900, 1800, 3100, 6500, 11500, 20000, 50000, 120000,
+	350000}
+
+// HyperLogLog estimates the number of distinct items added to it, in the way of HLL++
+// (Heule et al.). Its relative standard error is about 1.04/sqrt(M), M = 2^P, which by
+// precision P is:
+//
+//	P =  4: 26%     P = 10: 3.3%
+//	P =  6: 13%     P = 12: 1.6%
+//	P =  8: 6.5%    P = 14: 0.81%
+//
+// A HyperLogLog starts sparse: it keeps only the registers that are not empty, at precision
+// hllSparsePrecision, in 4 bytes each. Once that takes more than the M bytes of the dense
+// registers, it turns dense for good.
 type HyperLogLog struct {
-	P         uint8   //broj vodecih bitova za bucket
-	M         uint64  //velicina seta
-	Registers []uint8 //set registara
+	P         uint8    //broj vodecih bitova za bucket
+	M         uint64   //velicina seta
+	Registers []uint8  //set registara, nil dok je HLL redak
+	sparse    []uint32 // registers of the sparse representation, sorted, as index<<6 | value
 }
 
 func CreateHyperLogLog(numOfLeadingBits uint8) *HyperLogLog {
-	sizeOfRegisters := int(math.Pow(2, float64(numOfLeadingBits)))
-	return &HyperLogLog{numOfLeadingBits, uint64(sizeOfRegisters), make([]uint8, sizeOfRegisters, sizeOfRegisters)}
+	sizeOfRegisters := uint64(1) << numOfLeadingBits
+	hll := &HyperLogLog{P: numOfLeadingBits, M: sizeOfRegisters}
+	if numOfLeadingBits >= hllSparsePrecision {
+		hll.Registers = make([]uint8, sizeOfRegisters)
+	}
+	return hll
+}
+
+// StandardError returns the expected relative error of the estimate, 1.04/sqrt(M). While
+// the HyperLogLog is sparse, it is that of the sparse precision.
+func (hll *HyperLogLog) StandardError() float64 {
+	if hll.IsSparse() {
+		return 1.04 / math.Sqrt(float64(uint64(1)<<hllSparsePrecision))
+	}
+	return 1.04 / math.Sqrt(float64(hll.M))
+}
+
+// Copy returns a HyperLogLog that does not change with the original one.
+func (hll *HyperLogLog) Copy() *HyperLogLog {
+	return &HyperLogLog{P: hll.P, M: hll.M, Registers: append([]uint8(nil), hll.Registers...),
+		sparse: append([]uint32(nil), hll.sparse...)}
 }
 
+// IsSparse tells whether the HyperLogLog still has the sparse representation.
+func (hll *HyperLogLog) IsSparse() bool {
+	return hll.Registers == nil
+}
+
+// rank returns the position of the leading one in the bits of hash after the first p,
+// at most 64-p+1.
+func rank(hash uint64, p uint8) uint8 {
+	// Jedinica na kraju ogranicava rang na 64-P+1 kada su svi ostali bitovi nule.
+	return uint8(bits.LeadingZeros64(hash<<p|1<<(p-1)) + 1)
+}
+
+// Add adds an item. The first P bits of its 64-bit murmur3 hash choose a register, which
+// keeps the largest position of the leading one in the rest of the hash seen so far.
 func (hll *HyperLogLog) Add(word string) {
-	binary := ToBinary(GetMD5Hash(word))
-	numOfBucket := 0
-	numOfLeadingBits := hll.P
-	for i := 0; i < int(numOfLeadingBits); i++ {
-		numOfBucket += (int(binary[i]) - '0') * int(math.Pow(2, float64(int(numOfLeadingBits)-i)))
-	}
-	numOfMostConsecutiveZeros := 0
-	for i := len(binary) - 1; i > 0; i-- {
-		if binary[i] == '0' {
-			numOfMostConsecutiveZeros++
+	hash := murmur3.Sum64([]byte(word))
+	if hll.IsSparse() {
+		hll.addSparse(uint32(hash>>(64-hllSparsePrecision))<<6 | uint32(rank(hash, hllSparsePrecision)))
+		return
+	}
+	numOfBucket := hash >> (64 - hll.P)
+	if value := rank(hash, hll.P); value > hll.Registers[numOfBucket] {
+		hll.Registers[numOfBucket] = value
+	}
+}
+
+// addSparse sets a sparse register, given as index<<6 | value, unless it holds more already.
+func (hll *HyperLogLog) addSparse(entry uint32) {
+	index := entry >> 6
+	i := sort.Search(len(hll.sparse), func(i int) bool {
+		return hll.sparse[i]>>6 >= index
+	})
+	if i < len(hll.sparse) && hll.sparse[i]>>6 == index {
+		if entry > hll.sparse[i] {
+			hll.sparse[i] = entry
+		}
+		return
+	}
+	hll.sparse = append(hll.sparse, 0)
+	copy(hll.sparse[i+1:], hll.sparse[i:])
+	hll.sparse[i] = entry
+	if 4*uint64(len(hll.sparse)) > hll.M {
+		hll.toDense()
+	}
+}
+
+// denseRegister returns the dense register and value of a sparse register. The bits of the
+// sparse index after the first P are the first bits after P of the hashes it was set by.
+func (hll *HyperLogLog) denseRegister(entry uint32) (uint64, uint8) {
+	index, value := entry>>6, uint8(entry&63)
+	width := hllSparsePrecision - int(hll.P)
+	low := index & (1<<width - 1)
+	if low != 0 {
+		return uint64(index >> width), uint8(bits.LeadingZeros32(low)-(32-width)) + 1
+	}
+	return uint64(index >> width), uint8(width) + value
+}
+
+func (hll *HyperLogLog) toDense() {
+	hll.Registers = make([]uint8, hll.M)
+	for _, entry := range hll.sparse {
+		if bucket, value := hll.denseRegister(entry); value > hll.Registers[bucket] {
+			hll.Registers[bucket] = value
+		}
+	}
+	hll.sparse = nil
+}
+
+// Merge adds the items of other to the HyperLogLog, so it estimates the size of the union
+// of both. Both must have the same precision.
+func (hll *HyperLogLog) Merge(other *HyperLogLog) error {
+	if hll.P != other.P {
+		return fmt.Errorf("cannot merge a HyperLogLog of precision %d into one of precision %d", other.P, hll.P)
+	}
+	if other.IsSparse() {
+		for _, entry := range other.sparse {
+			if hll.IsSparse() {
+				hll.addSparse(entry)
+			} else if bucket, value := hll.denseRegister(entry); value > hll.Registers[bucket] {
+				hll.Registers[bucket] = value
+			}
+		}
+		return nil
+	}
+	if hll.IsSparse() {
+		hll.toDense()
+	}
+	for i, value := range other.Registers {
+		if value > hll.Registers[i] {
+			hll.Registers[i] = value
+		}
+	}
+	return nil
+}
+
+// MaxIntersectionHLLs is how many HyperLogLogs EstimateIntersection takes at most: it
+// estimates the union of every subset of them.
+const MaxIntersectionHLLs = 10
+
+// SetEstimate is an estimated cardinality of a set operation.
+type SetEstimate struct {
+	Estimate float64
+	Error    float64 // standard error of the estimate, in items
+}
+
+// UnionHLL returns a HyperLogLog of the union of the items of hlls, which must all have the
+// same precision. The hlls do not change.
+func UnionHLL(hlls []*HyperLogLog) (*HyperLogLog, error) {
+	if len(hlls) == 0 {
+		return nil, errors.New("no HyperLogLogs to unite")
+	}
+	union := hlls[0].Copy()
+	for _, hll := range hlls[1:] {
+		if err := union.Merge(hll); err != nil {
+			return nil, err
+		}
+	}
+	return union, nil
+}
+
+// EstimateUnion estimates how many distinct items hlls hold together.
+func EstimateUnion(hlls []*HyperLogLog) (SetEstimate, error) {
+	union, err := UnionHLL(hlls)
+	if err != nil {
+		return SetEstimate{}, err
+	}
+	estimate := union.Evaluate()
+	return SetEstimate{Estimate: estimate, Error: estimate * union.StandardError()}, nil
+}
+
+// EstimateIntersection estimates how many items are in every one of hlls, by inclusion and
+// exclusion of the unions of all their subsets. The errors of the unions add up rather than
+// cancel, so the Error is their sum, and an intersection that is small next to the union has
+// a large relative error. The estimate is kept between 0 and the smallest estimated set.
+func EstimateIntersection(hlls []*HyperLogLog) (SetEstimate, error) {
+	if len(hlls) == 0 {
+		return SetEstimate{}, errors.New("no HyperLogLogs to intersect")
+	}
+	if len(hlls) > MaxIntersectionHLLs {
+		return SetEstimate{}, fmt.Errorf("cannot intersect more than %d HyperLogLogs, not %d",
+			MaxIntersectionHLLs, len(hlls))
+	}
+	for _, hll := range hlls[1:] {
+		if hll.P != hlls[0].P {
+			return SetEstimate{}, fmt.Errorf("cannot intersect HyperLogLogs of precisions %d and %d", hlls[0].P, hll.P)
+		}
+	}
+
+	// Unija podskupa mask je unija podskupa bez najnizeg bita i HLL-a tog bita.
+	unions := make([]*HyperLogLog, 1<<len(hlls))
+	result := SetEstimate{}
+	smallest := math.Inf(1)
+	for mask := 1; mask < len(unions); mask++ {
+		lowest := bits.TrailingZeros(uint(mask))
+		rest := mask & (mask - 1)
+		if rest == 0 {
+			unions[mask] = hlls[lowest]
 		} else {
-			break
+			unions[mask] = unions[rest].Copy()
+			_ = unions[mask].Merge(hlls[lowest])
+		}
+		estimate := unions[mask].Evaluate()
+		if bits.OnesCount(uint(mask))%2 == 1 {
+			result.Estimate += estimate
+		} else {
+			result.Estimate -= estimate
+		}
+		result.Error += estimate * unions[mask].StandardError()
+		if rest == 0 && estimate < smallest {
+			smallest = estimate
 		}
 	}
-	hll.Registers[numOfBucket] = uint8(numOfMostConsecutiveZeros)
+	result.Estimate = math.Max(0, math.Min(result.Estimate, smallest))
+	return result, nil
+}
 
+// alpha corrects the bias of the harmonic mean of the registers.
+func (hll *HyperLogLog) alpha() float64 {
+	switch hll.M {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	}
+	return 0.7213 / (1 + 1.079/float64(hll.M))
+}
+
+// linearCounting estimates the cardinality from the number of empty registers out of m.
+func linearCounting(m, empty float64) float64 {
+	return m * math.Log(m/empty)
+}
+
+// bias returns how much a raw estimate overestimates, the mean bias of the 6 nearest
+// raw estimates measured at the precision of the HyperLogLog.
+func (hll *HyperLogLog) bias(raw float64) float64 {
+	table := int(hll.P) - hllBiasMinPrecision
+	rawEstimates, biases := hllRawEstimates[table], hllBiases[table]
+	nearest := make([]int, len(rawEstimates))
+	for i := range nearest {
+		nearest[i] = i
+	}
+	sort.Slice(nearest, func(i, j int) bool {
+		return math.Abs(rawEstimates[nearest[i]]-raw) < math.Abs(rawEstimates[nearest[j]]-raw)
+	})
+	bias := 0.0
+	for _, i := range nearest[:6] {
+		bias += biases[i]
+	}
+	return bias / 6
 }
 
 func (hll *HyperLogLog) Evaluate() float64 {
+	if hll.IsSparse() {
+		m := float64(uint64(1) << hllSparsePrecision)
+		return linearCounting(m, m-float64(len(hll.sparse)))
+	}
+
 	harmony := 0.0
 	for _, value := range hll.Registers {
-		harmony += math.Pow(math.Pow(2.0, float64(value)), -1)
+		harmony += math.Pow(2.0, -float64(value))
 	}
-
-	constant := 0.79402
-	estimation := constant * math.Pow(float64(hll.M), 2.0) / harmony
+	m := float64(hll.M)
+	estimation := hll.alpha() * m * m / harmony
 	emptyRegisters := hll.emptyRegistersCount()
 
-	if estimation < 2.5*float64(hll.M) { // small range correction
-		if emptyRegisters > 0 {
-			estimation = float64(hll.M) * math.Log(float64(hll.M)/float64(emptyRegisters))
+	// With a 64-bit hash there are no collisions to correct for in large ranges.
+	table := int(hll.P) - hllBiasMinPrecision
+	if table < 0 || table >= len(hllBiases) {
+		if estimation <= 2.5*m && emptyRegisters > 0 { // small range correction
+			estimation = linearCounting(m, float64(emptyRegisters))
+		}
+		return estimation
+	}
+	if estimation <= 5*m {
+		estimation -= hll.bias(estimation)
+	}
+	if emptyRegisters > 0 {
+		if linear := linearCounting(m, float64(emptyRegisters)); linear <= hllThresholds[table] {
+			return linear
 		}
-	} else if estimation > math.Pow(2.0, 32.0)/30.0 { // large range correction
-		estimation = -math.Pow(2.0, 32.0) * math.Log(1.0-estimation/math.Pow(2.0, 32.0))
 	}
 	return estimation
 }
 
-func (hll *HyperLogLog) emptyRegistersCount() uint8 {
-	numOfEmptyRegisters := uint8(0)
+func (hll *HyperLogLog) emptyRegistersCount() int {
+	numOfEmptyRegisters := 0
 	for _, value := range hll.Registers {
 		if value == 0 {
 			numOfEmptyRegisters++
@@ -66,25 +332,98 @@ func (hll *HyperLogLog) emptyRegistersCount() uint8 {
 	return numOfEmptyRegisters
 }
 
-func (hyperLogLog *HyperLogLog) SerializeHLL() []byte {
-
-	var buffer bytes.Buffer
-	encoder := gob.NewEncoder(&buffer)
-	encoder.Encode(&hyperLogLog)
-	return buffer.Bytes()
+// SerializeHLL encodes the HyperLogLog as the magic, the format version, P and the
+// representation, followed by the M registers when dense, or by the number of sparse
+// registers and the differences between them as uvarints when sparse, and a CRC32 of
+// everything before it, little endian.
+func (hll *HyperLogLog) SerializeHLL() []byte {
+	data := append([]byte(nil), hyperLogLogMagic...)
+	data = append(data, hyperLogLogVersion, hll.P)
+	if hll.IsSparse() {
+		data = append(data, hllSparse)
+		data = binary.AppendUvarint(data, uint64(len(hll.sparse)))
+		previous := uint32(0)
+		for _, entry := range hll.sparse {
+			data = binary.AppendUvarint(data, uint64(entry-previous))
+			previous = entry
+		}
+	} else {
+		data = append(data, hllDense)
+		data = append(data, hll.Registers...)
+	}
+	return binary.LittleEndian.AppendUint32(data, CRC32(data))
 }
 
-func DeserializeHLL(data []byte) *HyperLogLog {
+// legacyHyperLogLog is the HyperLogLog as older versions wrote it with gob, always dense.
+type legacyHyperLogLog struct {
+	P         uint8
+	M         uint64
+	Registers []uint8
+}
 
-	buffer := bytes.NewBuffer(data)
-	decoder := gob.NewDecoder(buffer)
-	hyperLogLog := new(HyperLogLog)
+// DeserializeHLL decodes a HyperLogLog encoded by SerializeHLL, or by gob in older versions.
+func DeserializeHLL(data []byte) (*HyperLogLog, error) {
+	if len(data) < len(hyperLogLogMagic) || !bytes.Equal(data[:4], hyperLogLogMagic) {
+		return deserializeLegacyHLL(data)
+	}
+	if len(data) < 4+3+4 {
+		return nil, errors.New("HyperLogLog is cut short")
+	}
+	if version := data[4]; version != hyperLogLogVersion {
+		return nil, fmt.Errorf("unknown HyperLogLog version %d", version)
+	}
+	body, crc := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if CRC32(body) != crc {
+		return nil, errors.New("HyperLogLog checksum mismatch")
+	}
 
-	for {
-		err := decoder.Decode(&hyperLogLog)
-		if err != nil {
-			break
+	p, representation, registers := body[5], body[6], body[7:]
+	if p < 1 || p > 32 {
+		return nil, fmt.Errorf("HyperLogLog precision %d is out of range", p)
+	}
+	hll := &HyperLogLog{P: p, M: uint64(1) << p}
+	switch representation {
+	case hllDense:
+		if uint64(len(registers)) != hll.M {
+			return nil, errors.New("HyperLogLog size does not match its registers")
+		}
+		hll.Registers = append([]uint8(nil), registers...)
+	case hllSparse:
+		count, n := binary.Uvarint(registers)
+		if n <= 0 || count > uint64(len(registers)) {
+			return nil, errors.New("HyperLogLog sparse registers are cut short")
+		}
+		registers = registers[n:]
+		hll.sparse = make([]uint32, 0, count)
+		entry := uint64(0)
+		for i := uint64(0); i < count; i++ {
+			delta, n := binary.Uvarint(registers)
+			if n <= 0 || (i > 0 && delta == 0) {
+				return nil, errors.New("HyperLogLog sparse registers are not valid")
+			}
+			registers = registers[n:]
+			if entry += delta; entry >= 1<<(hllSparsePrecision+6) {
+				return nil, errors.New("HyperLogLog sparse register is out of range")
+			}
+			hll.sparse = append(hll.sparse, uint32(entry))
 		}
+		if len(registers) != 0 {
+			return nil, errors.New("HyperLogLog sparse registers do not match their count")
+		}
+	default:
+		return nil, fmt.Errorf("unknown HyperLogLog representation %d", representation)
+	}
+	return hll, nil
+}
+
+// deserializeLegacyHLL decodes a HyperLogLog written with gob.
+func deserializeLegacyHLL(data []byte) (*HyperLogLog, error) {
+	legacy := new(legacyHyperLogLog)
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(legacy); err != nil {
+		return nil, err
+	}
+	if legacy.P < 1 || legacy.P > 32 || uint64(len(legacy.Registers)) != uint64(1)<<legacy.P {
+		return nil, errors.New("HyperLogLog size does not match its registers")
 	}
-	return hyperLogLog
+	return &HyperLogLog{P: legacy.P, M: uint64(1) << legacy.P, Registers: legacy.Registers}, nil
 }
@@ -0,0 +1,231 @@
+package structures
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math"
+	"strconv"
+	"testing"
+)
+
+// denseHLL returns a dense HyperLogLog of precision p with the items from item<from> up to
+// item<to>, without the last.
+func denseHLL(p uint8, from, to int) *HyperLogLog {
+	hll := CreateHyperLogLog(p)
+	hll.toDense()
+	for i := from; i < to; i++ {
+		hll.Add("item" + strconv.Itoa(i))
+	}
+	return hll
+}
+
+func TestRankIsThePositionOfTheLeadingOne(t *testing.T) {
+	for _, test := range []struct {
+		hash uint64
+		p    uint8
+		want uint8
+	}{
+		{1 << 59, 4, 1},
+		{1 << 58, 4, 2},
+		{1, 4, 60},
+		{0, 4, 61},
+		{1<<63 | 1<<49, 14, 1},
+		{0, 14, 51},
+	} {
+		if got := rank(test.hash, test.p); got != test.want {
+			t.Errorf("rank(%#x, %d) = %d, want %d", test.hash, test.p, got, test.want)
+		}
+	}
+}
+
+func TestRegistersKeepTheirLargestRank(t *testing.T) {
+	hll := denseHLL(8, 0, 1000)
+	registers := append([]uint8(nil), hll.Registers...)
+	// Adding the same items again must not lower any register.
+	for i := 0; i < 1000; i++ {
+		hll.Add("item" + strconv.Itoa(i))
+	}
+	if !bytes.Equal(hll.Registers, registers) {
+		t.Error("adding items again changed the registers")
+	}
+}
+
+func TestDenseEstimateIsWithinTheStandardError(t *testing.T) {
+	for _, p := range []uint8{10, 12, 14} {
+		hll := denseHLL(p, 0, 100000)
+		estimate := hll.Evaluate()
+		if relative := math.Abs(estimate-100000) / 100000; relative > 4*hll.StandardError() {
+			t.Errorf("precision %d estimates %v for 100000 items", p, estimate)
+		}
+	}
+}
+
+func TestMergeIsTheUnionOfTheItems(t *testing.T) {
+	first, second := denseHLL(10, 0, 3000), denseHLL(10, 2000, 5000)
+	if err := first.Merge(second); err != nil {
+		t.Fatal(err)
+	}
+	if all := denseHLL(10, 0, 5000); !bytes.Equal(first.Registers, all.Registers) {
+		t.Error("merged registers differ from those of all the items")
+	}
+	if err := first.Merge(denseHLL(12, 0, 10)); err == nil {
+		t.Error("HyperLogLogs of different precisions were merged")
+	}
+}
+
+func TestSmallSetsAreSparseAndCountedExactly(t *testing.T) {
+	hll := CreateHyperLogLog(14)
+	for i := 0; i < 1000; i++ {
+		hll.Add("item" + strconv.Itoa(i))
+	}
+	if !hll.IsSparse() {
+		t.Fatal("HyperLogLog of 1000 items is not sparse at precision 14")
+	}
+	if estimate := hll.Evaluate(); math.Abs(estimate-1000) > 1 {
+		t.Errorf("sparse HyperLogLog estimates %v for 1000 items", estimate)
+	}
+}
+
+func TestSparseHyperLogLogTurnsDense(t *testing.T) {
+	hll := CreateHyperLogLog(10)
+	for i := 0; i < 5000; i++ {
+		hll.Add("item" + strconv.Itoa(i))
+	}
+	if hll.IsSparse() {
+		t.Fatal("HyperLogLog of 5000 items is still sparse at precision 10")
+	}
+	if dense := denseHLL(10, 0, 5000); !bytes.Equal(hll.Registers, dense.Registers) {
+		t.Error("registers of the sparse HyperLogLog differ from those of a dense one")
+	}
+}
+
+func TestMergeOfSparseAndDense(t *testing.T) {
+	all := denseHLL(10, 0, 3000)
+	sparse := CreateHyperLogLog(10)
+	for i := 0; i < 100; i++ {
+		sparse.Add("item" + strconv.Itoa(i))
+	}
+
+	dense := denseHLL(10, 100, 3000)
+	if err := dense.Merge(sparse); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dense.Registers, all.Registers) {
+		t.Error("sparse merged into dense differs from all the items")
+	}
+	if err := sparse.Merge(denseHLL(10, 100, 3000)); err != nil {
+		t.Fatal(err)
+	}
+	if sparse.IsSparse() || !bytes.Equal(sparse.Registers, all.Registers) {
+		t.Error("dense merged into sparse differs from all the items")
+	}
+}
+
+func TestBiasCorrectedEstimates(t *testing.T) {
+	// Between linear counting and 5*M the raw estimate is corrected for its bias.
+	for _, n := range []int{1000, 2000, 3000, 4000} {
+		hll := denseHLL(10, 0, n)
+		estimate := hll.Evaluate()
+		if relative := math.Abs(estimate-float64(n)) / float64(n); relative > 4*hll.StandardError() {
+			t.Errorf("precision 10 estimates %v for %d items", estimate, n)
+		}
+	}
+}
+
+func TestHyperLogLogRoundTrip(t *testing.T) {
+	for _, hll := range []*HyperLogLog{CreateHyperLogLog(12), denseHLL(12, 0, 100), denseHLL(6, 0, 1000)} {
+		for i := 0; i < 50; i++ {
+			hll.Add("other" + strconv.Itoa(i))
+		}
+		data := hll.SerializeHLL()
+		decoded, err := DeserializeHLL(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.IsSparse() != hll.IsSparse() || !bytes.Equal(decoded.SerializeHLL(), data) {
+			t.Errorf("decoded HyperLogLog is %+v, want %+v", decoded, hll)
+		}
+		data[len(data)/2] ^= 1
+		if _, err = DeserializeHLL(data); err == nil {
+			t.Error("HyperLogLog with a flipped bit was decoded")
+		}
+	}
+}
+
+func TestLegacyHyperLogLogIsDecoded(t *testing.T) {
+	hll := denseHLL(8, 0, 500)
+	var buffer bytes.Buffer
+	err := gob.NewEncoder(&buffer).Encode(legacyHyperLogLog{P: hll.P, M: hll.M, Registers: hll.Registers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DeserializeHLL(buffer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.P != 8 || !bytes.Equal(decoded.Registers, hll.Registers) {
+		t.Errorf("legacy HyperLogLog is decoded as %+v", decoded)
+	}
+}
+
+func TestEstimatesOfOverlappingSets(t *testing.T) {
+	first, second, third := denseHLL(14, 0, 6000), denseHLL(14, 4000, 10000), denseHLL(14, 5000, 12000)
+	for _, test := range []struct {
+		name  string
+		hlls  []*HyperLogLog
+		union bool
+		want  float64
+	}{
+		{"union of two", []*HyperLogLog{first, second}, true, 10000},
+		{"union of three", []*HyperLogLog{first, second, third}, true, 12000},
+		{"intersection of two", []*HyperLogLog{first, second}, false, 2000},
+		{"intersection of three", []*HyperLogLog{first, second, third}, false, 1000},
+	} {
+		estimate, err := EstimateIntersection(test.hlls)
+		if test.union {
+			estimate, err = EstimateUnion(test.hlls)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if estimate.Error <= 0 || math.Abs(estimate.Estimate-test.want) > 3*estimate.Error {
+			t.Errorf("%s is %+v, want %v", test.name, estimate, test.want)
+		}
+	}
+	if !bytes.Equal(first.Registers, denseHLL(14, 0, 6000).Registers) {
+		t.Error("estimates changed the HyperLogLogs")
+	}
+}
+
+func TestIntersectionOfDisjointSetsIsNotNegative(t *testing.T) {
+	estimate, err := EstimateIntersection([]*HyperLogLog{denseHLL(10, 0, 1000), denseHLL(10, 1000, 2000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if estimate.Estimate < 0 || estimate.Estimate > 3*estimate.Error {
+		t.Errorf("intersection of disjoint sets is %+v", estimate)
+	}
+}
+
+func TestInvalidSetOperations(t *testing.T) {
+	if _, err := EstimateUnion(nil); err == nil {
+		t.Error("union of no HyperLogLogs was estimated")
+	}
+	if _, err := EstimateIntersection(nil); err == nil {
+		t.Error("intersection of no HyperLogLogs was estimated")
+	}
+	mixed := []*HyperLogLog{CreateHyperLogLog(10), CreateHyperLogLog(12)}
+	if _, err := EstimateUnion(mixed); err == nil {
+		t.Error("union of different precisions was estimated")
+	}
+	if _, err := EstimateIntersection(mixed); err == nil {
+		t.Error("intersection of different precisions was estimated")
+	}
+	many := make([]*HyperLogLog, MaxIntersectionHLLs+1)
+	for i := range many {
+		many[i] = CreateHyperLogLog(10)
+	}
+	if _, err := EstimateIntersection(many); err == nil {
+		t.Errorf("intersection of %d HyperLogLogs was estimated", len(many))
+	}
+}
@@ -3,7 +3,8 @@ package structures
 import (
 	"bufio"
 	"encoding/binary"
-	"log"
+	"errors"
+	"io"
 	"math/rand"
 	"os"
 )
@@ -41,11 +42,11 @@ func (index *SimpleIndex) Add(key string, offset uint) {
 }
 
 // WriteToFile writes the index data to a file and returns keys and offsets.
-func (index *SimpleIndex) WriteToFile() (keys []string, offsets []uint) {
+func (index *SimpleIndex) WriteToFile() (keys []string, offsets []uint, err error) {
 	currentOffset := uint(0)
 	file, err := os.Create(index.FileName)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, err
 	}
 	defer file.Close()
 
@@ -53,17 +54,7 @@ func (index *SimpleIndex) WriteToFile() (keys []string, offsets []uint) {
 
 	bytesLen := make([]byte, 8)
 	binary.LittleEndian.PutUint64(bytesLen, uint64(len(index.Keys)))
-	bytesWritten, err := writer.Write(bytesLen)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	currentOffset += uint(bytesWritten)
-
-	err = writer.Flush()
-	if err != nil {
-		return
-	}
+	currentOffset += writeBytes(writer, bytesLen)
 
 	rangeKeys := make([]string, 0)
 	rangeOffsets := make([]uint, 0)
@@ -84,97 +75,159 @@ func (index *SimpleIndex) WriteToFile() (keys []string, offsets []uint) {
 		keyLen := uint64(len(bytes))
 		bytesLen := make([]byte, 8)
 		binary.LittleEndian.PutUint64(bytesLen, keyLen)
-		bytesWritten, err := writer.Write(bytesLen)
-		if err != nil {
-			log.Fatal(err)
-		}
-		currentOffset += uint(bytesWritten)
-
-		bytesWritten, err = writer.Write(bytes)
-		if err != nil {
-			log.Fatal(err)
-		}
-		currentOffset += uint(bytesWritten)
+		currentOffset += writeBytes(writer, bytesLen)
+		currentOffset += writeBytes(writer, bytes)
 
 		bytes = make([]byte, 8)
 		binary.LittleEndian.PutUint64(bytes, uint64(offset))
-		bytesWritten, err = writer.Write(bytes)
-		if err != nil {
-			log.Fatal(err)
-		}
-		currentOffset += uint(bytesWritten)
+		currentOffset += writeBytes(writer, bytes)
 	}
 
-	err = writer.Flush()
-	if err != nil {
-		return
+	if err = writer.Flush(); err != nil {
+		return nil, nil, err
+	}
+	if err = syncAndClose(file); err != nil {
+		return nil, nil, err
 	}
 
+	if len(rangeKeys) == 1 {
+		// A single record is both the first and the last key of the table.
+		rangeKeys = append(rangeKeys, rangeKeys[0])
+		rangeOffsets = append(rangeOffsets, rangeOffsets[0])
+	}
 	keys = append(rangeKeys, sampleKeys...)
 	offsets = append(rangeOffsets, sampleOffsets...)
-	return
+	return keys, offsets, nil
 }
 
 // Search finds a key in the index and returns its existence status and data offset.
-func SearchIndex(key string, startOffset int64, filename string) (found bool, dataOffset int64) {
-	found = false
-	dataOffset = 0
-
+func SearchIndex(key string, startOffset int64, filename string) (found bool, dataOffset int64, err error) {
 	file, err := os.Open(filename)
 	if err != nil {
-		log.Panic(err)
+		return false, 0, err
 	}
 	defer file.Close()
 
-	reader := bufio.NewReader(file)
-	bytes := make([]byte, 8)
-	_, err = reader.Read(bytes)
+	bytes, err := readBytes(bufio.NewReader(file), 8)
 	if err != nil {
-		log.Panic(err)
+		return false, 0, readError(filename, err)
 	}
 	fileLen := binary.LittleEndian.Uint64(bytes)
 
 	_, err = file.Seek(startOffset, 0)
 	if err != nil {
-		return false, 0
+		return false, 0, err
 	}
 
-	reader = bufio.NewReader(file)
+	reader := bufio.NewReader(file)
 
 	var i uint64
 	for i = 0; i < fileLen; i++ {
-		bytes := make([]byte, 8)
-		_, err = reader.Read(bytes)
-		if err != nil {
-			log.Panic(err)
+		bytes, err := readBytes(reader, 8)
+		if errors.Is(err, io.EOF) {
+			// The search started in the middle of the index and reached its end.
+			return false, 0, nil
+		} else if err != nil {
+			return false, 0, readError(filename, err)
 		}
 		keyLen := binary.LittleEndian.Uint64(bytes)
 
-		bytes = make([]byte, keyLen)
-		_, err = reader.Read(bytes)
+		bytes, err = readBytes(reader, int(keyLen))
 		if err != nil {
-			log.Panic(err)
+			return false, 0, readError(filename, err)
 		}
 		nodeKey := string(bytes[:])
 
-		if nodeKey == key {
-			found = true
-		} else if nodeKey > key {
-			return false, 0
+		if nodeKey > key {
+			return false, 0, nil
 		}
 
-		bytes = make([]byte, 8)
-		_, err = reader.Read(bytes)
+		bytes, err = readBytes(reader, 8)
 		if err != nil {
-			log.Panic(err)
+			return false, 0, readError(filename, err)
 		}
-		newOffset := binary.LittleEndian.Uint64(bytes)
 
-		if found {
-			dataOffset = int64(newOffset)
+		if nodeKey == key {
+			return true, int64(binary.LittleEndian.Uint64(bytes)), nil
+		}
+	}
+
+	return false, 0, nil
+}
+
+// searchIndexKeys walks the index once from startOffset and returns the data offsets of
+// the sorted keys that are present.
+func searchIndexKeys(keys []string, startOffset int64, filename string) (map[string]int64, error) {
+	dataOffsets := make(map[string]int64)
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	_, err = file.Seek(startOffset, 0)
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(file)
+
+	next := 0
+	for next < len(keys) {
+		bytes, err := readBytes(reader, 8)
+		if errors.Is(err, io.EOF) {
 			break
+		} else if err != nil {
+			return nil, readError(filename, err)
 		}
+		nodeKeyBytes, err := readBytes(reader, int(binary.LittleEndian.Uint64(bytes)))
+		if err != nil {
+			return nil, readError(filename, err)
+		}
+		if bytes, err = readBytes(reader, 8); err != nil {
+			return nil, readError(filename, err)
+		}
+		nodeKey := string(nodeKeyBytes)
+
+		for next < len(keys) && keys[next] < nodeKey {
+			next++
+		}
+		if next < len(keys) && keys[next] == nodeKey {
+			dataOffsets[nodeKey] = int64(binary.LittleEndian.Uint64(bytes))
+			next++
+		}
+	}
+	return dataOffsets, nil
+}
+
+// readIndexKeys returns every key of the index, in order.
+func readIndexKeys(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
 	}
+	defer file.Close()
 
-	return
+	reader := bufio.NewReader(file)
+	bytes, err := readBytes(reader, 8)
+	if err != nil {
+		return nil, readError(filename, err)
+	}
+	fileLen := binary.LittleEndian.Uint64(bytes)
+
+	keys := make([]string, 0)
+	for i := uint64(0); i < fileLen; i++ {
+		if bytes, err = readBytes(reader, 8); err != nil {
+			return nil, readError(filename, err)
+		}
+		keyBytes, err := readBytes(reader, int(binary.LittleEndian.Uint64(bytes)))
+		if err != nil {
+			return nil, readError(filename, err)
+		}
+		if _, err = readBytes(reader, 8); err != nil {
+			return nil, readError(filename, err)
+		}
+		keys = append(keys, string(keyBytes))
+	}
+	return keys, nil
 }
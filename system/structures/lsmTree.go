@@ -5,7 +5,6 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"strconv"
 	"strings"
@@ -15,13 +14,15 @@ import (
 type LSMTree struct {
 	maxLevel int
 	maxSize  int
+	options  TableOptions // for the tables written by compaction
 }
 
 // NewLSMTree creates a new LSM Tree instance.
-func NewLSMTree(maxLevels, maxSize int) *LSMTree {
+func NewLSMTree(maxLevels, maxSize int, options TableOptions) *LSMTree {
 	return &LSMTree{
 		maxLevel: maxLevels,
 		maxSize:  maxSize,
+		options:  options,
 	}
 }
 
@@ -31,19 +32,21 @@ func (tree LSMTree) IsCompactionNeeded(directory string, level int) (bool, []str
 	return len(indexFiles) == tree.maxSize, dataFiles, indexFiles, summaryFiles, tocFiles, filterFiles
 }
 
-// PerformCompaction performs compaction at a given level in the LSM Tree.
-func (tree LSMTree) PerformCompaction(directory string, level int) {
+// PerformCompaction performs compaction at a given level in the LSM Tree
+// of the keyspace stored in directory.
+func (tree LSMTree) PerformCompaction(directory string, level int) error {
 	if level >= tree.maxLevel {
-		return // No compaction after reaching the last level.
+		return nil // No compaction after reaching the last level.
 	}
 
-	compactionNeeded, dataFiles, indexFiles, summaryFiles, tocFiles, filterFiles := tree.IsCompactionNeeded(directory, level)
+	compactionNeeded, dataFiles, indexFiles, summaryFiles, tocFiles, filterFiles :=
+		tree.IsCompactionNeeded(SSTableDirectory(directory), level)
 	if !compactionNeeded {
-		return
+		return nil
 	}
 
 	//compaction is needed
-	_, indexFilesNextLevel, _, _, _ := FindFiles(directory, level+1)
+	_, indexFilesNextLevel, _, _, _ := FindFiles(SSTableDirectory(directory), level+1)
 
 	var numFile int
 	if len(indexFilesNextLevel) == 0 {
@@ -59,387 +62,412 @@ func (tree LSMTree) PerformCompaction(directory string, level int) {
 		secondDataFile, secondIndexFile, secondSummaryFile, secondTocFile, secondFilterFile :=
 			dataFiles[i+1], indexFiles[i+1], summaryFiles[i+1], tocFiles[i+1], filterFiles[i+1]
 
-		MergeTables(directory, numFile, level+1, firstDataFile, firstIndexFile, firstSummaryFile, firstTocFile, firstFilterFile,
-			secondDataFile, secondIndexFile, secondSummaryFile, secondTocFile, secondFilterFile)
+		err := MergeTables(directory, numFile, level+1, firstDataFile, firstIndexFile, firstSummaryFile, firstTocFile,
+			firstFilterFile, secondDataFile, secondIndexFile, secondSummaryFile, secondTocFile, secondFilterFile, tree.options)
+		if err != nil {
+			return fmt.Errorf("compaction of level %d: %w", level, err)
+		}
 
 		numFile++
 	}
 
-	tree.PerformCompaction(directory, level+1)
+	return tree.PerformCompaction(directory, level+1)
 }
 
-// MergeTables merges two SSTables into a new one.
+// MergeTables merges two SSTables into a new one. The two tables are removed only
+// after the merged table has been written completely.
 func MergeTables(directory string, numFile, level int, firstData, firstIndex, firstSummary, firstToc, firstFilter,
-	secondData, secondIndex, secondSummary, secondToc, secondFilter string) {
+	secondData, secondIndex, secondSummary, secondToc, secondFilter string, options TableOptions) error {
 
-	strLevel := strconv.Itoa(level + 1)
-	generalFilename := directory + "usertable-data-ic-" + strconv.Itoa(numFile) + "-lev" + strLevel + "-"
-	mergedTable := &SSTable{generalFilename, generalFilename + "Data.db",
-		generalFilename + "Index.db", generalFilename + "Summary.db",
-		generalFilename + "Filter.gob"}
+	tablesDirectory := SSTableDirectory(directory)
+	mergedTable := tableFiles(directory, level, numFile)
+	firstRangeDel := tablesDirectory + strings.Replace(firstData, "Data.db", "RangeDel.db", 1)
+	secondRangeDel := tablesDirectory + strings.Replace(secondData, "Data.db", "RangeDel.db", 1)
 
-	newData, err := os.Create(mergedTable.generalFilename + "Data.db")
+	err := mergeTableFiles(mergedTable, directory, tablesDirectory+firstData, tablesDirectory+secondData,
+		firstRangeDel, secondRangeDel, options)
 	if err != nil {
-		log.Fatal(err)
-	}
+		mergedTable.remove(directory)
+		return err
+	}
+
+	_ = os.Remove(tablesDirectory + firstData)
+	_ = os.Remove(tablesDirectory + firstIndex)
+	_ = os.Remove(tablesDirectory + firstSummary)
+	_ = os.Remove(tablesDirectory + firstToc)
+	_ = os.Remove(tablesDirectory + firstFilter)
+	_ = os.Remove(firstRangeDel)
+	_ = os.Remove(tablesDirectory + secondData)
+	_ = os.Remove(tablesDirectory + secondIndex)
+	_ = os.Remove(tablesDirectory + secondSummary)
+	_ = os.Remove(tablesDirectory + secondToc)
+	_ = os.Remove(tablesDirectory + secondFilter)
+	_ = os.Remove(secondRangeDel)
+	_ = os.Remove(metadataFilename(directory, firstData))
+	_ = os.Remove(metadataFilename(directory, secondData))
+	return nil
+}
 
-	currentOffset := uint(0)
-	currentOffset1 := uint(0)
-	currentOffset2 := uint(0)
+// mergeTableFiles writes the merged table from the data and range tombstone files of the two tables.
+func mergeTableFiles(mergedTable *SSTable, directory string,
+	firstData, secondData, firstRangeDel, secondRangeDel string, options TableOptions) error {
 
-	writer := bufio.NewWriter(newData)
-	bytesLen := make([]byte, 8)
+	firstRangeTombstones, err := readRangeTombstones(firstRangeDel)
+	if err != nil {
+		return err
+	}
+	secondRangeTombstones, err := readRangeTombstones(secondRangeDel)
+	if err != nil {
+		return err
+	}
 
-	bytesWritten, err := writer.Write(bytesLen)
-	currentOffset += uint(bytesWritten)
+	newData, err := os.Create(mergedTable.dataFilename)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	defer newData.Close()
 
-	firstDataFile, err := os.Open(directory + firstData)
+	firstDataFile, err := os.Open(firstData)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	defer firstDataFile.Close()
 
-	secondDataFile, err := os.Open(directory + secondData)
+	secondDataFile, err := os.Open(secondData)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	defer secondDataFile.Close()
 
-	reader1 := bufio.NewReader(firstDataFile)
-	bytes := make([]byte, 8)
-	_, err = reader1.Read(bytes)
+	bytes, err := readBytes(bufio.NewReader(firstDataFile), 8)
 	if err != nil {
-		panic(err)
+		return readError(firstData, err)
 	}
 	fileLen1 := binary.LittleEndian.Uint64(bytes)
-	currentOffset1 += 8
 
 	// file length drugog data fajla
-	reader2 := bufio.NewReader(secondDataFile)
-	bytes = make([]byte, 8)
-	_, err = reader2.Read(bytes)
+	bytes, err = readBytes(bufio.NewReader(secondDataFile), 8)
 	if err != nil {
-		panic(err)
+		return readError(secondData, err)
 	}
 	fileLen2 := binary.LittleEndian.Uint64(bytes)
-	currentOffset2 += 8
-
-	fileLen := readAndWriteData(currentOffset, currentOffset1, currentOffset2, newData, firstDataFile, secondDataFile,
-		fileLen1, fileLen2, mergedTable, level)
-
-	FileSize(mergedTable.dataFilename, fileLen)
-
-	_ = newData.Close()
-	_ = firstDataFile.Close()
-	_ = secondDataFile.Close()
-
-	_ = os.Remove(directory + firstData)
-	_ = os.Remove(directory + firstIndex)
-	_ = os.Remove(directory + firstSummary)
-	_ = os.Remove(directory + firstToc)
-	_ = os.Remove(directory + firstFilter)
-	_ = os.Remove(directory + secondData)
-	_ = os.Remove(directory + secondIndex)
-	_ = os.Remove(directory + secondSummary)
-	_ = os.Remove(directory + secondToc)
-	_ = os.Remove(directory + secondFilter)
+
+	// Mesto za duzinu fajla, upisuje se kada je poznat broj zapisa.
+	currentOffset, err := writeRaw(newData, 0, make([]byte, 8))
+	if err != nil {
+		return err
+	}
+
+	fileLen, err := readAndWriteData(currentOffset, 8, 8, newData, firstDataFile, secondDataFile,
+		fileLen1, fileLen2, firstRangeTombstones, secondRangeTombstones, mergedTable, directory, options)
+	if err != nil {
+		return err
+	}
+
+	if err = syncAndClose(newData); err != nil {
+		return err
+	}
+	return FileSize(mergedTable.dataFilename, fileLen)
 }
 
 // readAndWriteData reads and writes data during the merging process.
+// The second file always holds the newer table, so it wins on equal timestamps, and its
+// range tombstones drop the keys they cover from the first file. Point and range tombstones
+// are kept, because they still have to hide older data in deeper levels.
 func readAndWriteData(currentOffset, currentOffset1, currentOffset2 uint, newData, firstDataFile, secondDataFile *os.File,
-	fileLen1, fileLen2 uint64, table *SSTable, level int) uint64 {
+	fileLen1, fileLen2 uint64, firstRangeDel, secondRangeDel []RangeTombstone, table *SSTable, directory string,
+	options TableOptions) (uint64, error) {
 
-	filter := CreateBF(uint(fileLen1+fileLen2), 2)
 	keys := make([]string, 0)
 	offsets := make([]uint, 0)
-	values := make([][]byte, 0)
-
-	crc1, timestamp1, tombstone1, keyLen1, valueLen1, key1, value1, currentOffset1 :=
-		readData(firstDataFile, currentOffset1)
-
-	crc2, timestamp2, tombstone2, keyLen2, valueLen2, key2, value2, currentOffset2 :=
-		readData(secondDataFile, currentOffset2)
+	leaves := make([][]byte, 0)
+
+	write := func(crc []byte, timestamp string, kind byte, key, value string) error {
+		offsets = append(offsets, currentOffset)
+		var err error
+		currentOffset, err = writeData(newData, currentOffset, crc, timestamp, kind,
+			uint64(len(key)), uint64(len(value)), key, value)
+		keys = append(keys, key)
+		leaves = append(leaves, MerkleLeaf(key, kind, timestamp, value))
+		return err
+	}
+
+	var crc1, crc2 []byte
+	var timestamp1, timestamp2, key1, key2, value1, value2 string
+	var kind1, kind2 byte
+	var err error
+
+	// read1 and read2 read the current record of a table. A corrupt record fails the
+	// compaction, so that it is not copied into the merged table with a new checksum.
+	read1 := func() error {
+		crc1, timestamp1, kind1, _, _, key1, value1, currentOffset1, err = readData(firstDataFile, currentOffset1)
+		if err == nil {
+			err = checkCRC(firstDataFile.Name(), crc1, key1, value1)
+		}
+		return err
+	}
+	read2 := func() error {
+		crc2, timestamp2, kind2, _, _, key2, value2, currentOffset2, err = readData(secondDataFile, currentOffset2)
+		if err == nil {
+			err = checkCRC(secondDataFile.Name(), crc2, key2, value2)
+		}
+		return err
+	}
 
 	first, second := uint64(0), uint64(0)
-	for {
-		if fileLen1 == first || fileLen2 == second {
-			break
+	// next1 and next2 move past the current record of a table and read its next one.
+	next1 := func() error {
+		first++
+		if first < fileLen1 {
+			return read1()
 		}
+		return nil
+	}
+	next2 := func() error {
+		second++
+		if second < fileLen2 {
+			return read2()
+		}
+		return nil
+	}
+
+	if fileLen1 > 0 {
+		if err = read1(); err != nil {
+			return 0, err
+		}
+	}
+	if fileLen2 > 0 {
+		if err = read2(); err != nil {
+			return 0, err
+		}
+	}
 
+	for fileLen1 != first && fileLen2 != second {
 		if key1 == key2 {
+			if coveredByAny(key1, secondRangeDel) {
+				kind1 = RecordTombstone
+			}
 			if timestamp1 > timestamp2 {
-				if tombstone1 == 0 {
-					offsets = append(offsets, currentOffset)
-					currentOffset = writeData(newData, currentOffset, crc1, timestamp1, tombstone1,
-						keyLen1, valueLen1, key1, value1)
-					filter.Add(Element{0, timestamp1, false, key1, nil, nil})
-					keys = append(keys, key1)
-					values = append(values, []byte(value1))
-				}
+				kind, value := combineRecords(options.Operators, kind1, value1, kind2, value2)
+				err = write(recordCRC(crc1, value1, value), timestamp1, kind, key1, value)
 			} else {
-				if tombstone2 == 0 {
-					offsets = append(offsets, currentOffset)
-					currentOffset = writeData(newData, currentOffset, crc2, timestamp2, tombstone2,
-						keyLen2, valueLen2, key2, value2)
-					filter.Add(Element{0, timestamp2, false, key2, nil, nil})
-					keys = append(keys, key2)
-					values = append(values, []byte(value2))
-				}
+				kind, value := combineRecords(options.Operators, kind2, value2, kind1, value1)
+				err = write(recordCRC(crc2, value2, value), timestamp2, kind, key2, value)
 			}
-
-			if fileLen1-1 > first {
-				crc1, timestamp1, tombstone1, keyLen1, valueLen1, key1, value1, currentOffset1 =
-					readData(firstDataFile, currentOffset1)
+			if err != nil {
+				return 0, err
 			}
-			first++
-
-			if fileLen2-1 > second {
-				crc2, timestamp2, tombstone2, keyLen2, valueLen2, key2, value2, currentOffset2 =
-					readData(secondDataFile, currentOffset2)
+			if err = next1(); err != nil {
+				return 0, err
+			}
+			if err = next2(); err != nil {
+				return 0, err
 			}
-			second++
 
 		} else if key1 < key2 {
-			if tombstone1 == 0 {
-				offsets = append(offsets, currentOffset)
-				currentOffset = writeData(newData, currentOffset, crc1, timestamp1, tombstone1,
-					keyLen1, valueLen1, key1, value1)
-				filter.Add(Element{0, timestamp1, false, key1, nil, nil})
-				keys = append(keys, key1)
-				values = append(values, []byte(value1))
+			if !coveredByAny(key1, secondRangeDel) {
+				if err = write(crc1, timestamp1, kind1, key1, value1); err != nil {
+					return 0, err
+				}
 			}
-
-			if fileLen1-1 > first {
-				crc1, timestamp1, tombstone1, keyLen1, valueLen1, key1, value1, currentOffset1 =
-					readData(firstDataFile, currentOffset1)
+			if err = next1(); err != nil {
+				return 0, err
 			}
-			first++
 
 		} else {
-			if tombstone2 == 0 {
-				offsets = append(offsets, currentOffset)
-				currentOffset = writeData(newData, currentOffset, crc2, timestamp2, tombstone2,
-					keyLen2, valueLen2, key2, value2)
-				filter.Add(Element{0, timestamp2, false, key2, nil, nil})
-				keys = append(keys, key2)
-				values = append(values, []byte(value2))
+			if err = write(crc2, timestamp2, kind2, key2, value2); err != nil {
+				return 0, err
 			}
-
-			if fileLen2-1 > second {
-				crc2, timestamp2, tombstone2, keyLen2, valueLen2, key2, value2, currentOffset2 =
-					readData(secondDataFile, currentOffset2)
+			if err = next2(); err != nil {
+				return 0, err
 			}
-			second++
-
 		}
 	}
 
-	if fileLen1 == first && fileLen2 != second {
-		for fileLen2 != second {
-			if tombstone2 == 0 {
-				offsets = append(offsets, currentOffset)
-				currentOffset = writeData(newData, currentOffset, crc2, timestamp2, tombstone2,
-					keyLen2, valueLen2, key2, value2)
-				filter.Add(Element{0, timestamp2, false, key2, nil, nil})
-				keys = append(keys, key2)
-				values = append(values, []byte(value2))
-			}
-
-			if fileLen2-1 > second {
-				crc2, timestamp2, tombstone2, keyLen2, valueLen2, key2, value2, currentOffset2 =
-					readData(secondDataFile, currentOffset2)
-			}
-			second++
-
+	for fileLen2 != second {
+		if err = write(crc2, timestamp2, kind2, key2, value2); err != nil {
+			return 0, err
 		}
-	} else if fileLen2 == second && fileLen1 != first {
-		for fileLen1 > first {
-			if tombstone1 == 0 {
-				offsets = append(offsets, currentOffset)
-				currentOffset = writeData(newData, currentOffset, crc1, timestamp1, tombstone1,
-					keyLen1, valueLen1, key1, value1)
-				filter.Add(Element{0, timestamp1, false, key1, nil, nil})
-				keys = append(keys, key1)
-				values = append(values, []byte(value1))
-			}
-
-			if fileLen1-1 != first {
-				crc1, timestamp1, tombstone1, keyLen1, valueLen1, key1, value1, currentOffset1 =
-					readData(firstDataFile, currentOffset1)
+		if err = next2(); err != nil {
+			return 0, err
+		}
+	}
+	for fileLen1 != first {
+		if !coveredByAny(key1, secondRangeDel) {
+			if err = write(crc1, timestamp1, kind1, key1, value1); err != nil {
+				return 0, err
 			}
-			first++
+		}
+		if err = next1(); err != nil {
+			return 0, err
 		}
 	}
 
-	index := NewSimpleIndex(keys, offsets, table.indexFilename)
-	keysIndex, offsetsIndex := index.WriteToFile()
-	WriteSummaryToFile(keysIndex, offsetsIndex, table.summaryFilename)
-	table.WriteTableOfContents()
-	writeBF(table.filterFilename, filter)
-	CreateMerkle(level, newData.Name(), values)
-
-	return uint64(len(keys))
+	err = table.writeAuxiliaryFiles(directory, keys, offsets, leaves, append(firstRangeDel, secondRangeDel...), options)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(len(keys)), nil
 }
 
-// writeData writes a key-value pair to a file, updating the file offset.
-func writeData(file *os.File, currentOffset uint, crcBytes []byte, timestamp string, tombstone byte,
-	keyLen, valueLen uint64, key, value string) uint {
+// combineRecords resolves two versions of the same key. Merge operands of the newer
+// version are folded into an older value, or appended to older operands. A quarantine
+// marker keeps both the older version below it and the operands above it.
+func combineRecords(operators MergeOperators, newerKind byte, newerValue string, olderKind byte,
+	olderValue string) (byte, string) {
+	if newerKind == RecordQuarantined {
+		return RecordQuarantined, markerOver(operators, newerValue, olderKind, olderValue)
+	}
+	if newerKind != RecordMerge {
+		return newerKind, newerValue
+	}
+	operands := DecodeOperands([]byte(newerValue))
+	switch olderKind {
+	case RecordMerge:
+		return RecordMerge, string(EncodeOperands(append(DecodeOperands([]byte(olderValue)), operands...)))
+	case RecordQuarantined:
+		return RecordQuarantined, markerUnder(olderValue, operands)
+	case RecordTombstone:
+		return RecordValue, string(operators.Fold(nil, operands))
+	default:
+		return RecordValue, string(operators.Fold([]byte(olderValue), operands))
+	}
+}
 
-	if tombstone == 1 {
-		return currentOffset
+// recordCRC returns the stored checksum, or a new one if combining changed the value.
+func recordCRC(crc []byte, oldValue, newValue string) []byte {
+	if oldValue == newValue {
+		return crc
 	}
+	crcBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(crcBytes, CRC32([]byte(newValue)))
+	return crcBytes
+}
 
-	file.Seek(int64(currentOffset), 0)
-	writer := bufio.NewWriter(file)
+// writeData writes a key-value pair to a file, updating the file offset.
+func writeData(file *os.File, currentOffset uint, crcBytes []byte, timestamp string, tombstone byte,
+	keyLen, valueLen uint64, key, value string) (uint, error) {
 
-	bytesWritten, err := writer.Write(crcBytes)
-	currentOffset += uint(bytesWritten)
-	if err != nil {
-		log.Fatal(err)
-	}
+	record := make([]byte, 0, 40+len(key)+len(value))
+	record = append(record, crcBytes...)
 
 	// Timestamp
 	timestampBytes := make([]byte, 19)
 	copy(timestampBytes, timestamp)
-	bytesWritten, err = writer.Write(timestampBytes)
-	if err != nil {
-		log.Fatal(err)
-	}
-	currentOffset += uint(bytesWritten)
+	record = append(record, timestampBytes...)
 
 	// Tombstone
-	tombstoneInt := tombstone
-	err = writer.WriteByte(tombstoneInt)
-	currentOffset += 1
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// keyLen
-	keyLenBytes := make([]byte, 8)
-	binary.LittleEndian.PutUint64(keyLenBytes, keyLen)
-	bytesWritten, err = writer.Write(keyLenBytes)
-	if err != nil {
-		log.Fatal(err)
-	}
-	currentOffset += uint(bytesWritten)
+	record = append(record, tombstone)
 
-	// valueLen
-	valueLenBytes := make([]byte, 8)
-	binary.LittleEndian.PutUint64(valueLenBytes, valueLen)
-	bytesWritten, err = writer.Write(valueLenBytes)
-	if err != nil {
-		log.Fatal(err)
-	}
-	currentOffset += uint(bytesWritten)
+	// keyLen and valueLen
+	lenBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(lenBytes, keyLen)
+	record = append(record, lenBytes...)
+	binary.LittleEndian.PutUint64(lenBytes, valueLen)
+	record = append(record, lenBytes...)
 
-	// Key
-	keyBytes := []byte(key)
-	bytesWritten, err = writer.Write(keyBytes)
-	if err != nil {
-		log.Fatal(err)
-	}
-	currentOffset += uint(bytesWritten)
+	// Key and Value
+	record = append(record, key...)
+	record = append(record, value...)
 
-	// Value
-	valueBytes := []byte(value)
-	bytesWritten, err = writer.Write(valueBytes)
-	if err != nil {
-		log.Fatal(err)
-	}
-	currentOffset += uint(bytesWritten)
-
-	err = writer.Flush()
-	if err != nil {
-		log.Fatal(err)
-	}
+	return writeRaw(file, currentOffset, record)
+}
 
-	return currentOffset
+// writeRaw writes data at offset and returns the offset after it.
+func writeRaw(file *os.File, offset uint, data []byte) (uint, error) {
+	n, err := file.WriteAt(data, int64(offset))
+	return offset + uint(n), err
 }
 
 // readData reads a key-value pair from a file, returning relevant information.
-func readData(file *os.File, currentOffset uint) ([]byte, string, byte, uint64, uint64, string, string, uint) {
-	file.Seek(int64(currentOffset), 0)
-	reader := bufio.NewReader(file)
+func readData(file *os.File, currentOffset uint) (crcBytes []byte, timestamp string, tombstone byte,
+	keyLen, valueLen uint64, key, value string, nextOffset uint, err error) {
 
-	// crc
-	crcBytes := make([]byte, 4)
-	_, err := reader.Read(crcBytes)
-	if err != nil {
-		panic(err)
+	if _, err = file.Seek(int64(currentOffset), 0); err != nil {
+		return
 	}
-	currentOffset += 4
+	reader := bufio.NewReader(file)
 
-	// Timestamp
-	timestampBytes := make([]byte, 19)
-	_, err = reader.Read(timestampBytes)
+	// crc, Timestamp, Tombstone, keyLen and valueLen
+	header, err := readBytes(reader, 4+19+1+8+8)
 	if err != nil {
-		panic(err)
+		err = readError(file.Name(), err)
+		return
 	}
-	timestamp := string(timestampBytes[:])
-	currentOffset += 19
+	crcBytes = header[0:4]
+	timestamp = string(header[4:23])
+	tombstone = header[23]
+	keyLen = binary.LittleEndian.Uint64(header[24:32])
+	valueLen = binary.LittleEndian.Uint64(header[32:40])
 
-	// Tombstone
-	tombstone, err := reader.ReadByte()
+	// Key
+	keyBytes, err := readBytes(reader, int(keyLen))
 	if err != nil {
-		panic(err)
+		err = readError(file.Name(), err)
+		return
 	}
-	currentOffset += 1
+	key = string(keyBytes)
 
-	// keyLen
-	keyLenBytes := make([]byte, 8)
-	_, err = reader.Read(keyLenBytes)
+	// Value
+	valueBytes, err := readBytes(reader, int(valueLen))
 	if err != nil {
-		panic(err)
+		err = readError(file.Name(), err)
+		return
 	}
-	keyLen := binary.LittleEndian.Uint64(keyLenBytes)
-	currentOffset += 8
+	value = string(valueBytes)
 
-	// valueLen
-	valueLenBytes := make([]byte, 8)
-	_, err = reader.Read(valueLenBytes)
-	if err != nil {
-		panic(err)
+	nextOffset = currentOffset + uint(len(header)) + uint(keyLen) + uint(valueLen)
+	return
+}
+
+// readRecord reads a record of a data file written in the given table format.
+func readRecord(file *os.File, currentOffset uint, format int) (crcBytes []byte, timestamp string, kind byte,
+	key, value string, nextOffset uint, err error) {
+	if format == legacyTableFormat {
+		return readLegacyData(file, currentOffset)
 	}
-	valueLen := binary.LittleEndian.Uint64(valueLenBytes)
-	currentOffset += 8
+	crcBytes, timestamp, kind, _, _, key, value, nextOffset, err = readData(file, currentOffset)
+	return
+}
 
-	// Key
-	keyBytes := make([]byte, keyLen)
-	_, err = reader.Read(keyBytes)
-	if err != nil {
-		panic(err)
+// readLegacyData reads a record of a data file in legacyTableFormat.
+func readLegacyData(file *os.File, currentOffset uint) (crcBytes []byte, timestamp string, kind byte,
+	key, value string, nextOffset uint, err error) {
+
+	if _, err = file.Seek(int64(currentOffset), 0); err != nil {
+		return
 	}
-	key := string(keyBytes)
-	currentOffset += uint(keyLen)
+	reader := bufio.NewReader(file)
 
-	// Value
-	valueBytes := make([]byte, valueLen)
-	_, err = reader.Read(valueBytes)
+	// crc, Timestamp and Tombstone
+	header, err := readBytes(reader, 4+19+1)
 	if err != nil {
-		panic(err)
+		err = readError(file.Name(), err)
+		return
 	}
-	value := string(valueBytes)
-	currentOffset += uint(valueLen)
-
-	return crcBytes, timestamp, tombstone, keyLen, valueLen, key, value, currentOffset
-}
-
-func CreateMerkle(level int, newData string, values [][]byte) {
-	files, _ := ioutil.ReadDir("./system/data/metadata/") // lista svih fajlova iz direktorijuma
-	for _, f := range files {
-		// brisemo sve metadata fajlove sa zadatog nivoa
-		if strings.Contains(f.Name(), "lev"+strconv.Itoa(level)+"-Metadata.txt") {
-			err := os.Remove("./system/data/metadata/" + f.Name())
-			if err != nil {
-				fmt.Println(err)
-			}
+	crcBytes = header[0:4]
+	timestamp = string(header[4:23])
+	kind = header[23]
+	nextOffset = currentOffset + uint(len(header))
+
+	// keyLen and Key, then valueLen and Value
+	fields := make([]string, 2)
+	for i := range fields {
+		var length uint64
+		if length, err = binary.ReadUvarint(reader); err != nil {
+			err = readError(file.Name(), err)
+			return
+		}
+		var data []byte
+		if data, err = readBytes(reader, int(length)); err != nil {
+			err = readError(file.Name(), err)
+			return
 		}
+		fields[i] = string(data)
+		nextOffset += uint(binary.PutUvarint(make([]byte, binary.MaxVarintLen64), length)) + uint(length)
 	}
-
-	filename := strings.ReplaceAll(newData, "system/data/sstable/", "")
-	BuildMerkleTree(values, filename)
+	key, value = fields[0], fields[1]
+	return
 }
 
 func FindFiles(dir string, level int) ([]string, []string, []string, []string, []string) {
@@ -474,27 +502,18 @@ func FindFiles(dir string, level int) ([]string, []string, []string, []string, [
 	return dataFiles, indexFiles, summaryFiles, tocFiles, filterFiles
 }
 
-func FileSize(filename string, len uint64) {
+// FileSize writes the number of records at the start of a data file.
+func FileSize(filename string, len uint64) error {
 	file, err := os.OpenFile(filename, os.O_WRONLY, 0644)
 	if err != nil {
-		log.Println(err)
+		return err
 	}
-	_, err = file.Seek(0, 0)
-
-	writer := bufio.NewWriter(file)
+	defer file.Close()
 
 	bytesLen := make([]byte, 8)
 	binary.LittleEndian.PutUint64(bytesLen, len)
-	_, err = writer.Write(bytesLen)
-
-	if err != nil {
-		log.Println(err)
+	if _, err = writeRaw(file, 0, bytesLen); err != nil {
+		return err
 	}
-
-	err = writer.Flush()
-	if err != nil {
-		return
-	}
-
-	err = file.Close()
+	return syncAndClose(file)
 }
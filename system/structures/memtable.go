@@ -1,30 +1,92 @@
 package structures
 
+import "time"
+
 type MemoryTable struct {
-	skipList  SkipList
-	size      uint
-	threshold uint
-	maxSize   uint
+	skipList        SkipList
+	size            uint
+	threshold       uint
+	maxSize         uint
+	rangeTombstones []RangeTombstone
 }
 
 func NewMemoryTable(height int, maxSize, threshold uint) *MemoryTable {
 	sl := CreateSkipList(height)
-	mt := MemoryTable{*sl, 0, threshold, maxSize}
+	mt := MemoryTable{*sl, 0, threshold, maxSize, nil}
 	return &mt
 }
 
 func (mt *MemoryTable) Insert(key string, value []byte, isTombstone bool) {
+	if node := mt.skipList.Retrieve(key); node != nil {
+		// Key is already in the table, so the newest write replaces it in place.
+		node.Value = value
+		node.Tombstone = isTombstone
+		node.Merge = false
+		node.Timestamp = time.Now().String()
+		return
+	}
 	mt.size++
 	mt.skipList.Insert(key, value, isTombstone)
 }
 
 func (mt *MemoryTable) Modify(key string, value []byte, isTombstone bool) {
+	mt.Insert(key, value, isTombstone)
+}
+
+// Merge records a merge operand for key. Operands on top of a value or tombstone that
+// is already in the table are folded right away, otherwise they are kept until a read
+// or compaction finds the base value.
+func (mt *MemoryTable) Merge(key string, operand []byte, operators MergeOperators) {
 	node := mt.skipList.Retrieve(key)
 	if node == nil {
-		mt.Insert(key, value, isTombstone)
+		mt.size++
+		node = mt.skipList.Insert(key, EncodeOperands([][]byte{operand}), false)
+		node.Merge = true
+		return
+	}
+	if node.Merge {
+		node.Value = EncodeOperands(append(DecodeOperands(node.Value), operand))
 	} else {
-		node.Value = value
+		var existing []byte
+		if !node.Tombstone {
+			existing = node.Value
+		}
+		node.Value = operators.Fold(existing, [][]byte{operand})
+		node.Tombstone = false
+	}
+	node.Timestamp = time.Now().String()
+}
+
+// DeleteRange deletes every key in [start, end). Keys already in the table become point
+// tombstones, and the range tombstone is kept to shadow older data in SSTables.
+func (mt *MemoryTable) DeleteRange(start, end string) {
+	timestamp := time.Now().String()
+	for _, node := range mt.Range(start, end) {
+		node.Tombstone = true
+		node.Merge = false
+		node.Timestamp = timestamp
 	}
+	mt.rangeTombstones = append(mt.rangeTombstones, RangeTombstone{start, end, timestamp})
+}
+
+// Covered reports whether a range tombstone in the table deletes key.
+func (mt *MemoryTable) Covered(key string) bool {
+	return coveredByAny(key, mt.rangeTombstones)
+}
+
+func (mt *MemoryTable) RangeTombstones() []RangeTombstone {
+	return mt.rangeTombstones
+}
+
+// Range returns the table nodes with keys in [start, end), in key order. An empty end has no bound.
+func (mt *MemoryTable) Range(start, end string) []*Element {
+	nodes := make([]*Element, 0)
+	for node := mt.skipList.head.NextNodes[0]; node != nil && (end == "" || node.Key < end); node = node.NextNodes[0] {
+		if node.Key >= start {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
 }
 
 func (mt *MemoryTable) Erase(key string) bool {
@@ -32,12 +94,16 @@ func (mt *MemoryTable) Erase(key string) bool {
 	return removedElement != nil
 }
 
-func (mt *MemoryTable) Lookup(key string) (found, deleted bool, value []byte) {
+// Lookup returns the value stored for key. If the table only holds merge operands
+// for the key, they are returned in operands and value is nil.
+func (mt *MemoryTable) Lookup(key string) (found, deleted bool, value []byte, operands [][]byte) {
 	node := mt.skipList.Retrieve(key)
 	if node == nil {
 		found, deleted, value = false, false, nil
 	} else if node.Tombstone {
 		found, deleted, value = true, true, nil
+	} else if node.Merge {
+		found, deleted, operands = true, false, DecodeOperands(node.Value)
 	} else {
 		found, deleted, value = true, false, node.Value
 	}
@@ -48,9 +114,13 @@ func (mt *MemoryTable) CurrentSize() uint {
 	return mt.size
 }
 
-func (mt *MemoryTable) PerformFlush() {
-	filename := findSSTableFilename("1")
-	NewSSTable(*mt, filename)
+func (mt *MemoryTable) PerformFlush(directory string, options TableOptions) error {
+	filename, err := findSSTableFilename(directory, "1")
+	if err != nil {
+		return err
+	}
+	_, err = NewSSTable(*mt, directory, filename, options)
+	return err
 }
 
 func (mt *MemoryTable) ShouldFlush() bool {
@@ -0,0 +1,345 @@
+package structures
+
+import "encoding/binary"
+
+// MergeOperator folds a merge operand into the existing value of a key.
+// Existing is nil when the key has no value (never written or deleted).
+// ValueType is the type of the values the operator works on and produces.
+type MergeOperator interface {
+	Name() string
+	ValueType() ValueType
+	FullMerge(existing []byte, operand []byte) []byte
+}
+
+// MergeOperators are the operators that fold operands on reads and compaction, by name.
+// Every engine has its own, so the parameters of the sketches and filters it creates are
+// those of its configuration. They are not changed after the engine is opened.
+type MergeOperators map[string]MergeOperator
+
+// NewMergeOperators returns the counter and append operators and the operators of
+// sketches and filters with default parameters.
+func NewMergeOperators() MergeOperators {
+	operators := make(MergeOperators)
+	operators.Register(CounterOperator{})
+	operators.Register(AppendOperator{})
+	operators.Register(NewHLLAddOperator(4))
+	operators.Register(NewCMSAddOperator(0.1, 0.01))
+	operators.Register(NewBloomAddOperator(1000, 0.01))
+	operators.Register(NewCuckooAddOperator(1000))
+	operators.Register(NewCuckooDeleteOperator(1000))
+	return operators
+}
+
+// Register makes an operator available to reads and compaction.
+// Registering an operator with an existing name replaces the old one.
+func (operators MergeOperators) Register(operator MergeOperator) {
+	operators[operator.Name()] = operator
+}
+
+// NewOperand encodes a merge operand as [name length][name][payload],
+// so every operand knows which operator has to fold it.
+func NewOperand(operator string, payload []byte) []byte {
+	operand := make([]byte, 0, 1+len(operator)+len(payload))
+	operand = append(operand, byte(len(operator)))
+	operand = append(operand, operator...)
+	operand = append(operand, payload...)
+	return operand
+}
+
+func splitOperand(operand []byte) (name string, payload []byte, ok bool) {
+	if len(operand) == 0 || len(operand) < 1+int(operand[0]) {
+		return "", nil, false
+	}
+	nameLen := int(operand[0])
+	return string(operand[1 : 1+nameLen]), operand[1+nameLen:], true
+}
+
+// EncodeOperands serializes a list of operands, oldest first.
+func EncodeOperands(operands [][]byte) []byte {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, uint64(len(operands)))
+	for _, operand := range operands {
+		lenBytes := make([]byte, 8)
+		binary.LittleEndian.PutUint64(lenBytes, uint64(len(operand)))
+		data = append(data, lenBytes...)
+		data = append(data, operand...)
+	}
+	return data
+}
+
+// DecodeOperands is the inverse of EncodeOperands. Truncated input yields the operands read so far.
+func DecodeOperands(data []byte) [][]byte {
+	if len(data) < 8 {
+		return nil
+	}
+	count := binary.LittleEndian.Uint64(data)
+	data = data[8:]
+	operands := make([][]byte, 0, count)
+	for i := uint64(0); i < count && len(data) >= 8; i++ {
+		operandLen := binary.LittleEndian.Uint64(data)
+		data = data[8:]
+		if uint64(len(data)) < operandLen {
+			break
+		}
+		operands = append(operands, data[:operandLen])
+		data = data[operandLen:]
+	}
+	return operands
+}
+
+// Fold applies operands, oldest first, on top of existing. Both existing and the result
+// are stored values with a type tag (see valueType.go). Operands of unknown operators,
+// and operands whose operator does not work on the type of the value, are skipped.
+func (operators MergeOperators) Fold(existing []byte, operands [][]byte) []byte {
+	for _, operand := range operands {
+		name, payload, ok := splitOperand(operand)
+		if !ok {
+			continue
+		}
+		operator, ok := operators[name]
+		if !ok {
+			continue
+		}
+		var data []byte
+		if existing != nil {
+			var valueType ValueType
+			valueType, data = DecodeValue(existing)
+			if !accepts(operator, valueType) {
+				continue
+			}
+			if valueType == TypeRaw && operator.ValueType() == TypeCounter {
+				value, ok := DecodeCounter(TypeRaw, data)
+				if !ok {
+					continue
+				}
+				data = EncodeCounter(value)
+			}
+		}
+		existing = EncodeValue(operator.ValueType(), operator.FullMerge(data, payload))
+	}
+	return existing
+}
+
+// accepts reports whether operator can fold into a value of the given type.
+// Counters also accept raw values that hold decimal strings, which Fold converts.
+func accepts(operator MergeOperator, valueType ValueType) bool {
+	return valueType == operator.ValueType() || (valueType == TypeRaw && operator.ValueType() == TypeCounter)
+}
+
+// CounterOperator adds signed 64-bit deltas to a counter (see counter.go).
+// A sum that overflows leaves the counter unchanged.
+type CounterOperator struct{}
+
+func (CounterOperator) Name() string {
+	return "int64-add"
+}
+
+func (CounterOperator) ValueType() ValueType {
+	return TypeCounter
+}
+
+func (CounterOperator) FullMerge(existing []byte, operand []byte) []byte {
+	current, _ := DecodeCounter(TypeCounter, existing)
+	delta, _ := DecodeCounter(TypeCounter, operand)
+	sum, _ := AddCounter(current, delta)
+	return EncodeCounter(sum)
+}
+
+// CounterOperand returns an operand that adds delta to a counter.
+func CounterOperand(delta int64) []byte {
+	return NewOperand(CounterOperator{}.Name(), EncodeCounter(delta))
+}
+
+// AppendOperator appends the operand to the end of the existing value.
+type AppendOperator struct{}
+
+func (AppendOperator) Name() string {
+	return "append"
+}
+
+func (AppendOperator) ValueType() ValueType {
+	return TypeRaw
+}
+
+func (AppendOperator) FullMerge(existing []byte, operand []byte) []byte {
+	merged := make([]byte, 0, len(existing)+len(operand))
+	merged = append(merged, existing...)
+	return append(merged, operand...)
+}
+
+// AppendOperand returns an operand that appends suffix to a value.
+func AppendOperand(suffix []byte) []byte {
+	return NewOperand(AppendOperator{}.Name(), suffix)
+}
+
+// HLLAddOperator adds an item to a serialized HyperLogLog, creating it if needed.
+type HLLAddOperator struct {
+	precision uint8
+}
+
+func NewHLLAddOperator(precision uint8) HLLAddOperator {
+	return HLLAddOperator{precision: precision}
+}
+
+func (HLLAddOperator) Name() string {
+	return "hll-add"
+}
+
+func (HLLAddOperator) ValueType() ValueType {
+	return TypeHLL
+}
+
+func (op HLLAddOperator) FullMerge(existing []byte, operand []byte) []byte {
+	var hll *HyperLogLog
+	if existing != nil {
+		hll, _ = DeserializeHLL(existing)
+	}
+	if hll == nil {
+		hll = CreateHyperLogLog(op.precision)
+	}
+	hll.Add(string(operand))
+	return hll.SerializeHLL()
+}
+
+// HLLAddOperand returns an operand that adds item to a HyperLogLog.
+func HLLAddOperand(item string) []byte {
+	return NewOperand(HLLAddOperator{}.Name(), []byte(item))
+}
+
+// CMSAddOperator adds an item to a serialized CountMinSketch, creating it if needed.
+type CMSAddOperator struct {
+	epsilon float64
+	delta   float64
+}
+
+func NewCMSAddOperator(epsilon, delta float64) CMSAddOperator {
+	return CMSAddOperator{epsilon: epsilon, delta: delta}
+}
+
+func (CMSAddOperator) Name() string {
+	return "cms-add"
+}
+
+func (CMSAddOperator) ValueType() ValueType {
+	return TypeCMS
+}
+
+func (op CMSAddOperator) FullMerge(existing []byte, operand []byte) []byte {
+	var cms *CountMinSketch
+	if existing != nil {
+		cms, _ = DeserializeCMS(existing)
+	}
+	if cms == nil {
+		cms = CreateCountMinSketch(op.epsilon, op.delta)
+	}
+	if err := cms.Add(string(operand)); err != nil {
+		return existing
+	}
+	return cms.SerializeCMS()
+}
+
+// CMSAddOperand returns an operand that adds item to a CountMinSketch.
+func CMSAddOperand(item string) []byte {
+	return NewOperand(CMSAddOperator{}.Name(), []byte(item))
+}
+
+// BloomAddOperator adds an item to a serialized BloomFilter, creating it if needed.
+type BloomAddOperator struct {
+	capacity      uint
+	falsePositive float64
+}
+
+func NewBloomAddOperator(capacity uint, falsePositive float64) BloomAddOperator {
+	return BloomAddOperator{capacity: capacity, falsePositive: falsePositive}
+}
+
+func (BloomAddOperator) Name() string {
+	return "bloom-add"
+}
+
+func (BloomAddOperator) ValueType() ValueType {
+	return TypeBloomFilter
+}
+
+func (op BloomAddOperator) FullMerge(existing []byte, operand []byte) []byte {
+	var bf *BloomFilter
+	if existing != nil {
+		bf, _ = DeserializeBF(existing)
+	}
+	if bf == nil {
+		bf = CreateBF(op.capacity, op.falsePositive)
+	}
+	bf.Add(Element{Key: string(operand)})
+	return bf.SerializeBF()
+}
+
+// BloomAddOperand returns an operand that adds item to a BloomFilter.
+func BloomAddOperand(item string) []byte {
+	return NewOperand(BloomAddOperator{}.Name(), []byte(item))
+}
+
+// CuckooAddOperator adds an item to a serialized CuckooFilter, creating it if needed.
+type CuckooAddOperator struct {
+	capacity uint
+}
+
+func NewCuckooAddOperator(capacity uint) CuckooAddOperator {
+	return CuckooAddOperator{capacity: capacity}
+}
+
+func (CuckooAddOperator) Name() string {
+	return "cuckoo-add"
+}
+
+func (CuckooAddOperator) ValueType() ValueType {
+	return TypeCuckooFilter
+}
+
+func (op CuckooAddOperator) FullMerge(existing []byte, operand []byte) []byte {
+	cf := cuckooFilterOrNew(existing, op.capacity)
+	cf.Add(string(operand))
+	return cf.SerializeCF()
+}
+
+// CuckooAddOperand returns an operand that adds item to a CuckooFilter.
+func CuckooAddOperand(item string) []byte {
+	return NewOperand(CuckooAddOperator{}.Name(), []byte(item))
+}
+
+// CuckooDeleteOperator removes an item from a serialized CuckooFilter, creating it if needed.
+type CuckooDeleteOperator struct {
+	capacity uint
+}
+
+func NewCuckooDeleteOperator(capacity uint) CuckooDeleteOperator {
+	return CuckooDeleteOperator{capacity: capacity}
+}
+
+func (CuckooDeleteOperator) Name() string {
+	return "cuckoo-delete"
+}
+
+func (CuckooDeleteOperator) ValueType() ValueType {
+	return TypeCuckooFilter
+}
+
+func (op CuckooDeleteOperator) FullMerge(existing []byte, operand []byte) []byte {
+	cf := cuckooFilterOrNew(existing, op.capacity)
+	cf.Delete(string(operand))
+	return cf.SerializeCF()
+}
+
+// CuckooDeleteOperand returns an operand that removes item from a CuckooFilter.
+func CuckooDeleteOperand(item string) []byte {
+	return NewOperand(CuckooDeleteOperator{}.Name(), []byte(item))
+}
+
+// cuckooFilterOrNew decodes an existing filter. A missing or unreadable one is replaced by an empty filter.
+func cuckooFilterOrNew(existing []byte, capacity uint) *CuckooFilter {
+	if existing != nil {
+		if cf, err := DeserializeCF(existing); err == nil {
+			return cf
+		}
+	}
+	return CreateCuckooFilter(capacity)
+}
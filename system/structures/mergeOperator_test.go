@@ -0,0 +1,65 @@
+package structures
+
+import (
+	"testing"
+)
+
+func TestOperandsRoundTrip(t *testing.T) {
+	operands := [][]byte{CounterOperand(1), AppendOperand([]byte("x")), {}}
+	decoded := DecodeOperands(EncodeOperands(operands))
+	if len(decoded) != len(operands) {
+		t.Fatalf("decoded %d operands, want %d", len(decoded), len(operands))
+	}
+	for i := range operands {
+		if string(decoded[i]) != string(operands[i]) {
+			t.Errorf("operand %d is %q, want %q", i, decoded[i], operands[i])
+		}
+	}
+	if cut := DecodeOperands(EncodeOperands(operands)[:12]); len(cut) != 0 {
+		t.Errorf("truncated operands decode to %q", cut)
+	}
+}
+
+func TestFold(t *testing.T) {
+	operators := NewMergeOperators()
+	value := operators.Fold(nil, [][]byte{CounterOperand(2), CounterOperand(-5)})
+	if valueType, data := DecodeValue(value); valueType != TypeCounter {
+		t.Errorf("folded counter has type %v", valueType)
+	} else if counter, _ := DecodeCounter(valueType, data); counter != -3 {
+		t.Errorf("folded counter is %d, want -3", counter)
+	}
+
+	raw := EncodeValue(TypeRaw, []byte("a"))
+	unknown := NewOperand("unknown", []byte("z"))
+	value = operators.Fold(raw, [][]byte{AppendOperand([]byte("b")), unknown, AppendOperand([]byte("c"))})
+	if valueType, data := DecodeValue(value); valueType != TypeRaw || string(data) != "abc" {
+		t.Errorf("folded value is %v %q, want raw abc", valueType, data)
+	}
+	// Operands of an operator for another type leave the value as it is.
+	if value = operators.Fold(raw, [][]byte{HLLAddOperand("x")}); string(value) != string(raw) {
+		t.Errorf("HLL operand changed a raw value to %q", value)
+	}
+}
+
+func TestCombineRecords(t *testing.T) {
+	operators := NewMergeOperators()
+	newer := string(EncodeOperands([][]byte{AppendOperand([]byte("b"))}))
+	older := string(EncodeOperands([][]byte{AppendOperand([]byte("a"))}))
+
+	kind, value := combineRecords(operators, RecordMerge, newer, RecordMerge, older)
+	if operands := DecodeOperands([]byte(value)); kind != RecordMerge || len(operands) != 2 ||
+		string(operators.Fold(nil, operands)) != string(EncodeValue(TypeRaw, []byte("ab"))) {
+		t.Errorf("merge over merge gives %d %q", kind, value)
+	}
+	kind, value = combineRecords(operators, RecordMerge, newer, RecordValue, string(EncodeValue(TypeRaw, []byte("x"))))
+	if kind != RecordValue || value != string(EncodeValue(TypeRaw, []byte("xb"))) {
+		t.Errorf("merge over a value gives %d %q", kind, value)
+	}
+	kind, value = combineRecords(operators, RecordMerge, newer, RecordTombstone, "deleted")
+	if kind != RecordValue || value != string(EncodeValue(TypeRaw, []byte("b"))) {
+		t.Errorf("merge over a tombstone gives %d %q", kind, value)
+	}
+	if kind, value = combineRecords(operators, RecordTombstone, "", RecordValue, "x"); kind != RecordTombstone {
+		t.Errorf("tombstone over a value gives %d %q", kind, value)
+	}
+}
@@ -1,16 +1,70 @@
 package structures
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
-	"log"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 )
 
+// HashFunction is the hash used for the nodes of Merkle trees.
+type HashFunction byte
+
+const (
+	SHA1 HashFunction = iota
+	SHA256
+)
+
+// ParseHashFunction returns the hash function with the given name. An empty name means SHA-1.
+func ParseHashFunction(name string) (HashFunction, error) {
+	switch strings.ToLower(name) {
+	case "", "sha1", "sha-1":
+		return SHA1, nil
+	case "sha256", "sha-256":
+		return SHA256, nil
+	}
+	return SHA1, fmt.Errorf("unknown hash function %q", name)
+}
+
+// Size returns the length of the hashes in bytes.
+func (h HashFunction) Size() int {
+	if h == SHA256 {
+		return sha256.Size
+	}
+	return sha1.Size
+}
+
+func (h HashFunction) String() string {
+	if h == SHA256 {
+		return "SHA-256"
+	}
+	return "SHA-1"
+}
+
+// hashFunctionOfSize returns the hash function whose hashes are size bytes long.
+func hashFunctionOfSize(size int) (HashFunction, bool) {
+	for _, h := range []HashFunction{SHA1, SHA256} {
+		if h.Size() == size {
+			return h, true
+		}
+	}
+	return SHA1, false
+}
+
 // MerkleRoot represents the root of the Merkle tree.
 type MerkleRoot struct {
 	TopNode *MerkleNode
+	Hash    HashFunction
+	keys    []string // keys of the leaves, sorted
 }
 
 func (mr *MerkleRoot) String() string {
@@ -19,18 +73,23 @@ func (mr *MerkleRoot) String() string {
 
 // MerkleNode represents a node in the Merkle tree.
 type MerkleNode struct {
-	HashValue [20]byte
+	HashValue []byte
 	Left      *MerkleNode
 	Right     *MerkleNode
 }
 
 func (n *MerkleNode) String() string {
-	return hex.EncodeToString(n.HashValue[:])
+	return hex.EncodeToString(n.HashValue)
 }
 
-// CalculateHash calculates the SHA-1 hash of the given data.
-func CalculateHash(data []byte) [20]byte {
-	return sha1.Sum(data)
+// CalculateHash calculates the SHA-1 or SHA-256 hash of the given data.
+func CalculateHash(data []byte, hash HashFunction) []byte {
+	if hash == SHA256 {
+		sum := sha256.Sum256(data)
+		return sum[:]
+	}
+	sum := sha1.Sum(data)
+	return sum[:]
 }
 
 // ConvertStringsToBytes converts an array of strings to a 2D byte slice.
@@ -42,29 +101,60 @@ func ConvertStringsToBytes(strings []string) [][]byte {
 	return byteData
 }
 
-// BuildMerkleTree is the entry point for creating the Merkle tree.
-func BuildMerkleTree(dataKeys [][]byte, filePath string) *MerkleRoot {
-	leafNodes := CreateLeafNodes(dataKeys)
-	rootNode := CreateAllNodes(leafNodes)
+// MerkleLeaf returns the leaf data of one record, [key len][key][kind][timestamp][value].
+// The kind tells a tombstone from a live record that holds the same value. Leaves are in
+// key order, so the trees of two tables with the same records are equal.
+func MerkleLeaf(key string, kind byte, timestamp, value string) []byte {
+	leaf := make([]byte, 8, 8+len(key)+1+19+len(value))
+	binary.LittleEndian.PutUint64(leaf, uint64(len(key)))
+	leaf = append(leaf, key...)
+	leaf = append(leaf, kind)
+	// The timestamp takes 19 bytes, as in the data file.
+	timestampBytes := make([]byte, 19)
+	copy(timestampBytes, timestamp)
+	leaf = append(leaf, timestampBytes...)
+	return append(leaf, value...)
+}
+
+// keyValueLeaf returns the leaf data of tables written before the kind and timestamp of
+// records were part of the leaves, [key len][key][value].
+func keyValueLeaf(key, value string) []byte {
+	leaf := make([]byte, 8, 8+len(key)+len(value))
+	binary.LittleEndian.PutUint64(leaf, uint64(len(key)))
+	leaf = append(leaf, key...)
+	return append(leaf, value...)
+}
+
+// NewMerkleTree builds the tree of a table from the keys of its records, sorted, and their leaf data.
+func NewMerkleTree(keys []string, leaves [][]byte, hash HashFunction) *MerkleRoot {
+	rootNode := CreateAllNodes(CreateLeafNodes(leaves, hash), hash)
+	return &MerkleRoot{TopNode: rootNode, Hash: hash, keys: keys}
+}
 
-	root := MerkleRoot{TopNode: rootNode}
-	newPath := strings.Replace(filePath, "Data.db", "Metadata.txt", 1)
-	newPath = "./system/data/metadata/" + newPath
-	WriteTreeToFile(rootNode, newPath)
-	return &root
+// BuildMerkleTree is the entry point for creating the Merkle tree.
+// The tree of the data file dataFilename is written to the metadata directory of directory.
+func BuildMerkleTree(keys []string, leaves [][]byte, directory, dataFilename string, hash HashFunction) (*MerkleRoot, error) {
+	root := NewMerkleTree(keys, leaves, hash)
+	if err := WriteTreeToFile(root.TopNode, metadataFilename(directory, dataFilename)); err != nil {
+		return nil, err
+	}
+	return root, nil
 }
 
 // CreateLeafNodes forms leaf nodes of the tree.
-func CreateLeafNodes(data [][]byte) []*MerkleNode {
+func CreateLeafNodes(data [][]byte, hash HashFunction) []*MerkleNode {
 	leaves := make([]*MerkleNode, len(data))
 	for i, datum := range data {
-		leaves[i] = &MerkleNode{HashValue: CalculateHash(datum), Left: nil, Right: nil}
+		leaves[i] = &MerkleNode{HashValue: CalculateHash(datum, hash), Left: nil, Right: nil}
 	}
 	return leaves
 }
 
 // CreateAllNodes creates all levels of the tree from leaves to root.
-func CreateAllNodes(leafNodes []*MerkleNode) *MerkleNode {
+func CreateAllNodes(leafNodes []*MerkleNode, hash HashFunction) *MerkleNode {
+	if len(leafNodes) == 0 {
+		return emptyNode(hash)
+	}
 	levelNodes := leafNodes
 
 	for len(levelNodes) > 1 {
@@ -72,8 +162,8 @@ func CreateAllNodes(leafNodes []*MerkleNode) *MerkleNode {
 
 		for i := 0; i < len(levelNodes); i += 2 {
 			node1 := levelNodes[i]
-			node2 := getOrCreateEmptyNode(levelNodes, i+1)
-			parent := createParentNode(node1, node2)
+			node2 := getOrCreateEmptyNode(levelNodes, i+1, hash)
+			parent := createParentNode(node1, node2, hash)
 			parentNodes = append(parentNodes, parent)
 		}
 
@@ -83,56 +173,232 @@ func CreateAllNodes(leafNodes []*MerkleNode) *MerkleNode {
 	return levelNodes[0]
 }
 
-func createParentNode(node1, node2 *MerkleNode) *MerkleNode {
-	node1Data := node1.HashValue[:]
-	node2Data := node2.HashValue[:]
-	newNodeBytes := append(node1Data, node2Data...)
-	newNode := &MerkleNode{HashValue: CalculateHash(newNodeBytes), Left: node1, Right: node2}
+func createParentNode(node1, node2 *MerkleNode, hash HashFunction) *MerkleNode {
+	newNodeBytes := make([]byte, 0, len(node1.HashValue)+len(node2.HashValue))
+	newNodeBytes = append(newNodeBytes, node1.HashValue...)
+	newNodeBytes = append(newNodeBytes, node2.HashValue...)
+	newNode := &MerkleNode{HashValue: CalculateHash(newNodeBytes, hash), Left: node1, Right: node2}
 	return newNode
 }
-func getOrCreateEmptyNode(nodes []*MerkleNode, index int) *MerkleNode {
+func getOrCreateEmptyNode(nodes []*MerkleNode, index int, hash HashFunction) *MerkleNode {
 	if index < len(nodes) {
 		return nodes[index]
 	}
-	return &MerkleNode{HashValue: [20]byte{}, Left: nil, Right: nil}
+	return emptyNode(hash)
 }
 
-func WriteTreeToFile(root *MerkleNode, filePath string) {
-	if err := createAndWriteToFile(root, filePath); err != nil {
-		log.Fatal(err)
-	}
+// emptyNode pads a level with an odd number of nodes. Its hash is all zeros.
+func emptyNode(hash HashFunction) *MerkleNode {
+	return &MerkleNode{HashValue: make([]byte, hash.Size()), Left: nil, Right: nil}
 }
-func createAndWriteToFile(root *MerkleNode, filePath string) error {
-	newFile, err := os.Create(filePath)
+
+func WriteTreeToFile(root *MerkleNode, filePath string) error {
+	file, err := os.Create(filePath)
 	if err != nil {
 		return err
 	}
-	defer newFile.Close()
+	defer file.Close()
 
-	file, err := os.OpenFile(filePath, os.O_WRONLY, 0444)
-	if err != nil {
+	writer := bufio.NewWriter(file)
+	writeNodesToFile(root, writer)
+	if err = writer.Flush(); err != nil {
 		return err
 	}
+	return syncAndClose(file)
+}
+
+func writeNodesToFile(root *MerkleNode, writer *bufio.Writer) {
+	for _, node := range breadthFirst(root) {
+		_, _ = writer.WriteString(node.String() + "\n")
+	}
+}
+
+// breadthFirst returns the nodes of the tree in the order they are written to the metadata file.
+func breadthFirst(root *MerkleNode) []*MerkleNode {
+	nodes := []*MerkleNode{root}
+	for i := 0; i < len(nodes); i++ {
+		if nodes[i].Left != nil {
+			nodes = append(nodes, nodes[i].Left)
+		}
+		if nodes[i].Right != nil {
+			nodes = append(nodes, nodes[i].Right)
+		}
+	}
+	return nodes
+}
+
+// ReadTreeFromFile reads the node hashes of a metadata file, in breadth-first order.
+// All hashes have the length of the hash function the tree was built with.
+func ReadTreeFromFile(filePath string) ([][]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
 	defer file.Close()
 
-	writeNodesToFile(root, file)
-	return nil
+	hashes := make([][]byte, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		hash, err := hex.DecodeString(scanner.Text())
+		if _, ok := hashFunctionOfSize(len(hash)); err != nil || !ok ||
+			len(hashes) > 0 && len(hash) != len(hashes[0]) {
+			return nil, corrupted(filePath, fmt.Errorf("invalid hash on line %d", len(hashes)+1))
+		}
+		hashes = append(hashes, hash)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return hashes, nil
 }
-func writeNodesToFile(root *MerkleNode, file *os.File) {
-	queue := []*MerkleNode{root}
 
-	for len(queue) != 0 {
-		node := queue[0]
-		queue = queue[1:]
-		_, _ = file.WriteString(node.String() + "\n")
+// metadataFilename returns the file with the Merkle tree of the table with the given data file.
+func metadataFilename(directory, dataFilename string) string {
+	return MetadataDirectory(directory) + strings.Replace(filepath.Base(dataFilename), "Data.db", "Metadata.txt", 1)
+}
 
-		if node.Left != nil {
-			queue = append(queue, node.Left)
+// MerkleProof is the path from the leaf of one record up to the root of its table's tree.
+type MerkleProof struct {
+	Hash      HashFunction
+	Index     int    // leaf of the record; its bits tell on which side each sibling is
+	Timestamp string // timestamp of the record
+	// Siblings holds the hash of the sibling on every level, from the leaf up.
+	Siblings [][]byte
+}
+
+// Proof returns the proof for the record with key, or false if the tree has no such leaf.
+func (mr *MerkleRoot) Proof(key string) (*MerkleProof, bool) {
+	index := sort.SearchStrings(mr.keys, key)
+	if index == len(mr.keys) || mr.keys[index] != key {
+		return nil, false
+	}
+
+	// Leaf i of a level is child i%2 of node i/2 on the level above it.
+	height := 0
+	for 1<<height < len(mr.keys) {
+		height++
+	}
+	siblings := make([][]byte, height)
+	node := mr.TopNode
+	for level := height - 1; level >= 0; level-- {
+		if index>>level&1 == 0 {
+			siblings[level] = node.Right.HashValue
+			node = node.Left
+		} else {
+			siblings[level] = node.Left.HashValue
+			node = node.Right
 		}
-		if node.Right != nil {
-			queue = append(queue, node.Right)
+	}
+	return &MerkleProof{Hash: mr.Hash, Index: index, Siblings: siblings}, true
+}
+
+// VerifyProof reports whether a live record of key with value is a leaf of the tree with the
+// given root. The leaf of a tombstone that kept the value does not verify.
+func VerifyProof(root []byte, key string, value []byte, proof *MerkleProof) bool {
+	hash := CalculateHash(MerkleLeaf(key, RecordValue, proof.Timestamp, string(value)), proof.Hash)
+	for level, sibling := range proof.Siblings {
+		data := make([]byte, 0, len(hash)+len(sibling))
+		if proof.Index>>level&1 == 0 {
+			data = append(append(data, hash...), sibling...)
+		} else {
+			data = append(append(data, sibling...), hash...)
+		}
+		hash = CalculateHash(data, proof.Hash)
+	}
+	return bytes.Equal(hash, root)
+}
+
+// ErrNotProvable is returned for keys whose value is not held by a single SSTable record.
+var ErrNotProvable = errors.New("value cannot be proven with a Merkle tree")
+
+// ProveRecord finds the newest record of key in the SSTables of the keyspace stored in
+// directory, like SearchThroughSSTables, and returns its value with the proof of it and the
+// root of its table's tree. A key whose newest record holds merge operands returns ErrNotProvable.
+func ProveRecord(directory, key string, maxLevels int) (found bool, value, root []byte, proof *MerkleProof, err error) {
+	for levelNum := 1; levelNum <= maxLevels; levelNum++ {
+		count, err := TableCount(directory, levelNum)
+		if err != nil {
+			return false, nil, nil, nil, err
+		}
+		for filenameNum := count; filenameNum > 0; filenameNum-- {
+			table, err := readSSTable(directory, strconv.Itoa(filenameNum), strconv.Itoa(levelNum))
+			if err != nil {
+				return false, nil, nil, nil, err
+			}
+			ok, data, timestamp, kind, err := table.QueryRecord(key, nil)
+			if err != nil {
+				return false, nil, nil, nil, err
+			}
+			if !ok {
+				rangeTombstones, err := readRangeTombstones(table.rangeDelFilename)
+				if err != nil {
+					return false, nil, nil, nil, err
+				}
+				if coveredByAny(key, rangeTombstones) {
+					return false, nil, nil, nil, nil
+				}
+				continue
+			}
+			switch kind {
+			case RecordTombstone:
+				return false, nil, nil, nil, nil
+			case RecordMerge:
+				return false, nil, nil, nil, ErrNotProvable
+			case RecordQuarantined:
+				return false, nil, nil, nil, quarantinedKeyError(key, string(data))
+			}
+
+			tree, err := readMerkleTree(directory, table.dataFilename)
+			if err != nil {
+				return false, nil, nil, nil, err
+			}
+			proof, _ = tree.Proof(key)
+			proof.Timestamp = timestamp
+			return true, data, tree.TopNode.HashValue, proof, nil
+		}
+	}
+	return false, nil, nil, nil, nil
+}
+
+// readMerkleTree rebuilds the tree of a table from its data file and checks it against the
+// root in its metadata file.
+func readMerkleTree(directory, dataFilename string) (*MerkleRoot, error) {
+	filename := metadataFilename(directory, dataFilename)
+	stored, err := ReadTreeFromFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(stored) == 0 {
+		return nil, corrupted(filename, errors.New("no root"))
+	}
+	hash, _ := hashFunctionOfSize(len(stored[0]))
+
+	keys := make([]string, 0)
+	leaves := make([][]byte, 0)
+	keyValueLeaves := make([][]byte, 0)
+	values := make([][]byte, 0)
+	err = readRecords(dataFilename, func(_ uint64, _ uint32, timestamp string, kind byte, key, value string) {
+		keys = append(keys, key)
+		leaves = append(leaves, MerkleLeaf(key, kind, timestamp, value))
+		keyValueLeaves = append(keyValueLeaves, keyValueLeaf(key, value))
+		values = append(values, []byte(value))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tree := NewMerkleTree(keys, leaves, hash)
+	if !bytes.Equal(tree.TopNode.HashValue, stored[0]) {
+		// Older tables have trees over their keys and values, or over their values only,
+		// which cannot tell a tombstone from a live record.
+		for _, legacyLeaves := range [][][]byte{keyValueLeaves, values} {
+			if legacy := CreateAllNodes(CreateLeafNodes(legacyLeaves, hash), hash); bytes.Equal(legacy.HashValue, stored[0]) {
+				return nil, ErrNotProvable
+			}
 		}
+		return nil, corrupted(filename, errors.New("root does not match the data"))
 	}
+	return tree, nil
 }
 
 // PrintTree prints the tree in breadth-first order.
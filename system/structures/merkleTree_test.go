@@ -0,0 +1,81 @@
+package structures
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestProofOfTombstoneDoesNotVerify(t *testing.T) {
+	mt := NewMemoryTable(5, 100, 80)
+	mt.Insert("a", []byte("1"), false)
+	mt.Insert("b", []byte("2"), true)
+	directory := writeTestTable(t, mt)
+
+	found, value, root, proof, err := ProveRecord(directory, "a", 1)
+	if err != nil || !found {
+		t.Fatalf("proof of a: %v, %v", found, err)
+	}
+	if !VerifyProof(root, "a", value, proof) {
+		t.Error("the proof of a live record does not verify")
+	}
+	if VerifyProof(root, "a", []byte("2"), proof) {
+		t.Error("the proof verifies another value")
+	}
+
+	if found, _, _, _, err = ProveRecord(directory, "b", 1); err != nil || found {
+		t.Errorf("a tombstone was proven: %v, %v", found, err)
+	}
+	// The tombstone holds the old value, so only its kind tells it from a live record.
+	tree, err := readMerkleTree(directory, tableFiles(directory, 1, 1).dataFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, _ = tree.Proof("b")
+	proof.Timestamp = "2024-01-01 00:00:00"
+	if VerifyProof(tree.TopNode.HashValue, "b", []byte("2"), proof) {
+		t.Error("the leaf of a tombstone verifies as a live record")
+	}
+}
+
+func TestProofsOfEveryRecordVerify(t *testing.T) {
+	// Trees of these sizes have levels of odd length, which are padded with empty nodes.
+	for _, size := range []int{1, 2, 5, 8, 9} {
+		mt := NewMemoryTable(5, 100, 80)
+		for i := 0; i < size; i++ {
+			mt.Insert(strconv.Itoa(i), []byte("value"+strconv.Itoa(i)), false)
+		}
+		directory := writeTestTable(t, mt)
+		for i := 0; i < size; i++ {
+			key := strconv.Itoa(i)
+			found, value, root, proof, err := ProveRecord(directory, key, 1)
+			if err != nil || !found {
+				t.Fatalf("%d records: proof of %s: %v, %v", size, key, found, err)
+			}
+			if !VerifyProof(root, key, value, proof) {
+				t.Errorf("%d records: proof of %s does not verify", size, key)
+			}
+			if VerifyProof(root, key+"x", value, proof) {
+				t.Errorf("%d records: proof of %s verifies another key", size, key)
+			}
+			if len(proof.Siblings) > 0 {
+				proof.Siblings[0] = CalculateHash([]byte("tampered"), proof.Hash)
+				if VerifyProof(root, key, value, proof) {
+					t.Errorf("%d records: proof of %s verifies with a tampered sibling", size, key)
+				}
+			}
+		}
+		if found, _, _, _, err := ProveRecord(directory, "missing", 1); err != nil || found {
+			t.Errorf("%d records: missing key was proven: %v, %v", size, found, err)
+		}
+	}
+}
+
+func TestProofOfMergeOperandsIsNotProvable(t *testing.T) {
+	mt := NewMemoryTable(5, 100, 80)
+	mt.Merge("a", AppendOperand([]byte("1")), NewMergeOperators())
+	directory := writeTestTable(t, mt)
+	if _, _, _, _, err := ProveRecord(directory, "a", 1); !errors.Is(err, ErrNotProvable) {
+		t.Errorf("proof of merge operands gives %v", err)
+	}
+}
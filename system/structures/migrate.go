@@ -0,0 +1,73 @@
+package structures
+
+import "encoding/binary"
+
+// TagLegacyTables rewrites the tables of the keyspace stored in directory that were written
+// before values had type tags, so that every value, and the old value a tombstone keeps, is
+// tagged with valueType. Merge operands name their operator and are kept as they are. Tables whose
+// data file is in migrated are skipped, and done is called with the data file of every
+// table that was rewritten. The keyspace must not be in use.
+func TagLegacyTables(directory string, maxLevels int, valueType ValueType, options TableOptions,
+	migrated map[string]bool, done func(dataFilename string) error) error {
+	for levelNum := 1; levelNum <= maxLevels; levelNum++ {
+		count, err := TableCount(directory, levelNum)
+		if err != nil {
+			return err
+		}
+		for num := 1; num <= count; num++ {
+			table := tableFiles(directory, levelNum, num)
+			if migrated[table.dataFilename] {
+				continue
+			}
+			if err = tagLegacyTable(directory, table, valueType, options); err != nil {
+				return err
+			}
+			if err = done(table.dataFilename); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// tagLegacyTable writes the records of a table again with tagged values and rebuilds its
+// other files. A record with a bad checksum stops it, so that the new checksum does not
+// hide the corruption; such tables have to be repaired first.
+func tagLegacyTable(directory string, table *SSTable, valueType ValueType, options TableOptions) error {
+	records := make([]salvagedRecord, 0)
+	var crcErr error
+	err := readRecords(table.dataFilename, func(_ uint64, crc uint32, timestamp string, kind byte, key, value string) {
+		crcBytes := make([]byte, 4)
+		binary.LittleEndian.PutUint32(crcBytes, crc)
+		if crcErr == nil {
+			crcErr = checkCRC(table.dataFilename, crcBytes, key, value)
+		}
+		if kind != RecordMerge {
+			value = string(EncodeValue(valueType, []byte(value)))
+			binary.LittleEndian.PutUint32(crcBytes, CRC32([]byte(value)))
+		}
+		records = append(records, salvagedRecord{crc: crcBytes, timestamp: timestamp, kind: kind, key: key, value: value})
+	})
+	if err != nil {
+		return err
+	}
+	if crcErr != nil {
+		return crcErr
+	}
+	rangeTombstones, err := readRangeTombstones(table.rangeDelFilename)
+	if err != nil {
+		return err
+	}
+
+	if err = writeRecords(table.dataFilename, records); err != nil {
+		return err
+	}
+	keys := make([]string, len(records))
+	offsets := make([]uint, len(records))
+	leaves := make([][]byte, len(records))
+	for i, record := range records {
+		keys[i], offsets[i] = record.key, record.offset
+		leaves[i] = MerkleLeaf(record.key, record.kind, record.timestamp, record.value)
+	}
+	return table.writeAuxiliaryFiles(directory, keys, offsets, leaves, rangeTombstones, options)
+}
@@ -0,0 +1,76 @@
+package structures
+
+import "strings"
+
+// PrefixExtractor chooses the prefixes of a key that the filter of an SSTable holds besides
+// the key, so scans of a prefix can skip tables without keys that start with it. At most one
+// of the two fields is set; the zero value extracts nothing.
+type PrefixExtractor struct {
+	Length    int    // the first Length bytes of keys that are at least that long
+	Delimiter string // every prefix of a key that ends with Delimiter, e.g. "tenant:" and "tenant:42:"
+}
+
+// prefixes returns the prefixes extracted from key.
+func (pe PrefixExtractor) prefixes(key string) []string {
+	if pe.Length > 0 {
+		if len(key) < pe.Length {
+			return nil
+		}
+		return []string{key[:pe.Length]}
+	}
+	if pe.Delimiter == "" {
+		return nil
+	}
+	prefixes := make([]string, 0)
+	for i := 0; i+len(pe.Delimiter) <= len(key); i++ {
+		if strings.HasPrefix(key[i:], pe.Delimiter) {
+			prefixes = append(prefixes, key[:i+len(pe.Delimiter)])
+		}
+	}
+	return prefixes
+}
+
+// distinctPrefixes returns every prefix extracted from the keys once.
+func (pe PrefixExtractor) distinctPrefixes(keys []string) []string {
+	seen := make(map[string]bool)
+	prefixes := make([]string, 0)
+	for _, key := range keys {
+		for _, prefix := range pe.prefixes(key) {
+			if !seen[prefix] {
+				seen[prefix] = true
+				prefixes = append(prefixes, prefix)
+			}
+		}
+	}
+	return prefixes
+}
+
+// covering returns a prefix that is extracted from every key starting with prefix. It
+// returns false if there is none, because prefix is too short or has no delimiter.
+func (pe PrefixExtractor) covering(prefix string) (string, bool) {
+	if pe.Length > 0 {
+		if len(prefix) < pe.Length {
+			return "", false
+		}
+		return prefix[:pe.Length], true
+	}
+	prefixes := pe.prefixes(prefix)
+	if len(prefixes) == 0 {
+		return "", false
+	}
+	return prefixes[len(prefixes)-1], true
+}
+
+// PrefixEnd returns the smallest key greater than every key that starts with prefix, so the
+// keys with the prefix are those in [prefix, PrefixEnd(prefix)). It returns "" if there is no
+// such key, when prefix is empty or made only of 0xff bytes.
+func PrefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] != 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,74 @@
+package structures
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestPrefixes(t *testing.T) {
+	delimiter := PrefixExtractor{Delimiter: ":"}
+	if prefixes := delimiter.prefixes("tenant:42:user"); !reflect.DeepEqual(prefixes, []string{"tenant:", "tenant:42:"}) {
+		t.Errorf("prefixes are %q", prefixes)
+	}
+	if prefix, ok := delimiter.covering("tenant:4"); !ok || prefix != "tenant:" {
+		t.Errorf("covering prefix of tenant:4 is %q, %v", prefix, ok)
+	}
+	if _, ok := delimiter.covering("tenant"); ok {
+		t.Error("prefix without a delimiter is covered")
+	}
+
+	length := PrefixExtractor{Length: 3}
+	if prefixes := length.prefixes("ab"); len(prefixes) != 0 {
+		t.Errorf("short key has prefixes %q", prefixes)
+	}
+	if prefix, ok := length.covering("abcd"); !ok || prefix != "abc" {
+		t.Errorf("covering prefix of abcd is %q, %v", prefix, ok)
+	}
+	if prefixes := length.distinctPrefixes([]string{"abc1", "abc2", "abd"}); !reflect.DeepEqual(prefixes, []string{"abc", "abd"}) {
+		t.Errorf("distinct prefixes are %q", prefixes)
+	}
+	if prefixes := (PrefixExtractor{}).prefixes("abc"); len(prefixes) != 0 {
+		t.Errorf("zero extractor gives %q", prefixes)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for prefix, want := range map[string]string{"ab": "ac", "a\xff": "b", "\xff\xff": "", "": ""} {
+		if end := PrefixEnd(prefix); end != want {
+			t.Errorf("PrefixEnd(%q) = %q, want %q", prefix, end, want)
+		}
+	}
+}
+
+func TestPrefixScanSkipsTablesWithoutThePrefix(t *testing.T) {
+	mt := NewMemoryTable(5, 100, 80)
+	for _, key := range []string{"tenant:1:a", "tenant:1:b"} {
+		mt.Insert(key, EncodeValue(TypeRaw, []byte(key)), false)
+	}
+	directory := t.TempDir() + "/"
+	for _, dir := range []string{SSTableDirectory(directory), MetadataDirectory(directory)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mt.PerformFlush(directory, TableOptions{Prefix: PrefixExtractor{Delimiter: ":"}}); err != nil {
+		t.Fatal(err)
+	}
+	values, _, err := ScanSSTables(directory, "tenant:1:", PrefixEnd("tenant:1:"), "tenant:1:", 1, nil, nil)
+	if err != nil || len(values) != 2 {
+		t.Fatalf("scan of tenant:1: gives %v, %v", values, err)
+	}
+
+	// With its data file gone, the table can be scanned only if its filter rules the prefix out.
+	if err = os.Remove(tableFiles(directory, 1, 1).dataFilename); err != nil {
+		t.Fatal(err)
+	}
+	values, _, err = ScanSSTables(directory, "tenant:2:", PrefixEnd("tenant:2:"), "tenant:2:", 1, nil, nil)
+	if err != nil || len(values) != 0 {
+		t.Errorf("scan of tenant:2: read the table: %v, %v", values, err)
+	}
+	if _, _, err = ScanSSTables(directory, "tenant:1:", PrefixEnd("tenant:1:"), "tenant:1:", 1, nil, nil); err == nil {
+		t.Error("scan of tenant:1: did not read the table")
+	}
+}
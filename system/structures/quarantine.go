@@ -0,0 +1,245 @@
+package structures
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Quarantine describes a table that was moved to a quarantine directory.
+type Quarantine struct {
+	// Table is the data file of the table, relative to the directory that holds the
+	// quarantine directories of its keyspace. The markers left in its place name it.
+	Table string `json:"table"`
+	// Lost holds the keys that no marker stands for, because the table could not be read
+	// far enough to learn them. Reads of them fail until a forced repair releases them.
+	Lost []QuarantinedRange `json:"lost,omitempty"`
+}
+
+// Overlaps reports whether keys in [start, end) may be lost; an empty end has no bound.
+func (q Quarantine) Overlaps(start, end string) bool {
+	for _, keys := range q.Lost {
+		if keys.Overlaps(start, end) {
+			return true
+		}
+	}
+	return false
+}
+
+// QuarantinedRange holds the keys in [Start, End); an empty End has no bound.
+type QuarantinedRange struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// Contains reports whether key is in the range.
+func (qr QuarantinedRange) Contains(key string) bool {
+	return key >= qr.Start && (qr.End == "" || key < qr.End)
+}
+
+// Overlaps reports whether the range has keys in [start, end); an empty end has no bound.
+func (qr QuarantinedRange) Overlaps(start, end string) bool {
+	return (end == "" || qr.Start < end) && (qr.End == "" || start < qr.End)
+}
+
+// quarantineMarker stands in the place of the record of a key in a quarantined table until
+// a repair restores that record. Compaction treats it like the record: it hides older
+// versions from reads, but keeps the older version it was written over and the merge
+// operands written over it since, so the restored record is combined with both. Reads of
+// a marker fail with ErrCorruption.
+type quarantineMarker struct {
+	table    string
+	hasBase  bool
+	baseKind byte
+	base     string
+	operands [][]byte
+}
+
+// encode returns the value of the marker record: the table, then the base as
+// [has base][kind][value], then the operands.
+func (m quarantineMarker) encode() string {
+	base := []byte{0, RecordValue}
+	if m.hasBase {
+		base = []byte{1, m.baseKind}
+	}
+	parts := [][]byte{[]byte(m.table), append(base, m.base...)}
+	return string(EncodeOperands(append(parts, m.operands...)))
+}
+
+func decodeQuarantineMarker(value string) (quarantineMarker, error) {
+	parts := DecodeOperands([]byte(value))
+	if len(parts) < 2 || len(parts[1]) < 2 {
+		return quarantineMarker{}, fmt.Errorf("%w: malformed quarantine marker", ErrCorruption)
+	}
+	return quarantineMarker{
+		table:    string(parts[0]),
+		hasBase:  parts[1][0] == 1,
+		baseKind: parts[1][1],
+		base:     string(parts[1][2:]),
+		operands: parts[2:],
+	}, nil
+}
+
+// markerOver returns the value of a marker that was written over an older version of its key.
+func markerOver(operators MergeOperators, value string, olderKind byte, olderValue string) string {
+	marker, err := decodeQuarantineMarker(value)
+	if err != nil {
+		return value
+	}
+	if marker.hasBase {
+		marker.baseKind, marker.base = combineRecords(operators, marker.baseKind, marker.base, olderKind, olderValue)
+	} else {
+		marker.hasBase, marker.baseKind, marker.base = true, olderKind, olderValue
+	}
+	return marker.encode()
+}
+
+// markerUnder returns the value of a marker that merge operands were written over.
+func markerUnder(value string, operands [][]byte) string {
+	marker, err := decodeQuarantineMarker(value)
+	if err != nil {
+		return value
+	}
+	marker.operands = append(marker.operands, operands...)
+	return marker.encode()
+}
+
+// restore returns the record that the marker stands for, given the salvaged record of the
+// quarantined table: that record over the base of the marker, under its operands.
+func (m quarantineMarker) restore(operators MergeOperators, kind byte, value string) (byte, string) {
+	if m.hasBase {
+		kind, value = combineRecords(operators, kind, value, m.baseKind, m.base)
+	}
+	if len(m.operands) > 0 {
+		kind, value = combineRecords(operators, RecordMerge, string(EncodeOperands(m.operands)), kind, value)
+	}
+	return kind, value
+}
+
+// quarantinedKeyError is returned by reads that reach the marker of a key.
+func quarantinedKeyError(key, value string) error {
+	marker, err := decodeQuarantineMarker(value)
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: key %q was in quarantined table %s", ErrCorruption, key, marker.table)
+}
+
+// QuarantineSSTable moves every file of the table with the given number on a level to
+// quarantineDirectory and writes a table with the same number in its place. That table
+// keeps the range tombstones of the quarantined one and holds a marker for each of its
+// keys, so the versions of the keys keep their place among the other tables until a repair
+// restores them. The markers get the newest timestamp of the quarantined records: newer
+// versions of their keys are written later, and older ones earlier.
+func QuarantineSSTable(directory string, level, num int, quarantineDirectory string,
+	options TableOptions) (Quarantine, error) {
+	table := tableFiles(directory, level, num)
+	quarantine := Quarantine{Table: filepath.Join(filepath.Base(quarantineDirectory),
+		filepath.Base(table.dataFilename))}
+	keys, timestamp, lost := quarantinedKeys(table)
+	if timestamp == "" && len(keys) > 0 {
+		// Without a readable record there is no timestamp for the markers.
+		lost = &QuarantinedRange{Start: keys[0], End: keys[len(keys)-1] + "\x00"}
+		keys = nil
+	}
+	rangeTombstones, err := readRangeTombstones(table.rangeDelFilename)
+	if err != nil {
+		// The keys that its range tombstones deleted are not known.
+		rangeTombstones, lost = nil, &QuarantinedRange{}
+	}
+	if lost != nil {
+		quarantine.Lost = []QuarantinedRange{*lost}
+	}
+
+	if err = os.MkdirAll(quarantineDirectory, 0755); err != nil {
+		return Quarantine{}, err
+	}
+	for _, filename := range table.files(directory) {
+		err = os.Rename(filename, filepath.Join(quarantineDirectory, filepath.Base(filename)))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return Quarantine{}, err
+		}
+	}
+
+	records := make([]salvagedRecord, len(keys))
+	leaves := make([][]byte, len(keys))
+	offsets := make([]uint, len(keys))
+	value := quarantineMarker{table: quarantine.Table}.encode()
+	crc := make([]byte, 4)
+	binary.LittleEndian.PutUint32(crc, CRC32([]byte(value)))
+	for i, key := range keys {
+		records[i] = salvagedRecord{crc, timestamp, RecordQuarantined, key, value, 0}
+		leaves[i] = MerkleLeaf(key, RecordQuarantined, timestamp, value)
+	}
+	if err = writeRecords(table.dataFilename, records); err != nil {
+		return Quarantine{}, err
+	}
+	for i, record := range records {
+		offsets[i] = record.offset
+	}
+	if err = table.writeAuxiliaryFiles(directory, keys, offsets, leaves, rangeTombstones, options); err != nil {
+		return Quarantine{}, err
+	}
+	return quarantine, nil
+}
+
+// quarantinedKeys returns the keys of a table from its index, or else from its data file,
+// and the newest timestamp of the records that can be read. If neither file can be read
+// to the end, lost holds the keys after the last one that is known.
+func quarantinedKeys(table *SSTable) (keys []string, timestamp string, lost *QuarantinedRange) {
+	indexKeys, indexErr := readIndexKeys(table.indexFilename)
+	file, err := os.Open(table.dataFilename)
+	if err != nil {
+		if indexErr != nil {
+			return nil, "", &QuarantinedRange{}
+		}
+		return indexKeys, "", nil
+	}
+	defer file.Close()
+
+	dataKeys := make([]string, 0)
+	complete := false
+	var offset uint = 8
+	fileLenBytes := make([]byte, 8)
+	if _, err = file.ReadAt(fileLenBytes, 0); err == nil {
+		fileLen := binary.LittleEndian.Uint64(fileLenBytes)
+		for i := uint64(0); ; i++ {
+			if i == fileLen {
+				complete = true
+				break
+			}
+			_, recordTimestamp, _, _, _, key, _, next, err := readData(file, offset)
+			if err != nil {
+				break
+			}
+			dataKeys = append(dataKeys, key)
+			if recordTimestamp > timestamp {
+				timestamp = recordTimestamp
+			}
+			offset = next
+		}
+	}
+	if indexErr == nil {
+		return indexKeys, timestamp, nil
+	}
+
+	sort.Strings(dataKeys)
+	unique := dataKeys[:0]
+	for i, key := range dataKeys {
+		if i == 0 || key != dataKeys[i-1] {
+			unique = append(unique, key)
+		}
+	}
+	if complete {
+		return unique, timestamp, nil
+	}
+	lost = &QuarantinedRange{}
+	if len(unique) > 0 {
+		// Keys are written in order, so the unknown ones come after the last known one.
+		lost.Start = unique[len(unique)-1] + "\x00"
+	}
+	return unique, timestamp, lost
+}
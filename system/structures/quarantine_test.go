@@ -0,0 +1,37 @@
+package structures
+
+import (
+	"testing"
+)
+
+func TestQuarantineMarkerKeepsVersionsAroundIt(t *testing.T) {
+	operators := NewMergeOperators()
+	first, second := AppendOperand([]byte("-1")), AppendOperand([]byte("-2"))
+	marker := quarantineMarker{table: "20260101-000000.000000000/usertable-data-ic-2-lev1-Data.db"}.encode()
+
+	// Compaction brings the version below the marker and the operands written over it.
+	kind, value := combineRecords(operators, RecordQuarantined, marker, RecordValue, "base")
+	if kind != RecordQuarantined {
+		t.Fatalf("marker over a value gives kind %d", kind)
+	}
+	kind, value = combineRecords(operators, RecordMerge, string(EncodeOperands([][]byte{second})), kind, value)
+	if kind != RecordQuarantined {
+		t.Fatalf("operands over a marker give kind %d", kind)
+	}
+	restored, err := decodeQuarantineMarker(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The salvaged record holds operands that go over the base and under the newer ones.
+	kind, value = restored.restore(operators, RecordMerge, string(EncodeOperands([][]byte{first})))
+	want := string(operators.Fold([]byte("base"), [][]byte{first, second}))
+	if kind != RecordValue || value != want {
+		t.Errorf("restored record is %d %q, want %q", kind, value, want)
+	}
+	kind, value = restored.restore(operators, RecordTombstone, "")
+	want = string(operators.Fold(nil, [][]byte{second}))
+	if kind != RecordValue || value != want {
+		t.Errorf("restored tombstone is %d %q, want %q", kind, value, want)
+	}
+}
@@ -0,0 +1,113 @@
+package structures
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"os"
+)
+
+// RangeTombstone deletes every key in [Start, End) that was written before it.
+type RangeTombstone struct {
+	Start     string
+	End       string
+	Timestamp string
+}
+
+// Covers reports whether key falls into the deleted range.
+func (rt RangeTombstone) Covers(key string) bool {
+	return key >= rt.Start && key < rt.End
+}
+
+func coveredByAny(key string, tombstones []RangeTombstone) bool {
+	for _, rt := range tombstones {
+		if rt.Covers(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeRangeTombstones writes the range tombstones of a table:
+// [count] followed by [start len][start][end len][end][timestamp] for each tombstone.
+func writeRangeTombstones(filename string, tombstones []RangeTombstone) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	countBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(countBytes, uint64(len(tombstones)))
+	writeBytes(writer, countBytes)
+
+	for _, rt := range tombstones {
+		for _, bound := range []string{rt.Start, rt.End} {
+			lenBytes := make([]byte, 8)
+			binary.LittleEndian.PutUint64(lenBytes, uint64(len(bound)))
+			writeBytes(writer, lenBytes)
+			writeBytes(writer, []byte(bound))
+		}
+		timestampBytes := make([]byte, 19)
+		copy(timestampBytes, rt.Timestamp)
+		writeBytes(writer, timestampBytes)
+	}
+
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	return syncAndClose(file)
+}
+
+// readRangeTombstones reads the range tombstones of a table. Tables written before
+// range tombstones existed have no such file and no tombstones.
+func readRangeTombstones(filename string) ([]RangeTombstone, error) {
+	file, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	readString := func(length uint64) (string, error) {
+		data, err := readBytes(reader, int(length))
+		if err != nil {
+			return "", readError(filename, err)
+		}
+		return string(data), nil
+	}
+	readUint := func() (uint64, error) {
+		data, err := readBytes(reader, 8)
+		if err != nil {
+			return 0, readError(filename, err)
+		}
+		return binary.LittleEndian.Uint64(data), nil
+	}
+
+	count, err := readUint()
+	if err != nil {
+		return nil, err
+	}
+	tombstones := make([]RangeTombstone, 0)
+	for i := uint64(0); i < count; i++ {
+		var bounds [2]string
+		for j := range bounds {
+			length, err := readUint()
+			if err != nil {
+				return nil, err
+			}
+			if bounds[j], err = readString(length); err != nil {
+				return nil, err
+			}
+		}
+		timestamp, err := readString(19)
+		if err != nil {
+			return nil, err
+		}
+		tombstones = append(tombstones, RangeTombstone{bounds[0], bounds[1], timestamp})
+	}
+	return tombstones, nil
+}
@@ -0,0 +1,41 @@
+package structures
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestRangeTombstonesRoundTrip(t *testing.T) {
+	filename := t.TempDir() + "/RangeTombstones.db"
+	if tombstones, err := readRangeTombstones(filename); err != nil || tombstones != nil {
+		t.Fatalf("missing file gives %v, %v", tombstones, err)
+	}
+	written := []RangeTombstone{{"a", "c", "2024-01-01 00:00:00"}, {"", "\xff", "2024-01-02 00:00:00"}}
+	if err := writeRangeTombstones(filename, written); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readRangeTombstones(filename)
+	if err != nil || len(read) != len(written) {
+		t.Fatalf("read %v, %v", read, err)
+	}
+	for i := range written {
+		if read[i] != written[i] {
+			t.Errorf("tombstone %d is %v, want %v", i, read[i], written[i])
+		}
+	}
+	if !coveredByAny("b", read[:1]) || coveredByAny("c", read[:1]) {
+		t.Error("ranges do not cover [Start, End)")
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filename, data[:len(data)-5], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = readRangeTombstones(filename); !errors.Is(err, ErrCorruption) {
+		t.Errorf("truncated file gives %v", err)
+	}
+}
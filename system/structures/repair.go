@@ -0,0 +1,302 @@
+package structures
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// RepairReport tells what the repair of a keyspace rebuilt and what it could not keep.
+type RepairReport struct {
+	Rebuilt  []string        // data files whose tables were rebuilt
+	Dropped  []DroppedRecord // records that were not salvaged
+	Problems []string        // everything else that was lost or removed
+}
+
+// DroppedRecord is a record left out of a repaired table.
+type DroppedRecord struct {
+	Table  string // data file of the table
+	Index  int    // position of the record in the data file
+	Key    string
+	Reason string
+}
+
+var tableFilename = regexp.MustCompile(`^usertable-data-ic-(\d+)-lev(\d+)-(.+)$`)
+
+// salvagedRecord is a record of a data file that passed its checksum.
+type salvagedRecord struct {
+	crc       []byte
+	timestamp string
+	kind      byte
+	key       string
+	value     string
+	offset    uint
+}
+
+// RepairSSTables rebuilds the index, summary, filter, Merkle tree and table of contents of
+// every table in the keyspace stored in directory from its data file. Records with a bad
+// checksum, records out of key order and everything after an unreadable record are dropped.
+// The markers of quarantined tables are replaced by the records salvaged from those tables,
+// which quarantineDirectory holds; a marker whose record cannot be salvaged is kept. Files of
+// tables without a data file are removed, and tables are renumbered so no level has a gap.
+// The keyspace must not be in use.
+func RepairSSTables(directory, quarantineDirectory string, options TableOptions) (*RepairReport, error) {
+	report := &RepairReport{Rebuilt: make([]string, 0), Dropped: make([]DroppedRecord, 0),
+		Problems: make([]string, 0)}
+
+	files, err := ioutil.ReadDir(SSTableDirectory(directory))
+	if errors.Is(err, os.ErrNotExist) {
+		return report, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Level number -> table numbers with a data file, and tables with no data file.
+	levels := make(map[int][]int)
+	orphans := make(map[[2]int]bool)
+	for _, file := range files {
+		match := tableFilename.FindStringSubmatch(file.Name())
+		if match == nil {
+			continue
+		}
+		num, _ := strconv.Atoi(match[1])
+		level, _ := strconv.Atoi(match[2])
+		if match[3] == "Data.db" {
+			levels[level] = append(levels[level], num)
+		} else if _, err := os.Stat(tableFiles(directory, level, num).dataFilename); errors.Is(err, os.ErrNotExist) {
+			orphans[[2]int{level, num}] = true
+		}
+	}
+
+	for table := range orphans {
+		st := tableFiles(directory, table[0], table[1])
+		st.remove(directory)
+		report.Problems = append(report.Problems, fmt.Sprintf("%s has no data file, its other files were removed",
+			filepath.Base(st.generalFilename+"TOC.txt")))
+	}
+
+	quarantined := &quarantinedTables{directory: quarantineDirectory, tables: make(map[string]map[string]salvagedRecord)}
+	levelNums := make([]int, 0, len(levels))
+	for level := range levels {
+		levelNums = append(levelNums, level)
+	}
+	sort.Ints(levelNums)
+	for _, level := range levelNums {
+		nums := levels[level]
+		sort.Ints(nums)
+		for i, num := range nums {
+			table := tableFiles(directory, level, num)
+			if err = repairTable(directory, table, options, quarantined, report); err != nil {
+				return nil, err
+			}
+			// Newer tables have higher numbers, so the order is kept when gaps are closed.
+			if num != i+1 {
+				if err = renameTable(directory, table, tableFiles(directory, level, i+1)); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return report, nil
+}
+
+// renameTable gives every file of a table the names of another table number.
+func renameTable(directory string, from, to *SSTable) error {
+	fromFiles, toFiles := from.files(directory), to.files(directory)
+	for i := range fromFiles[:len(fromFiles)-1] {
+		err := os.Rename(fromFiles[i], toFiles[i])
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := to.WriteTableOfContents(); err != nil {
+		return err
+	}
+	return os.Remove(fromFiles[len(fromFiles)-1])
+}
+
+// repairTable salvages the records of a data file, restores the records its quarantine
+// markers stand for and writes every other file of the table again.
+func repairTable(directory string, table *SSTable, options TableOptions, quarantined *quarantinedTables,
+	report *RepairReport) error {
+	name := filepath.Base(table.dataFilename)
+	records, complete, err := salvageRecords(table.dataFilename, name, report)
+	if err != nil {
+		return err
+	}
+	restored, err := quarantined.restore(records, options.Operators, report)
+	if err != nil {
+		return err
+	}
+
+	if !complete || restored {
+		if err = writeRecords(table.dataFilename, records); err != nil {
+			return err
+		}
+	}
+
+	rangeTombstones, err := readRangeTombstones(table.rangeDelFilename)
+	if errors.Is(err, ErrCorruption) {
+		report.Problems = append(report.Problems, fmt.Sprintf("%s: range tombstones were lost: %v", name, err))
+		rangeTombstones = nil
+	} else if err != nil {
+		return err
+	}
+
+	keys := make([]string, len(records))
+	offsets := make([]uint, len(records))
+	leaves := make([][]byte, len(records))
+	for i, record := range records {
+		keys[i], offsets[i] = record.key, record.offset
+		leaves[i] = MerkleLeaf(record.key, record.kind, record.timestamp, record.value)
+	}
+	if err = table.writeAuxiliaryFiles(directory, keys, offsets, leaves, rangeTombstones, options); err != nil {
+		return err
+	}
+	report.Rebuilt = append(report.Rebuilt, name)
+	return nil
+}
+
+// salvageRecords reads the records of a data file up to the first one that cannot be read.
+// It returns the records that can be kept and whether that is every record of the file in
+// TableFormat.
+func salvageRecords(dataFilename, name string, report *RepairReport) ([]salvagedRecord, bool, error) {
+	file, err := os.Open(dataFilename)
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+
+	records := make([]salvagedRecord, 0)
+	fileLenBytes, err := readBytes(bufio.NewReader(file), 8)
+	if err != nil {
+		report.Problems = append(report.Problems, fmt.Sprintf("%s: no record could be read: %v", name,
+			readError(dataFilename, err)))
+		return records, false, nil
+	}
+	fileLen := binary.LittleEndian.Uint64(fileLenBytes)
+
+	format := dataFileFormat(dataFilename)
+	complete := format == TableFormat
+	offset := uint(8)
+	for i := uint64(0); i < fileLen; i++ {
+		crc, timestamp, kind, key, value, next, err := readRecord(file, offset, format)
+		if errors.Is(err, ErrCorruption) {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s: records from %d of %d on could not be read: %v",
+				name, i, fileLen, err))
+			return records, false, nil
+		} else if err != nil {
+			return nil, false, err
+		}
+
+		reason := ""
+		if CRC32([]byte(value)) != binary.LittleEndian.Uint32(crc) {
+			reason = "checksum mismatch"
+		} else if len(records) > 0 && key <= records[len(records)-1].key {
+			reason = "key out of order"
+		}
+		if reason != "" {
+			report.Dropped = append(report.Dropped, DroppedRecord{Table: name, Index: int(i), Key: key, Reason: reason})
+			complete = false
+		} else {
+			records = append(records, salvagedRecord{crc, timestamp, kind, key, value, offset})
+		}
+		offset = next
+	}
+	return records, complete, nil
+}
+
+// quarantinedTables holds the records salvaged from the quarantined tables of a keyspace.
+type quarantinedTables struct {
+	directory string
+	tables    map[string]map[string]salvagedRecord // table -> key -> record
+}
+
+// restore replaces the quarantine markers among records by the records they stand for and
+// reports whether there were any.
+func (q *quarantinedTables) restore(records []salvagedRecord, operators MergeOperators,
+	report *RepairReport) (bool, error) {
+	restored := false
+	for i := range records {
+		record := &records[i]
+		if record.kind != RecordQuarantined {
+			continue
+		}
+		marker, err := decodeQuarantineMarker(record.value)
+		if err != nil {
+			return false, err
+		}
+		salvaged, err := q.salvage(marker.table, report)
+		if err != nil {
+			return false, err
+		}
+		original, ok := salvaged[record.key]
+		if !ok {
+			report.Problems = append(report.Problems, fmt.Sprintf("key %q could not be salvaged from quarantined "+
+				"table %s, reads of it fail until it is written again", record.key, marker.table))
+			continue
+		}
+		record.kind, record.value = marker.restore(operators, original.kind, original.value)
+		record.crc = make([]byte, 4)
+		binary.LittleEndian.PutUint32(record.crc, CRC32([]byte(record.value)))
+		restored = true
+	}
+	return restored, nil
+}
+
+// salvage returns the records of a quarantined table that pass their checksums, by key.
+func (q *quarantinedTables) salvage(table string, report *RepairReport) (map[string]salvagedRecord, error) {
+	if records, ok := q.tables[table]; ok {
+		return records, nil
+	}
+	records := make(map[string]salvagedRecord)
+	salvaged, _, err := salvageRecords(filepath.Join(q.directory, table), table, report)
+	if errors.Is(err, os.ErrNotExist) {
+		report.Problems = append(report.Problems, fmt.Sprintf("quarantined table %s is missing", table))
+	} else if err != nil {
+		return nil, err
+	}
+	for _, record := range salvaged {
+		records[record.key] = record
+	}
+	q.tables[table] = records
+	return records, nil
+}
+
+// writeRecords writes a data file holding only the given records and sets their new offsets.
+// The file is written under a temporary name and then replaces the old one.
+func writeRecords(dataFilename string, records []salvagedRecord) error {
+	temporary := dataFilename + ".repair"
+	file, err := os.Create(temporary)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fileLenBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(fileLenBytes, uint64(len(records)))
+	offset, err := writeRaw(file, 0, fileLenBytes)
+	if err != nil {
+		return err
+	}
+	for i := range records {
+		record := &records[i]
+		record.offset = offset
+		offset, err = writeData(file, offset, record.crc, record.timestamp, record.kind,
+			uint64(len(record.key)), uint64(len(record.value)), record.key, record.value)
+		if err != nil {
+			return err
+		}
+	}
+	if err = syncAndClose(file); err != nil {
+		return err
+	}
+	return os.Rename(temporary, dataFilename)
+}
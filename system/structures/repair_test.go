@@ -0,0 +1,111 @@
+package structures
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// checkRepairedTable checks that the table on level 1 with number num is intact and holds
+// exactly the keys of want, with their values.
+func checkRepairedTable(t *testing.T, directory string, num int, want map[string]string, keys ...string) {
+	t.Helper()
+	report, err := VerifyTable(directory, 1, num)
+	if err != nil || report.Corrupted() || report.MissingMetadata {
+		t.Errorf("repaired table has report %+v, %v", report, err)
+	}
+	for _, key := range keys {
+		found, value, err := SearchThroughSSTables(directory, key, 1, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wanted, ok := want[key]
+		if found != ok || string(value) != string(EncodeValue(TypeRaw, []byte(wanted))) && ok {
+			t.Errorf("%s is %v %q after the repair, want %v %q", key, found, value, ok, wanted)
+		}
+	}
+}
+
+func TestRepairRebuildsMissingFiles(t *testing.T) {
+	directory, dataFilename := writeVerifiedTable(t)
+	table := tableFiles(directory, 1, 1)
+	for _, filename := range []string{table.indexFilename, table.summaryFilename, table.filterFilename,
+		metadataFilename(directory, dataFilename)} {
+		if err := os.Remove(filename); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report, err := RepairSSTables(directory, t.TempDir()+"/", TableOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Rebuilt) != 1 || len(report.Dropped) != 0 || len(report.Problems) != 0 {
+		t.Errorf("report is %+v", report)
+	}
+	checkRepairedTable(t, directory, 1, map[string]string{"a": "a-value", "b": "b-value", "c": "c-value"},
+		"a", "b", "c")
+}
+
+func TestRepairDropsBadRecords(t *testing.T) {
+	directory, dataFilename := writeVerifiedTable(t)
+	data, err := os.ReadFile(dataFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[bytes.Index(data, []byte("b-value"))] = 'x'
+	if err = os.WriteFile(dataFilename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := RepairSSTables(directory, t.TempDir()+"/", TableOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Dropped) != 1 || report.Dropped[0].Key != "b" || report.Dropped[0].Index != 1 {
+		t.Errorf("dropped %+v, want record 1 of b", report.Dropped)
+	}
+	checkRepairedTable(t, directory, 1, map[string]string{"a": "a-value", "c": "c-value"}, "a", "b", "c")
+
+	// Records from one that is cut off at the end of the file on are lost too.
+	if data, err = os.ReadFile(dataFilename); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(dataFilename, data[:len(data)-2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if report, err = RepairSSTables(directory, t.TempDir()+"/", TableOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Dropped) != 0 || len(report.Problems) != 1 {
+		t.Errorf("report of a truncated file is %+v", report)
+	}
+	checkRepairedTable(t, directory, 1, map[string]string{"a": "a-value"}, "a", "c")
+}
+
+func TestRepairClosesGapsInTableNumbers(t *testing.T) {
+	directory, _ := writeVerifiedTable(t)
+	newer := NewMemoryTable(5, 100, 80)
+	newer.Insert("d", EncodeValue(TypeRaw, []byte("d-value")), false)
+	if err := newer.PerformFlush(directory, TableOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(tableFiles(directory, 1, 1).dataFilename); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := RepairSSTables(directory, t.TempDir()+"/", TableOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 1 || len(report.Rebuilt) != 1 {
+		t.Errorf("report is %+v", report)
+	}
+	if count, err := TableCount(directory, 1); err != nil || count != 1 {
+		t.Fatalf("level 1 has %d tables, %v after the repair", count, err)
+	}
+	files, err := filepath.Glob(SSTableDirectory(directory) + "usertable-data-ic-2-*")
+	if err != nil || len(files) != 0 {
+		t.Errorf("files of table 2 are left: %v, %v", files, err)
+	}
+	checkRepairedTable(t, directory, 1, map[string]string{"d": "d-value"}, "a", "d")
+}
@@ -3,13 +3,66 @@ package structures
 import (
 	"bufio"
 	"crypto/md5"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"math/bits"
 	"os"
+	"sort"
 	"strings"
+	"unicode"
 )
 
+// ErrFingerprintSize is returned when fingerprints of different sizes are compared.
+var ErrFingerprintSize = errors.New("fingerprints have different sizes")
+
+// Fingerprint is a packed SimHash fingerprint of 64 or 128 bits. Bit i of the fingerprint
+// is bit i of Low for i < 64 and bit i-64 of High otherwise.
+type Fingerprint struct {
+	Size int // 64 ili 128 bita
+	Low  uint64
+	High uint64
+}
+
+// Distance returns the Hamming distance between two fingerprints of the same size.
+func (fp Fingerprint) Distance(other Fingerprint) (int, error) {
+	if fp.Size != other.Size {
+		return 0, ErrFingerprintSize
+	}
+	return bits.OnesCount64(fp.Low^other.Low) + bits.OnesCount64(fp.High^other.High), nil
+}
+
+func (fp Fingerprint) String() string {
+	if fp.Size == 128 {
+		return fmt.Sprintf("%016x%016x", fp.High, fp.Low)
+	}
+	return fmt.Sprintf("%016x", fp.Low)
+}
+
+// EncodeFingerprint serializes a fingerprint as its size in bits followed by its 64-bit
+// words, little endian.
+func EncodeFingerprint(fp Fingerprint) []byte {
+	data := []byte{byte(fp.Size)}
+	data = binary.LittleEndian.AppendUint64(data, fp.Low)
+	if fp.Size == 128 {
+		data = binary.LittleEndian.AppendUint64(data, fp.High)
+	}
+	return data
+}
+
+// DecodeFingerprint is the inverse of EncodeFingerprint.
+func DecodeFingerprint(data []byte) (Fingerprint, error) {
+	if len(data) == 0 || (data[0] != 64 && data[0] != 128) || len(data) != 1+int(data[0])/8 {
+		return Fingerprint{}, errors.New("not a SimHash fingerprint")
+	}
+	fp := Fingerprint{Size: int(data[0]), Low: binary.LittleEndian.Uint64(data[1:])}
+	if fp.Size == 128 {
+		fp.High = binary.LittleEndian.Uint64(data[9:])
+	}
+	return fp, nil
+}
+
 func generateWordsStop() map[string]bool {
 	wordsStop := []string{"A", "About", "Actually", "Almost", "Also", "Although", "Always", "Am", "An", "And", "Any", "Are",
 		"As", "At", "Be", "Became", "Become", "But", "By", "Can", "Could", "Did", "Do", "Does", "Each", "Either", "Else", "For",
@@ -17,99 +70,247 @@ func generateWordsStop() map[string]bool {
 		"Mine", "Must", "My", "Neither", "Nor", "Not", "Of", "Oh", "Ok", "When", "Where", "Whereas", "Wherever", "Whenever",
 		"Whether", "Which", "While", "Who", "Whom", "Whoever", "Whose", "Why", "Will", "With", "Within", "Without",
 		"Would", "Yes", "Yet", "You", "Your"}
+	return stopWordSet(wordsStop)
+}
+
+// stopWordSet normalizes stop words the way tokens are normalized, so they match in any case.
+func stopWordSet(words []string) map[string]bool {
 	mapWordsStop := make(map[string]bool)
-	for _, word := range wordsStop {
-		mapWordsStop[strings.ToUpper(word)] = true
+	for _, word := range words {
+		if word = normalizeToken(word); word != "" {
+			mapWordsStop[word] = true
+		}
 	}
 	return mapWordsStop
 }
 
+// LoadStopWords reads a stop-word list: words separated by white space, where lines that
+// start with '#' are comments.
+func LoadStopWords(path string) (map[string]bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	words := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "#") {
+			words = append(words, strings.Fields(line)...)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return stopWordSet(words), nil
+}
+
+// Tokenizer splits a text into tokens.
+type Tokenizer byte
+
+const (
+	WordTokenizer       Tokenizer = iota // runs of letters and digits
+	WhitespaceTokenizer                  // runs of anything but white space
+)
+
+// ParseTokenizer returns the tokenizer with the given name. An empty name means words.
+func ParseTokenizer(name string) (Tokenizer, error) {
+	switch strings.ToLower(name) {
+	case "", "words", "word":
+		return WordTokenizer, nil
+	case "whitespace", "space":
+		return WhitespaceTokenizer, nil
+	}
+	return WordTokenizer, fmt.Errorf("unknown tokenizer %q", name)
+}
+
+// Split returns the tokens of text in order.
+func (tokenizer Tokenizer) Split(text string) []string {
+	if tokenizer == WhitespaceTokenizer {
+		return strings.Fields(text)
+	}
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// normalizeToken lower-cases a token and strips the punctuation around it, so "Word," and
+// "word" are the same token.
+func normalizeToken(token string) string {
+	return strings.ToLower(strings.TrimFunc(token, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}))
+}
+
+// SimHashOptions configure how a SimHash turns a text into weighted features: the text is
+// split into tokens, tokens are normalized, stop words are dropped, the remaining tokens are
+// joined into shingles, and every occurrence of a feature adds its weight.
+type SimHashOptions struct {
+	Size      int                // bits of the fingerprints, 64 or 128
+	Tokenizer Tokenizer          // how the text is split into tokens
+	StopWords map[string]bool    // tokens left out, normalized; nil means the builtin list
+	Shingle   int                // consecutive tokens in a feature; 0 means 1, single tokens
+	Weights   map[string]float64 // weight of an occurrence of a normalized feature; 1 if not set
+}
+
 type SimHash struct {
 	mapWordsStop map[string]bool
+	size         int // velicina otiska u bitovima, 64 ili 128
+	tokenizer    Tokenizer
+	shingle      int
+	weights      map[string]float64
 }
 
+// CreateSimHash returns a SimHash with 64-bit fingerprints.
 func CreateSimHash() SimHash {
-	mapWordsStop := generateWordsStop()
-	return SimHash{mapWordsStop}
+	simHash, _ := CreateSimHashOfSize(64)
+	return simHash
 }
 
-func (*SimHash) Hemingway(text1 Text, text2 Text) int {
-	fingerprint1 := text1.fingerprint
-	fingerprint2 := text2.fingerprint
-	fingerprint := make([]int, 256, 256)
+// CreateSimHashOfSize returns a SimHash whose fingerprints have size bits, 64 or 128.
+func CreateSimHashOfSize(size int) (SimHash, error) {
+	return NewSimHash(SimHashOptions{Size: size})
+}
+
+// NewSimHash returns a SimHash with the given options.
+func NewSimHash(options SimHashOptions) (SimHash, error) {
+	if options.Size != 64 && options.Size != 128 {
+		return SimHash{}, fmt.Errorf("SimHash fingerprints have 64 or 128 bits, not %d", options.Size)
+	}
+	if options.Shingle < 0 {
+		return SimHash{}, fmt.Errorf("SimHash shingles cannot have %d tokens", options.Shingle)
+	}
+	simHash := SimHash{mapWordsStop: options.StopWords, size: options.Size, tokenizer: options.Tokenizer,
+		shingle: options.Shingle, weights: make(map[string]float64)}
+	if simHash.mapWordsStop == nil {
+		simHash.mapWordsStop = generateWordsStop()
+	}
+	if simHash.shingle == 0 {
+		simHash.shingle = 1
+	}
+	for feature, weight := range options.Weights {
+		simHash.weights[simHash.normalizeFeature(feature)] = weight
+	}
+	return simHash, nil
+}
 
-	for i := 0; i < 256; i++ {
-		fingerprint[i] = fingerprint1[i] ^ fingerprint2[i]
+// normalizeFeature normalizes the tokens of a feature given in the options, e.g. a weight.
+func (simHash *SimHash) normalizeFeature(feature string) string {
+	tokens := simHash.tokenizer.Split(feature)
+	for i, token := range tokens {
+		tokens[i] = normalizeToken(token)
 	}
+	return strings.Join(tokens, " ")
+}
 
-	hemingway := 0
-	for _, value := range fingerprint {
-		if value == 1 {
-			hemingway++
-		}
+// Hemingway returns the Hamming distance between the fingerprints of two texts.
+func (*SimHash) Hemingway(text1 Text, text2 Text) int {
+	distance, err := text1.fingerprint.Distance(text2.fingerprint)
+	if err != nil {
+		return -1
 	}
-	return hemingway
+	return distance
 }
 
 type Text struct {
-	fingerprint []int
+	fingerprint Fingerprint
 }
 
-func GenerateText(filepath string, simHash SimHash) Text {
-	mapWordsOccurencies := CalculateNumOfWords(filepath, simHash)
-	hashedWords := HashWords(mapWordsOccurencies)
-	fingerprint := SumHashesWords(hashedWords)
-	return Text{fingerprint}
+func (text Text) Fingerprint() Fingerprint {
+	return text.fingerprint
 }
 
-func CalculateNumOfWords(filepath string, simHash SimHash) map[string]int {
-	mapWordsOccurencies := make(map[string]int)
-	f, err := os.Open(filepath)
+// GenerateText fingerprints the text in the file at filepath.
+func GenerateText(filepath string, simHash SimHash) (Text, error) {
+	data, err := os.ReadFile(filepath)
 	if err != nil {
-		log.Fatal(err)
+		return Text{}, err
 	}
-	defer f.Close()
+	return GenerateTextFromString(string(data), simHash), nil
+}
 
-	scanner := bufio.NewScanner(f)
-	scanner.Split(bufio.ScanWords)
-	for scanner.Scan() {
-		if simHash.mapWordsStop[scanner.Text()] == false {
-			mapWordsOccurencies[strings.ToUpper(scanner.Text())] += 1
+// GenerateTextFromString is GenerateText for a text that is already in memory.
+func GenerateTextFromString(text string, simHash SimHash) Text {
+	features := simHash.Features(text)
+	hashedWords := HashWords(features)
+	fingerprint := SumHashesWords(features, hashedWords, simHash.size)
+	return Text{fingerprint}
+}
+
+// Features returns the features of text with their weights: the number of occurrences of a
+// feature times its weight.
+func (simHash *SimHash) Features(text string) map[string]float64 {
+	tokens := make([]string, 0)
+	for _, token := range simHash.tokenizer.Split(text) {
+		token = normalizeToken(token)
+		if token != "" && !simHash.mapWordsStop[token] {
+			tokens = append(tokens, token)
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
-	}
 
-	return mapWordsOccurencies
+	features := make(map[string]float64)
+	// Tekst kraci od jednog shingle-a je sam svoj shingle.
+	count := len(tokens) - simHash.shingle + 1
+	if count < 1 && len(tokens) > 0 {
+		count = 1
+	}
+	for i := 0; i < count; i++ {
+		end := i + simHash.shingle
+		if end > len(tokens) {
+			end = len(tokens)
+		}
+		feature := strings.Join(tokens[i:end], " ")
+		weight, ok := simHash.weights[feature]
+		if !ok {
+			weight = 1
+		}
+		features[feature] += weight
+	}
+	return features
 }
 
-func HashWords(mapWordsOccurencies map[string]int) map[int]string {
-
-	hash := make(map[int]string)
-	for word, _ := range mapWordsOccurencies {
-		hash[mapWordsOccurencies[word]] = ToBinary(GetMD5Hash(word))
+// HashWords returns the MD5 hashes of the features, in hexadecimal.
+func HashWords(features map[string]float64) map[string]string {
+	hash := make(map[string]string, len(features))
+	for feature := range features {
+		hash[feature] = GetMD5Hash(feature)
 	}
 	return hash
 }
 
-func SumHashesWords(hashs map[int]string) []int {
+// SumHashesWords adds up the bits of the feature hashes, weighted by the features, and packs
+// the first size bits of the sum into a fingerprint: a bit is set where the sum is positive.
+func SumHashesWords(features map[string]float64, hashs map[string]string, size int) Fingerprint {
+	// Sabira se po redu, da bi zbir decimalnih tezina uvek bio isti.
+	sorted := make([]string, 0, len(hashs))
+	for feature := range hashs {
+		sorted = append(sorted, feature)
+	}
+	sort.Strings(sorted)
 
-	fingerprint := make([]int, 256, 256)
-	for occurency, hash := range hashs {
-		for i := 0; i < len(hash); i++ {
-			if hash[i] == '1' {
-				fingerprint[i] += occurency
+	sums := make([]float64, size)
+	for _, feature := range sorted {
+		weight := features[feature]
+		digest, _ := hex.DecodeString(hashs[feature])
+		for i := range sums {
+			if digest[i/8]&(0x80>>(i%8)) != 0 {
+				sums[i] += weight
 			} else {
-				fingerprint[i] -= occurency
+				sums[i] -= weight
 			}
 		}
 	}
-	for i, value := range fingerprint {
-		if value > 0 {
-			fingerprint[i] = 1
+	fingerprint := Fingerprint{Size: size}
+	for i, value := range sums {
+		if value <= 0 {
+			continue
+		}
+		if i < 64 {
+			fingerprint.Low |= 1 << i
 		} else {
-			fingerprint[i] = 0
+			fingerprint.High |= 1 << (i - 64)
 		}
 	}
 	return fingerprint
@@ -120,13 +321,3 @@ func GetMD5Hash(text string) string {
 	hash := md5.Sum([]byte(text))
 	return hex.EncodeToString(hash[:])
 }
-
-// Funkcija koja pretvara heksadecimalni zapis izrazen u stringu u binarni
-// zapis izrazen u stringu
-func ToBinary(s string) string {
-	res := ""
-	for _, c := range s {
-		res = fmt.Sprintf("%s%.8b", res, c)
-	}
-	return res
-}
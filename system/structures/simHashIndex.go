@@ -0,0 +1,120 @@
+package structures
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SimHashIndex is the layout of a permuted-table index (Manku et al.) that finds the
+// fingerprints within MaxDistance bits of a query. Fingerprints are split into at least
+// MaxDistance+1 blocks, and two fingerprints that differ in at most MaxDistance bits have
+// at least one block in common. Table i keeps every fingerprint sorted by its block i, as
+// if its bits were permuted so that block i leads, so the candidates of a query are the
+// fingerprints that share a block with it in the table of that block.
+//
+// An entry of the index is a key made of the table, the block and the key of the
+// fingerprint, which is stored as the value of the entry.
+type SimHashIndex struct {
+	Size        int // bits of the indexed fingerprints
+	MaxDistance int
+	blocks      []simHashBlock
+}
+
+type simHashBlock struct {
+	start  int
+	length int
+}
+
+// NewSimHashIndex returns the layout of an index of fingerprints of size bits that answers
+// queries up to maxDistance bits.
+func NewSimHashIndex(size, maxDistance int) (SimHashIndex, error) {
+	if size != 64 && size != 128 {
+		return SimHashIndex{}, fmt.Errorf("SimHash fingerprints have 64 or 128 bits, not %d", size)
+	}
+	if maxDistance < 0 || maxDistance >= size/4 {
+		return SimHashIndex{}, fmt.Errorf("SimHash index distance must be between 0 and %d, not %d",
+			size/4-1, maxDistance)
+	}
+	// Blocks are at most 64 bits long, so they fit in a word.
+	numBlocks := maxDistance + 1
+	if numBlocks < size/64 {
+		numBlocks = size / 64
+	}
+	index := SimHashIndex{Size: size, MaxDistance: maxDistance, blocks: make([]simHashBlock, numBlocks)}
+	start := 0
+	for i := range index.blocks {
+		length := size / numBlocks
+		if i < size%numBlocks {
+			length++
+		}
+		index.blocks[i] = simHashBlock{start, length}
+		start += length
+	}
+	return index, nil
+}
+
+// bitsAt returns length bits of the fingerprint from bit start on.
+func (fp Fingerprint) bitsAt(start, length int) uint64 {
+	var value uint64
+	for i := 0; i < length; i++ {
+		bit := start + i
+		word := fp.Low
+		if bit >= 64 {
+			word, bit = fp.High, bit-64
+		}
+		value |= (word >> bit & 1) << i
+	}
+	return value
+}
+
+// tablePrefix returns the prefix of the entries of table i whose block equals that of fp.
+func (index SimHashIndex) tablePrefix(i int, fp Fingerprint) string {
+	block := index.blocks[i]
+	return fmt.Sprintf("%02d/%0*x/", i, (block.length+3)/4, fp.bitsAt(block.start, block.length))
+}
+
+// Entries returns the keys of the index entries of the fingerprint stored under key, one per table.
+func (index SimHashIndex) Entries(key string, fp Fingerprint) []string {
+	entries := make([]string, len(index.blocks))
+	for i := range index.blocks {
+		entries[i] = index.tablePrefix(i, fp) + key
+	}
+	return entries
+}
+
+// Prefixes returns the prefixes of the entries that are candidates for a query, one per table.
+func (index SimHashIndex) Prefixes(fp Fingerprint) []string {
+	prefixes := make([]string, len(index.blocks))
+	for i := range index.blocks {
+		prefixes[i] = index.tablePrefix(i, fp)
+	}
+	return prefixes
+}
+
+// EntryKey returns the key of the fingerprint that an index entry points to.
+func (index SimHashIndex) EntryKey(entry string) string {
+	// The key follows the table number and the block, which hold no '/'.
+	parts := strings.SplitN(entry, "/", 3)
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[2]
+}
+
+// SimilarFingerprint is a stored fingerprint found by a similarity query.
+type SimilarFingerprint struct {
+	Key         string
+	Fingerprint Fingerprint
+	Distance    int
+}
+
+// SortSimilar sorts fingerprints by distance, and those at the same distance by key.
+func SortSimilar(similar []SimilarFingerprint) {
+	sort.Slice(similar, func(i, j int) bool {
+		if similar[i].Distance != similar[j].Distance {
+			return similar[i].Distance < similar[j].Distance
+		}
+		return similar[i].Key < similar[j].Key
+	})
+}
@@ -0,0 +1,80 @@
+package structures
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestNewSimHashIndexCoversEveryBit(t *testing.T) {
+	for _, layout := range [][2]int{{64, 0}, {64, 3}, {64, 15}, {128, 0}, {128, 5}} {
+		index, err := NewSimHashIndex(layout[0], layout[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := 0
+		for _, block := range index.blocks {
+			if block.start != start || block.length < 1 || block.length > 64 {
+				t.Errorf("%v: block %+v after bit %d", layout, block, start)
+			}
+			start += block.length
+		}
+		if start != layout[0] || len(index.blocks) <= layout[1] {
+			t.Errorf("%v: %d blocks cover %d bits", layout, len(index.blocks), start)
+		}
+	}
+	for _, layout := range [][2]int{{32, 1}, {64, -1}, {64, 16}} {
+		if _, err := NewSimHashIndex(layout[0], layout[1]); err == nil {
+			t.Errorf("%v is a valid layout", layout)
+		}
+	}
+}
+
+func TestCloseFingerprintsShareAnEntryPrefix(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	index, err := NewSimHashIndex(128, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		fp := Fingerprint{Size: 128, Low: random.Uint64(), High: random.Uint64()}
+		near := fp
+		for _, bit := range random.Perm(128)[:random.Intn(6)] {
+			if bit < 64 {
+				near.Low ^= 1 << bit
+			} else {
+				near.High ^= 1 << (bit - 64)
+			}
+		}
+		shared := false
+		prefixes := index.Prefixes(near)
+		for j, entry := range index.Entries("key", fp) {
+			shared = shared || strings.HasPrefix(entry, prefixes[j])
+		}
+		if !shared {
+			t.Fatalf("%v and %v share no entry prefix", fp, near)
+		}
+	}
+}
+
+func TestEntryKey(t *testing.T) {
+	index, err := NewSimHashIndex(64, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"doc", "a/b/c", ""} {
+		for _, entry := range index.Entries(key, Fingerprint{Size: 64, Low: 0xabcdef}) {
+			if got := index.EntryKey(entry); got != key {
+				t.Errorf("entry %q points to %q, want %q", entry, got, key)
+			}
+		}
+	}
+}
+
+func TestSortSimilar(t *testing.T) {
+	similar := []SimilarFingerprint{{Key: "c", Distance: 1}, {Key: "b", Distance: 0}, {Key: "a", Distance: 1}}
+	SortSimilar(similar)
+	if similar[0].Key != "b" || similar[1].Key != "a" || similar[2].Key != "c" {
+		t.Errorf("sorted fingerprints are %+v", similar)
+	}
+}
@@ -0,0 +1,193 @@
+package structures
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	original = `The storage engine keeps recent writes in a memory table and flushes it to sorted
+string tables on disk. Every table has an index, a summary and a filter, so a lookup reads
+at most one block of a table. Compaction merges the tables of a level into the next one and
+drops the records that newer writes have replaced.`
+	edited = `The storage engine keeps recent writes in a memory table and flushes it to sorted
+string tables on disk. Each table has an index, a summary and a filter, so a lookup reads
+at most one block of a table. Compaction merges the tables of a level into the next level and
+drops the records that newer writes have replaced.`
+	unrelated = `Bake the bread for forty minutes until the crust turns golden brown, then let it
+rest on a wire rack. Slice it only once it has cooled, or the crumb will be gummy and dense.
+Fresh loaves keep for three days wrapped in linen and much longer in the freezer.`
+)
+
+func distance(t *testing.T, simHash SimHash, text1, text2 string) int {
+	t.Helper()
+	d, err := GenerateTextFromString(text1, simHash).Fingerprint().Distance(
+		GenerateTextFromString(text2, simHash).Fingerprint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestSimilarTextsAreClose(t *testing.T) {
+	for _, options := range []SimHashOptions{
+		{Size: 64},
+		{Size: 128},
+		{Size: 64, Shingle: 2},
+		{Size: 128, Tokenizer: WhitespaceTokenizer, Shingle: 3},
+	} {
+		simHash, err := NewSimHash(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		similar := distance(t, simHash, original, edited)
+		different := distance(t, simHash, original, unrelated)
+		// Unrelated texts differ in about half of the bits.
+		if similar > options.Size/5 {
+			t.Errorf("%+v: distance of similar texts is %d", options, similar)
+		}
+		if different < options.Size/4 {
+			t.Errorf("%+v: distance of unrelated texts is %d", options, different)
+		}
+		if similar >= different {
+			t.Errorf("%+v: similar texts are %d bits apart, unrelated ones %d", options, similar, different)
+		}
+	}
+}
+
+func TestFingerprintIsDeterministic(t *testing.T) {
+	simHash := CreateSimHash()
+	first := GenerateTextFromString(original, simHash).Fingerprint()
+	for i := 0; i < 20; i++ {
+		if fingerprint := GenerateTextFromString(original, simHash).Fingerprint(); fingerprint != first {
+			t.Fatalf("fingerprint changed from %s to %s", first, fingerprint)
+		}
+	}
+}
+
+func TestWordsWithTheSameCountAreKept(t *testing.T) {
+	simHash := CreateSimHash()
+	features := simHash.Features("red green blue")
+	if len(features) != 3 {
+		t.Fatalf("features %v, want red, green and blue", features)
+	}
+	if distance(t, simHash, "red green blue", "red green yellow") == 0 {
+		t.Error("a changed word that occurs once does not change the fingerprint")
+	}
+}
+
+func TestNormalizationAndStopWords(t *testing.T) {
+	simHash := CreateSimHash()
+	features := simHash.Features("The table, the TABLE and a Table!")
+	if len(features) != 2 || features["table"] != 3 || features["the"] != 2 {
+		t.Errorf("features %v, want table 3 times and the twice", features)
+	}
+	for _, stopWord := range []string{"and", "And", "AND", "a", "Yes"} {
+		if features := simHash.Features(stopWord); len(features) != 0 {
+			t.Errorf("stop word %q gives features %v", stopWord, features)
+		}
+	}
+	if distance(t, simHash, original, strings.ToUpper(original)) != 0 {
+		t.Error("case changes the fingerprint")
+	}
+}
+
+func TestLoadStopWords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stop.txt")
+	if err := os.WriteFile(path, []byte("# common words\nThe table\nof\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stopWords, err := LoadStopWords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	simHash, err := NewSimHash(SimHashOptions{Size: 64, StopWords: stopWords})
+	if err != nil {
+		t.Fatal(err)
+	}
+	features := simHash.Features("The index of the table and the summary")
+	if len(features) != 3 || features["index"] != 1 || features["and"] != 1 || features["summary"] != 1 {
+		t.Errorf("features %v, want index, and, summary", features)
+	}
+	if _, err = LoadStopWords(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("a missing stop-word file was read")
+	}
+}
+
+func TestShingles(t *testing.T) {
+	simHash, err := NewSimHash(SimHashOptions{Size: 64, Shingle: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	features := simHash.Features("memory table flush memory table")
+	want := map[string]float64{"memory table": 2, "table flush": 1, "flush memory": 1}
+	if len(features) != len(want) {
+		t.Fatalf("features %v, want %v", features, want)
+	}
+	for feature, weight := range want {
+		if features[feature] != weight {
+			t.Errorf("feature %q has weight %v, want %v", feature, features[feature], weight)
+		}
+	}
+	if features := simHash.Features("table"); len(features) != 1 || features["table"] != 1 {
+		t.Errorf("a text shorter than a shingle gives %v", features)
+	}
+	if _, err = NewSimHash(SimHashOptions{Size: 64, Shingle: -1}); err == nil {
+		t.Error("a negative shingle was accepted")
+	}
+}
+
+func TestWeights(t *testing.T) {
+	simHash, err := NewSimHash(SimHashOptions{Size: 64, Weights: map[string]float64{"Compaction": 5, "table": 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	features := simHash.Features("compaction table compaction")
+	if features["compaction"] != 10 || features["table"] != 0 {
+		t.Errorf("features %v, want compaction 10 and table 0", features)
+	}
+	// A feature of weight 0 does not count.
+	if distance(t, simHash, "compaction merges levels", "compaction merges levels table") != 0 {
+		t.Error("a feature of weight 0 changes the fingerprint")
+	}
+}
+
+func TestTokenizers(t *testing.T) {
+	if _, err := ParseTokenizer("sentences"); err == nil {
+		t.Error("an unknown tokenizer was accepted")
+	}
+	words, _ := ParseTokenizer("words")
+	if tokens := words.Split("key-value store's index"); len(tokens) != 5 {
+		t.Errorf("words tokens %q", tokens)
+	}
+	whitespace, _ := ParseTokenizer("whitespace")
+	if tokens := whitespace.Split("key-value store's index"); len(tokens) != 3 {
+		t.Errorf("whitespace tokens %q", tokens)
+	}
+}
+
+func TestFingerprintEncoding(t *testing.T) {
+	for _, size := range []int{64, 128} {
+		simHash, _ := CreateSimHashOfSize(size)
+		fingerprint := GenerateTextFromString(original, simHash).Fingerprint()
+		decoded, err := DecodeFingerprint(EncodeFingerprint(fingerprint))
+		if err != nil || decoded != fingerprint {
+			t.Errorf("%d bits: decoded %s, %v, want %s", size, decoded, err, fingerprint)
+		}
+	}
+	if _, err := DecodeFingerprint([]byte{64, 1, 2}); err == nil {
+		t.Error("a short fingerprint was decoded")
+	}
+	if _, err := (Fingerprint{Size: 64}).Distance(Fingerprint{Size: 128}); err != ErrFingerprintSize {
+		t.Errorf("distance of fingerprints of different sizes: %v", err)
+	}
+}
+
+func TestFingerprintDistance(t *testing.T) {
+	a := Fingerprint{Size: 128, Low: 0b1011, High: 1}
+	if d, err := a.Distance(Fingerprint{Size: 128, Low: 0b0001, High: 3}); err != nil || d != 3 {
+		t.Errorf("distance is %d, %v, want 3", d, err)
+	}
+}
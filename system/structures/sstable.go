@@ -1,368 +1,795 @@
 package structures
 
 import (
+	"KVSystem/config"
 	"bufio"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
-	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
 
+// ErrCorruption is returned when a file of the storage does not hold what its format requires.
+var ErrCorruption = errors.New("data corruption")
+
+// TableFormat is the version of the record layout of the data files written now. It is the
+// last line of the table of contents, after the file names.
+const TableFormat = 1
+
+// legacyTableFormat is the layout of tables whose table of contents has no format line.
+// They were written before records had a kind: the byte after the timestamp only marks
+// tombstones, and the key and value lengths are varints in front of the key and the value.
+// Only migration and repair read them, and both write them again in TableFormat.
+const legacyTableFormat = 0
+
+const formatLinePrefix = "format "
+
+// maxFieldLength is the largest key, value or file section the storage reads in one piece.
+const maxFieldLength = 1 << 30
+
+var errInvalidLength = errors.New("invalid length")
+
+// TableOptions control how new SSTables are written.
+type TableOptions struct {
+	MerkleHash         HashFunction
+	BloomFalsePositive []float64 // false-positive rate of the filters of each level, from level 1
+	Prefix             PrefixExtractor
+	Filter             FilterType
+	Operators          MergeOperators // fold the operands that compaction combines with older records
+}
+
+// bloomFalsePositive returns the false-positive rate of the filters of tables on a level.
+func (options TableOptions) bloomFalsePositive(level int) float64 {
+	return config.LevelFalsePositive(options.BloomFalsePositive, level, 0)
+}
+
 type SSTable struct {
-	generalFilename string
-	dataFilename    string
-	indexFilename   string
-	summaryFilename string
-	filterFilename  string
+	generalFilename  string
+	dataFilename     string
+	indexFilename    string
+	summaryFilename  string
+	filterFilename   string
+	rangeDelFilename string
+	level            int
+}
+
+// SSTableDirectory returns the directory with the SSTables of a keyspace stored in directory.
+func SSTableDirectory(directory string) string {
+	return directory + "sstable/"
+}
+
+// MetadataDirectory returns the directory with the Merkle metadata of a keyspace stored in directory.
+func MetadataDirectory(directory string) string {
+	return directory + "metadata/"
 }
 
-func NewSSTable(data MemoryTable, filename string) (table *SSTable) {
-	baseFilename := "system/data/sstable/usertable-data-ic-" + filename + "-lev1-"
-	table = &SSTable{
-		generalFilename: baseFilename,
-		dataFilename:    baseFilename + "Data.db",
-		indexFilename:   baseFilename + "Index.db",
-		summaryFilename: baseFilename + "Summary.db",
-		filterFilename:  baseFilename + "Filter.gob",
+func NewSSTable(data MemoryTable, directory, filename string, options TableOptions) (*SSTable, error) {
+	baseFilename := SSTableDirectory(directory) + "usertable-data-ic-" + filename + "-lev1-"
+	table := &SSTable{
+		generalFilename:  baseFilename,
+		dataFilename:     baseFilename + "Data.db",
+		indexFilename:    baseFilename + "Index.db",
+		summaryFilename:  baseFilename + "Summary.db",
+		filterFilename:   baseFilename + "Filter.gob",
+		rangeDelFilename: baseFilename + "RangeDel.db",
+		level:            1,
 	}
+	if err := table.writeFrom(data, directory, options); err != nil {
+		// A table without all of its files would break reads and compaction.
+		table.remove(directory)
+		return nil, err
+	}
+	return table, nil
+}
 
-	bloomFilter := CreateBF(data.CurrentSize(), 2)
+func (st *SSTable) writeFrom(data MemoryTable, directory string, options TableOptions) error {
 	keys := make([]string, 0)
 	offsets := make([]uint, 0)
-	values := make([][]byte, 0)
-	currentOffset := uint(0)
+	leaves := make([][]byte, 0)
 
-	file, err := os.Create(table.dataFilename)
+	file, err := os.Create(st.dataFilename)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer file.Close()
 
+	// bufio.Writer keeps the first write error, so it is checked once on Flush.
 	writer := bufio.NewWriter(file)
 
 	// Write file length
 	fileLenBytes := make([]byte, 8)
 	binary.LittleEndian.PutUint64(fileLenBytes, uint64(data.CurrentSize()))
-	bytesWritten, err := writer.Write(fileLenBytes)
-	currentOffset += uint(bytesWritten)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	err = writer.Flush()
-	if err != nil {
-		return
-	}
+	currentOffset := writeBytes(writer, fileLenBytes)
 
 	// Iterate over data and write to SSTable file
 	for node := data.skipList.head.NextNodes[0]; node != nil; node = node.NextNodes[0] {
 		key, value := node.Key, node.Value
 		keys = append(keys, key)
 		offsets = append(offsets, currentOffset)
-		values = append(values, value)
-
-		bloomFilter.Add(*node)
+		leaves = append(leaves, MerkleLeaf(key, node.Kind(), node.Timestamp, string(value)))
 
 		// Write Checksum
-		crc := CRC32(value)
 		crcBytes := make([]byte, 4)
-		binary.LittleEndian.PutUint32(crcBytes, crc)
-		bytesWritten, err := writer.Write(crcBytes)
-		currentOffset += uint(bytesWritten)
-		if err != nil {
-			return
-		}
+		binary.LittleEndian.PutUint32(crcBytes, CRC32(value))
+		currentOffset += writeBytes(writer, crcBytes)
 
 		// Write Timestamp
-		timestamp := node.Timestamp
 		timestampBytes := make([]byte, 19)
-		copy(timestampBytes, timestamp)
-		bytesWritten, err = writer.Write(timestampBytes)
-		if err != nil {
-			log.Fatal(err)
-		}
-		currentOffset += uint(bytesWritten)
-
-		// Write Tombstone
-		tombstone := node.Tombstone
-		tombstoneInt := uint8(0)
-		if tombstone {
-			tombstoneInt = 1
-		}
+		copy(timestampBytes, node.Timestamp)
+		currentOffset += writeBytes(writer, timestampBytes)
 
-		err = writer.WriteByte(tombstoneInt)
-		currentOffset += 1
-		if err != nil {
-			return
-		}
+		// Write record kind
+		currentOffset += writeBytes(writer, []byte{node.Kind()})
 
-		// Write Key
+		// Write key and value lengths
 		keyBytes := []byte(key)
-		keyLen := uint64(len(keyBytes))
-		writeVarUint(writer, keyLen)
+		lenBytes := make([]byte, 8)
+		binary.LittleEndian.PutUint64(lenBytes, uint64(len(keyBytes)))
+		currentOffset += writeBytes(writer, lenBytes)
+		lenBytes = make([]byte, 8)
+		binary.LittleEndian.PutUint64(lenBytes, uint64(len(value)))
+		currentOffset += writeBytes(writer, lenBytes)
+
+		// Write Key and Value
 		currentOffset += writeBytes(writer, keyBytes)
-
-		// Write Value
-		valueLen := uint64(len(value))
-		writeVarUint(writer, valueLen)
 		currentOffset += writeBytes(writer, value)
+	}
 
-		err = writer.Flush()
-		if err != nil {
-			return
-		}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	if err = syncAndClose(file); err != nil {
+		return err
+	}
+
+	return st.writeAuxiliaryFiles(directory, keys, offsets, leaves, data.rangeTombstones, options)
+}
+
+// writeAuxiliaryFiles writes every file of a table besides its data file, from the keys,
+// data offsets and Merkle leaves of its records: the index, summary, filter, range
+// tombstones, Merkle tree and, last, the table of contents.
+func (st *SSTable) writeAuxiliaryFiles(directory string, keys []string, offsets []uint, leaves [][]byte,
+	rangeTombstones []RangeTombstone, options TableOptions) error {
+
+	index := NewSimpleIndex(keys, offsets, st.indexFilename)
+	indexKeys, indexOffsets, err := index.WriteToFile()
+	if err != nil {
+		return err
+	}
+	if err = WriteSummaryToFile(indexKeys, indexOffsets, st.summaryFilename); err != nil {
+		return err
 	}
+	if err = writeFilter(st.filterFilename, buildFilter(keys, options, st.level)); err != nil {
+		return err
+	}
+	if err = writeRangeTombstones(st.rangeDelFilename, rangeTombstones); err != nil {
+		return err
+	}
+	if _, err = BuildMerkleTree(keys, leaves, directory, filepath.Base(st.dataFilename), options.MerkleHash); err != nil {
+		return err
+	}
+	return st.WriteTableOfContents()
+}
 
-	index := NewSimpleIndex(keys, offsets, table.indexFilename)
-	indexKeys, indexOffsets := index.WriteToFile()
-	WriteSummaryToFile(indexKeys, indexOffsets, table.summaryFilename)
-	writeBF(table.filterFilename, bloomFilter)
-	BuildMerkleTree(values, strings.ReplaceAll(table.dataFilename, "kv-system/data/sstable/", ""))
-	table.WriteTableOfContents()
+// tableFiles returns the table with the given number on a level of the keyspace stored in
+// directory, with the file names that flushes and compaction give it.
+func tableFiles(directory string, level, num int) *SSTable {
+	generalFilename := SSTableDirectory(directory) + "usertable-data-ic-" + strconv.Itoa(num) + "-lev" +
+		strconv.Itoa(level) + "-"
+	return &SSTable{generalFilename, generalFilename + "Data.db",
+		generalFilename + "Index.db", generalFilename + "Summary.db",
+		generalFilename + "Filter.gob", generalFilename + "RangeDel.db", level}
+}
+
+// files returns every file of the table, together with its Merkle tree in the metadata
+// directory of directory. The table of contents comes last.
+func (st *SSTable) files(directory string) []string {
+	return []string{st.dataFilename, st.indexFilename, st.summaryFilename, st.filterFilename,
+		st.rangeDelFilename, metadataFilename(directory, st.dataFilename), st.generalFilename + "TOC.txt"}
+}
 
-	return
+// remove deletes every file of the table.
+func (st *SSTable) remove(directory string) {
+	for _, filename := range st.files(directory) {
+		_ = os.Remove(filename)
+	}
 }
 
-func (st *SSTable) WriteTableOfContents() {
+func (st *SSTable) WriteTableOfContents() error {
 	filename := st.generalFilename + "TOC.txt"
 	file, err := os.Create(filename)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer file.Close()
 
 	writer := bufio.NewWriter(file)
 
-	writeLine(writer, st.dataFilename)
-	writeLine(writer, st.indexFilename)
-	writeLine(writer, st.summaryFilename)
-	writeLine(writer, st.filterFilename)
+	// File names are stored without their directory, so the data directory can be moved.
+	writeLine(writer, filepath.Base(st.dataFilename))
+	writeLine(writer, filepath.Base(st.indexFilename))
+	writeLine(writer, filepath.Base(st.summaryFilename))
+	writeLine(writer, filepath.Base(st.filterFilename))
+	writeLine(writer, filepath.Base(st.rangeDelFilename))
+	writeLine(writer, formatLinePrefix+strconv.Itoa(TableFormat))
 
-	err = writer.Flush()
-	if err != nil {
-		return
+	if err = writer.Flush(); err != nil {
+		return err
 	}
+	return syncAndClose(file)
 }
 
-func (st *SSTable) FindRecord(key string, offset int64) (found bool, value []byte, timestamp string) {
-	found = false
-	timestamp = ""
-
+// FindRecord reads records starting at offset until it reaches key. The kind tells
+// whether the record holds a value, a tombstone or merge operands.
+func (st *SSTable) FindRecord(key string, offset int64) (found bool, value []byte, timestamp string, kind byte, err error) {
 	file, err := os.Open(st.dataFilename)
 	if err != nil {
-		panic(err)
+		return false, nil, "", RecordValue, err
 	}
+	defer file.Close()
 
-	reader := bufio.NewReader(file)
-	fileLenBytes := make([]byte, 8)
-	_, err = reader.Read(fileLenBytes)
+	fileLenBytes, err := readBytes(bufio.NewReader(file), 8)
 	if err != nil {
-		panic(err)
+		return false, nil, "", RecordValue, readError(st.dataFilename, err)
 	}
 	fileLen := binary.LittleEndian.Uint64(fileLenBytes)
 
-	_, err = file.Seek(offset, 0)
-	if err != nil {
-		return false, nil, ""
-	}
-	reader = bufio.NewReader(file)
-
+	currentOffset := uint(offset)
 	for i := uint64(0); i < fileLen; i++ {
-		var deleted bool
-
-		// Read CRC
-		crcBytes := readBytes(reader, 4)
-		crcValue := binary.LittleEndian.Uint32(crcBytes)
-
-		// Read Timestamp
-		timestampBytes := readBytes(reader, 19)
-		timestamp = string(timestampBytes[:])
-
-		// Read Tombstone
-		tombstoneByte, err := reader.ReadByte()
+		var crcBytes []byte
+		var nodeKey, nodeValue string
+		crcBytes, timestamp, kind, _, _, nodeKey, nodeValue, currentOffset, err = readData(file, currentOffset)
 		if err != nil {
-			panic(err)
-		}
-		deleted = tombstoneByte == 1
-
-		// Read Key
-		keyLen := readVarUint(reader)
-		keyBytes := readBytes(reader, int(keyLen))
-		nodeKey := string(keyBytes[:])
-
-		if nodeKey == key {
-			found = true
+			return false, nil, "", RecordValue, err
 		}
 
-		// Read Value
-		valueLen := readVarUint(reader)
-		value = readBytes(reader, int(valueLen))
-
-		if found && !deleted && CRC32(value) == crcValue {
+		if nodeKey > key {
 			break
-		} else if found && deleted {
-			return false, nil, ""
+		}
+		if nodeKey == key {
+			if err = checkCRC(st.dataFilename, crcBytes, nodeKey, nodeValue); err != nil {
+				return false, nil, "", RecordValue, err
+			}
+			return true, []byte(nodeValue), timestamp, kind, nil
 		}
 	}
 
-	file.Close()
-	return found, value, timestamp
+	return false, nil, "", RecordValue, nil
 }
 
-func readSSTable(filename, level string) (table *SSTable) {
-	filename = "system/data/sstable/usertable-data-ic-" + filename + "-lev" + level + "-TOC.txt"
+func readSSTable(directory, filename, level string) (*SSTable, error) {
+	tablesDirectory := SSTableDirectory(directory)
+	filename = tablesDirectory + "usertable-data-ic-" + filename + "-lev" + level + "-TOC.txt"
 
 	file, err := os.Open(filename)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer file.Close()
 
 	reader := bufio.NewReader(file)
-
-	dataFilename := readLine(reader)
-	indexFilename := readLine(reader)
-	summaryFilename := readLine(reader)
-	filterFilename := readLine(reader)
+	filenames := make([]string, 4)
+	for i := range filenames {
+		line, err := readLine(reader)
+		if err != nil {
+			return nil, readError(filename, err)
+		}
+		filenames[i] = tablesDirectory + filepath.Base(line)
+	}
+	format, err := readTableFormat(reader, filename)
+	if err != nil {
+		return nil, err
+	}
+	if format == legacyTableFormat {
+		return nil, fmt.Errorf("%s: table format %d must be migrated by opening the store first", filename, format)
+	}
+	dataFilename, indexFilename, summaryFilename, filterFilename := filenames[0], filenames[1], filenames[2], filenames[3]
 	generalFilename := strings.ReplaceAll(dataFilename, "Data.db", "")
+	levelNum, _ := strconv.Atoi(level)
+
+	return &SSTable{
+		generalFilename:  generalFilename,
+		dataFilename:     dataFilename,
+		indexFilename:    indexFilename,
+		summaryFilename:  summaryFilename,
+		filterFilename:   filterFilename,
+		rangeDelFilename: generalFilename + "RangeDel.db",
+		level:            levelNum,
+	}, nil
+}
+
+// readTableFormat reads the rest of a table of contents and returns the format in it.
+func readTableFormat(reader *bufio.Reader, filename string) (int, error) {
+	for {
+		line, err := readLine(reader)
+		if errors.Is(err, io.EOF) {
+			return legacyTableFormat, nil
+		} else if err != nil {
+			return 0, err
+		}
+		if !strings.HasPrefix(line, formatLinePrefix) {
+			continue
+		}
+		format, err := strconv.Atoi(strings.TrimPrefix(line, formatLinePrefix))
+		if err != nil || format <= legacyTableFormat || format > TableFormat {
+			return 0, corrupted(filename, fmt.Errorf("unknown table format %q", line))
+		}
+		return format, nil
+	}
+}
 
-	table = &SSTable{
-		generalFilename: generalFilename,
-		dataFilename:    dataFilename,
-		indexFilename:   indexFilename,
-		summaryFilename: summaryFilename,
-		filterFilename:  filterFilename,
-	}
-
-	return
-}
-
-func (st *SSTable) QueryRecord(key string) (found bool, value []byte, timestamp string) {
-	found = false
-	value = nil
-	bf := readBF(st.filterFilename)
-	found = bf.Search(key)
-	if found {
-		found, offset := FindSummaryByKey(key, st.summaryFilename)
-		if found {
-			found, offset = SearchIndex(key, offset, st.indexFilename)
-			if found {
-				found, value, timestamp = st.FindRecord(key, offset)
-				if found {
-					return true, value, timestamp
+// dataFileFormat returns the format of a data file from the table of contents next to it.
+// A data file whose table of contents cannot be read is taken to be in TableFormat.
+func dataFileFormat(dataFilename string) int {
+	file, err := os.Open(strings.TrimSuffix(dataFilename, "Data.db") + "TOC.txt")
+	if err != nil {
+		return TableFormat
+	}
+	defer file.Close()
+	format, err := readTableFormat(bufio.NewReader(file), file.Name())
+	if err != nil {
+		return TableFormat
+	}
+	return format
+}
+
+// QueryRecord looks up key in the table. The probe of the filter is counted in stats,
+// which may be nil.
+func (st *SSTable) QueryRecord(key string, stats *BloomStats) (found bool, value []byte, timestamp string, kind byte, err error) {
+	bf, err := readFilter(st.filterFilename)
+	if err != nil {
+		return false, nil, "", RecordValue, err
+	}
+	if !bf.Search(key) {
+		stats.record(st.level, false, false)
+		return false, nil, "", RecordValue, nil
+	}
+	found, offset, err := FindSummaryByKey(key, st.summaryFilename)
+	if err == nil && found {
+		found, offset, err = SearchIndex(key, offset, st.indexFilename)
+	}
+	if err == nil && found {
+		found, value, timestamp, kind, err = st.FindRecord(key, offset)
+	}
+	if err != nil {
+		return false, nil, "", RecordValue, err
+	}
+	stats.record(st.level, true, found)
+	return found, value, timestamp, kind, nil
+}
+
+func findSSTableFilename(directory, level string) (string, error) {
+	for filenameNum := 1; ; filenameNum++ {
+		filename := strconv.Itoa(filenameNum)
+		possibleFilename := SSTableDirectory(directory) + "usertable-data-ic-" + filename + "-lev" + level + "-TOC.txt"
+		_, err := os.Stat(possibleFilename)
+		if errors.Is(err, os.ErrNotExist) {
+			return filename, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+}
+
+// TableCount returns how many tables a level has. Tables are numbered from 1 and a higher number is newer.
+func TableCount(directory string, levelNum int) (int, error) {
+	filename, err := findSSTableFilename(directory, strconv.Itoa(levelNum))
+	if err != nil {
+		return 0, err
+	}
+	next, _ := strconv.Atoi(filename)
+	return next - 1, nil
+}
+
+// SearchThroughSSTables looks for key from the newest table to the oldest one: level 1
+// before deeper levels and higher table numbers before lower ones. Merge operands that
+// are found on the way are folded on top of the first value or tombstone below them. A key
+// whose version is in a quarantined table fails with ErrCorruption. Probes of the bloom
+// filters are counted in stats, which may be nil.
+func SearchThroughSSTables(directory, key string, maxLevels int, operators MergeOperators,
+	stats *BloomStats) (found bool, value []byte, err error) {
+	var operands [][]byte
+	for levelNum := 1; levelNum <= maxLevels; levelNum++ {
+		count, err := TableCount(directory, levelNum)
+		if err != nil {
+			return false, nil, err
+		}
+		for filenameNum := count; filenameNum > 0; filenameNum-- {
+			table, err := readSSTable(directory, strconv.Itoa(filenameNum), strconv.Itoa(levelNum))
+			if err != nil {
+				return false, nil, err
+			}
+			ok, data, _, kind, err := table.QueryRecord(key, stats)
+			if err != nil {
+				return false, nil, err
+			}
+			if ok {
+				switch kind {
+				case RecordTombstone:
+					found, value = resolveOperands(operators, nil, false, operands)
+					return found, value, nil
+				case RecordMerge:
+					operands = append(DecodeOperands(data), operands...)
+				case RecordQuarantined:
+					return false, nil, quarantinedKeyError(key, string(data))
+				default:
+					found, value = resolveOperands(operators, data, true, operands)
+					return found, value, nil
 				}
 			}
+			// Records of a table are newer than its range tombstones, so those are checked last.
+			// They hide older values below the merge operands of the table too.
+			rangeTombstones, err := readRangeTombstones(table.rangeDelFilename)
+			if err != nil {
+				return false, nil, err
+			}
+			if coveredByAny(key, rangeTombstones) {
+				found, value = resolveOperands(operators, nil, false, operands)
+				return found, value, nil
+			}
 		}
 	}
-	return false, nil, ""
+	found, value = resolveOperands(operators, nil, false, operands)
+	return found, value, nil
 }
 
-func findSSTableFilename(level string) (filename string) {
-	filenameNum := 1
-	filename = strconv.Itoa(filenameNum)
-	possibleFilename := "./system/data/sstable/usertable-data-ic-" + filename + "-lev" + level + "-TOC.txt"
+// ScanSSTables returns the visible values of keys in [start, end) stored in SSTables; an
+// empty end has no bound. Range tombstones passed in come from newer data (the memory table)
+// and hide keys in every table. If all keys in the range start with prefix, tables whose
+// filter rules the prefix out are not read; an empty prefix reads every table. Keys whose
+// version is in a quarantined table are returned in quarantined, with the error to read them.
+func ScanSSTables(directory, start, end, prefix string, maxLevels int, operators MergeOperators,
+	rangeTombstones []RangeTombstone) (values map[string][]byte, quarantined map[string]error, err error) {
+	keys := make(map[string]*pendingRecord)
+	for levelNum := 1; levelNum <= maxLevels; levelNum++ {
+		count, err := TableCount(directory, levelNum)
+		if err != nil {
+			return nil, nil, err
+		}
+		for filenameNum := count; filenameNum > 0; filenameNum-- {
+			table, err := readSSTable(directory, strconv.Itoa(filenameNum), strconv.Itoa(levelNum))
+			if err != nil {
+				return nil, nil, err
+			}
+			records, err := table.scanRecordsWithPrefix(start, end, prefix)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, record := range records {
+				state, ok := keys[record.Key]
+				if !ok {
+					state = &pendingRecord{}
+					keys[record.Key] = state
+				}
+				if state.done {
+					continue
+				}
+				if coveredByAny(record.Key, rangeTombstones) {
+					state.done = true
+					continue
+				}
+				state.apply(record)
+			}
+			tableRangeTombstones, err := readRangeTombstones(table.rangeDelFilename)
+			if err != nil {
+				return nil, nil, err
+			}
+			rangeTombstones = append(rangeTombstones, tableRangeTombstones...)
+		}
+	}
+
+	values, quarantined = make(map[string][]byte), make(map[string]error)
+	for key, state := range keys {
+		if state.quarantined != "" {
+			quarantined[key] = quarantinedKeyError(key, state.quarantined)
+		} else if found, value := state.resolve(operators); found {
+			values[key] = value
+		}
+	}
+	return values, quarantined, nil
+}
 
-	for {
-		_, err := os.Stat(possibleFilename)
-		if err == nil {
-			filenameNum += 1
-			filename = strconv.Itoa(filenameNum)
-		} else if errors.Is(err, os.ErrNotExist) {
-			return
+// MultiSearchThroughSSTables is SearchThroughSSTables for many keys at once. Keys must be
+// sorted. Every table's filter, summary, index and data file is read once for all keys.
+// If a key's version is in a quarantined table, the search fails with ErrCorruption.
+func MultiSearchThroughSSTables(directory string, keys []string, maxLevels int, operators MergeOperators,
+	stats *BloomStats) (found []bool, values [][]byte, err error) {
+	states := make([]pendingRecord, len(keys))
+	for levelNum := 1; levelNum <= maxLevels; levelNum++ {
+		count, err := TableCount(directory, levelNum)
+		if err != nil {
+			return nil, nil, err
 		}
-		possibleFilename = "./system/data/sstable/usertable-data-ic-" + filename + "-lev" + level + "-TOC.txt"
-	}
-
-}
-
-func SearchThroughSSTables(key string, maxLevels int) (found bool, oldValue []byte) {
-	oldTimestamp := ""
-	found = false
-	levelNum := maxLevels
-	for ; levelNum >= 1; levelNum-- {
-		level := strconv.Itoa(levelNum)
-		maxFilename := findSSTableFilename(level)
-		maxFilenameNum, _ := strconv.Atoi(maxFilename)
-		filenameNum := maxFilenameNum - 1
-		for ; filenameNum > 0; filenameNum-- {
-			filename := strconv.Itoa(filenameNum)
-			table := readSSTable(filename, level)
-			found, value, timestamp := table.QueryRecord(key)
-			if oldTimestamp == "" && found {
-				oldTimestamp = timestamp
-				found = true
-				oldValue = value
-			} else if oldTimestamp != "" && found {
-				if timestamp > oldTimestamp {
-					oldValue = value
-					found = true
+		for filenameNum := count; filenameNum > 0; filenameNum-- {
+			pendingKeys := make([]string, 0, len(keys))
+			for i, key := range keys {
+				if !states[i].done {
+					pendingKeys = append(pendingKeys, key)
+				}
+			}
+			if len(pendingKeys) == 0 {
+				break
+			}
+
+			table, err := readSSTable(directory, strconv.Itoa(filenameNum), strconv.Itoa(levelNum))
+			if err != nil {
+				return nil, nil, err
+			}
+			records, err := table.QueryRecords(pendingKeys, stats)
+			if err != nil {
+				return nil, nil, err
+			}
+			rangeTombstones, err := readRangeTombstones(table.rangeDelFilename)
+			if err != nil {
+				return nil, nil, err
+			}
+			for i, key := range keys {
+				if states[i].done {
+					continue
+				}
+				if record, ok := records[key]; ok {
+					states[i].apply(record)
+				}
+				if !states[i].done && coveredByAny(key, rangeTombstones) {
+					states[i].done = true
 				}
 			}
 		}
 	}
-	return
+
+	found = make([]bool, len(keys))
+	values = make([][]byte, len(keys))
+	for i := range states {
+		if states[i].quarantined != "" {
+			return nil, nil, quarantinedKeyError(keys[i], states[i].quarantined)
+		}
+		found[i], values[i] = states[i].resolve(operators)
+	}
+	return found, values, nil
 }
 
-// Helper functions
+// QueryRecords looks up sorted keys in the table. The filter and summary are read once,
+// the index is walked in a single pass and the data file is opened once. The probes of
+// the filter are counted in stats, which may be nil.
+func (st *SSTable) QueryRecords(keys []string, stats *BloomStats) (map[string]Element, error) {
+	bf, err := readFilter(st.filterFilename)
+	if err != nil {
+		return nil, err
+	}
+	firstKey, lastKey, summaryKeys, summaryOffsets, err := readSummary(st.summaryFilename)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if key < firstKey || key > lastKey {
+			continue
+		}
+		if bf.Search(key) {
+			candidates = append(candidates, key)
+		} else {
+			stats.record(st.level, false, false)
+		}
+	}
 
-func writeVarUint(writer *bufio.Writer, value uint64) (written uint) {
-	bytes := make([]byte, 0, 10)
-	for value >= 0x80 {
-		bytes = append(bytes, byte(value|0x80))
-		value >>= 7
+	records, err := st.queryCandidates(candidates, summaryKeys, summaryOffsets)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range candidates {
+		_, found := records[key]
+		stats.record(st.level, true, found)
 	}
-	bytes = append(bytes, byte(value))
-	written, _ = writer.Write(bytes)
-	return
+	return records, nil
 }
 
-func writeBytes(writer *bufio.Writer, data []byte) (written uint) {
-	written, _ = writer.Write(data)
-	return
+// queryCandidates reads the records of sorted keys that passed the filter of the table.
+func (st *SSTable) queryCandidates(candidates, summaryKeys []string, summaryOffsets []int64) (map[string]Element, error) {
+	records := make(map[string]Element)
+	if len(candidates) == 0 {
+		return records, nil
+	}
+
+	startOffset := int64(8)
+	for i, summaryKey := range summaryKeys {
+		if summaryKey <= candidates[0] {
+			startOffset = summaryOffsets[i]
+		}
+	}
+	dataOffsets, err := searchIndexKeys(candidates, startOffset, st.indexFilename)
+	if err != nil || len(dataOffsets) == 0 {
+		return records, err
+	}
+
+	file, err := os.Open(st.dataFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	for _, key := range candidates {
+		offset, ok := dataOffsets[key]
+		if !ok {
+			continue
+		}
+		crcBytes, timestamp, kind, _, _, nodeKey, value, _, err := readData(file, uint(offset))
+		if err != nil {
+			return nil, err
+		}
+		if nodeKey != key {
+			continue
+		}
+		if err = checkCRC(st.dataFilename, crcBytes, nodeKey, value); err != nil {
+			return nil, err
+		}
+		records[key] = Element{
+			Timestamp:   timestamp,
+			Tombstone:   kind == RecordTombstone,
+			Merge:       kind == RecordMerge,
+			Quarantined: kind == RecordQuarantined,
+			Key:         key,
+			Value:       []byte(value),
+		}
+	}
+	return records, nil
 }
 
-func readVarUint(reader *bufio.Reader) (value uint64) {
-	shift := uint(0)
-	for {
-		b, err := reader.ReadByte()
-		if err == io.EOF {
-			break
+// pendingRecord collects the versions of a key while tables are searched from the newest one.
+type pendingRecord struct {
+	done        bool
+	found       bool
+	value       []byte
+	operands    [][]byte
+	quarantined string // the quarantine marker that was reached
+}
+
+func (state *pendingRecord) apply(record Element) {
+	switch record.Kind() {
+	case RecordTombstone:
+		state.done = true
+	case RecordMerge:
+		state.operands = append(DecodeOperands(record.Value), state.operands...)
+	case RecordQuarantined:
+		state.done, state.quarantined = true, string(record.Value)
+	default:
+		state.done, state.found, state.value = true, true, record.Value
+	}
+}
+
+func (state *pendingRecord) resolve(operators MergeOperators) (bool, []byte) {
+	return resolveOperands(operators, state.value, state.found, state.operands)
+}
+
+// ScanRecords reads every record of the table with a key in [start, end).
+// scanRecordsWithPrefix is ScanRecords that first asks the filter of the table whether it
+// may hold keys starting with prefix.
+func (st *SSTable) scanRecordsWithPrefix(start, end, prefix string) ([]Element, error) {
+	if prefix != "" {
+		bf, err := readFilter(st.filterFilename)
+		if err != nil {
+			return nil, err
+		}
+		if !bf.SearchPrefix(prefix) {
+			return nil, nil
 		}
+	}
+	return st.ScanRecords(start, end)
+}
+
+func (st *SSTable) ScanRecords(start, end string) ([]Element, error) {
+	file, err := os.Open(st.dataFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	fileLenBytes, err := readBytes(bufio.NewReader(file), 8)
+	if err != nil {
+		return nil, readError(st.dataFilename, err)
+	}
+	fileLen := binary.LittleEndian.Uint64(fileLenBytes)
+
+	records := make([]Element, 0)
+	offset := uint(8)
+	for i := uint64(0); i < fileLen; i++ {
+		var crcBytes []byte
+		var timestamp, key, value string
+		var kind byte
+		crcBytes, timestamp, kind, _, _, key, value, offset, err = readData(file, offset)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-		value |= uint64(b&0x7F) << shift
-		shift += 7
-		if b&0x80 == 0 {
+		if end != "" && key >= end {
 			break
 		}
+		if key >= start {
+			if err = checkCRC(st.dataFilename, crcBytes, key, value); err != nil {
+				return nil, err
+			}
+			records = append(records, Element{
+				Timestamp:   timestamp,
+				Tombstone:   kind == RecordTombstone,
+				Merge:       kind == RecordMerge,
+				Quarantined: kind == RecordQuarantined,
+				Key:         key,
+				Value:       []byte(value),
+			})
+		}
+	}
+	return records, nil
+}
+
+func resolveOperands(operators MergeOperators, base []byte, found bool, operands [][]byte) (bool, []byte) {
+	if len(operands) == 0 {
+		return found, base
 	}
-	return
+	return true, operators.Fold(base, operands)
 }
 
-func readBytes(reader *bufio.Reader, length int) (data []byte) {
-	data = make([]byte, length)
+// Helper functions
+
+// checkCRC returns ErrCorruption if the value of a record does not match its checksum.
+func checkCRC(filename string, crcBytes []byte, key, value string) error {
+	if CRC32([]byte(value)) != binary.LittleEndian.Uint32(crcBytes) {
+		return corrupted(filename, errors.New("checksum mismatch for key "+key))
+	}
+	return nil
+}
+
+// corrupted wraps err, found in filename, as ErrCorruption.
+func corrupted(filename string, err error) error {
+	return fmt.Errorf("%w: %s: %v", ErrCorruption, filename, err)
+}
+
+// readError wraps an error from reading filename. A file that ends in the middle
+// of a record is corrupted.
+func readError(filename string, err error) error {
+	if errors.Is(err, errInvalidLength) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return corrupted(filename, err)
+	}
+	return fmt.Errorf("%s: %w", filename, err)
+}
+
+// syncAndClose makes sure a written file is on disk before it is closed.
+func syncAndClose(file *os.File) error {
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func writeBytes(writer *bufio.Writer, data []byte) (written uint) {
+	n, _ := writer.Write(data)
+	return uint(n)
+}
+
+// readBytes reads exactly length bytes. Lengths that no record can have come from
+// corrupted length fields and are refused before anything is allocated.
+func readBytes(reader *bufio.Reader, length int) ([]byte, error) {
+	if length < 0 || length > maxFieldLength {
+		return nil, fmt.Errorf("%w %d", errInvalidLength, length)
+	}
+	data := make([]byte, length)
 	_, err := io.ReadFull(reader, data)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	return
+	return data, nil
 }
 
 func writeLine(writer *bufio.Writer, line string) {
-	_, err := writer.WriteString(line + "\n")
-	if err != nil {
-		panic(err)
-	}
+	_, _ = writer.WriteString(line + "\n")
 }
 
-func readLine(reader *bufio.Reader) (line string) {
+func readLine(reader *bufio.Reader) (string, error) {
 	line, err := reader.ReadString('\n')
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	return strings.TrimSpace(line)
+	return strings.TrimSpace(line), nil
 }
@@ -0,0 +1,157 @@
+package structures
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+)
+
+// writeLegacyTable writes table 1 of level 1 the way flushes wrote it before records had a
+// kind: varint lengths, a tombstone byte and a table of contents without a format.
+func writeLegacyTable(t *testing.T, directory string) {
+	t.Helper()
+	for _, dir := range []string{SSTableDirectory(directory), MetadataDirectory(directory)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	table := tableFiles(directory, 1, 1)
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, 2)
+	for _, record := range []struct {
+		key, value string
+		tombstone  byte
+	}{{"a", "first", 0}, {"b", "second", 1}} {
+		data = binary.LittleEndian.AppendUint32(data, CRC32([]byte(record.value)))
+		data = append(data, "2024-01-01 00:00:00"...)
+		data = append(data, record.tombstone)
+		data = binary.AppendUvarint(data, uint64(len(record.key)))
+		data = append(data, record.key...)
+		data = binary.AppendUvarint(data, uint64(len(record.value)))
+		data = append(data, record.value...)
+	}
+	if err := os.WriteFile(table.dataFilename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	toc := table.dataFilename + "\n" + table.indexFilename + "\n" + table.summaryFilename + "\n" +
+		table.filterFilename + "\n"
+	if err := os.WriteFile(table.generalFilename+"TOC.txt", []byte(toc), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLegacyTableIsMigratedToTableFormat(t *testing.T) {
+	directory := t.TempDir() + "/"
+	writeLegacyTable(t, directory)
+	options := TableOptions{Operators: NewMergeOperators()}
+	if _, _, err := SearchThroughSSTables(directory, "a", 1, options.Operators, nil); err == nil {
+		t.Fatal("a table in the legacy format was read without a migration")
+	}
+
+	tagged := 0
+	err := TagLegacyTables(directory, 1, TypeRaw, options, nil, func(string) error {
+		tagged++
+		return nil
+	})
+	if err != nil || tagged != 1 {
+		t.Fatalf("migrated %d tables, %v", tagged, err)
+	}
+	if format := dataFileFormat(tableFiles(directory, 1, 1).dataFilename); format != TableFormat {
+		t.Errorf("migrated table has format %d", format)
+	}
+	found, value, err := SearchThroughSSTables(directory, "a", 1, options.Operators, nil)
+	if err != nil || !found || string(value) != string(EncodeValue(TypeRaw, []byte("first"))) {
+		t.Errorf("a is %v %q, %v after the migration", found, value, err)
+	}
+	if found, _, err = SearchThroughSSTables(directory, "b", 1, options.Operators, nil); err != nil || found {
+		t.Errorf("deleted b is %v, %v after the migration", found, err)
+	}
+}
+
+func TestUnknownTableFormatIsCorruption(t *testing.T) {
+	directory := t.TempDir() + "/"
+	writeLegacyTable(t, directory)
+	toc := tableFiles(directory, 1, 1).generalFilename + "TOC.txt"
+	file, err := os.OpenFile(toc, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = file.WriteString("format 99\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = readSSTable(directory, "1", "1"); !errors.Is(err, ErrCorruption) {
+		t.Errorf("table of a newer format gives %v", err)
+	}
+}
+
+func TestTruncatedTableFilesAreCorruption(t *testing.T) {
+	for _, file := range []string{"Data.db", "Index.db", "Summary.db", "Filter.gob", "RangeDel.db"} {
+		mt := NewMemoryTable(5, 100, 80)
+		for _, key := range []string{"a", "b", "c"} {
+			mt.Insert(key, EncodeValue(TypeRaw, []byte(key+"-value")), false)
+		}
+		mt.DeleteRange("x", "y")
+		directory := writeTestTable(t, mt)
+		filename := tableFiles(directory, 1, 1).generalFilename + file
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(filename, data[:len(data)/2], 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		errs := 0
+		for _, key := range []string{"a", "b", "c", "xa"} {
+			if _, _, err = SearchThroughSSTables(directory, key, 1, nil, nil); err != nil {
+				errs++
+				if !errors.Is(err, ErrCorruption) {
+					t.Errorf("truncated %s: search of %s gives %v", file, key, err)
+				}
+			}
+		}
+		if errs == 0 {
+			t.Errorf("truncated %s: every key was read", file)
+		}
+		// Scans read only the data file and the range tombstones.
+		_, _, err = ScanSSTables(directory, "", "z", "", 1, nil, nil)
+		if (file == "Data.db" || file == "RangeDel.db") && !errors.Is(err, ErrCorruption) {
+			t.Errorf("truncated %s: scan gives %v", file, err)
+		}
+	}
+}
+
+func TestFiltersUseTheRateOfTheirLevel(t *testing.T) {
+	options := TableOptions{BloomFalsePositive: []float64{0.2, 0.001}}
+	mt := NewMemoryTable(5, 100, 80)
+	mt.Insert("a", EncodeValue(TypeRaw, []byte("a")), false)
+	directory := t.TempDir() + "/"
+	for _, dir := range []string{SSTableDirectory(directory), MetadataDirectory(directory)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mt.PerformFlush(directory, options); err != nil {
+		t.Fatal(err)
+	}
+	filter, err := readFilter(tableFiles(directory, 1, 1).filterFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bf, ok := filter.(*BloomFilter); !ok || bf.P != 0.2 {
+		t.Errorf("filter of level 1 is %+v, want a rate of 0.2", filter)
+	}
+	for level, want := range map[int]float64{2: 0.001, 5: 0.001} {
+		bf, err := DeserializeBF(buildFilter([]string{"a", "b"}, options, level))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bf.P != want {
+			t.Errorf("filter of level %d has a rate of %v, want %v", level, bf.P, want)
+		}
+	}
+}
@@ -3,103 +3,89 @@ package structures
 import (
 	"bufio"
 	"encoding/binary"
-	"log"
 	"os"
 )
 
 // FindSummaryByKey searches for a summary in a file by a given key,
 // returning a boolean indicating whether the key is found and the associated offset.
-func FindSummaryByKey(targetKey, filename string) (found bool, offset int64) {
-	found = false
-	offset = int64(8)
-
-	file, err := os.Open(filename)
+func FindSummaryByKey(targetKey, filename string) (found bool, offset int64, err error) {
+	firstKey, lastKey, keys, offsets, err := readSummary(filename)
 	if err != nil {
-		panic(err)
+		return false, 0, err
 	}
-	defer file.Close()
-
-	reader := bufio.NewReader(file)
-
-	fileLengthBytes := make([]byte, 8)
-	_, err = reader.Read(fileLengthBytes)
-	if err != nil {
-		panic(err)
+	if targetKey < firstKey || targetKey > lastKey {
+		return false, 0, nil
 	}
-	fileLength := binary.LittleEndian.Uint64(fileLengthBytes)
 
-	startKeyLengthBytes := make([]byte, 8)
-	_, err = reader.Read(startKeyLengthBytes)
-	if err != nil {
-		panic(err)
+	offset = int64(8)
+	for i, nodeKey := range keys {
+		if nodeKey > targetKey {
+			break
+		}
+		offset = offsets[i]
 	}
-	startKeyLength := binary.LittleEndian.Uint64(startKeyLengthBytes)
+	return true, offset, nil
+}
 
-	startKeyBytes := make([]byte, startKeyLength)
-	_, err = reader.Read(startKeyBytes)
+// readSummary reads the whole summary: the key range of the table and the sampled
+// index keys with their offsets in the index file.
+func readSummary(filename string) (firstKey, lastKey string, keys []string, offsets []int64, err error) {
+	file, err := os.Open(filename)
 	if err != nil {
-		panic(err)
+		return "", "", nil, nil, err
 	}
-	firstKey := string(startKeyBytes[:])
+	defer file.Close()
 
-	if targetKey < firstKey {
-		return false, 0
+	reader := bufio.NewReader(file)
+	readUint := func() (uint64, error) {
+		data, err := readBytes(reader, 8)
+		if err != nil {
+			return 0, readError(filename, err)
+		}
+		return binary.LittleEndian.Uint64(data), nil
 	}
-
-	endKeyLengthBytes := make([]byte, 8)
-	_, err = reader.Read(endKeyLengthBytes)
-	if err != nil {
-		panic(err)
+	readKey := func() (string, error) {
+		keyLength, err := readUint()
+		if err != nil {
+			return "", err
+		}
+		data, err := readBytes(reader, int(keyLength))
+		if err != nil {
+			return "", readError(filename, err)
+		}
+		return string(data), nil
 	}
-	endKeyLength := binary.LittleEndian.Uint64(endKeyLengthBytes)
 
-	endKeyBytes := make([]byte, endKeyLength)
-	_, err = reader.Read(endKeyBytes)
-	if err != nil {
-		panic(err)
+	fileLength, err := readUint()
+	if err != nil || fileLength < 2 {
+		return "", "", nil, nil, err
 	}
-	lastKey := string(endKeyBytes[:])
-
-	if targetKey > lastKey {
-		return false, 0
+	if firstKey, err = readKey(); err != nil {
+		return "", "", nil, nil, err
 	}
-
-	found = true
-	var i uint64
-	for i = 0; i < fileLength-2; i++ {
-		keyBytes := make([]byte, 8)
-		_, err = reader.Read(keyBytes)
+	if lastKey, err = readKey(); err != nil {
+		return "", "", nil, nil, err
+	}
+	for i := uint64(2); i < fileLength; i++ {
+		key, err := readKey()
 		if err != nil {
-			panic(err)
+			return "", "", nil, nil, err
 		}
-		nodeKeyLength := binary.LittleEndian.Uint64(keyBytes)
-
-		nodeKeyBytes := make([]byte, nodeKeyLength)
-		_, err = reader.Read(nodeKeyBytes)
+		offset, err := readUint()
 		if err != nil {
-			panic(err)
-		}
-		nodeKey := string(nodeKeyBytes[:])
-
-		if nodeKey <= targetKey {
-			offsetBytes := make([]byte, 8)
-			_, err = reader.Read(offsetBytes)
-			if err != nil {
-				panic(err)
-			}
-			offset = int64(binary.LittleEndian.Uint64(offsetBytes))
-		} else {
-			break
+			return "", "", nil, nil, err
 		}
+		keys = append(keys, key)
+		offsets = append(offsets, int64(offset))
 	}
-	return
+	return firstKey, lastKey, keys, offsets, nil
 }
 
 // WriteSummaryToFile creates a summary file with provided keys and corresponding offsets.
-func WriteSummaryToFile(keys []string, offsets []uint, filename string) {
+func WriteSummaryToFile(keys []string, offsets []uint, filename string) error {
 	file, err := os.Create(filename)
 	if err != nil {
-		return
+		return err
 	}
 	defer file.Close()
 
@@ -108,10 +94,7 @@ func WriteSummaryToFile(keys []string, offsets []uint, filename string) {
 	fileLength := uint64(len(keys))
 	fileLengthBytes := make([]byte, 8)
 	binary.LittleEndian.PutUint64(fileLengthBytes, fileLength)
-	_, err = writer.Write(fileLengthBytes)
-	if err != nil {
-		log.Fatal(err)
-	}
+	writeBytes(writer, fileLengthBytes)
 
 	for i := range keys {
 		key := keys[i]
@@ -122,28 +105,18 @@ func WriteSummaryToFile(keys []string, offsets []uint, filename string) {
 		keyLength := uint64(len(keyBytes))
 		keyLengthBytes := make([]byte, 8)
 		binary.LittleEndian.PutUint64(keyLengthBytes, keyLength)
-		_, err := writer.Write(keyLengthBytes)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		_, err = writer.Write(keyBytes)
-		if err != nil {
-			log.Fatal(err)
-		}
+		writeBytes(writer, keyLengthBytes)
+		writeBytes(writer, keyBytes)
 
 		if i >= 2 {
 			offsetBytes := make([]byte, 8)
 			binary.LittleEndian.PutUint64(offsetBytes, uint64(offset))
-			_, err = writer.Write(offsetBytes)
-			if err != nil {
-				log.Fatal(err)
-			}
+			writeBytes(writer, offsetBytes)
 		}
+	}
 
-		err = writer.Flush()
-		if err != nil {
-			return
-		}
+	if err = writer.Flush(); err != nil {
+		return err
 	}
+	return syncAndClose(file)
 }
@@ -0,0 +1,96 @@
+package structures
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// FilterType selects the filter written for new SSTables.
+type FilterType byte
+
+const (
+	FilterBloom FilterType = iota
+	FilterCuckoo
+)
+
+// ParseFilterType returns the filter type with the given name. An empty name means a bloom filter.
+func ParseFilterType(name string) (FilterType, error) {
+	switch strings.ToLower(name) {
+	case "", "bloom":
+		return FilterBloom, nil
+	case "cuckoo":
+		return FilterCuckoo, nil
+	}
+	return FilterBloom, fmt.Errorf("unknown filter type %q", name)
+}
+
+// tableFilter is the filter of an SSTable, which tells whether the table may hold a key.
+type tableFilter interface {
+	Search(key string) bool
+	SearchPrefix(prefix string) bool
+}
+
+// buildFilter returns the contents of the filter file of a table on a level with the given
+// keys. Bloom filters are sized for the false-positive rate of the level; cuckoo filters
+// have a fixed fingerprint size, so the rate does not change them.
+func buildFilter(keys []string, options TableOptions, level int) []byte {
+	prefixes := options.Prefix.distinctPrefixes(keys)
+	if options.Filter == FilterCuckoo {
+		filter := CreateCuckooFilter(uint(len(keys) + len(prefixes)))
+		filter.Prefix = options.Prefix
+		for _, key := range keys {
+			filter.Add(key)
+		}
+		for _, prefix := range prefixes {
+			filter.AddPrefix(prefix)
+		}
+		return filter.SerializeCF()
+	}
+
+	filter := CreateBF(uint(len(keys)+len(prefixes)), options.bloomFalsePositive(level))
+	filter.Prefix = options.Prefix
+	for _, key := range keys {
+		filter.Add(Element{Key: key})
+	}
+	for _, prefix := range prefixes {
+		filter.AddPrefix(prefix)
+	}
+	return filter.SerializeBF()
+}
+
+// readFilter reads the filter file of a table, whichever filter it holds. Files that start
+// with neither magic are bloom filters written with gob by older versions.
+func readFilter(filename string) (tableFilter, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var filter tableFilter
+	switch {
+	case bytes.HasPrefix(data, cuckooFilterMagic):
+		filter, err = DeserializeCF(data)
+	case bytes.HasPrefix(data, bloomFilterMagic):
+		filter, err = DeserializeBF(data)
+	default:
+		filter, err = deserializeLegacyBF(data)
+	}
+	if err != nil {
+		return nil, corrupted(filename, err)
+	}
+	return filter, nil
+}
+
+func writeFilter(filename string, data []byte) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+	return syncAndClose(f)
+}
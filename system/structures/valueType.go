@@ -0,0 +1,63 @@
+package structures
+
+import "fmt"
+
+// ValueType tells what kind of object a stored value holds.
+type ValueType byte
+
+const (
+	TypeRaw ValueType = iota
+	TypeHLL
+	TypeCMS
+	TypeBloomFilter
+	TypeSimHash
+	TypeCounter
+	TypeCuckooFilter
+)
+
+func (vt ValueType) String() string {
+	switch vt {
+	case TypeRaw:
+		return "raw value"
+	case TypeHLL:
+		return "HyperLogLog"
+	case TypeCMS:
+		return "CountMinSketch"
+	case TypeBloomFilter:
+		return "BloomFilter"
+	case TypeSimHash:
+		return "SimHash"
+	case TypeCounter:
+		return "counter"
+	case TypeCuckooFilter:
+		return "CuckooFilter"
+	}
+	return fmt.Sprintf("ValueType(%d)", byte(vt))
+}
+
+// EncodeValue prefixes data with its type tag. Memory tables, the WAL and SSTables
+// all hold values in this form.
+func EncodeValue(valueType ValueType, data []byte) []byte {
+	stored := make([]byte, 0, 1+len(data))
+	stored = append(stored, byte(valueType))
+	return append(stored, data...)
+}
+
+// DecodeValue splits a stored value into its type tag and data.
+func DecodeValue(stored []byte) (ValueType, []byte) {
+	if len(stored) == 0 {
+		return TypeRaw, stored
+	}
+	return ValueType(stored[0]), stored[1:]
+}
+
+// TypeMismatchError is returned when a key holds a different type than the caller asked for.
+type TypeMismatchError struct {
+	Key      string
+	Expected ValueType
+	Actual   ValueType
+}
+
+func (err *TypeMismatchError) Error() string {
+	return fmt.Sprintf("key %q holds a %s, not a %s", err.Key, err.Actual, err.Expected)
+}
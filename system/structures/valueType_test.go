@@ -0,0 +1,26 @@
+package structures
+
+import (
+	"testing"
+)
+
+func TestValueRoundTrip(t *testing.T) {
+	for _, valueType := range []ValueType{TypeRaw, TypeHLL, TypeCounter, TypeCuckooFilter} {
+		for _, data := range [][]byte{{}, []byte("hll-prefixed")} {
+			decodedType, decoded := DecodeValue(EncodeValue(valueType, data))
+			if decodedType != valueType || string(decoded) != string(data) {
+				t.Errorf("%s %q decodes to %s %q", valueType, data, decodedType, decoded)
+			}
+		}
+	}
+	if valueType, data := DecodeValue(nil); valueType != TypeRaw || len(data) != 0 {
+		t.Errorf("empty value decodes to %s %q", valueType, data)
+	}
+}
+
+func TestTypeMismatchError(t *testing.T) {
+	err := &TypeMismatchError{Key: "k", Expected: TypeCMS, Actual: ValueType(42)}
+	if want := `key "k" holds a ValueType(42), not a CountMinSketch`; err.Error() != want {
+		t.Errorf("error is %q, want %q", err.Error(), want)
+	}
+}
@@ -0,0 +1,192 @@
+package structures
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// TableReport is the result of checking one SSTable against the checksums of its records
+// and the Merkle tree in its metadata file. Record and leaf indexes count from 0.
+type TableReport struct {
+	Table   string // data file of the table
+	Records uint64 // records that could be read
+	// FirstBadChecksum is the first record whose value does not match its CRC, or -1.
+	FirstBadChecksum int
+	// FirstBadLeaf is the first Merkle leaf that differs from the stored tree, or -1.
+	FirstBadLeaf int
+	// RootMismatch is set when the recomputed root differs from the stored one. With no
+	// bad leaf, the metadata file itself is damaged.
+	RootMismatch bool
+	// MissingMetadata is set for tables without a metadata file; only their checksums are checked.
+	MissingMetadata bool
+	// Err is set when the data or metadata file cannot be read to the end.
+	Err error
+}
+
+// Corrupted reports whether any check of the table failed.
+func (r TableReport) Corrupted() bool {
+	return r.Err != nil || r.FirstBadChecksum >= 0 || r.FirstBadLeaf >= 0 || r.RootMismatch
+}
+
+// Problem describes what is wrong with a corrupted table.
+func (r TableReport) Problem() string {
+	problems := make([]string, 0)
+	if r.Err != nil {
+		problems = append(problems, r.Err.Error())
+	}
+	if r.FirstBadChecksum >= 0 {
+		problems = append(problems, "bad checksum of record "+strconv.Itoa(r.FirstBadChecksum))
+	}
+	if r.FirstBadLeaf >= 0 {
+		problems = append(problems, "bad Merkle leaf "+strconv.Itoa(r.FirstBadLeaf))
+	} else if r.RootMismatch {
+		problems = append(problems, "bad Merkle root")
+	}
+	return strings.Join(problems, "; ")
+}
+
+// VerifySSTables checks every SSTable of the keyspace stored in directory. Corruption is
+// described in the reports; an error is returned only if the files cannot be read at all.
+func VerifySSTables(directory string, maxLevels int) ([]TableReport, error) {
+	reports := make([]TableReport, 0)
+	for levelNum := 1; levelNum <= maxLevels; levelNum++ {
+		count, err := TableCount(directory, levelNum)
+		if err != nil {
+			return nil, err
+		}
+		for tableNum := 1; tableNum <= count; tableNum++ {
+			report, err := VerifyTable(directory, levelNum, tableNum)
+			if err != nil {
+				return nil, err
+			}
+			reports = append(reports, report)
+		}
+	}
+	return reports, nil
+}
+
+// VerifyTable checks the table with the given number on a level.
+func VerifyTable(directory string, level, num int) (TableReport, error) {
+	table, err := readSSTable(directory, strconv.Itoa(num), strconv.Itoa(level))
+	if errors.Is(err, ErrCorruption) {
+		return TableReport{Table: tableFiles(directory, level, num).generalFilename + "TOC.txt",
+			FirstBadChecksum: -1, FirstBadLeaf: -1, Err: err}, nil
+	} else if err != nil {
+		return TableReport{}, err
+	}
+	return VerifySSTable(directory, table.dataFilename)
+}
+
+// VerifySSTable checks the records of one data file and recomputes its Merkle tree.
+func VerifySSTable(directory, dataFilename string) (TableReport, error) {
+	report := TableReport{Table: dataFilename, FirstBadChecksum: -1, FirstBadLeaf: -1}
+
+	leaves, legacyLeaves, err := verifyRecords(dataFilename, &report)
+	if errors.Is(err, ErrCorruption) {
+		report.Err = err
+		return report, nil
+	} else if err != nil {
+		return report, err
+	}
+
+	stored, err := ReadTreeFromFile(metadataFilename(directory, dataFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		report.MissingMetadata = true
+		return report, nil
+	} else if errors.Is(err, ErrCorruption) {
+		report.Err = err
+		return report, nil
+	} else if err != nil {
+		return report, err
+	}
+
+	report.RootMismatch, report.FirstBadLeaf = compareTree(leaves, stored)
+	// Older tables have trees over their keys and values, or over their values only.
+	for _, legacy := range legacyLeaves {
+		if !report.RootMismatch {
+			break
+		}
+		if rootMismatch, firstBadLeaf := compareTree(legacy, stored); !rootMismatch {
+			report.RootMismatch, report.FirstBadLeaf = rootMismatch, firstBadLeaf
+		}
+	}
+	return report, nil
+}
+
+// compareTree builds the tree over leafData, with the hash function of the stored node
+// hashes, and compares it with them. It returns whether the roots differ and the first
+// leaf that differs, or -1.
+func compareTree(leafData [][]byte, stored [][]byte) (rootMismatch bool, firstBadLeaf int) {
+	hash := SHA1
+	if len(stored) > 0 {
+		hash, _ = hashFunctionOfSize(len(stored[0]))
+	}
+	leaves := CreateLeafNodes(leafData, hash)
+	nodes := breadthFirst(CreateAllNodes(leaves, hash))
+	rootMismatch = len(stored) == 0 || !bytes.Equal(stored[0], nodes[0].HashValue)
+
+	// The stored tree has the same shape as long as the number of records did not change,
+	// so every node is compared with the hash at the same position of the file.
+	positions := make(map[*MerkleNode]int, len(nodes))
+	for i, node := range nodes {
+		positions[node] = i
+	}
+	for i, leaf := range leaves {
+		position := positions[leaf]
+		if position >= len(stored) || !bytes.Equal(stored[position], leaf.HashValue) {
+			return rootMismatch, i
+		}
+	}
+	return rootMismatch, -1
+}
+
+// verifyRecords reads every record of a data file and checks its CRC. It returns the
+// leaves of the table's Merkle tree and the leaves of older tables: over the keys and
+// values of the records, and over their values only.
+func verifyRecords(dataFilename string, report *TableReport) (leaves [][]byte, legacyLeaves [2][][]byte, err error) {
+	err = readRecords(dataFilename, func(i uint64, crc uint32, timestamp string, kind byte, key, value string) {
+		if crc != CRC32([]byte(value)) && report.FirstBadChecksum < 0 {
+			report.FirstBadChecksum = int(i)
+		}
+		leaves = append(leaves, MerkleLeaf(key, kind, timestamp, value))
+		legacyLeaves[0] = append(legacyLeaves[0], keyValueLeaf(key, value))
+		legacyLeaves[1] = append(legacyLeaves[1], []byte(value))
+		report.Records++
+	})
+	return leaves, legacyLeaves, err
+}
+
+// readRecords calls visit for every record of a data file, in order, in the format the
+// table of contents next to it gives.
+func readRecords(dataFilename string, visit func(i uint64, crc uint32, timestamp string, kind byte, key, value string)) error {
+	file, err := os.Open(dataFilename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fileLenBytes, err := readBytes(bufio.NewReader(file), 8)
+	if err != nil {
+		return readError(dataFilename, err)
+	}
+	fileLen := binary.LittleEndian.Uint64(fileLenBytes)
+
+	format := dataFileFormat(dataFilename)
+	currentOffset := uint(8)
+	for i := uint64(0); i < fileLen; i++ {
+		var crcBytes []byte
+		var timestamp, key, value string
+		var kind byte
+		crcBytes, timestamp, kind, key, value, currentOffset, err = readRecord(file, currentOffset, format)
+		if err != nil {
+			return err
+		}
+		visit(i, binary.LittleEndian.Uint32(crcBytes), timestamp, kind, key, value)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package structures
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+// writeVerifiedTable writes a table of the keys a, b and c and returns its directory and
+// data file.
+func writeVerifiedTable(t *testing.T) (directory, dataFilename string) {
+	t.Helper()
+	mt := NewMemoryTable(5, 100, 80)
+	for _, key := range []string{"a", "b", "c"} {
+		mt.Insert(key, EncodeValue(TypeRaw, []byte(key+"-value")), false)
+	}
+	directory = writeTestTable(t, mt)
+	return directory, tableFiles(directory, 1, 1).dataFilename
+}
+
+func TestVerifyIntactTable(t *testing.T) {
+	directory, _ := writeVerifiedTable(t)
+	reports, err := VerifySSTables(directory, 2)
+	if err != nil || len(reports) != 1 {
+		t.Fatalf("reports are %v, %v", reports, err)
+	}
+	if report := reports[0]; report.Corrupted() || report.Records != 3 || report.MissingMetadata {
+		t.Errorf("intact table has report %+v", report)
+	}
+}
+
+func TestVerifyFindsTheBadRecord(t *testing.T) {
+	directory, dataFilename := writeVerifiedTable(t)
+	data, err := os.ReadFile(dataFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[bytes.Index(data, []byte("b-value"))] = 'x'
+	if err = os.WriteFile(dataFilename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := VerifyTable(directory, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Corrupted() || report.FirstBadChecksum != 1 || report.FirstBadLeaf != 1 || !report.RootMismatch {
+		t.Errorf("table with a flipped value has report %+v", report)
+	}
+	if report.Problem() != "bad checksum of record 1; bad Merkle leaf 1" {
+		t.Errorf("problem is %q", report.Problem())
+	}
+}
+
+func TestVerifyMetadata(t *testing.T) {
+	directory, dataFilename := writeVerifiedTable(t)
+	metadata := metadataFilename(directory, dataFilename)
+	data, err := os.ReadFile(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first line holds the root, so the leaves still match the data.
+	root := bytes.Repeat([]byte("0"), bytes.IndexByte(data, '\n'))
+	if err = os.WriteFile(metadata, append(root, data[len(root):]...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := VerifyTable(directory, 1, 1)
+	if err != nil || !report.RootMismatch || report.FirstBadLeaf != -1 || report.Problem() != "bad Merkle root" {
+		t.Errorf("table with a bad stored root has report %+v, %v", report, err)
+	}
+
+	if err = os.WriteFile(metadata, []byte("not a tree\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if report, err = VerifyTable(directory, 1, 1); err != nil || !errors.Is(report.Err, ErrCorruption) {
+		t.Errorf("table with an unreadable tree has report %+v, %v", report, err)
+	}
+
+	if err = os.Remove(metadata); err != nil {
+		t.Fatal(err)
+	}
+	if report, err = VerifyTable(directory, 1, 1); err != nil || report.Corrupted() || !report.MissingMetadata {
+		t.Errorf("table without metadata has report %+v, %v", report, err)
+	}
+}
@@ -3,21 +3,22 @@ package structures
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"io/ioutil"
-	"log"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
 const (
-	WalPath         = "./system/data/wal/"
 	CrcSize         = 4
 	TimestampSize   = 19
 	TombstoneSize   = 1
+	KeyspaceSize    = 8
 	KeySizeSize     = 8
 	ValueSizeSize   = 8
 	SegmentCapacity = 50
@@ -54,29 +55,19 @@ func (s *WalSegment) AppendData(elemData []byte) int {
 	return -1
 }
 
-func (s *WalSegment) Persist(walPath string) {
+func (s *WalSegment) Persist(walPath string) error {
 	path := walPath + "wal" + strconv.FormatUint(s.index, 10) + ".log"
-	newFile, _ := os.Create(path)
-	err := newFile.Close()
+	file, err := os.Create(path)
 	if err != nil {
-		fmt.Println(err)
-	}
-
-	file, err := os.OpenFile(path, os.O_WRONLY, 0666)
-	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer file.Close()
 	bufferedWriter := bufio.NewWriter(file)
-	err = bufferedWriter.Flush()
-	if err != nil {
-		return
-	}
-	_, err = bufferedWriter.Write(s.data)
-	err = bufferedWriter.Flush()
-	if err != nil {
-		return
+	_, _ = bufferedWriter.Write(s.data)
+	if err = bufferedWriter.Flush(); err != nil {
+		return err
 	}
+	return syncAndClose(file)
 }
 
 type WriteAheadLog struct {
@@ -108,41 +99,51 @@ func NewWriteAheadLog(path string) *WriteAheadLog {
 	return &wal
 }
 
-func (wal *WriteAheadLog) CreateNewSegment() {
+func (wal *WriteAheadLog) CreateNewSegment() error {
 	newSegment := WalSegment{
 		index:    wal.currentSegment.index + 1,
 		data:     make([]byte, 0, SegmentCapacity),
 		size:     0,
 		capacity: wal.currentSegment.capacity,
 	}
-	wal.PersistCurrentSegment()
+	if err := wal.PersistCurrentSegment(); err != nil {
+		return err
+	}
 	wal.segments = append(wal.segments, &newSegment)
 	wal.currentSegment = &newSegment
-	wal.PersistCurrentSegment()
+	return wal.PersistCurrentSegment()
 }
 
-func (wal *WriteAheadLog) PersistCurrentSegment() {
-	wal.currentSegment.Persist(wal.path)
+func (wal *WriteAheadLog) PersistCurrentSegment() error {
+	if err := wal.currentSegment.Persist(wal.path); err != nil {
+		return fmt.Errorf("write-ahead log: %w", err)
+	}
 	wal.segmentNames[wal.currentSegment.index] = "wal" + strconv.FormatUint(wal.currentSegment.index, 10) + ".log"
+	return nil
 }
 
-func (wal *WriteAheadLog) PutElement(elem *Element) bool {
+// Position returns where the next record starts: the number of bytes written to the log
+// since its first segment.
+func (wal *WriteAheadLog) Position() uint64 {
+	return wal.currentSegment.index*SegmentCapacity + wal.currentSegment.size
+}
+
+// PutElement appends the record of elem to the log and writes the segments it is in to disk.
+// If a segment cannot be written the error is returned and the caller must not apply the write.
+func (wal *WriteAheadLog) PutElement(elem *Element) error {
 	crc := make([]byte, CrcSize)
 	binary.LittleEndian.PutUint32(crc, elem.Checksum)
 	timestamp := make([]byte, TimestampSize)
 	binary.LittleEndian.PutUint64(timestamp, uint64(time.Now().Unix()))
-	tombstone := make([]byte, TombstoneSize)
-	switch elem.Tombstone {
-	case true:
-		tombstone = []byte{1}
-	case false:
-		tombstone = []byte{0}
-	}
+	tombstone := []byte{elem.Kind()}
+	keyspaceSize := make([]byte, KeyspaceSize)
 	keySize := make([]byte, KeySizeSize)
 	valueSize := make([]byte, ValueSizeSize)
+	binary.LittleEndian.PutUint64(keyspaceSize, uint64(len(elem.Keyspace)))
 	binary.LittleEndian.PutUint64(keySize, uint64(len(elem.Key)))
 	binary.LittleEndian.PutUint64(valueSize, uint64(len(elem.Value)))
 
+	keyspace := []byte(elem.Keyspace)
 	key := []byte(elem.Key)
 	value := elem.Value
 
@@ -150,8 +151,10 @@ func (wal *WriteAheadLog) PutElement(elem *Element) bool {
 	elemData = append(elemData, crc...)
 	elemData = append(elemData, timestamp...)
 	elemData = append(elemData, tombstone...)
+	elemData = append(elemData, keyspaceSize...)
 	elemData = append(elemData, keySize...)
 	elemData = append(elemData, valueSize...)
+	elemData = append(elemData, keyspace...)
 	elemData = append(elemData, key...)
 	elemData = append(elemData, value...)
 
@@ -160,85 +163,183 @@ func (wal *WriteAheadLog) PutElement(elem *Element) bool {
 	for offset >= 0 {
 		offset = wal.CurrentSegment().AppendData(elemData[start:])
 		if offset != -1 {
-			wal.CreateNewSegment()
+			if err := wal.CreateNewSegment(); err != nil {
+				return err
+			}
 			start += offset
 		}
 	}
-	return true
+	return wal.PersistCurrentSegment()
 }
 
-func (wal *WriteAheadLog) RemoveOldSegments() {
+func (wal *WriteAheadLog) RemoveOldSegments() error {
 	wal.lowWaterMark = uint(wal.currentSegment.index - 2)
-	for index, value := range wal.segmentNames {
-		index2 := uint(index)
-		if index2 <= wal.lowWaterMark {
-			err := os.Remove(WalPath + value)
-			delete(wal.segmentNames, index)
-			if err != nil {
-				fmt.Println(err)
+	return wal.RemoveSegmentsBefore(uint64(wal.lowWaterMark) + 1)
+}
+
+// RemoveSegmentsBefore deletes the persisted segments with an index lower than index.
+// Segments from index on may still hold records that are not flushed to SSTables.
+// A segment that could not be deleted is kept in the log, so a later call tries again.
+func (wal *WriteAheadLog) RemoveSegmentsBefore(index uint64) error {
+	for segmentIndex, name := range wal.segmentNames {
+		if segmentIndex < index {
+			if err := os.Remove(wal.path + name); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
 			}
+			delete(wal.segmentNames, segmentIndex)
 		}
 	}
+	return nil
 }
 
-func (wal *WriteAheadLog) ReadLatestSegment(path string) {
-	files, err := ioutil.ReadDir(WalPath)
+// WalRecord is a record read back from the write-ahead log.
+type WalRecord struct {
+	Element
+	Position uint64 // where the record starts in the log
+}
+
+// OpenWriteAheadLog opens the log in path and returns its records from position start on.
+// Appends continue after the last complete record; a record cut off by a crash is dropped
+// together with the segments after it. Without segments the log starts at start, which
+// must then be the beginning of a segment.
+func OpenWriteAheadLog(path string, start uint64) (*WriteAheadLog, []WalRecord, error) {
+	wal := NewWriteAheadLog(path)
+	files, err := ioutil.ReadDir(path)
 	if err != nil {
-		fmt.Println(err)
+		return nil, nil, err
 	}
-
-	for i := 0; i < len(files); i++ {
-		indexStr := strings.Split(files[i].Name(), "wal")[1]
-		indexStr = strings.Split(indexStr, ".log")[0]
-		index, err := strconv.ParseUint(indexStr, 10, 64)
-		if err != nil {
-			fmt.Println(err)
+	indexes := make([]uint64, 0, len(files))
+	for _, file := range files {
+		index, ok := walSegmentIndex(file.Name())
+		if !ok {
+			return nil, nil, fmt.Errorf("%s%s is not a WAL segment", path, file.Name())
 		}
-		wal.segmentNames[index] = files[i].Name()
+		wal.segmentNames[index] = file.Name()
+		indexes = append(indexes, index)
 	}
-
-	maxIndex := uint64(0)
-	for key := range wal.segmentNames {
-		if maxIndex < key {
-			maxIndex = key
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	if len(indexes) == 0 {
+		if start%SegmentCapacity != 0 {
+			return nil, nil, fmt.Errorf("%w: WAL segment %d is missing", ErrCorruption, start/SegmentCapacity)
 		}
+		wal.currentSegment.index = start / SegmentCapacity
+		return wal, nil, nil
 	}
-	index := maxIndex
-	current := wal.segmentNames[index]
 
-	file, err := os.Open(path + current)
-	if err != nil {
-		fmt.Println(err)
+	// The segments are read up to the first gap or the first segment that is not full,
+	// which ends the log.
+	first := indexes[0]
+	if start < first*SegmentCapacity {
+		return nil, nil, fmt.Errorf("%w: WAL segment %d is missing", ErrCorruption, start/SegmentCapacity)
 	}
-
-	err = file.Close()
-	if err != nil {
-		fmt.Println(err)
+	data := make([]byte, 0)
+	for i, index := range indexes {
+		if index != first+uint64(i) {
+			break
+		}
+		segment, err := ioutil.ReadFile(path + wal.segmentNames[index])
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(segment) > SegmentCapacity {
+			segment = segment[:SegmentCapacity]
+		}
+		data = append(data, segment...)
+		if len(segment) < SegmentCapacity {
+			break
+		}
 	}
 
-	bufferedReader := bufio.NewReader(file)
-	info, err := os.Stat(file.Name())
-	if err != nil {
-		fmt.Println(err)
+	base := first * SegmentCapacity
+	if start > base+uint64(len(data)) {
+		return nil, nil, fmt.Errorf("%w: WAL ends before position %d", ErrCorruption, start)
+	}
+	records := make([]WalRecord, 0)
+	end := start
+	for {
+		record, size, ok := readWalRecord(data[end-base:])
+		if !ok {
+			break
+		}
+		record.Position = end
+		records = append(records, record)
+		end += size
 	}
-	numBytes := info.Size()
-
-	bytes := make([]byte, numBytes)
-	_, err = bufferedReader.Read(bytes)
 
-	currentSegment := WalSegment{
+	// The log continues in the segment of its end, cut back to the last complete record.
+	index, size := end/SegmentCapacity, end%SegmentCapacity
+	if size == 0 && index > first {
+		index, size = index-1, SegmentCapacity
+	}
+	offset := (index - first) * SegmentCapacity
+	wal.currentSegment = &WalSegment{
 		index:    index,
-		data:     nil,
-		size:     0,
+		data:     append(make([]byte, 0, SegmentCapacity), data[offset:offset+size]...),
+		size:     size,
 		capacity: SegmentCapacity,
 	}
-	currentSegment.AppendData(bytes)
+	for _, later := range indexes {
+		if later > index {
+			if err = os.Remove(path + wal.segmentNames[later]); err != nil {
+				return nil, nil, err
+			}
+			delete(wal.segmentNames, later)
+		}
+	}
+	if err = wal.PersistCurrentSegment(); err != nil {
+		return nil, nil, err
+	}
+	return wal, records, nil
+}
 
-	wal.currentSegment = &currentSegment
-	wal.segments = append(wal.segments, &currentSegment)
+// walSegmentIndex returns the index of the segment in a file named wal<index>.log.
+func walSegmentIndex(name string) (uint64, bool) {
+	if !strings.HasPrefix(name, "wal") || !strings.HasSuffix(name, ".log") {
+		return 0, false
+	}
+	index, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, "wal"), ".log"), 10, 64)
+	return index, err == nil
+}
 
-	err = file.Close()
-	if err != nil {
-		fmt.Println(err)
+// readWalRecord decodes the record at the start of data and returns it with its size.
+// ok is false if data ends before the record does or the record fails its checksum.
+func readWalRecord(data []byte) (record WalRecord, size uint64, ok bool) {
+	header := uint64(CrcSize + TimestampSize + TombstoneSize + KeyspaceSize + KeySizeSize + ValueSizeSize)
+	if uint64(len(data)) < header {
+		return record, 0, false
+	}
+	offset := uint64(CrcSize + TimestampSize)
+	kind := data[offset]
+	offset += TombstoneSize
+	keyspaceSize := binary.LittleEndian.Uint64(data[offset:])
+	keySize := binary.LittleEndian.Uint64(data[offset+KeyspaceSize:])
+	valueSize := binary.LittleEndian.Uint64(data[offset+KeyspaceSize+KeySizeSize:])
+	if keyspaceSize > maxFieldLength || keySize > maxFieldLength || valueSize > maxFieldLength ||
+		uint64(len(data))-header < keyspaceSize+keySize+valueSize {
+		return record, 0, false
+	}
+	offset = header
+	record.Keyspace = string(data[offset : offset+keyspaceSize])
+	offset += keyspaceSize
+	record.Key = string(data[offset : offset+keySize])
+	offset += keySize
+	record.Value = append([]byte(nil), data[offset:offset+valueSize]...)
+	record.Checksum = binary.LittleEndian.Uint32(data)
+	if CalculateCRC32(record.Value) != record.Checksum {
+		return record, 0, false
+	}
+	switch kind {
+	case RecordValue:
+	case RecordTombstone:
+		record.Tombstone = true
+	case RecordMerge:
+		record.Merge = true
+	case RecordRangeTombstone:
+		record.RangeDelete = true
+	case RecordBatch:
+		record.Batch = true
+	default:
+		return record, 0, false
 	}
+	return record, offset + valueSize, true
 }
@@ -0,0 +1,56 @@
+package structures
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestOpenWriteAheadLogDropsCutOffRecord(t *testing.T) {
+	path := t.TempDir() + "/"
+	wal := NewWriteAheadLog(path)
+	for _, key := range []string{"first", "second"} {
+		elem := Element{Keyspace: "default", Key: key, Value: []byte("value of " + key)}
+		elem.Checksum = CRC32(elem.Value)
+		if err := wal.PutElement(&elem); err != nil {
+			t.Fatal(err)
+		}
+	}
+	end := wal.Position()
+	// A crash in the middle of the third record leaves only part of it on disk.
+	segment := path + "wal" + strconv.FormatUint(wal.CurrentSegment().Index(), 10) + ".log"
+	file, err := os.OpenFile(segment, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = file.Write([]byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	wal, records, err := OpenWriteAheadLog(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Key != "first" || records[1].Key != "second" || records[1].Keyspace != "default" {
+		t.Fatalf("read back %v", records)
+	}
+	if wal.Position() != end {
+		t.Errorf("log continues at %d, want %d", wal.Position(), end)
+	}
+
+	elem := Element{Keyspace: "default", Key: "third", Value: []byte("3"), Tombstone: true}
+	elem.Checksum = CRC32(elem.Value)
+	if err = wal.PutElement(&elem); err != nil {
+		t.Fatal(err)
+	}
+	_, records, err = OpenWriteAheadLog(path, records[1].Position)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1].Key != "third" || !records[1].Tombstone {
+		t.Errorf("read back %v from the second record on", records)
+	}
+}